@@ -0,0 +1,151 @@
+package caddys3fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/floj/caddy-s3fs/s3fs"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAccessExportDepth    = 1
+	defaultAccessExportInterval = time.Hour
+
+	// accessExportTimeout bounds writing a single export.
+	accessExportTimeout = time.Minute
+)
+
+// AccessExport configures periodic exports of per-prefix access statistics,
+// e.g. to design lifecycle transitions for rarely served content.
+type AccessExport struct {
+	// Number of leading path components making up a prefix. Defaults to 1.
+	Depth int `json:"depth,omitempty"`
+
+	// Time between exports. Defaults to 1h.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// Local file to write the statistics to.
+	File string `json:"file,omitempty"`
+
+	// Key to write the statistics to.
+	Key string `json:"key,omitempty"`
+
+	// Bucket of Key. Defaults to the served bucket.
+	Bucket string `json:"bucket,omitempty"`
+}
+
+// accessReport is the exported document.
+type accessReport struct {
+	Bucket    string              `json:"bucket"`
+	Since     time.Time           `json:"since"`
+	Generated time.Time           `json:"generated"`
+	Prefixes  []s3fs.PrefixAccess `json:"prefixes"`
+}
+
+// provision applies defaults.
+func (e *AccessExport) provision(fs *FS) {
+	if e.Depth == 0 {
+		e.Depth = defaultAccessExportDepth
+	}
+	if e.Interval == 0 {
+		e.Interval = caddy.Duration(defaultAccessExportInterval)
+	}
+	if e.Bucket == "" {
+		e.Bucket = fs.Bucket
+	}
+}
+
+// run exports the statistics of s every interval until ctx is done, exporting
+// them a last time before returning.
+func (e *AccessExport) run(ctx context.Context, s *s3fs.S3FS, client *s3.Client, log *zap.Logger) {
+	since := time.Now()
+	ticker := time.NewTicker(time.Duration(e.Interval))
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+		report := accessReport{
+			Bucket:    e.Bucket,
+			Since:     since,
+			Generated: time.Now(),
+			Prefixes:  s.AccessStats(),
+		}
+		if err := e.export(report, client); err != nil {
+			log.Warn("could not export access statistics", zap.Error(err))
+		}
+	}
+}
+
+// export writes report to the configured file or key.
+func (e *AccessExport) export(report accessReport, client *s3.Client) error {
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if e.File != "" {
+		tmp := filepath.Join(filepath.Dir(e.File), "."+filepath.Base(e.File)+".tmp")
+		if err := os.WriteFile(tmp, body, 0o640); err != nil {
+			return err
+		}
+		return os.Rename(tmp, e.File)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), accessExportTimeout)
+	defer cancel()
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(e.Bucket),
+		Key:         aws.String(e.Key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// unmarshalAccessExport parses the access_export block.
+func unmarshalAccessExport(d *caddyfile.Dispenser) (*AccessExport, error) {
+	e := new(AccessExport)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "depth":
+			if err := parseInt(d, &e.Depth); err != nil {
+				return nil, err
+			}
+		case "interval":
+			var val string
+			if !d.AllArgs(&val) {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return nil, d.Errf("invalid access export interval %q: %v", val, err)
+			}
+			e.Interval = caddy.Duration(dur)
+		case "file":
+			if !d.AllArgs(&e.File) {
+				return nil, d.ArgErr()
+			}
+		case "key":
+			if !d.AllArgs(&e.Key) {
+				return nil, d.ArgErr()
+			}
+		case "bucket":
+			if !d.AllArgs(&e.Bucket) {
+				return nil, d.ArgErr()
+			}
+		default:
+			return nil, d.Errf("%s not a valid access_export option", d.Val())
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,379 @@
+package caddys3fs
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+func init() {
+	caddy.RegisterModule(AccessLog{})
+	httpcaddyfile.RegisterHandlerDirective("s3fs_log", parseAccessLog)
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*AccessLog)(nil)
+	_ caddyfile.Unmarshaler       = (*AccessLog)(nil)
+	_ caddy.Provisioner           = (*AccessLog)(nil)
+	_ caddy.Validator             = (*AccessLog)(nil)
+)
+
+// Response header fields carrying the S3 fields into the access log.
+const (
+	headerS3Key    = "X-S3-Key"
+	headerS3ETag   = "X-S3-Etag"
+	headerS3Cache  = "X-S3-Cache"
+	headerS3Calls  = "X-S3-Calls"
+	headerS3Origin = "X-S3-Origin-Ms"
+)
+
+// checksums maps the checksum algorithms of the served bytes to the header
+// field carrying them and their constructor.
+var checksums = map[string]struct {
+	header string
+	hash   func() hash.Hash
+}{
+	"sha256": {"X-S3-Checksum-Sha256", sha256.New},
+	"md5":    {"X-S3-Checksum-Md5", md5.New},
+}
+
+// AccessLog is a middleware enriching the access log with the S3 activity of
+// each request served from the filesystem of Bucket: the key and ETag
+// served, STALE if expired metadata was served, HIT if no S3 call was needed
+// and MISS otherwise, the number of S3 calls and the total time S3 took to
+// respond. Caddy logs the response header fields, so the fields are added to
+// the header once the response was written, when they are no longer sent to
+// the client, and show up as `resp_headers` X-S3-Key, X-S3-Etag, X-S3-Cache,
+// X-S3-Calls and X-S3-Origin-Ms. They are also set as the variables s3_key,
+// s3_etag, s3_cache, s3_calls and s3_origin_ms. With Checksum set, the hex
+// encoded digest of the response body is added as X-S3-Checksum-Sha256 or
+// X-S3-Checksum-Md5 and s3_checksum, computed while the body is streamed.
+// The handler needs to precede the file_server and s3fs_negotiate, and is
+// required for the file_server to serve a bucket name or prefix with
+// placeholders: it sets `{http.vars.root}` to Root below a root bound to the
+// request, see s3fs.BindRequest, so the calls of the file_server, which does
+// not pass the request on, are issued for exactly this request. The
+// file_server has to take its root from `{http.vars.root}`, its default.
+// Readahead and CacheTTL override the parameters of the filesystem for the
+// requests of the route, e.g. a larger readahead for downloads, and with
+// PresignRedirect, GET requests of large files are redirected to S3.
+type AccessLog struct {
+	// Bucket of the filesystem to report. May be omitted if only a single
+	// bucket is served.
+	Bucket string `json:"bucket,omitempty"`
+
+	// Root of the file_server, set below the root bound to the request.
+	// Defaults to `{http.vars.root}`, like the file_server.
+	Root string `json:"root,omitempty"`
+
+	// Digest of the bytes served, sha256 or md5, covering the body as sent,
+	// e.g. only the requested range or the compressed variant. Disabled if
+	// empty.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Send the checksum to the client as trailer field, announced in the
+	// Trailer header field. Clients only receive it over HTTP/2 and
+	// HTTP/3, or HTTP/1.1 responses without Content-Length.
+	ChecksumTrailer bool `json:"checksum_trailer,omitempty"`
+
+	// Number of bytes requested beyond each read of the requests of the
+	// route, taking precedence over the overrides of the filesystem. -1
+	// disables readahead.
+	Readahead int64 `json:"readahead,omitempty"`
+
+	// Lifetime of the metadata cached for the requests of the route.
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+
+	// Minimum size of the files GET requests are redirected for to a
+	// presigned URL of the object, instead of streaming them through the
+	// server. Disabled if 0.
+	PresignRedirect int64 `json:"presign_redirect,omitempty"`
+
+	// Lifetime of the presigned URLs. Defaults to 15m.
+	PresignExpires caddy.Duration `json:"presign_expires,omitempty"`
+}
+
+// presigner presigns the URLs of the files of a filesystem.
+type presigner interface {
+	PresignGet(name string, expires time.Duration) (string, fs.FileInfo, error)
+}
+
+// CaddyModule returns the Caddy module information.
+func (AccessLog) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.s3fs_log",
+		New: func() caddy.Module { return new(AccessLog) },
+	}
+}
+
+// Provision applies defaults.
+func (l *AccessLog) Provision(ctx caddy.Context) error {
+	if l.Root == "" {
+		l.Root = "{http.vars.root}"
+	}
+	if l.PresignExpires == 0 {
+		l.PresignExpires = caddy.Duration(15 * time.Minute)
+	}
+	return nil
+}
+
+// Validate checks the checksum algorithm and the presign settings.
+func (l *AccessLog) Validate() error {
+	if _, ok := checksums[l.Checksum]; !ok && l.Checksum != "" {
+		return fmt.Errorf("unsupported checksum %q, must be sha256 or md5", l.Checksum)
+	}
+	if l.ChecksumTrailer && l.Checksum == "" {
+		return errors.New("checksum_trailer requires a checksum")
+	}
+	if l.PresignRedirect < 0 {
+		return fmt.Errorf("invalid presign_redirect %d, must not be negative", l.PresignRedirect)
+	}
+	if time.Duration(l.PresignExpires) > 7*24*time.Hour {
+		return errors.New("presign_expires must not exceed 7 days")
+	}
+	return nil
+}
+
+// ServeHTTP collects the S3 activity while the rest of the chain handles
+// the request.
+func (l *AccessLog) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	fsys, err := Lookup(l.Bucket)
+	if err != nil {
+		return next.ServeHTTP(w, r)
+	}
+	switch fsys.StatFS.(type) {
+	case *s3fs.S3FS, *s3fs.Overlay, *dynamicBuckets:
+	default:
+		return next.ServeHTTP(w, r)
+	}
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	root := repl.ReplaceAll(l.Root, ".")
+
+	// the file_server serves from below the root bound to this request, so
+	// its calls are issued for this request only
+	ctx, stats := s3fs.NewRequestContext(r.Context())
+	if l.Readahead != 0 || l.CacheTTL != 0 {
+		ctx = s3fs.WithRouteOverride(ctx, s3fs.Override{
+			Readahead: l.Readahead,
+			CacheTTL:  time.Duration(l.CacheTTL),
+		})
+	}
+	bound, unbind := s3fs.BindRequest(ctx)
+	defer unbind()
+	root = path.Join(bound, root)
+	prevRoot := caddyhttp.GetVar(ctx, "root")
+	caddyhttp.SetVar(ctx, "root", root)
+	lw := &accessLogWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}}
+	checksum, hashed := checksums[l.Checksum]
+	if hashed {
+		lw.hash = checksum.hash()
+		if l.ChecksumTrailer {
+			w.Header().Add("Trailer", checksum.header)
+		}
+	}
+	if url, ok := l.presign(fsys.StatFS, r, caddyhttp.SanitizedPathJoin(root, r.URL.Path)); ok {
+		http.Redirect(lw, r, url, http.StatusTemporaryRedirect)
+	} else {
+		err = next.ServeHTTP(lw, r.WithContext(ctx))
+	}
+	caddyhttp.SetVar(ctx, "root", prevRoot)
+
+	summary := stats.Summary()
+	origin := strconv.FormatFloat(summary.Origin.Seconds()*1e3, 'f', -1, 64)
+	caddyhttp.SetVar(ctx, "s3_key", summary.Key)
+	caddyhttp.SetVar(ctx, "s3_etag", summary.ETag)
+	caddyhttp.SetVar(ctx, "s3_cache", summary.Cache())
+	caddyhttp.SetVar(ctx, "s3_calls", summary.Calls)
+	caddyhttp.SetVar(ctx, "s3_origin_ms", origin)
+	if lw.wroteHeader {
+		h := w.Header()
+		if summary.Key != "" {
+			h.Set(headerS3Key, summary.Key)
+		}
+		if summary.ETag != "" {
+			h.Set(headerS3ETag, summary.ETag)
+		}
+		h.Set(headerS3Cache, summary.Cache())
+		h.Set(headerS3Calls, strconv.Itoa(summary.Calls))
+		h.Set(headerS3Origin, origin)
+	}
+	// bodies of partial or no content are no object served
+	if hashed && summary.Key != "" && r.Method != http.MethodHead &&
+		(lw.status == http.StatusOK || lw.status == http.StatusPartialContent) {
+		sum := hex.EncodeToString(lw.hash.Sum(nil))
+		caddyhttp.SetVar(ctx, "s3_checksum", sum)
+		w.Header().Set(checksum.header, sum)
+	}
+	return err
+}
+
+// presign returns a presigned URL of the file name of a GET request if
+// redirects are enabled and the file is at least PresignRedirect bytes.
+// Files which can't be presigned, e.g. directories, are served as usual.
+func (l *AccessLog) presign(fsys fs.StatFS, r *http.Request, name string) (string, bool) {
+	p, ok := fsys.(presigner)
+	if l.PresignRedirect == 0 || r.Method != http.MethodGet || !ok {
+		return "", false
+	}
+	url, info, err := p.PresignGet(name, time.Duration(l.PresignExpires))
+	if err != nil || info.Size() < l.PresignRedirect {
+		return "", false
+	}
+	return url, true
+}
+
+// accessLogWriter records whether the response header was written, and
+// hashes the body if hash is set.
+type accessLogWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	wroteHeader bool
+	status      int       // status is the status of the response, 0 until written
+	hash        hash.Hash // hash digests the body, nil if disabled
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	// informational responses are followed by the final header
+	if status >= 200 && !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	w.wroteHeader, w.status = true, w.statusOr(http.StatusOK)
+	n, err := w.ResponseWriterWrapper.Write(b)
+	if w.hash != nil {
+		w.hash.Write(b[:n])
+	}
+	return n, err
+}
+
+func (w *accessLogWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader, w.status = true, w.statusOr(http.StatusOK)
+	if w.hash == nil {
+		return w.ResponseWriterWrapper.ReadFrom(r)
+	}
+	// the bytes handed to the connection are digested, none are buffered
+	return w.ResponseWriterWrapper.ReadFrom(io.TeeReader(r, w.hash))
+}
+
+// statusOr returns the status written, or status if none was.
+func (w *accessLogWriter) statusOr(status int) int {
+	if w.status != 0 {
+		return w.status
+	}
+	return status
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	s3fs_log {
+//		bucket <name>
+//		root <path>
+//		checksum sha256|md5 [trailer]
+//		readahead <size>|off
+//		cache_ttl <duration>
+//		presign_redirect <min_size> [<expires>]
+//	}
+func (l *AccessLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "bucket":
+				if !d.AllArgs(&l.Bucket) {
+					return d.ArgErr()
+				}
+			case "root":
+				if !d.AllArgs(&l.Root) {
+					return d.ArgErr()
+				}
+			case "checksum":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				l.Checksum = d.Val()
+				if d.NextArg() {
+					if d.Val() != "trailer" {
+						return d.Errf("%s not a valid checksum option", d.Val())
+					}
+					l.ChecksumTrailer = true
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "readahead":
+				var val string
+				if !d.AllArgs(&val) {
+					return d.ArgErr()
+				}
+				if val == "off" {
+					l.Readahead = -1
+					continue
+				}
+				size, err := humanize.ParseBytes(val)
+				if err != nil {
+					return d.Errf("invalid readahead %q: %v", val, err)
+				}
+				l.Readahead = int64(size)
+			case "cache_ttl":
+				var val string
+				if !d.AllArgs(&val) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(val)
+				if err != nil {
+					return d.Errf("invalid cache_ttl %q: %v", val, err)
+				}
+				l.CacheTTL = caddy.Duration(dur)
+			case "presign_redirect":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(args[0])
+				if err != nil {
+					return d.Errf("invalid presign_redirect size %q: %v", args[0], err)
+				}
+				l.PresignRedirect = int64(size)
+				if len(args) == 2 {
+					dur, err := caddy.ParseDuration(args[1])
+					if err != nil {
+						return d.Errf("invalid presign_redirect expiry %q: %v", args[1], err)
+					}
+					l.PresignExpires = caddy.Duration(dur)
+				}
+			default:
+				return d.Errf("%s not a valid s3fs_log option", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// parseAccessLog unmarshals the s3fs_log directive.
+func parseAccessLog(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	l := new(AccessLog)
+	err := l.UnmarshalCaddyfile(h.Dispenser)
+	return l, err
+}
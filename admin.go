@@ -0,0 +1,504 @@
+package caddys3fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// instances holds all provisioned filesystems so the admin API can report on
+// them, together with the order they were provisioned in.
+var instances = struct {
+	sync.Mutex
+	seq uint64
+	fss map[*FS]uint64
+}{fss: make(map[*FS]uint64)}
+
+func registerInstance(fs *FS) {
+	instances.Lock()
+	defer instances.Unlock()
+	instances.seq++
+	instances.fss[fs] = instances.seq
+}
+
+func unregisterInstance(fs *FS) {
+	instances.Lock()
+	defer instances.Unlock()
+	delete(instances.fss, fs)
+}
+
+// eachInstance calls fn for every provisioned filesystem.
+func eachInstance(fn func(fs *FS)) {
+	instances.Lock()
+	defer instances.Unlock()
+	for fs := range instances.fss {
+		fn(fs)
+	}
+}
+
+// adminAPI is a module that serves endpoints reporting on the
+// S3 filesystems of the running config.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.s3fs",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes for the S3 filesystems.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/s3fs/hotkeys",
+			Handler: caddy.AdminHandlerFunc(a.handleHotKeys),
+		},
+		{
+			Pattern: "/s3fs/streams",
+			Handler: caddy.AdminHandlerFunc(a.handleStreams),
+		},
+		{
+			Pattern: "/s3fs/state",
+			Handler: caddy.AdminHandlerFunc(a.handleState),
+		},
+		{
+			Pattern: "/s3fs/purge",
+			Handler: caddy.AdminHandlerFunc(a.handlePurge),
+		},
+		{
+			Pattern: "/s3fs/diff",
+			Handler: caddy.AdminHandlerFunc(a.handleDiff),
+		},
+		{
+			Pattern: "/s3fs/schema",
+			Handler: caddy.AdminHandlerFunc(a.handleSchema),
+		},
+		{
+			Pattern: "/s3fs/benchmark",
+			Handler: caddy.AdminHandlerFunc(a.handleBenchmark),
+		},
+		{
+			Pattern: "/s3fs/preposition",
+			Handler: caddy.AdminHandlerFunc(a.handlePreposition),
+		},
+		{
+			Pattern: "/s3fs/settings",
+			Handler: caddy.AdminHandlerFunc(a.handleSettings),
+		},
+	}
+}
+
+// hotKeysReport lists the hot keys of a single filesystem.
+type hotKeysReport struct {
+	Bucket  string         `json:"bucket"`
+	HotKeys []s3fs.KeyStat `json:"hot_keys"`
+}
+
+// handleHotKeys reports the most requested keys of every filesystem with
+// hot-key tracking enabled.
+func (a *adminAPI) handleHotKeys(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	reports := []hotKeysReport{}
+	eachInstance(func(fs *FS) {
+		s3, ok := fs.StatFS.(*s3fs.S3FS)
+		if !ok || s3.HotKeys() == nil {
+			return
+		}
+		reports = append(reports, hotKeysReport{
+			Bucket:  fs.Bucket,
+			HotKeys: s3.HotKeys(),
+		})
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// streamsReport holds the aggregated stream statistics of a single filesystem.
+type streamsReport struct {
+	Bucket string `json:"bucket"`
+	*s3fs.StreamReport
+}
+
+// handleStreams reports the read statistics of open files of every
+// filesystem with stream statistics enabled, to tell slow clients from a
+// slow origin.
+func (a *adminAPI) handleStreams(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	reports := []streamsReport{}
+	eachInstance(func(fs *FS) {
+		s3, ok := fs.StatFS.(*s3fs.S3FS)
+		if !ok {
+			return
+		}
+		if stats := s3.StreamStats(); stats != nil {
+			reports = append(reports, streamsReport{Bucket: fs.Bucket, StreamReport: stats})
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// handleState dumps the internal state of every filesystem, for debugging
+// production incidents.
+func (a *adminAPI) handleState(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	states := []s3fs.State{}
+	eachInstance(func(fs *FS) {
+		if s3, ok := fs.StatFS.(*s3fs.S3FS); ok {
+			states = append(states, s3.State())
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(states)
+}
+
+// purgeReport holds the number of cache entries purged from a single filesystem.
+type purgeReport struct {
+	Bucket string `json:"bucket"`
+	Purged int    `json:"purged"`
+}
+
+// handlePurge drops cached metadata of the keys starting with the prefix
+// query parameter, optionally only of the filesystems of the bucket query
+// parameter.
+func (a *adminAPI) handlePurge(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	bucket := r.URL.Query().Get("bucket")
+	reports := []purgeReport{}
+	eachInstance(func(fs *FS) {
+		switch s := fs.StatFS.(type) {
+		case *s3fs.S3FS:
+			if bucket == "" || fs.Bucket == bucket {
+				reports = append(reports, purgeReport{Bucket: fs.Bucket, Purged: s.PurgeCache(prefix)})
+			}
+		case *s3fs.Overlay:
+			for _, l := range s.Layers() {
+				if l, ok := l.(*s3fs.S3FS); ok && (bucket == "" || l.Bucket() == bucket) {
+					reports = append(reports, purgeReport{Bucket: l.Bucket(), Purged: l.PurgeCache(prefix)})
+				}
+			}
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// handleDiff compares the objects below the prefix query parameter of the
+// filesystem of the bucket query parameter against the manifest posted as
+// JSON array of key, size and optional ETag.
+func (a *adminAPI) handleDiff(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	var manifest []s3fs.ManifestEntry
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decoding manifest: %v", err),
+		}
+	}
+	fs, err := Lookup(r.URL.Query().Get("bucket"))
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        err,
+		}
+	}
+	s3, ok := fs.StatFS.(*s3fs.S3FS)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        ErrNoFilesystem,
+		}
+	}
+	diff, err := s3.DiffManifest(r.URL.Query().Get("prefix"), manifest)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        err,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(diff)
+}
+
+// handleSchema serves the JSON schema of the module config.
+func (a *adminAPI) handleSchema(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	return json.NewEncoder(w).Encode(JSONSchema())
+}
+
+// maxBenchmarkReads bounds the number of files read per strategy by a
+// benchmark.
+const maxBenchmarkReads = 100
+
+// handleBenchmark compares the read strategies on the objects of the key
+// query parameters, read rounds times, of the filesystem of the bucket query
+// parameter.
+func (a *adminAPI) handleBenchmark(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	query := r.URL.Query()
+	keys := query["key"]
+	rounds := 1
+	if v := query.Get("rounds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid rounds %q", v),
+			}
+		}
+		rounds = n
+	}
+	if len(keys) == 0 || len(keys)*rounds > maxBenchmarkReads {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("benchmark requires 1 to %d reads of keys", maxBenchmarkReads),
+		}
+	}
+	fs, err := Lookup(query.Get("bucket"))
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        err,
+		}
+	}
+	if fs.client == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        ErrNoFilesystem,
+		}
+	}
+	results := s3fs.Benchmark(r.Context(), fs.Bucket, fs.client, caddy.Log(), keys, rounds)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(results)
+}
+
+// handlePreposition warms the caches of the filesystem of the bucket query
+// parameter with the objects below the prefix query parameter, e.g. called
+// by a deploy pipeline or a consumer of S3 event notifications once a new
+// release was uploaded, before traffic is switched to it.
+func (a *adminAPI) handlePreposition(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	fs, err := Lookup(r.URL.Query().Get("bucket"))
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        err,
+		}
+	}
+	s3, ok := fs.StatFS.(*s3fs.S3FS)
+	if !ok || fs.Preposition == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("preposition not configured for bucket %s", fs.Bucket),
+		}
+	}
+	result, err := s3.Preposition(r.Context(), r.URL.Query().Get("prefix"), s3fs.Preposition{
+		Extensions:  fs.Preposition.Extensions,
+		MaxObjects:  fs.Preposition.MaxObjects,
+		Concurrency: fs.Preposition.Concurrency,
+	})
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        err,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+// runtimeSettings are the s3fs.Settings in the units of the config.
+type runtimeSettings struct {
+	Readahead        int64          `json:"readahead"`
+	StatCacheTTL     caddy.Duration `json:"stat_cache_ttl"`
+	NotFoundCacheTTL caddy.Duration `json:"not_found_cache_ttl"`
+	RateLimit        float64        `json:"rate_limit"`
+	RateBurst        int            `json:"rate_burst"`
+	Verbose          bool           `json:"verbose"`
+}
+
+func newRuntimeSettings(s s3fs.Settings) runtimeSettings {
+	return runtimeSettings{
+		Readahead:        s.Readahead,
+		StatCacheTTL:     caddy.Duration(s.StatCacheTTL),
+		NotFoundCacheTTL: caddy.Duration(s.NotFoundCacheTTL),
+		RateLimit:        s.RateLimit,
+		RateBurst:        s.RateBurst,
+		Verbose:          s.Verbose,
+	}
+}
+
+// settingsReport holds the runtime settings of a single filesystem.
+type settingsReport struct {
+	Bucket   string          `json:"bucket"`
+	Settings runtimeSettings `json:"settings"`
+}
+
+// handleSettings reports the runtime settings of every filesystem, or
+// changes the settings posted as JSON object of the filesystems of the
+// bucket query parameter, or of all filesystems if it is unset, until the
+// config is reloaded. Settings left out keep their value. The settings are
+// validated for every filesystem before any is changed, so a rejected
+// change leaves all of them as they were. Of bucket placeholders, only the
+// buckets open are changed.
+func (a *adminAPI) handleSettings(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	var body []byte
+	if r.Method == http.MethodPost {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        err,
+			}
+		}
+	}
+	bucket := r.URL.Query().Get("bucket")
+	var fss []*s3fs.S3FS
+	eachInstance(func(fs *FS) {
+		switch s := fs.StatFS.(type) {
+		case *s3fs.S3FS:
+			if bucket == "" || fs.Bucket == bucket {
+				fss = append(fss, s)
+			}
+		case *s3fs.Overlay:
+			for _, l := range s.Layers() {
+				if l, ok := l.(*s3fs.S3FS); ok && (bucket == "" || l.Bucket() == bucket) {
+					fss = append(fss, l)
+				}
+			}
+		case *dynamicBuckets:
+			for _, s := range s.open() {
+				if bucket == "" || fs.Bucket == bucket || s.Bucket() == bucket {
+					fss = append(fss, s)
+				}
+			}
+		}
+	})
+
+	if body != nil {
+		changes := make([]s3fs.Settings, 0, len(fss))
+		for _, s := range fss {
+			// decoding over the current settings keeps the ones left out
+			settings := newRuntimeSettings(s.Settings())
+			if err := json.Unmarshal(body, &settings); err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        err,
+				}
+			}
+			change := s3fs.Settings{
+				Readahead:        settings.Readahead,
+				StatCacheTTL:     time.Duration(settings.StatCacheTTL),
+				NotFoundCacheTTL: time.Duration(settings.NotFoundCacheTTL),
+				RateLimit:        settings.RateLimit,
+				RateBurst:        settings.RateBurst,
+				Verbose:          settings.Verbose,
+			}
+			if err := s.ValidateSettings(change); err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        fmt.Errorf("bucket %s: %v", s.Bucket(), err),
+				}
+			}
+			changes = append(changes, change)
+		}
+		for i, s := range fss {
+			if err := s.SetSettings(changes[i]); err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusInternalServerError,
+					Err:        fmt.Errorf("bucket %s: %v", s.Bucket(), err),
+				}
+			}
+		}
+	}
+
+	reports := []settingsReport{}
+	for _, s := range fss {
+		reports = append(reports, settingsReport{Bucket: s.Bucket(), Settings: newRuntimeSettings(s.Settings())})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
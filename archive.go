@@ -0,0 +1,105 @@
+package caddys3fs
+
+import (
+	"errors"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+// defaultArchiveQueueSize is the number of objects waiting to be archived
+// before further ones are dropped.
+const defaultArchiveQueueSize = 1000
+
+// Archive configures copies of served objects, kept in a local directory or
+// a second bucket.
+type Archive struct {
+	// Local directory to store copies in, at `<directory>/<key>/<etag>`.
+	Directory string `json:"directory,omitempty"`
+
+	// Bucket to store copies in, at `<prefix><key>/<etag>`.
+	Bucket string `json:"bucket,omitempty"`
+
+	// The AWS region of the archive bucket.
+	Region string `json:"region,omitempty"`
+
+	// Use non-standard endpoint for the archive bucket.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Set this to `true` to force requests to the archive bucket to use path-style addressing.
+	S3ForcePathStyle bool `json:"force_path_style,omitempty"`
+
+	// Prefix of the keys in the archive bucket.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Patterns of keys to archive, all if empty.
+	Match []string `json:"match,omitempty"`
+
+	// Number of objects waiting to be archived before further ones are
+	// dropped. Defaults to 1000.
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// option returns the s3fs option archiving served objects.
+func (a *Archive) option(ctx caddy.Context, fs *FS) (s3fs.Option, error) {
+	var archiver s3fs.Archiver
+	switch {
+	case a.Directory != "" && a.Bucket != "":
+		return nil, errors.New("archive must either use a directory or a bucket")
+	case a.Directory != "":
+		archiver = s3fs.DirArchiver(a.Directory)
+	case a.Bucket != "":
+		cfg, err := fs.sharedConfig(a.Region, a.Endpoint, fs.Profile)
+		if err != nil {
+			return nil, err
+		}
+		archiver = &s3fs.BucketArchiver{Client: fs.newS3Client(cfg, a.S3ForcePathStyle), Bucket: a.Bucket, Prefix: a.Prefix}
+	default:
+		return nil, errors.New("archive directory or bucket must be set")
+	}
+	if a.QueueSize == 0 {
+		a.QueueSize = defaultArchiveQueueSize
+	}
+	return s3fs.WithArchive(ctx, archiver, a.QueueSize, a.Match...), nil
+}
+
+// unmarshalArchive parses the archive block.
+func unmarshalArchive(d *caddyfile.Dispenser) (*Archive, error) {
+	a := new(Archive)
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "directory":
+			if !d.AllArgs(&a.Directory) {
+				return nil, d.ArgErr()
+			}
+		case "bucket":
+			if !d.AllArgs(&a.Bucket) {
+				return nil, d.ArgErr()
+			}
+		case "region":
+			if !d.AllArgs(&a.Region) {
+				return nil, d.ArgErr()
+			}
+		case "endpoint":
+			if !d.AllArgs(&a.Endpoint) {
+				return nil, d.ArgErr()
+			}
+		case "force_path_style":
+			a.S3ForcePathStyle = true
+		case "prefix":
+			if !d.AllArgs(&a.Prefix) {
+				return nil, d.ArgErr()
+			}
+		case "match":
+			a.Match = append(a.Match, d.RemainingArgs()...)
+		case "queue_size":
+			if err := parseInt(d, &a.QueueSize); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, d.Errf("%s not a valid archive option", d.Val())
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,60 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// validateBucketARN checks that bucket, if it is an ARN, names a bucket or
+// an access point objects can be read through: an S3, S3 Object Lambda or
+// Outposts access point. Requests to access points are routed to their
+// endpoint by the S3 client.
+func validateBucketARN(bucket string) error {
+	if !arn.IsARN(bucket) {
+		return nil
+	}
+	a, err := arn.Parse(bucket)
+	if err != nil {
+		return fmt.Errorf("invalid bucket ARN %q: %v", bucket, err)
+	}
+	if a.Service == "s3" && a.Resource != "" && !strings.Contains(a.Resource, "/") {
+		return nil
+	}
+	if a.Region == "" {
+		return fmt.Errorf("bucket ARN %q lacks a region", bucket)
+	}
+	parts := strings.Split(a.Resource, "/")
+	switch {
+	case (a.Service == "s3" || a.Service == "s3-object-lambda") && len(parts) == 2 && parts[0] == "accesspoint" && parts[1] != "":
+		return nil
+	case a.Service == "s3-outposts" && len(parts) == 4 && parts[0] == "outpost" && parts[1] != "" && parts[3] != "":
+		switch parts[2] {
+		case "accesspoint":
+			return nil
+		case "bucket":
+			return fmt.Errorf("bucket ARN %q names an Outposts bucket, whose objects can only be read through an access point, use the ARN of one of its access points", bucket)
+		}
+	}
+	return fmt.Errorf("bucket ARN %q is not the ARN of an access point", bucket)
+}
+
+// arnBucket returns the name of the bucket if bucket is the ARN of a
+// bucket, as S3 only accepts ARNs of access points, bucket otherwise.
+func arnBucket(bucket string) string {
+	a, err := arn.Parse(bucket)
+	if err != nil || a.Service != "s3" || strings.Contains(a.Resource, "/") {
+		return bucket
+	}
+	return a.Resource
+}
+
+// arnRegion returns the region of bucket if it is an ARN, or "".
+func arnRegion(bucket string) string {
+	a, err := arn.Parse(bucket)
+	if err != nil {
+		return ""
+	}
+	return a.Region
+}
@@ -0,0 +1,64 @@
+package caddys3fs
+
+import (
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+const defaultCanaryInterval = time.Minute
+
+// Canary configures periodic checks of a canary object, e.g. one written by
+// every deployment, alerting through logs and events when it can't be
+// fetched or wasn't updated for too long.
+type Canary struct {
+	// Key of the canary object.
+	Key string `json:"key,omitempty"`
+
+	// Time between checks. Defaults to 1m.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// Age of the last modification after which the canary is stale, e.g.
+	// a couple of deployment cycles. Staleness isn't checked if unset.
+	MaxAge caddy.Duration `json:"max_age,omitempty"`
+}
+
+// option returns the s3fs option checking the canary.
+func (c *Canary) option(ctx caddy.Context) s3fs.Option {
+	if c.Interval == 0 {
+		c.Interval = caddy.Duration(defaultCanaryInterval)
+	}
+	return s3fs.WithCanary(ctx, c.Key, time.Duration(c.Interval), time.Duration(c.MaxAge))
+}
+
+// unmarshalCanary parses the canary block.
+func unmarshalCanary(d *caddyfile.Dispenser) (*Canary, error) {
+	c := new(Canary)
+	if !d.AllArgs(&c.Key) {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "interval", "max_age":
+			directive := d.Val()
+			var val string
+			if !d.AllArgs(&val) {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return nil, d.Errf("invalid canary %s %q: %v", directive, val, err)
+			}
+			if directive == "interval" {
+				c.Interval = caddy.Duration(dur)
+			} else {
+				c.MaxAge = caddy.Duration(dur)
+			}
+		default:
+			return nil, d.Errf("%s not a valid canary option", d.Val())
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package caddys3fs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/floj/caddy-s3fs/s3fs"
+	"go.uber.org/zap"
+)
+
+// capabilityProbeTimeout bounds probing the capabilities of the endpoint.
+const capabilityProbeTimeout = 10 * time.Second
+
+// probeCapabilities probes the endpoint of client, returning the option
+// disabling what it doesn't support, nil if probing failed.
+func (fs *FS) probeCapabilities(ctx caddy.Context, client s3fs.Client) s3fs.Option {
+	key := fs.ProbeKey
+	if key == "" && fs.Canary != nil {
+		key = fs.Canary.Key
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, capabilityProbeTimeout)
+	defer cancel()
+
+	log := ctx.Logger().With(zap.String("bucket", fs.Bucket))
+	caps, err := s3fs.ProbeCapabilities(probeCtx, client, fs.Bucket, key)
+	switch {
+	case errors.Is(err, s3fs.ErrNoProbeObject):
+		log.Info("no object to probe reads with, assuming ranges and conditional requests are supported")
+	case err != nil:
+		log.Warn("probing S3 capabilities failed, assuming full support", zap.Error(err))
+		return nil
+	}
+	log.Info("detected S3 capabilities", zap.Any("capabilities", caps))
+
+	var disabled []string
+	if !caps.Ranges {
+		disabled = append(disabled, "range reads", "parallel downloads")
+	}
+	if !caps.Conditional {
+		disabled = append(disabled, "conditional reads", "strict consistency")
+	}
+	if !caps.StartAfter {
+		disabled = append(disabled, "partition window listings")
+	}
+	if len(disabled) > 0 {
+		log.Warn("endpoint lacks S3 features, disabling optimizations", zap.Strings("disabled", disabled))
+	}
+	return s3fs.WithCapabilities(caps)
+}
@@ -0,0 +1,92 @@
+package caddys3fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// sharedConfigs holds the AWS configurations of all provisioned filesystems
+// by their connection settings, so filesystems of several sites, and the
+// filesystems replacing them on a config reload, share their HTTP
+// connections and cached credentials.
+var sharedConfigs = struct {
+	sync.Mutex
+	entries map[string]*sharedConfig
+}{entries: make(map[string]*sharedConfig)}
+
+// sharedConfig is an AWS configuration used by refs filesystems.
+type sharedConfig struct {
+	cfg  aws.Config
+	refs int
+}
+
+// configKey hashes the settings the AWS configuration of a bucket in region
+// at endpoint using profile is loaded from, including the retry policy and
+// the credential timeout.
+func (fs *FS) configKey(region, endpoint, profile string) string {
+	settings, _ := json.Marshal(struct {
+		Region            string
+		Endpoint          string
+		Profile           string
+		STSRegion         string
+		STSEndpoint       string
+		Retry             *Retry
+		CredentialTimeout caddy.Duration
+	}{region, endpoint, profile, fs.STSRegion, fs.STSEndpoint, fs.Retry, fs.CredentialTimeout})
+	sum := sha256.Sum256(settings)
+	return hex.EncodeToString(sum[:])
+}
+
+// sharedConfig returns the AWS configuration for the given connection
+// settings, loading it unless another filesystem uses the same settings.
+// Clients created from it share a single connection pool. The configuration
+// is released by Cleanup.
+func (fs *FS) sharedConfig(region, endpoint, profile string) (aws.Config, error) {
+	key := fs.configKey(region, endpoint, profile)
+	sharedConfigs.Lock()
+	defer sharedConfigs.Unlock()
+	shared := sharedConfigs.entries[key]
+	if shared == nil {
+		cfg, err := fs.newConfig(region, endpoint, profile)
+		if err != nil {
+			return cfg, err
+		}
+		if b, ok := cfg.HTTPClient.(*awshttp.BuildableClient); ok {
+			// S3 clients configure and build their own copy of a
+			// buildable client, each with its own connections
+			cfg.HTTPClient = b.Freeze()
+		}
+		shared = &sharedConfig{cfg: cfg}
+		sharedConfigs.entries[key] = shared
+	}
+	shared.refs++
+	fs.configs = append(fs.configs, key)
+	return shared.cfg, nil
+}
+
+// releaseConfigs releases the configurations returned by sharedConfig,
+// closing the idle connections of the ones no longer used.
+func (fs *FS) releaseConfigs() {
+	sharedConfigs.Lock()
+	defer sharedConfigs.Unlock()
+	for _, key := range fs.configs {
+		shared := sharedConfigs.entries[key]
+		if shared == nil {
+			continue
+		}
+		if shared.refs--; shared.refs > 0 {
+			continue
+		}
+		delete(sharedConfigs.entries, key)
+		if ic, ok := shared.cfg.HTTPClient.(interface{ CloseIdleConnections() }); ok {
+			ic.CloseIdleConnections()
+		}
+	}
+	fs.configs = nil
+}
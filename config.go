@@ -0,0 +1,767 @@
+package caddys3fs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+// Config holds all options of the filesystem, as found in the JSON config.
+// Infrastructure-as-code tools may build it programmatically, check it with
+// Validate and describe it using JSONSchema.
+type Config struct {
+	// The name of the S3 bucket. It may contain placeholders resolved per
+	// request, e.g. `{host}-assets`, serving each bucket through its own
+	// caches; the file_server then requires the s3fs_log handler in the
+	// route. Options bound to a single bucket are not supported with
+	// placeholders. Instead of a name it may be the ARN of the bucket, of an
+	// access point, an S3 Object Lambda access point or an Outposts access
+	// point, whose region is used if no region is configured.
+	Bucket string `json:"bucket,omitempty"`
+
+	// The AWS region the bucket is hosted in. Requests to access point ARNs
+	// go to the region of the ARN.
+	Region string `json:"region,omitempty"`
+
+	// The AWS profile to use if mulitple profiles are specified.
+	Profile string `json:"profile,omitempty"`
+
+	// Use non-standard endpoint for S3.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Set this to `true` to force the request to use path-style addressing.
+	S3ForcePathStyle bool `json:"force_path_style,omitempty"`
+
+	// Maximum number of entries a full directory listing may return before
+	// failing. Defaults to 100000, a negative value disables the limit.
+	MaxListEntries int `json:"max_list_entries,omitempty"`
+
+	// Maximum number of ListObjectsV2 pages a full directory listing may
+	// request before failing. Defaults to 200, a negative value disables the limit.
+	MaxListPages int `json:"max_list_pages,omitempty"`
+
+	// Order of full directory listings: `name`, `modtime` or `size`.
+	// By default entries are returned in the order S3 lists them.
+	Sort string `json:"sort,omitempty"`
+
+	// Reverse the configured sort order, e.g. to list newest files first.
+	SortDescending bool `json:"sort_descending,omitempty"`
+
+	// Sliding window over which requests and bytes served per key are
+	// tracked for the hot-key report of the admin API. Disabled if unset.
+	HotKeysWindow caddy.Duration `json:"hot_keys_window,omitempty"`
+
+	// Number of keys included in the hot-key report. Defaults to 100.
+	HotKeysTop int `json:"hot_keys_top,omitempty"`
+
+	// Optimize the filesystem for running as the origin behind a CDN.
+	// This single switch disables directory listings (opening a directory
+	// is denied, index files are still resolved) and collapses concurrent
+	// lookups of the same key into one S3 request. The file_server only
+	// sends the S3 ETag as strong validator, and evaluates If-Match and
+	// If-None-Match against it, behind the s3fs_etag handler.
+	OriginMode bool `json:"origin_mode,omitempty"`
+
+	// Collapse concurrent stats of the same key or directory into a single
+	// S3 request, sharing its result. Implied by OriginMode.
+	CollapseRequests bool `json:"collapse_requests,omitempty"`
+
+	// Make every read conditional (If-Match/If-Unmodified-Since) on the
+	// metadata observed when the file was opened, failing the read if the
+	// object changed in the meantime.
+	StrictConsistency bool `json:"strict_consistency,omitempty"`
+
+	// Report access denied responses as missing files: "always", or
+	// "detect" to do so only if S3 denies reading a key that does not
+	// exist, as it does without s3:ListBucket. Lets sites run with only
+	// s3:GetObject and still serve 404 pages. Directories can't be listed
+	// without s3:ListBucket and are reported as missing as well.
+	ForbiddenAsNotExist string `json:"forbidden_as_not_exist,omitempty"`
+
+	// Accept the charges of buckets with Requester Pays enabled, which
+	// deny all requests not acknowledging them.
+	RequesterPays bool `json:"requester_pays,omitempty"`
+
+	// Allow modules such as WebDAV handlers or upload endpoints to create,
+	// replace, rename and remove objects and create directories through
+	// the filesystem. Files are written when they are closed. Filesystems
+	// with an overlay or a bucket placeholder stay read-only.
+	Writable bool `json:"writable,omitempty"`
+
+	// Allow a writable filesystem to remove directories with all objects
+	// below them in one call, e.g. for WebDAV DELETE of a collection.
+	AllowRemoveAll bool `json:"allow_remove_all,omitempty"`
+
+	// Server-side encryption of the objects written through a writable
+	// filesystem, the default encryption of the bucket if unset.
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Customer-provided key (SSE-C) of the objects served, sent with every
+	// read and write. Requires an encrypted disk_cache, if any.
+	CustomerKey *CustomerKey `json:"customer_key,omitempty"`
+
+	// Stream large files written through a writable filesystem to S3 as
+	// multipart uploads instead of buffering them in memory.
+	Multipart *Multipart `json:"multipart,omitempty"`
+
+	// A replica of the bucket, e.g. in another region, which can serve
+	// the same content. Reads failing on the bucket with a server error,
+	// throttling, a timeout or a network error are retried on the replica,
+	// and once the bucket failed repeatedly all reads go to the replica
+	// for a cooldown.
+	Fallback *Fallback `json:"fallback,omitempty"`
+
+	// Continuously probe the bucket and its fallback at this interval and
+	// route reads of each key-hash partition to the faster one, instead of
+	// using the fallback only on failure. Requires a fallback.
+	LatencyRouting caddy.Duration `json:"latency_routing,omitempty"`
+
+	// Prefetch the metadata of this many files of each directory listing
+	// in the background, so opening them afterwards needs no S3 request.
+	// Disabled if unset.
+	PrefetchCount int `json:"prefetch_count,omitempty"`
+
+	// Maximum number of concurrent prefetch requests. Defaults to 4.
+	PrefetchConcurrency int `json:"prefetch_concurrency,omitempty"`
+
+	// How long prefetched metadata is kept if the stat cache is disabled.
+	// Defaults to 1m.
+	PrefetchTTL caddy.Duration `json:"prefetch_ttl,omitempty"`
+
+	// Cache the metadata of files and directories for this long, so
+	// repeated requests for the same key are served without a HeadObject.
+	// Disabled if unset.
+	StatCacheTTL caddy.Duration `json:"stat_cache_ttl,omitempty"`
+
+	// Maximum number of cached stat results. Defaults to 10000.
+	StatCacheSize int `json:"stat_cache_size,omitempty"`
+
+	// Wait at most this long for S3 to refresh expired metadata of the stat
+	// cache while it is still younger than StaleMaxAge, serving the expired
+	// metadata and the contents cached for it if S3 did not respond in time
+	// or failed, and refreshing it in the background. Disabled if unset.
+	StaleBudget caddy.Duration `json:"stale_budget,omitempty"`
+
+	// Maximum time since the expiry of metadata served by StaleBudget.
+	// Defaults to 1h.
+	StaleMaxAge caddy.Duration `json:"stale_max_age,omitempty"`
+
+	// Remember for this long that a path does not exist, so repeated
+	// requests for missing files, e.g. favicon.ico or try_files candidates,
+	// are answered without a HeadObject and a ListObjectsV2. Keep it short,
+	// files uploaded in the meantime stay hidden until it expires. Disabled
+	// if unset.
+	NotFoundCacheTTL caddy.Duration `json:"not_found_cache_ttl,omitempty"`
+
+	// Maximum number of cached missing paths. Defaults to 10000.
+	NotFoundCacheSize int `json:"not_found_cache_size,omitempty"`
+
+	// Regular expressions of keys never cached as missing, e.g.
+	// `^uploads/` for destinations where new keys must become visible
+	// immediately. Keys are matched without a leading slash.
+	NotFoundCacheExclude []string `json:"not_found_cache_exclude,omitempty"`
+
+	// Key prefix the filesystem is mounted at, e.g. `sites/example.com/`
+	// to serve one of several sites from a bucket. Paths can't reach keys
+	// outside of it. It may contain placeholders resolved per request, e.g.
+	// `tenants/{host}/public/`, requiring the s3fs_log handler in the route
+	// of the file_server; a placeholder resolving to an empty value or to a
+	// value containing a slash fails the request. Placeholders can't be
+	// combined with `prefix_layers`.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Key prefixes paths are looked up below in order, serving the first
+	// layer a path exists in, e.g. `overrides/`, `current/` and `defaults/`
+	// for layered content. An empty prefix or `/` stands for the bucket
+	// root. Directories are listed from the first layer they exist in.
+	// Layers are below `prefix` if set.
+	PrefixLayers []string `json:"prefix_layers,omitempty"`
+
+	// Remember for this long that a path does not exist in a layer, so
+	// paths served from later layers don't cost lookups in the earlier
+	// ones on every request. Disabled if unset.
+	PrefixLayerMissTTL caddy.Duration `json:"prefix_layer_miss_ttl,omitempty"`
+
+	// Buckets, prefixes or local directories layered with the bucket, e.g.
+	// a bucket of shared base assets below a bucket of site specific
+	// overrides, or a directory of a few locally patched files above it.
+	// Paths are served from the first source they exist in: the sources
+	// marked above in order, the bucket and prefix of the filesystem
+	// itself, then the other sources in order. Directories list the merged
+	// entries of all sources. Buckets are read with the same connection
+	// settings and options as the bucket, each with its own caches.
+	Overlay []OverlaySource `json:"overlay,omitempty"`
+
+	// Suffixes of the candidates looked up in parallel whenever a path
+	// without extension is looked up, e.g. `/index.html` for the index
+	// files of the file_server and `.html` for try_files fallbacks, so
+	// resolving them takes the time of one lookup instead of one each.
+	ProbeCandidates []string `json:"probe_candidates,omitempty"`
+
+	// Memory budget in bytes for keeping the content of small objects,
+	// e.g. CSS, JS and icons, in memory. Objects with the same ETag share
+	// their cached content. Disabled if unset.
+	ContentCacheSize int64 `json:"content_cache_size,omitempty"`
+
+	// Size in bytes up to which the content of objects is cached.
+	// Defaults to 256KiB.
+	ContentCacheMaxObjectSize int64 `json:"content_cache_max_object_size,omitempty"`
+
+	// Maximum number of concurrent S3 requests. When saturated, metadata
+	// requests and small objects (up to 1MiB) are served before range
+	// requests of large objects. Unlimited if unset.
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+
+	// Adapt the concurrency limit between this minimum and
+	// MaxConcurrentRequests, backing off once S3 throttles requests or
+	// responds slower than ConcurrencyLatencyTarget. Static if unset.
+	MinConcurrentRequests int `json:"min_concurrent_requests,omitempty"`
+
+	// Response time above which the adaptive concurrency limit is lowered.
+	// Only throttling lowers it if unset.
+	ConcurrencyLatencyTarget caddy.Duration `json:"concurrency_latency_target,omitempty"`
+
+	// Maximum number of S3 requests per second, e.g. so a crawler walking
+	// deep directory trees cannot cause unbounded request costs. Requests
+	// beyond the rate wait for their turn. Unlimited if unset.
+	RequestRate float64 `json:"request_rate,omitempty"`
+
+	// Number of requests that may exceed RequestRate in a burst. Defaults
+	// to RequestRate.
+	RequestBurst int `json:"request_burst,omitempty"`
+
+	// Track the throughput of every open file and report files delivering
+	// less than this many bytes per second as slow streams through the
+	// admin API. Disabled if unset.
+	SlowStreamThreshold int64 `json:"slow_stream_threshold,omitempty"`
+
+	// Name of the user metadata field (without the `x-amz-meta-` prefix)
+	// holding the modification time of objects, e.g. the original file
+	// mtime recorded at upload. Defaults to the S3 LastModified timestamp.
+	ModTimeMetadata string `json:"mod_time_metadata,omitempty"`
+
+	// Truncate modification times, and thus Last-Modified and the ETag
+	// derived from it, to multiples of this duration, e.g. `1s` to agree
+	// with other origins storing whole seconds.
+	ModTimePrecision caddy.Duration `json:"mod_time_precision,omitempty"`
+
+	// Round modification times to ModTimePrecision instead of truncating.
+	ModTimeRound bool `json:"mod_time_round,omitempty"`
+
+	// Ordered list of credential sources to fall back to once the active
+	// one is consistently denied access (403), e.g. for hybrid public/private
+	// buckets during permission migrations. The default credentials (or
+	// profile) are always tried first.
+	CredentialFallback []CredentialSource `json:"credential_fallback,omitempty"`
+
+	// Number of consecutive 403 responses after which the next credential
+	// source is used. Defaults to 5.
+	CredentialFallbackThreshold int `json:"credential_fallback_threshold,omitempty"`
+
+	// Expose prior versions of every object through a virtual `.versions`
+	// directory, e.g. `/docs/.versions/report.pdf/` lists all versions of
+	// `/docs/report.pdf`, which can be opened read-only by their version ID.
+	VersionsDirectory bool `json:"versions_directory,omitempty"`
+
+	// Only serve objects carrying this tag, e.g. `public=true`. Other
+	// objects are treated as nonexistent and hidden from listings.
+	RequiredTag string `json:"required_tag,omitempty"`
+
+	// How long object tags are cached. Defaults to 5m.
+	TagCacheTTL caddy.Duration `json:"tag_cache_ttl,omitempty"`
+
+	// Name of the user metadata field (without the `x-amz-meta-` prefix)
+	// holding the expiry time of objects as RFC 3339 timestamp or unix
+	// seconds. Expired objects are treated as nonexistent.
+	ExpiryMetadata string `json:"expiry_metadata,omitempty"`
+
+	// Name of the object tag holding the expiry time of objects. Unlike
+	// metadata, tags also hide expired objects from listings.
+	ExpiryTag string `json:"expiry_tag,omitempty"`
+
+	// Patterns of keys that never change, e.g. `assets/hashed/**` for
+	// fingerprinted assets. Their metadata is cached forever after the
+	// first lookup and reads are never made conditional.
+	Immutable []string `json:"immutable,omitempty"`
+
+	// Patterns of keys read without readahead, requesting exactly the
+	// bytes read, e.g. for backends billing per byte-range returned.
+	ExactRanges []string `json:"exact_ranges,omitempty"`
+
+	// Inject artificial faults into requests to S3, for resilience testing
+	// only.
+	FaultInjection *FaultInjection `json:"fault_injection,omitempty"`
+
+	// Copy served objects to a local directory or second bucket, e.g. to
+	// retain exact copies of delivered content.
+	Archive *Archive `json:"archive,omitempty"`
+
+	// Only serve objects whose signature sidecar verifies against a public
+	// key, e.g. for mirrors of software downloads.
+	SignatureVerification *SignatureVerification `json:"signature_verification,omitempty"`
+
+	// Cache whole large objects on local disk, so repeated downloads of
+	// big files are not streamed from S3 every time.
+	DiskCache *DiskCache `json:"disk_cache,omitempty"`
+
+	// Periodically export the number of requests and last access time per
+	// key prefix to a local file or key.
+	AccessExport *AccessExport `json:"access_export,omitempty"`
+
+	// Periodically check a canary object, alerting when it can't be
+	// fetched or is stale.
+	Canary *Canary `json:"canary,omitempty"`
+
+	// Warm the caches with the objects of a new release when triggered
+	// through the `/s3fs/preposition` admin endpoint, keeping the heads of
+	// larger objects in memory. Disabled if unset.
+	Preposition *Preposition `json:"preposition,omitempty"`
+
+	// Region of the STS endpoint used to assume roles and exchange web
+	// identity tokens. Defaults to the region of the bucket.
+	STSRegion string `json:"sts_region,omitempty"`
+
+	// Use non-standard endpoint for STS, e.g. an interface VPC endpoint.
+	STSEndpoint string `json:"sts_endpoint,omitempty"`
+
+	// Maximum time to wait for credentials to be resolved, including the
+	// STS calls and their retries. Provisioning fails if they can't be
+	// resolved within it. Defaults to 10s.
+	CredentialTimeout caddy.Duration `json:"credential_timeout,omitempty"`
+
+	// Access the bucket through an S3 interface endpoint (PrivateLink) set
+	// as endpoint. Endpoints are resolved on provision and denied requests
+	// name the action the endpoint policy needs to allow.
+	VPCEndpoint bool `json:"vpc_endpoint,omitempty"`
+
+	// Maximum time to wait for open files to be read to the end once the
+	// config is unloaded. Opening files fails in the meantime. Defaults to
+	// 10s, negative values disable draining.
+	DrainTimeout caddy.Duration `json:"drain_timeout,omitempty"`
+
+	// Limit listings of date-partitioned prefixes (`year=/month=/day=` or
+	// `dt=YYYY-MM-DD`) to the partitions of this many recent days, skipping
+	// older partitions without scanning them. Disabled if unset.
+	PartitionWindowDays int `json:"partition_window_days,omitempty"`
+
+	// Key of single-level date partitions. Defaults to `dt`.
+	PartitionDateKey string `json:"partition_date_key,omitempty"`
+
+	// Present Hive-style partition directories of these keys by their
+	// value only, e.g. `year=2024/month=06` as `2024/06`, and map such paths
+	// back when serving them.
+	PartitionDisplay []string `json:"partition_display,omitempty"`
+
+	// Number of bytes requested beyond each read, e.g. `1MiB` for large
+	// media, or -1 (`off`) to request only the bytes read. Defaults to 64KiB.
+	Readahead int64 `json:"readahead,omitempty"`
+
+	// Grow the readahead of sequentially read files up to this many bytes,
+	// doubling it with every range, e.g. `8MiB` for large downloads.
+	// Disabled if unset.
+	ReadaheadMax int64 `json:"readahead_max,omitempty"`
+
+	// Download objects larger than this many bytes in parts of this size
+	// using concurrent ranged GETs, e.g. `16MiB` for multi-hundred-MB
+	// downloads. Disabled if unset.
+	ParallelPartSize int64 `json:"parallel_part_size,omitempty"`
+
+	// Maximum number of parts of a single download fetched at the same
+	// time. Defaults to 4.
+	ParallelConcurrency int `json:"parallel_concurrency,omitempty"`
+
+	// Parameters overriding the defaults for keys matching patterns, e.g.
+	// per route of a site. The first matching override applies.
+	Overrides []Override `json:"overrides,omitempty"`
+
+	// Limits of the bytes served per tenant, i.e. key prefix, so a single
+	// tenant cannot use up a shared egress budget. Files of tenants
+	// exceeding their quota are answered with 503 until the period ends.
+	Quotas []Quota `json:"quotas,omitempty"`
+
+	// Timeouts of S3 calls, so a slow or hung endpoint can't stall
+	// requests indefinitely. Calls are unbounded if unset.
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+
+	// Export Prometheus metrics of the S3 requests, bytes transferred and
+	// cache lookups through Caddy's metrics endpoint.
+	Metrics bool `json:"metrics,omitempty"`
+
+	// Emit OpenTelemetry spans of the S3 calls of requests traced by
+	// Caddy's tracing handler.
+	// Calls of the file_server are attributed to requests by the s3fs_log
+	// handler, which must be in the route.
+	Tracing bool `json:"tracing,omitempty"`
+
+	// Log every S3 call with its operation, key, range, duration, HTTP
+	// status and request ID. The entries are logged at debug level, which
+	// the log has to be configured for.
+	VerboseLogging bool `json:"verbose_logging,omitempty"`
+
+	// Only serve metadata and directory listings, for credentials lacking
+	// s3:GetObject. Requests for file contents are answered with 403.
+	MetadataOnly bool `json:"metadata_only,omitempty"`
+
+	// Retry policy of the S3 clients, the SDK defaults apply if unset.
+	Retry *Retry `json:"retry,omitempty"`
+
+	// Circuit breaker failing S3 calls fast once too many of them failed,
+	// answering requests with 503 instead of piling them up while the
+	// bucket or endpoint is down. Disabled if unset.
+	CircuitBreaker *CircuitBreaker `json:"circuit_breaker,omitempty"`
+
+	// Probe the features the endpoint supports at provision time, e.g. of
+	// an S3 compatible store, disabling the optimizations it can't handle.
+	ProbeCapabilities bool `json:"probe_capabilities,omitempty"`
+
+	// Key of the object reads are probed with. Defaults to the canary key
+	// or the first object listed.
+	ProbeKey string `json:"probe_key,omitempty"`
+}
+
+// CircuitBreaker opens once too many S3 calls failed within a window.
+type CircuitBreaker struct {
+	// Failed calls within Window opening the breaker. Defaults to 5.
+	Failures int `json:"failures,omitempty"`
+
+	// Percentage (0-100) of failed calls within Window additionally
+	// required to open the breaker.
+	ErrorPercent float64 `json:"error_percent,omitempty"`
+
+	// Period failures are counted over. Defaults to 10s.
+	Window caddy.Duration `json:"window,omitempty"`
+
+	// Time the breaker stays open before a trial call is let through.
+	// Defaults to 30s.
+	Cooldown caddy.Duration `json:"cooldown,omitempty"`
+}
+
+// Timeouts bound S3 calls by operation.
+type Timeouts struct {
+	// Time HeadObject and other metadata calls may take.
+	Head caddy.Duration `json:"head,omitempty"`
+
+	// Time until GetObject responds, excluding reading the body.
+	FirstByte caddy.Duration `json:"first_byte,omitempty"`
+
+	// Time a single ListObjectsV2 call may take.
+	List caddy.Duration `json:"list,omitempty"`
+}
+
+// Quota limits the bytes served below a key prefix.
+type Quota struct {
+	// Key prefix of the tenant, empty for the whole filesystem.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Bytes that may be served per UTC day.
+	Daily int64 `json:"daily,omitempty"`
+
+	// Bytes that may be served per UTC month.
+	Monthly int64 `json:"monthly,omitempty"`
+}
+
+// Preposition selects the objects warmed on deploys and the memory kept for
+// their heads.
+type Preposition struct {
+	// Bytes of the head of each object kept in memory. Defaults to 16KiB.
+	HeadSize int64 `json:"head_size,omitempty"`
+
+	// Memory used for heads in total. Defaults to 64MiB.
+	CacheSize int64 `json:"cache_size,omitempty"`
+
+	// Extensions of the keys warmed. Defaults to .html, .css and .js.
+	Extensions []string `json:"extensions,omitempty"`
+
+	// Maximum number of objects warmed per trigger. Defaults to 1000.
+	MaxObjects int `json:"max_objects,omitempty"`
+
+	// Objects warmed in parallel. Defaults to 4.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// Encryption configures the server-side encryption of written objects.
+type Encryption struct {
+	// Either sse-s3 or sse-kms.
+	Type string `json:"type,omitempty"`
+
+	// ID or ARN of the KMS key of sse-kms. Defaults to the AWS managed key
+	// of S3.
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+
+	// Use an S3 Bucket Key with sse-kms, reducing the requests to KMS.
+	BucketKey bool `json:"bucket_key,omitempty"`
+}
+
+// CustomerKey configures the customer-provided key (SSE-C) objects are
+// encrypted with.
+type CustomerKey struct {
+	// Path of a file holding the 32 byte AES-256 key, raw, hex or base64
+	// encoded.
+	KeyFile string `json:"key_file,omitempty"`
+
+	// Base64 encoded MD5 digest of the key, verified against the key if
+	// set.
+	KeyMD5 string `json:"key_md5,omitempty"`
+}
+
+// Multipart configures multipart uploads of written files.
+type Multipart struct {
+	// Size above which files are uploaded in parts. Defaults to the part
+	// size.
+	Threshold int64 `json:"threshold,omitempty"`
+
+	// Size of the parts, at least 5MiB. Defaults to 8MiB.
+	PartSize int64 `json:"part_size,omitempty"`
+
+	// Parts uploaded in parallel per file. Defaults to 4.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// OverlaySource is a bucket, prefix or local directory layered with the
+// filesystem.
+type OverlaySource struct {
+	// Name of the bucket, the bucket of the filesystem if unset.
+	Bucket string `json:"bucket,omitempty"`
+
+	// Key prefix the source is mounted at.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Local directory served instead of a bucket.
+	Dir string `json:"dir,omitempty"`
+
+	// Serve the source in preference to the bucket of the filesystem
+	// rather than as a fallback.
+	Above bool `json:"above,omitempty"`
+}
+
+// bucketSources reports whether sources include buckets or prefixes.
+func bucketSources(sources []OverlaySource) bool {
+	for _, src := range sources {
+		if src.Dir == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Override changes parameters for the keys matching any of its patterns.
+type Override struct {
+	// Patterns of keys, a `**` suffix matches everything below a prefix.
+	Match []string `json:"match,omitempty"`
+
+	// Number of bytes requested beyond each read, -1 disables readahead.
+	Readahead int64 `json:"readahead,omitempty"`
+
+	// Lifetime of cached metadata of the keys.
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+}
+
+// buckets returns the names of all buckets configured.
+func (c *Config) buckets() []string {
+	buckets := []string{c.Bucket}
+	if c.Fallback != nil {
+		buckets = append(buckets, c.Fallback.Bucket)
+	}
+	for _, src := range c.Overlay {
+		if src.Bucket != "" {
+			buckets = append(buckets, src.Bucket)
+		}
+	}
+	return buckets
+}
+
+// Validate checks the configuration for errors not requiring access to AWS.
+func (c *Config) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("bucket must be set")
+	}
+	for _, bucket := range c.buckets() {
+		if err := validateBucketARN(bucket); err != nil {
+			return err
+		}
+	}
+	if c.S3ForcePathStyle && arn.IsARN(arnBucket(c.Bucket)) {
+		return errors.New("force_path_style can't be used with an access point ARN")
+	}
+	if hasPlaceholder(c.Bucket) {
+		if err := c.validateSingleBucket("placeholders in the bucket " + c.Bucket); err != nil {
+			return err
+		}
+	}
+	if bucketSources(c.Overlay) {
+		if err := c.validateSingleBucket("overlay buckets"); err != nil {
+			return err
+		}
+	}
+	if len(c.Overlay) > 0 {
+		if hasPlaceholder(c.Bucket) || hasPlaceholder(c.Prefix) {
+			return errors.New("overlay can't be combined with placeholders in the bucket or prefix")
+		}
+		for i, src := range c.Overlay {
+			if src.Dir != "" && (src.Bucket != "" || src.Prefix != "") {
+				return fmt.Errorf("overlay source %d can't set a directory and a bucket or prefix", i)
+			}
+			if src.Dir == "" && src.Bucket == "" && src.Prefix == "" {
+				return fmt.Errorf("overlay source %d requires a bucket, prefix or directory", i)
+			}
+			if hasPlaceholder(src.Bucket) || hasPlaceholder(src.Prefix) {
+				return fmt.Errorf("overlay source %d can't contain placeholders", i)
+			}
+		}
+	}
+	if hasPlaceholder(c.Prefix) && len(c.PrefixLayers) > 0 {
+		return errors.New("prefix_layers can't be combined with placeholders in the prefix")
+	}
+	if _, err := s3fs.ParseListOrder(c.Sort); err != nil {
+		return err
+	}
+	if _, err := s3fs.ParseForbiddenMode(c.ForbiddenAsNotExist); err != nil {
+		return err
+	}
+	if c.AllowRemoveAll && !c.Writable {
+		return errors.New("allow_remove_all requires writable")
+	}
+	if e := c.Encryption; e != nil {
+		if !c.Writable {
+			return errors.New("encryption requires writable")
+		}
+		switch e.Type {
+		case "sse-s3":
+			if e.KMSKeyID != "" || e.BucketKey {
+				return errors.New("encryption sse-s3 can't be combined with a KMS key or bucket_key")
+			}
+		case "sse-kms":
+		default:
+			return fmt.Errorf("unknown encryption type %q, must be sse-s3 or sse-kms", e.Type)
+		}
+	}
+	if k := c.CustomerKey; k != nil {
+		if k.KeyFile == "" {
+			return errors.New("customer key file must be set")
+		}
+		if c.Encryption != nil {
+			return errors.New("customer_key can't be combined with encryption")
+		}
+		if d := c.DiskCache; d != nil && d.EncryptionKey == "" && d.KMSKeyID == "" {
+			return errors.New("customer_key requires disk_cache encryption, objects would be cached in plaintext")
+		}
+	}
+	if c.Multipart != nil {
+		if !c.Writable {
+			return errors.New("multipart requires writable")
+		}
+		if c.Multipart.PartSize != 0 && c.Multipart.PartSize < 5<<20 {
+			return fmt.Errorf("multipart part_size %d below the S3 minimum of 5MiB", c.Multipart.PartSize)
+		}
+	}
+	if c.RequiredTag != "" {
+		if key, _, ok := strings.Cut(c.RequiredTag, "="); !ok || key == "" {
+			return fmt.Errorf("invalid required_tag %q, must be key=value", c.RequiredTag)
+		}
+	}
+	for i, src := range c.CredentialFallback {
+		n := 0
+		for _, set := range []bool{src.Profile != "", src.RoleARN != "", src.Anonymous} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("credential source %d requires exactly one of profile, role_arn or anonymous", i)
+		}
+	}
+	if c.Fallback != nil && c.Fallback.Bucket == "" {
+		return errors.New("fallback bucket must be set")
+	}
+	if c.VPCEndpoint && c.Endpoint == "" {
+		return errors.New("vpc_endpoint requires the endpoint of the S3 interface endpoint to be set")
+	}
+	if a := c.Archive; a != nil && (a.Directory == "") == (a.Bucket == "") {
+		return errors.New("archive requires either a directory or a bucket")
+	}
+	if v := c.SignatureVerification; v != nil {
+		if v.Format != "cosign" && v.Format != "minisign" {
+			return fmt.Errorf("invalid signature format %q, must be cosign or minisign", v.Format)
+		}
+		if v.PublicKey == "" {
+			return errors.New("signature verification requires a public key")
+		}
+	}
+	for _, expr := range c.NotFoundCacheExclude {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid not_found_cache exclude %q: %v", expr, err)
+		}
+	}
+	if f := c.FaultInjection; f != nil {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	if c.StaleBudget > 0 && c.StatCacheTTL <= 0 {
+		return errors.New("stale_fallback requires stat_cache")
+	}
+	if c.RequestRate < 0 || c.RequestBurst < 0 {
+		return errors.New("request rate and burst must not be negative")
+	}
+	if c.MinConcurrentRequests > 0 && c.MaxConcurrentRequests <= 0 {
+		return errors.New("adaptive concurrency requires max_concurrent_requests")
+	}
+	if c.DiskCache != nil && c.DiskCache.Directory == "" {
+		return errors.New("disk cache directory must be set")
+	}
+	if c.DiskCache != nil && c.DiskCache.EncryptionKey != "" && c.DiskCache.KMSKeyID != "" {
+		return errors.New("disk cache encryption requires either a key file or a KMS key, not both")
+	}
+	if c.Retry != nil {
+		if err := c.Retry.validate(); err != nil {
+			return err
+		}
+	}
+	if b := c.CircuitBreaker; b != nil && (b.Failures < 0 || b.ErrorPercent < 0 || b.ErrorPercent > 100) {
+		return errors.New("circuit breaker requires a positive number of failures and an error percentage between 0 and 100")
+	}
+	if c.Canary != nil && c.Canary.Key == "" {
+		return errors.New("canary key must be set")
+	}
+	if e := c.AccessExport; e != nil && (e.File == "") == (e.Key == "") {
+		return errors.New("access export requires either a file or a key")
+	}
+	for i, q := range c.Quotas {
+		if q.Daily <= 0 && q.Monthly <= 0 {
+			return fmt.Errorf("quota %d requires a daily or monthly limit", i)
+		}
+	}
+	for i, o := range c.Overrides {
+		if len(o.Match) == 0 {
+			return fmt.Errorf("override %d requires at least one pattern", i)
+		}
+	}
+	return nil
+}
+
+// validateSingleBucket rejects the options bound to a single bucket if the
+// filesystem serves several of them with feature.
+func (c *Config) validateSingleBucket(feature string) error {
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"fallback", c.Fallback != nil},
+		{"archive", c.Archive != nil},
+		{"disk_cache", c.DiskCache != nil},
+		{"canary", c.Canary != nil},
+		{"preposition", c.Preposition != nil},
+		{"access_export", c.AccessExport != nil},
+		{"probe_capabilities", c.ProbeCapabilities},
+	} {
+		if o.set {
+			return fmt.Errorf("%s is not supported with %s", o.name, feature)
+		}
+	}
+	return nil
+}
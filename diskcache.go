@@ -0,0 +1,180 @@
+package caddys3fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+const (
+	defaultDiskCacheMaxSize       = 10 << 30
+	defaultDiskCacheMinObjectSize = 1 << 20
+
+	// diskCacheKeyFile is the file in the cache directory holding the
+	// KMS encrypted key of the cache.
+	diskCacheKeyFile = ".key"
+
+	// diskCacheKeyTimeout bounds obtaining the key from KMS.
+	diskCacheKeyTimeout = 30 * time.Second
+)
+
+// DiskCache configures a cache of whole objects on local disk, for large
+// objects downloaded repeatedly.
+type DiskCache struct {
+	// Local directory to store cached objects in. It should not be shared
+	// with anything but other instances of the same filesystem.
+	Directory string `json:"directory,omitempty"`
+
+	// Maximum total size in bytes of cached objects. Defaults to 10GiB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// Size in bytes from which objects are cached. Defaults to 1MiB,
+	// smaller objects are better kept in the content cache.
+	MinObjectSize int64 `json:"min_object_size,omitempty"`
+
+	// Path of a file holding the 32 byte key encrypting cached content
+	// with AES-256-GCM, raw, hex or base64 encoded.
+	EncryptionKey string `json:"encryption_key,omitempty"`
+
+	// KMS key to generate the key encrypting cached content with. The
+	// generated key is kept encrypted by KMS in the cache directory, so
+	// content cached by earlier runs stays readable.
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+}
+
+// options returns the s3fs options caching objects on disk.
+func (c *DiskCache) options(ctx caddy.Context, cfg aws.Config) ([]s3fs.Option, error) {
+	if c.MaxSize == 0 {
+		c.MaxSize = defaultDiskCacheMaxSize
+	}
+	if c.MinObjectSize == 0 {
+		c.MinObjectSize = defaultDiskCacheMinObjectSize
+	}
+	opts := []s3fs.Option{s3fs.WithDiskCache(ctx, c.Directory, c.MaxSize, c.MinObjectSize)}
+	if c.EncryptionKey == "" && c.KMSKeyID == "" {
+		return opts, nil
+	}
+	key, err := c.key(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("disk cache key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, s3fs.WithDiskCacheEncryption(aead)), nil
+}
+
+// key returns the key encrypting cached content, reading it from the
+// configured file or obtaining it from KMS.
+func (c *DiskCache) key(ctx context.Context, cfg aws.Config) ([]byte, error) {
+	if c.EncryptionKey != "" {
+		data, err := os.ReadFile(c.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		return decodeKey(data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, diskCacheKeyTimeout)
+	defer cancel()
+	client := kms.NewFromConfig(cfg)
+	path := filepath.Join(c.Directory, diskCacheKeyFile)
+	blob, err := os.ReadFile(path)
+	if err == nil {
+		res, err := client.Decrypt(ctx, &kms.DecryptInput{
+			CiphertextBlob: blob,
+			KeyId:          aws.String(c.KMSKeyID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res.Plaintext, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	res, err := client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(c.KMSKeyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.Directory, 0o750); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, res.CiphertextBlob, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+// decodeKey decodes a 32 byte key given raw, hex or base64 encoded.
+func decodeKey(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, errors.New("key must be 32 bytes, raw, hex or base64 encoded")
+}
+
+// unmarshalDiskCache parses the disk_cache block.
+func unmarshalDiskCache(d *caddyfile.Dispenser) (*DiskCache, error) {
+	c := new(DiskCache)
+	if !d.AllArgs(&c.Directory) {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "max_size":
+			if err := parseSize(d, &c.MaxSize); err != nil {
+				return nil, err
+			}
+		case "min_object_size":
+			if err := parseSize(d, &c.MinObjectSize); err != nil {
+				return nil, err
+			}
+		case "encryption_key":
+			if !d.AllArgs(&c.EncryptionKey) {
+				return nil, d.ArgErr()
+			}
+		case "kms_key":
+			if !d.AllArgs(&c.KMSKeyID) {
+				return nil, d.ArgErr()
+			}
+		default:
+			return nil, d.Errf("%s not a valid disk_cache option", d.Val())
+		}
+	}
+	return c, nil
+}
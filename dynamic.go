@@ -0,0 +1,268 @@
+package caddys3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/floj/caddy-s3fs/s3fs"
+	"go.uber.org/zap"
+)
+
+// ErrUnresolvedBucket is returned for calls of a filesystem with a bucket
+// placeholder which cannot be associated with a request: the file_server
+// does not pass the request on, so the s3fs_log handler has to precede it.
+var ErrUnresolvedBucket = errors.New("bucket placeholders require the s3fs_log handler in the route")
+
+// ErrUnresolvedPrefix is returned for calls of a filesystem with a prefix
+// placeholder which cannot be associated with a request.
+var ErrUnresolvedPrefix = errors.New("prefix placeholders require the s3fs_log handler in the route")
+
+// maxDynamicBuckets bounds the number of filesystems of a bucket placeholder
+// kept open, the least recently used one is closed beyond.
+const maxDynamicBuckets = 100
+
+// bucketName matches valid S3 bucket names.
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// hasPlaceholder reports whether bucket is resolved per request.
+func hasPlaceholder(bucket string) bool {
+	return strings.Contains(bucket, "{")
+}
+
+// prefixResolver returns a function resolving the prefix template for the
+// request of a context. Placeholders must resolve to a single non-empty path
+// segment, so requests can't reach the subtrees of other values.
+func prefixResolver(template string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		if !ok {
+			return "", ErrUnresolvedPrefix
+		}
+		return repl.ReplaceFunc(template, func(variable string, val any) (any, error) {
+			v := caddy.ToString(val)
+			if v == "" || v == "." || v == ".." || strings.Contains(v, "/") {
+				return nil, fmt.Errorf("placeholder {%s} of prefix %s resolved to invalid path segment %q: %w", variable, template, v, fs.ErrNotExist)
+			}
+			return v, nil
+		})
+	}
+}
+
+// dynamicBuckets serves a bucket name with Caddy placeholders, e.g.
+// `{host}-assets`, through a filesystem per resolved bucket, created on
+// first use.
+type dynamicBuckets struct {
+	template string
+	newFS    func(bucket string) *s3fs.S3FS
+	release  func(s *s3fs.S3FS) error
+	log      *zap.Logger
+
+	mu     sync.Mutex
+	fss    map[string]*dynamicBucket
+	closed bool
+}
+
+// dynamicBucket is the filesystem of a resolved bucket.
+type dynamicBucket struct {
+	fs   *s3fs.S3FS
+	used time.Time // used is the time the bucket was last resolved
+}
+
+// newDynamicBuckets serves template with filesystems created by newFS and
+// closed by release. The buckets in use by prev are opened right away,
+// adopting the caches of its filesystems.
+func newDynamicBuckets(template string, newFS func(string) *s3fs.S3FS, release func(*s3fs.S3FS) error, log *zap.Logger, prev *dynamicBuckets) *dynamicBuckets {
+	d := &dynamicBuckets{
+		template: template,
+		newFS:    newFS,
+		release:  release,
+		log:      log,
+		fss:      make(map[string]*dynamicBucket),
+	}
+	if prev != nil {
+		prev.mu.Lock()
+		for bucket, b := range prev.fss {
+			s := newFS(bucket)
+			s.Adopt(b.fs)
+			d.fss[bucket] = &dynamicBucket{fs: s, used: b.used}
+		}
+		prev.mu.Unlock()
+	}
+	return d
+}
+
+// resolve returns the bucket of the request of repl.
+func (d *dynamicBuckets) resolve(repl *caddy.Replacer) (string, error) {
+	bucket := repl.ReplaceAll(d.template, "")
+	if !bucketName.MatchString(bucket) {
+		return "", fmt.Errorf("invalid bucket %q resolved from %s: %w", bucket, d.template, fs.ErrNotExist)
+	}
+	return bucket, nil
+}
+
+// instance returns the filesystem of bucket, creating it if needed.
+func (d *dynamicBuckets) instance(bucket string) (*s3fs.S3FS, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, fs.ErrClosed
+	}
+	b := d.fss[bucket]
+	if b == nil {
+		d.evict()
+		b = &dynamicBucket{fs: d.newFS(bucket)}
+		d.fss[bucket] = b
+		d.log.Debug("opened bucket resolved from placeholders", zap.String("bucket", bucket))
+	}
+	b.used = time.Now()
+	return b.fs, nil
+}
+
+// evict closes the least recently used filesystem once maxDynamicBuckets
+// are open. Calls in flight on it complete unless they outlast the drain.
+func (d *dynamicBuckets) evict() {
+	if len(d.fss) < maxDynamicBuckets {
+		return
+	}
+	var oldest string
+	for bucket, b := range d.fss {
+		if oldest == "" || b.used.Before(d.fss[oldest].used) {
+			oldest = bucket
+		}
+	}
+	s := d.fss[oldest].fs
+	delete(d.fss, oldest)
+	go func() {
+		if err := d.release(s); err != nil {
+			d.log.Warn("closing evicted bucket", zap.String("bucket", oldest), zap.Error(err))
+		}
+	}()
+}
+
+// open returns the filesystems of the buckets open.
+func (d *dynamicBuckets) open() []*s3fs.S3FS {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fss := make([]*s3fs.S3FS, 0, len(d.fss))
+	for _, b := range d.fss {
+		fss = append(fss, b.fs)
+	}
+	return fss
+}
+
+// forContext returns the filesystem of the bucket resolved by the replacer
+// of the request context ctx.
+func (d *dynamicBuckets) forContext(ctx context.Context) (*s3fs.S3FS, error) {
+	repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return nil, ErrUnresolvedBucket
+	}
+	bucket, err := d.resolve(repl)
+	if err != nil {
+		return nil, err
+	}
+	return d.instance(bucket)
+}
+
+// lookup returns the filesystem of the bucket of the request bound to name
+// by the s3fs_log handler.
+func (d *dynamicBuckets) lookup(op, name string) (*s3fs.S3FS, error) {
+	ctx, _, err := s3fs.BoundRequest(name)
+	if err == nil && ctx == nil {
+		err = ErrUnresolvedBucket
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return d.forContext(ctx)
+}
+
+func (d *dynamicBuckets) Open(name string) (fs.File, error) {
+	s, err := d.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.Open(name)
+}
+
+func (d *dynamicBuckets) Stat(name string) (fs.FileInfo, error) {
+	s, err := d.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stat(name)
+}
+
+func (d *dynamicBuckets) ReadDirPage(name, token string, n int) ([]fs.DirEntry, string, error) {
+	s, err := d.lookup("readdir", name)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.ReadDirPage(name, token, n)
+}
+
+func (d *dynamicBuckets) PresignGet(name string, expires time.Duration) (string, fs.FileInfo, error) {
+	s, err := d.lookup("presign", name)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.PresignGet(name, expires)
+}
+
+func (d *dynamicBuckets) StreamDir(ctx context.Context, name string, fn func(entries []fs.DirEntry) error) error {
+	s, err := d.forContext(ctx)
+	if errors.Is(err, ErrUnresolvedBucket) {
+		s, err = d.lookup("readdir", name)
+	}
+	if err != nil {
+		return err
+	}
+	return s.StreamDir(ctx, name, fn)
+}
+
+// close releases all filesystems, failing later calls with fs.ErrClosed.
+func (d *dynamicBuckets) close() error {
+	d.mu.Lock()
+	d.closed = true
+	fss := d.fss
+	d.fss = make(map[string]*dynamicBucket)
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(fss))
+	for _, b := range fss {
+		wg.Add(1)
+		go func(s *s3fs.S3FS) {
+			defer wg.Done()
+			errs <- d.release(s)
+		}(b.fs)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errFS fails all calls with err.
+type errFS struct {
+	err error
+}
+
+func (e errFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: e.err}
+}
+
+func (e errFS) Stat(name string) (fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: e.err}
+}
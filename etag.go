@@ -0,0 +1,272 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+func init() {
+	caddy.RegisterModule(ETagPolicy{})
+	httpcaddyfile.RegisterHandlerDirective("s3fs_etag", parseETagPolicy)
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*ETagPolicy)(nil)
+	_ caddyfile.Unmarshaler       = (*ETagPolicy)(nil)
+	_ caddy.Provisioner           = (*ETagPolicy)(nil)
+	_ caddy.Validator             = (*ETagPolicy)(nil)
+)
+
+// ETag policies.
+const (
+	etagStrong = "strong"
+	etagWeak   = "weak"
+	etagNone   = "none"
+)
+
+// ETagPolicy is a middleware controlling the ETag sent for files served by
+// the file_server it wraps, for operators coordinating validators across
+// multiple origins and CDNs. Files of the filesystem of Bucket are sent with
+// the ETag of their S3 object, in place of the one the file_server derives
+// from the modification time and size, and conditional requests are
+// evaluated against it. Other responses, e.g. of index files resolved by
+// the file_server, keep the ETag of the file_server, see mod_time_precision
+// of the filesystem to align it across origins.
+type ETagPolicy struct {
+	// `strong` sends the S3 ETag, `weak` marks it as weak validator and
+	// `none` removes the ETag, leaving Last-Modified as the only validator.
+	// Defaults to `strong`.
+	Policy string `json:"policy,omitempty"`
+
+	// Bucket of the filesystem to look files up in. May be omitted if only
+	// a single bucket is served.
+	Bucket string `json:"bucket,omitempty"`
+
+	// Root of the file_server. Defaults to `{http.vars.root}`, like the
+	// file_server.
+	Root string `json:"root,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (ETagPolicy) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.s3fs_etag",
+		New: func() caddy.Module { return new(ETagPolicy) },
+	}
+}
+
+// Provision applies defaults.
+func (p *ETagPolicy) Provision(ctx caddy.Context) error {
+	if p.Root == "" {
+		p.Root = "{http.vars.root}"
+	}
+	return nil
+}
+
+// Validate checks the policy.
+func (p *ETagPolicy) Validate() error {
+	switch p.Policy {
+	case "", etagStrong, etagWeak, etagNone:
+		return nil
+	}
+	return fmt.Errorf("invalid etag policy %q, must be one of strong, weak or none", p.Policy)
+}
+
+// ServeHTTP applies the policy to the response of next.
+func (p *ETagPolicy) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	var info fs.FileInfo
+	if fsys, err := Lookup(p.Bucket); err == nil && p.Policy != etagNone {
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		root := repl.ReplaceAll(p.Root, ".")
+		info, _ = fsys.WithContext(r.Context()).Stat(caddyhttp.SanitizedPathJoin(root, r.URL.Path))
+	}
+	return p.serveObject(w, r, next, info)
+}
+
+// serveObject serves the file described by info with its S3 ETag, or
+// applies the policy to the ETag of the file_server if info is nil or
+// carries no S3 ETag. The ETag is removed in either case by the policy
+// none.
+func (p *ETagPolicy) serveObject(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, info fs.FileInfo) error {
+	etag := s3ETag(info)
+	if etag == "" || p.Policy == etagNone {
+		if p.Policy == etagWeak || p.Policy == etagNone {
+			return next.ServeHTTP(&etagWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}, policy: p.Policy}, r)
+		}
+		return next.ServeHTTP(w, r)
+	}
+	if p.Policy == etagWeak {
+		etag = "W/" + etag
+	}
+	if status := checkPreconditions(r, etag); status != 0 {
+		w.Header().Set("Etag", etag)
+		if status == http.StatusNotModified && !info.ModTime().IsZero() {
+			w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(status)
+		return nil
+	}
+	// the preconditions on the ETag are met, the file_server must not
+	// evaluate them against its own
+	r = r.Clone(r.Context())
+	if r.Header.Get("If-Match") != "" {
+		r.Header.Del("If-Unmodified-Since")
+	}
+	if r.Header.Get("If-None-Match") != "" {
+		r.Header.Del("If-Modified-Since")
+	}
+	r.Header.Del("If-Match")
+	r.Header.Del("If-None-Match")
+	if ir := r.Header.Get("If-Range"); ir != "" && (strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/")) {
+		if !etagListMatches(ir, etag, true) {
+			r.Header.Del("Range")
+		}
+		r.Header.Del("If-Range")
+	}
+	return next.ServeHTTP(&etagWriter{ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w}, policy: p.Policy, etag: etag}, r)
+}
+
+// s3ETag returns the ETag of the S3 object described by info, "" if info
+// describes none.
+func s3ETag(info fs.FileInfo) string {
+	if info == nil || info.IsDir() {
+		return ""
+	}
+	obj, ok := info.Sys().(*s3fs.ObjectInfo)
+	if !ok {
+		return ""
+	}
+	return obj.ETag
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match of r against etag
+// like RFC 7232, returning the status of the response if a precondition
+// decides it, 0 if the request is to be served.
+func checkPreconditions(r *http.Request, etag string) int {
+	if im := r.Header.Get("If-Match"); im != "" && !etagListMatches(im, etag, true) {
+		return http.StatusPreconditionFailed
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagListMatches(inm, etag, false) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return http.StatusNotModified
+		}
+		return http.StatusPreconditionFailed
+	}
+	return 0
+}
+
+// etagListMatches reports whether the comma separated list of ETags of a
+// precondition header field contains etag or is "*". A strong comparison
+// never matches weak ETags.
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for list = strings.TrimLeft(list, " \t,"); list != ""; list = strings.TrimLeft(list, " \t,") {
+		candidate := list
+		weak := strings.HasPrefix(candidate, "W/")
+		// ETags are quoted and may contain commas
+		end := 0
+		if rest := strings.TrimPrefix(candidate, "W/"); strings.HasPrefix(rest, `"`) {
+			if i := strings.IndexByte(rest[1:], '"'); i >= 0 {
+				end = len(candidate) - len(rest) + i + 2
+			}
+		}
+		if end == 0 {
+			return false
+		}
+		candidate, list = candidate[:end], candidate[end:]
+		if strong {
+			if !weak && !strings.HasPrefix(etag, "W/") && candidate == etag {
+				return true
+			}
+		} else if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter applies an ETag policy right before the header is written,
+// replacing the ETag of the file_server with etag if set.
+type etagWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	policy      string
+	etag        string
+	wroteHeader bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if etag := w.Header().Get("Etag"); etag != "" {
+		switch {
+		case w.policy == etagNone:
+			w.Header().Del("Etag")
+		case w.etag != "":
+			w.Header().Set("Etag", w.etag)
+		case w.policy == etagWeak && !strings.HasPrefix(etag, "W/"):
+			w.Header().Set("Etag", "W/"+etag)
+		}
+	}
+	w.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriterWrapper.Write(b)
+}
+
+func (w *etagWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriterWrapper.ReadFrom(r)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	s3fs_etag [strong|weak|none] {
+//		bucket <name>
+//		root <path>
+//	}
+func (p *ETagPolicy) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		d.Args(&p.Policy)
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "bucket":
+				if !d.AllArgs(&p.Bucket) {
+					return d.ArgErr()
+				}
+			case "root":
+				if !d.AllArgs(&p.Root) {
+					return d.ArgErr()
+				}
+			default:
+				return d.Errf("%s not a valid s3fs_etag option", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// parseETagPolicy unmarshals the s3fs_etag directive.
+func parseETagPolicy(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	p := new(ETagPolicy)
+	err := p.UnmarshalCaddyfile(h.Dispenser)
+	return p, err
+}
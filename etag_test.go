@@ -0,0 +1,83 @@
+package caddys3fs
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+// objectInfo describes an S3 object of the tests.
+type objectInfo struct {
+	etag    string
+	modTime time.Time
+}
+
+func (i objectInfo) Name() string       { return "file.txt" }
+func (i objectInfo) Size() int64        { return int64(len(etagTestContent)) }
+func (i objectInfo) Mode() fs.FileMode  { return 0o444 }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() interface{}   { return &s3fs.ObjectInfo{ETag: i.etag} }
+
+const etagTestContent = "0123456789"
+
+// fileServer serves the content like the file_server, with the ETag it
+// derives from the modification time and size.
+var fileServer = caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Etag", `"synthetic"`)
+	http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(etagTestContent))
+	return nil
+})
+
+func TestETagPolicy(t *testing.T) {
+	info := objectInfo{etag: `"d41d8cd98f00b204e9800998ecf8427e"`, modTime: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name   string
+		policy string
+		info   fs.FileInfo
+		header map[string]string
+		status int
+		etag   string
+	}{
+		{"strong", etagStrong, info, nil, http.StatusOK, info.etag},
+		{"default", "", info, nil, http.StatusOK, info.etag},
+		{"weak", etagWeak, info, nil, http.StatusOK, "W/" + info.etag},
+		{"none", etagNone, info, nil, http.StatusOK, ""},
+		{"no S3 ETag", etagStrong, nil, nil, http.StatusOK, `"synthetic"`},
+		{"no S3 ETag weak", etagWeak, nil, nil, http.StatusOK, `W/"synthetic"`},
+		{"if-none-match", etagStrong, info, map[string]string{"If-None-Match": info.etag}, http.StatusNotModified, info.etag},
+		{"if-none-match weak", etagWeak, info, map[string]string{"If-None-Match": info.etag}, http.StatusNotModified, "W/" + info.etag},
+		{"if-none-match list", etagStrong, info, map[string]string{"If-None-Match": `"other", ` + info.etag}, http.StatusNotModified, info.etag},
+		{"if-none-match synthetic", etagStrong, info, map[string]string{"If-None-Match": `"synthetic"`}, http.StatusOK, info.etag},
+		{"if-match", etagStrong, info, map[string]string{"If-Match": info.etag}, http.StatusOK, info.etag},
+		{"if-match other", etagStrong, info, map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed, info.etag},
+		{"if-match weak", etagWeak, info, map[string]string{"If-Match": "W/" + info.etag}, http.StatusPreconditionFailed, "W/" + info.etag},
+		{"if-range", etagStrong, info, map[string]string{"Range": "bytes=0-1", "If-Range": info.etag}, http.StatusPartialContent, info.etag},
+		{"if-range other", etagStrong, info, map[string]string{"Range": "bytes=0-1", "If-Range": `"synthetic"`}, http.StatusOK, info.etag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ETagPolicy{Policy: tt.policy}
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if err := p.serveObject(w, r, fileServer, tt.info); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Etag"); got != tt.etag {
+				t.Errorf("ETag %q, want %q", got, tt.etag)
+			}
+		})
+	}
+}
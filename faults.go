@@ -0,0 +1,124 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// FaultInjection configures artificial faults for a share of the requests to
+// S3, to validate retry, failover and caching behaviour before real outages.
+// It must never be enabled in production.
+type FaultInjection struct {
+	// Percentage (0-100) of requests delayed by Delay.
+	DelayPercent float64 `json:"delay_percent,omitempty"`
+
+	// Delay added to affected requests. Defaults to 1s.
+	Delay caddy.Duration `json:"delay,omitempty"`
+
+	// Percentage (0-100) of requests failing with a 503 SlowDown response
+	// without reaching S3.
+	ErrorPercent float64 `json:"error_percent,omitempty"`
+
+	// Percentage (0-100) of successful responses whose body is cut in half.
+	TruncatePercent float64 `json:"truncate_percent,omitempty"`
+}
+
+// slowDownBody is the body of a synthetic SlowDown error.
+const slowDownBody = `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>SlowDown</Code><Message>Injected fault: please reduce your request rate.</Message></Error>`
+
+// faultClient injects the configured faults into requests passed to the
+// HTTP client of an S3 client. Other clients, e.g. of STS, are not affected.
+type faultClient struct {
+	faults *FaultInjection
+	base   aws.HTTPClient
+}
+
+// validate checks that the percentages are within 0-100.
+func (f *FaultInjection) validate() error {
+	for _, p := range []struct {
+		name    string
+		percent float64
+	}{
+		{"delay", f.DelayPercent},
+		{"error", f.ErrorPercent},
+		{"truncate", f.TruncatePercent},
+	} {
+		if p.percent < 0 || p.percent > 100 {
+			return fmt.Errorf("fault_injection %s percentage %v outside 0-100", p.name, p.percent)
+		}
+	}
+	if f.Delay < 0 {
+		return fmt.Errorf("fault_injection delay_duration %v is negative", time.Duration(f.Delay))
+	}
+	return nil
+}
+
+// s3Option returns the S3 client option injecting the faults into the
+// requests of the client. It leaves the client as is if f is nil.
+func (f *FaultInjection) s3Option() func(*s3.Options) {
+	return func(o *s3.Options) {
+		if f != nil {
+			o.HTTPClient = &faultClient{faults: f, base: o.HTTPClient}
+		}
+	}
+}
+
+// hit reports whether a request is affected by a fault with the given percentage.
+func hit(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+func (t *faultClient) Do(req *http.Request) (*http.Response, error) {
+	if hit(t.faults.DelayPercent) {
+		delay := time.Duration(t.faults.Delay)
+		if delay <= 0 {
+			delay = time.Second
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if hit(t.faults.ErrorPercent) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{
+			Status:        "503 Service Unavailable",
+			StatusCode:    http.StatusServiceUnavailable,
+			Proto:         req.Proto,
+			ProtoMajor:    req.ProtoMajor,
+			ProtoMinor:    req.ProtoMinor,
+			Header:        http.Header{"Content-Type": []string{"application/xml"}},
+			Body:          io.NopCloser(strings.NewReader(slowDownBody)),
+			ContentLength: int64(len(slowDownBody)),
+			Request:       req,
+		}, nil
+	}
+	resp, err := t.base.Do(req)
+	if err != nil || resp.StatusCode >= 300 || resp.ContentLength <= 1 || !hit(t.faults.TruncatePercent) {
+		return resp, err
+	}
+	// keep the announced length, so the truncation surfaces as short body
+	resp.Body = &truncatedBody{
+		Reader: io.LimitReader(resp.Body, resp.ContentLength/2),
+		Closer: resp.Body,
+	}
+	return resp, nil
+}
+
+// truncatedBody ends a response body early.
+type truncatedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,53 @@
+package caddys3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// ErrNoFilesystem is returned by Lookup if no provisioned filesystem serves
+// the requested bucket.
+var ErrNoFilesystem = errors.New("no s3 filesystem provisioned")
+
+// Lookup returns the filesystem serving bucket, or any filesystem if bucket
+// is empty, so other Caddy modules can read objects through its caches,
+// limits and credentials instead of creating their own S3 clients, e.g. an
+// auth module reading a policy file from the served bucket. While a config
+// is reloaded the filesystem provisioned last wins. Filesystems are
+// provisioned together with the handlers using them, so call Lookup while
+// handling requests rather than from Provision.
+func Lookup(bucket string) (*FS, error) {
+	instances.Lock()
+	defer instances.Unlock()
+	var found *FS
+	var latest uint64
+	for fsys, seq := range instances.fss {
+		if (bucket == "" || fsys.Bucket == bucket) && seq > latest {
+			found, latest = fsys, seq
+		}
+	}
+	if found == nil {
+		if bucket == "" {
+			return nil, ErrNoFilesystem
+		}
+		return nil, fmt.Errorf("%w for bucket %s", ErrNoFilesystem, bucket)
+	}
+	return found, nil
+}
+
+// ReadFile reads the named object of bucket through the filesystem returned
+// by Lookup.
+func ReadFile(bucket, name string) ([]byte, error) {
+	return ReadFileContext(context.Background(), bucket, name)
+}
+
+// ReadFileContext is like ReadFile, cancelling the read once ctx is done.
+func ReadFileContext(ctx context.Context, bucket, name string) ([]byte, error) {
+	fsys, err := Lookup(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(fsys.WithContext(ctx), name)
+}
@@ -1,15 +1,28 @@
 package caddys3fs
 
 import (
+	"context"
 	"errors"
 	"io/fs"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
 	"github.com/floj/caddy-s3fs/s3fs"
+	"go.uber.org/zap"
 )
 
 func init() {
@@ -19,29 +32,94 @@ func init() {
 // Interface guards
 var (
 	_ fs.StatFS             = (*FS)(nil)
+	_ s3fs.DirPager         = (*FS)(nil)
+	_ s3fs.DirStreamer      = (*FS)(nil)
 	_ caddyfile.Unmarshaler = (*FS)(nil)
+	_ caddy.Provisioner     = (*FS)(nil)
+	_ caddy.CleanerUpper    = (*FS)(nil)
 )
 
 // FS is a Caddy virtual filesystem module for AWS S3 (and compatible) object store.
 type FS struct {
 	fs.StatFS `json:"-"`
 
-	// The name of the S3 bucket.
-	Bucket string `json:"bucket,omitempty"`
+	Config
 
-	// The AWS region the bucket is hosted in.
-	Region string `json:"region,omitempty"`
+	awsConfig aws.Config  // awsConfig is the AWS configuration of the primary bucket
+	handoff   string      // handoff is the key of the settings the caches depend on
+	client    s3fs.Client // client is the client of the primary bucket
+	configs   []string    // configs are the keys of the shared AWS configurations in use
+}
 
-	// The AWS profile to use if mulitple profiles are specified.
+// CredentialSource describes a single set of credentials. Exactly one of the
+// fields must be set.
+type CredentialSource struct {
+	// Use the credentials of this profile of the shared credentials file.
 	Profile string `json:"profile,omitempty"`
 
-	// Use non-standard endpoint for S3.
+	// Assume this IAM role using the default credentials.
+	RoleARN string `json:"role_arn,omitempty"`
+
+	// Send unsigned requests, for public buckets.
+	Anonymous bool `json:"anonymous,omitempty"`
+}
+
+// Fallback describes a replica bucket of the filesystem.
+type Fallback struct {
+	// The name of the replica S3 bucket.
+	Bucket string `json:"bucket,omitempty"`
+
+	// The AWS region the replica bucket is hosted in.
+	Region string `json:"region,omitempty"`
+
+	// Use non-standard endpoint for the replica bucket.
 	Endpoint string `json:"endpoint,omitempty"`
 
-	// Set this to `true` to force the request to use path-style addressing.
+	// Set this to `true` to force requests to the replica to use path-style addressing.
 	S3ForcePathStyle bool `json:"force_path_style,omitempty"`
+
+	// Consecutive failed calls after which all reads go to the replica.
+	// Defaults to 3.
+	FailoverThreshold int `json:"failover_threshold,omitempty"`
+
+	// Time reads go to the replica before the bucket is tried again.
+	// Defaults to 30s.
+	FailoverCooldown caddy.Duration `json:"failover_cooldown,omitempty"`
 }
 
+const (
+	defaultMaxListEntries = 100000
+	defaultMaxListPages   = 200
+	defaultHotKeysTop     = 100
+
+	defaultCredentialFallbackThreshold = 5
+
+	defaultTagCacheTTL = 5 * time.Minute
+
+	defaultCredentialTimeout = 10 * time.Second
+
+	defaultDrainTimeout = 10 * time.Second
+
+	defaultEndpointRegion = "us-east-1"
+
+	defaultPrefetchConcurrency = 4
+	defaultPrefetchTTL         = time.Minute
+	defaultStatCacheSize       = 10000
+	defaultNotFoundCacheSize   = 10000
+
+	defaultContentCacheMaxObjectSize = 256 << 10
+
+	defaultParallelConcurrency = 4
+
+	defaultCandidateTTL = 5 * time.Second
+
+	defaultStaleMaxAge = time.Hour
+
+	defaultPrepositionHeadSize   = 16 << 10
+	defaultPrepositionCacheSize  = 64 << 20
+	defaultPrepositionMaxObjects = 1000
+)
+
 // CaddyModule returns the Caddy module information.
 func (FS) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -51,37 +129,517 @@ func (FS) CaddyModule() caddy.ModuleInfo {
 }
 
 func (fs *FS) Provision(ctx caddy.Context) error {
-	if fs.Bucket == "" {
-		return errors.New("bucket must be set")
+	if err := fs.Validate(); err != nil {
+		return err
 	}
 
-	var config aws.Config
+	fs.Bucket = arnBucket(fs.Bucket)
+	if fs.Fallback != nil {
+		fs.Fallback.Bucket = arnBucket(fs.Fallback.Bucket)
+	}
+	for i := range fs.Overlay {
+		fs.Overlay[i].Bucket = arnBucket(fs.Overlay[i].Bucket)
+	}
+	fs.handoff = fs.handoffKey()
+	prev := previousInstance(fs.handoff)
 
-	if fs.Region != "" {
-		config.Region = aws.String(fs.Region)
+	if fs.Region == "" {
+		fs.Region = arnRegion(fs.Bucket)
+	}
+	if fs.CredentialTimeout == 0 {
+		fs.CredentialTimeout = caddy.Duration(defaultCredentialTimeout)
+	}
+	cfg, err := fs.sharedConfig(fs.Region, fs.Endpoint, fs.Profile)
+	if err != nil {
+		return err
+	}
+	fs.awsConfig = cfg
+	if err := fs.resolveCredentials(cfg); err != nil {
+		return err
+	}
+	if fs.DrainTimeout == 0 {
+		fs.DrainTimeout = caddy.Duration(defaultDrainTimeout)
+	}
+	if fs.VPCEndpoint {
+		if err := fs.validateVPCEndpoint(ctx.Logger()); err != nil {
+			return err
+		}
+	}
+	if fs.FaultInjection != nil {
+		ctx.Logger().Warn("fault injection enabled, do not use in production")
 	}
 
-	if fs.Endpoint != "" {
-		config.Endpoint = aws.String(fs.Endpoint)
+	if fs.MaxListEntries == 0 {
+		fs.MaxListEntries = defaultMaxListEntries
+	}
+	if fs.MaxListPages == 0 {
+		fs.MaxListPages = defaultMaxListPages
 	}
 
-	if fs.S3ForcePathStyle {
-		config.S3ForcePathStyle = aws.Bool(fs.S3ForcePathStyle)
+	if fs.HotKeysTop == 0 {
+		fs.HotKeysTop = defaultHotKeysTop
+	}
+
+	if fs.PrefetchConcurrency == 0 {
+		fs.PrefetchConcurrency = defaultPrefetchConcurrency
+	}
+	if fs.PrefetchTTL == 0 {
+		fs.PrefetchTTL = caddy.Duration(defaultPrefetchTTL)
+	}
+	if fs.StatCacheSize == 0 {
+		fs.StatCacheSize = defaultStatCacheSize
+	}
+	if fs.NotFoundCacheSize == 0 {
+		fs.NotFoundCacheSize = defaultNotFoundCacheSize
+	}
+	if fs.StaleMaxAge == 0 {
+		fs.StaleMaxAge = caddy.Duration(defaultStaleMaxAge)
+	}
+	if fs.ParallelConcurrency == 0 {
+		fs.ParallelConcurrency = defaultParallelConcurrency
+	}
+	if fs.ContentCacheMaxObjectSize == 0 {
+		fs.ContentCacheMaxObjectSize = defaultContentCacheMaxObjectSize
+	}
+
+	order, err := s3fs.ParseListOrder(fs.Sort)
+	if err != nil {
+		return err
+	}
+	notFoundExclude := make([]*regexp.Regexp, 0, len(fs.NotFoundCacheExclude))
+	for _, expr := range fs.NotFoundCacheExclude {
+		// validated by Validate
+		notFoundExclude = append(notFoundExclude, regexp.MustCompile(expr))
+	}
+
+	opts := []s3fs.Option{
+		s3fs.WithListLimits(fs.MaxListEntries, fs.MaxListPages),
+		s3fs.WithListOrder(order, fs.SortDescending),
+		s3fs.WithHotKeys(time.Duration(fs.HotKeysWindow), fs.HotKeysTop),
+		s3fs.WithPrefetch(fs.PrefetchCount, fs.PrefetchConcurrency, time.Duration(fs.PrefetchTTL)),
+		s3fs.WithStatCache(time.Duration(fs.StatCacheTTL), fs.StatCacheSize),
+		s3fs.WithStaleFallback(time.Duration(fs.StaleBudget), time.Duration(fs.StaleMaxAge)),
+		s3fs.WithNegativeCache(time.Duration(fs.NotFoundCacheTTL), fs.NotFoundCacheSize),
+		s3fs.WithNegativeCacheExclusions(notFoundExclude...),
+		s3fs.WithPrefixLayers(time.Duration(fs.PrefixLayerMissTTL), fs.PrefixLayers...),
+		s3fs.WithCandidateProbing(defaultCandidateTTL, fs.ProbeCandidates...),
+		s3fs.WithContentCache(fs.ContentCacheMaxObjectSize, fs.ContentCacheSize),
+		s3fs.WithConcurrencyLimit(fs.MaxConcurrentRequests),
+		s3fs.WithAdaptiveConcurrency(fs.MinConcurrentRequests, time.Duration(fs.ConcurrencyLatencyTarget)),
+		s3fs.WithRateLimit(fs.RequestRate, fs.RequestBurst),
+		s3fs.WithStreamStats(fs.SlowStreamThreshold),
+		s3fs.WithModTimeMetadata(fs.ModTimeMetadata),
+		s3fs.WithModTimePrecision(time.Duration(fs.ModTimePrecision), fs.ModTimeRound),
+		s3fs.WithImmutablePrefixes(fs.Immutable...),
+		s3fs.WithExactRanges(fs.ExactRanges...),
+		s3fs.WithReadahead(fs.Readahead),
+		s3fs.WithAdaptiveReadahead(fs.ReadaheadMax),
+		s3fs.WithParallelDownload(fs.ParallelPartSize, fs.ParallelConcurrency),
+		s3fs.WithPartitionWindow(fs.PartitionWindowDays, fs.PartitionDateKey),
+		s3fs.WithPartitionDisplay(fs.PartitionDisplay...),
+	}
+	if hasPlaceholder(fs.Prefix) {
+		opts = append(opts, s3fs.WithPrefixResolver(prefixResolver(fs.Prefix)))
+	} else {
+		opts = append(opts, s3fs.WithPrefix(fs.Prefix))
+	}
+	if fs.StrictConsistency {
+		opts = append(opts, s3fs.WithStrictConsistency())
+	}
+	if fs.ForbiddenAsNotExist != "" {
+		mode, err := s3fs.ParseForbiddenMode(fs.ForbiddenAsNotExist)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, s3fs.WithForbiddenAsNotExist(mode))
+	}
+	if fs.RequesterPays {
+		opts = append(opts, s3fs.WithRequesterPays())
+	}
+	if fs.Writable {
+		opts = append(opts, s3fs.WithWrites())
+	}
+	if fs.AllowRemoveAll {
+		opts = append(opts, s3fs.WithRemoveAll())
+	}
+	if e := fs.Encryption; e != nil {
+		algorithm := types.ServerSideEncryptionAes256
+		if e.Type == "sse-kms" {
+			algorithm = types.ServerSideEncryptionAwsKms
+		}
+		opts = append(opts, s3fs.WithServerSideEncryption(s3fs.Encryption{
+			Algorithm: algorithm,
+			KMSKeyID:  e.KMSKeyID,
+			BucketKey: e.BucketKey,
+		}))
+	}
+	if fs.CustomerKey != nil {
+		opt, err := fs.CustomerKey.option()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, opt)
+	}
+	if m := fs.Multipart; m != nil {
+		opts = append(opts, s3fs.WithMultipartUpload(m.Threshold, m.PartSize, m.Concurrency))
+	}
+	if len(fs.Overrides) > 0 {
+		overrides := make([]s3fs.Override, 0, len(fs.Overrides))
+		for _, o := range fs.Overrides {
+			overrides = append(overrides, s3fs.Override{
+				Patterns:  o.Match,
+				Readahead: o.Readahead,
+				CacheTTL:  time.Duration(o.CacheTTL),
+			})
+		}
+		opts = append(opts, s3fs.WithOverrides(overrides...))
+	}
+	if fs.VPCEndpoint {
+		opts = append(opts, s3fs.WithVPCEndpoint())
+	}
+	if fs.AccessExport != nil {
+		fs.AccessExport.provision(fs)
+		opts = append(opts, s3fs.WithAccessStats(fs.AccessExport.Depth))
+	}
+	if fs.Archive != nil {
+		opt, err := fs.Archive.option(ctx, fs)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, opt)
+	}
+	if t := fs.Timeouts; t != nil {
+		opts = append(opts, s3fs.WithTimeouts(s3fs.Timeouts{
+			Head:      time.Duration(t.Head),
+			FirstByte: time.Duration(t.FirstByte),
+			List:      time.Duration(t.List),
+		}))
+	}
+	if fs.Metrics {
+		opts = append(opts, s3fs.WithMetrics(newMetrics(fs.Bucket)))
+	}
+	if fs.Tracing {
+		opts = append(opts, s3fs.WithTracing())
+	}
+	if fs.VerboseLogging {
+		opts = append(opts, s3fs.WithVerboseLogging())
+	}
+	if fs.MetadataOnly {
+		opts = append(opts, s3fs.WithMetadataOnly())
+	}
+	if b := fs.CircuitBreaker; b != nil {
+		opts = append(opts, s3fs.WithCircuitBreaker(s3fs.CircuitBreaker{
+			Failures:  b.Failures,
+			ErrorRate: b.ErrorPercent / 100,
+			Window:    time.Duration(b.Window),
+			Cooldown:  time.Duration(b.Cooldown),
+		}))
+	}
+	if fs.Canary != nil {
+		opts = append(opts, fs.Canary.option(ctx))
+	}
+	if p := fs.Preposition; p != nil {
+		if p.HeadSize == 0 {
+			p.HeadSize = defaultPrepositionHeadSize
+		}
+		if p.CacheSize == 0 {
+			p.CacheSize = defaultPrepositionCacheSize
+		}
+		if len(p.Extensions) == 0 {
+			p.Extensions = []string{".html", ".css", ".js"}
+		}
+		if p.MaxObjects == 0 {
+			p.MaxObjects = defaultPrepositionMaxObjects
+		}
+		opts = append(opts, s3fs.WithHeadCache(p.HeadSize, p.CacheSize))
+	}
+	if fs.DiskCache != nil {
+		cacheOpts, err := fs.DiskCache.options(ctx, fs.awsConfig)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, cacheOpts...)
+	}
+	if len(fs.Quotas) > 0 {
+		quotas := make([]s3fs.Quota, 0, len(fs.Quotas))
+		for _, q := range fs.Quotas {
+			quotas = append(quotas, s3fs.Quota{Prefix: q.Prefix, Daily: q.Daily, Monthly: q.Monthly})
+		}
+		opts = append(opts, s3fs.WithQuotas(quotas...))
+	}
+	if fs.SignatureVerification != nil {
+		opt, err := fs.SignatureVerification.option()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, opt)
+	}
+	if fs.VersionsDirectory {
+		opts = append(opts, s3fs.WithVersionsDirectory())
+	}
+	if fs.TagCacheTTL == 0 {
+		fs.TagCacheTTL = caddy.Duration(defaultTagCacheTTL)
+	}
+	if fs.RequiredTag != "" {
+		key, value, _ := strings.Cut(fs.RequiredTag, "=")
+		opts = append(opts, s3fs.WithRequiredTag(key, value, time.Duration(fs.TagCacheTTL)))
+	}
+	if fs.ExpiryMetadata != "" || fs.ExpiryTag != "" {
+		opts = append(opts, s3fs.WithExpiry(fs.ExpiryMetadata, fs.ExpiryTag, time.Duration(fs.TagCacheTTL)))
+	}
+	if len(fs.CredentialFallback) > 0 {
+		if fs.CredentialFallbackThreshold == 0 {
+			fs.CredentialFallbackThreshold = defaultCredentialFallbackThreshold
+		}
+		clients := make([]s3fs.Client, 0, len(fs.CredentialFallback))
+		for _, src := range fs.CredentialFallback {
+			client, err := src.newClient(fs, cfg)
+			if err != nil {
+				return err
+			}
+			clients = append(clients, client)
+		}
+		opts = append(opts, s3fs.WithCredentialFallback(fs.CredentialFallbackThreshold, clients...))
+	}
+	if fs.Fallback != nil {
+		fallbackCfg, err := fs.sharedConfig(fs.Fallback.Region, fs.Fallback.Endpoint, fs.Profile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts,
+			s3fs.WithReplica(fs.Fallback.Bucket, fs.newS3Client(fallbackCfg, fs.Fallback.S3ForcePathStyle)),
+			s3fs.WithFailover(fs.Fallback.FailoverThreshold, time.Duration(fs.Fallback.FailoverCooldown)),
+		)
+		if fs.LatencyRouting > 0 {
+			opts = append(opts, s3fs.WithLatencyRouting(ctx, time.Duration(fs.LatencyRouting)))
+		}
+	}
+	if fs.OriginMode {
+		opts = append(opts,
+			s3fs.WithDirectoryListing(false),
+			s3fs.WithRequestCollapsing(),
+		)
+	} else if fs.CollapseRequests {
+		opts = append(opts, s3fs.WithRequestCollapsing())
 	}
 
-	sess, err := session.NewSessionWithOptions(session.Options{
-		Config:  config,
-		Profile: fs.Profile,
-	})
+	eventsApp, err := ctx.App("events")
 	if err != nil {
 		return err
 	}
+	events := eventsApp.(*caddyevents.App)
+	opts = append(opts,
+		s3fs.WithCredentialsProvider(cfg.Credentials),
+		s3fs.WithEvents(func(name string, data map[string]interface{}) {
+			events.Emit(ctx, name, data)
+		}),
+	)
+	client := fs.newS3Client(cfg, fs.S3ForcePathStyle)
+	fs.client = client
+	if fs.ProbeCapabilities {
+		if opt := fs.probeCapabilities(ctx, client); opt != nil {
+			opts = append(opts, opt)
+		}
+	}
+	if hasPlaceholder(fs.Bucket) {
+		var prevDynamic *dynamicBuckets
+		if prev != nil {
+			prevDynamic = prev.StatFS.(*dynamicBuckets)
+		}
+		fs.StatFS = newDynamicBuckets(fs.Bucket, func(bucket string) *s3fs.S3FS {
+			return s3fs.NewFS(bucket, client, ctx.Logger().With(zap.String("bucket", bucket)), opts...)
+		}, fs.release, ctx.Logger(), prevDynamic)
+		fs.logEffectiveConfig(ctx.Logger(), cfg)
+		registerInstance(fs)
+		return nil
+	}
+	s3FS := s3fs.NewFS(fs.Bucket, client, ctx.Logger(), opts...)
+	fs.StatFS = s3FS
+	if len(fs.Overlay) > 0 {
+		overlay, err := fs.newOverlay(ctx, s3FS, client, opts)
+		if err != nil {
+			return err
+		}
+		fs.StatFS = overlay
+	}
+	if prev != nil {
+		adoptCaches(fs.StatFS, prev.StatFS)
+		ctx.Logger().Debug("reusing caches of previous config", zap.String("bucket", fs.Bucket))
+	}
+	if fs.AccessExport != nil {
+		go fs.AccessExport.run(ctx, s3FS, client, ctx.Logger())
+	}
 
-	fs.StatFS = s3fs.NewFS(fs.Bucket, s3.New(sess), ctx.Logger())
+	fs.logEffectiveConfig(ctx.Logger(), cfg)
+	registerInstance(fs)
 
 	return nil
 }
 
+// newConfig loads the AWS configuration for the given connection settings.
+func (fs *FS) newConfig(region, endpoint, profile string) (aws.Config, error) {
+	// Only S3 requests use the custom endpoint, credential providers
+	// (STS) resolve theirs separately.
+	opts := []func(*config.LoadOptions) error{
+		config.WithEndpointResolverWithOptions(fs.endpointResolver(endpoint)),
+	}
+
+	if retryer := fs.Retry.retryer(); retryer != nil {
+		opts = append(opts, config.WithRetryer(retryer))
+	}
+
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Credentials != nil {
+		cfg.Credentials = timeoutCredentials{provider: cfg.Credentials, timeout: time.Duration(fs.CredentialTimeout)}
+	}
+	if cfg.Region == "" && endpoint != "" {
+		// S3 compatible stores mostly ignore the region, but requests need
+		// one to be signed
+		cfg.Region = defaultEndpointRegion
+	}
+	return cfg, nil
+}
+
+// newS3Client creates an S3 client for cfg, injecting the faults of fs.
+func (fs *FS) newS3Client(cfg aws.Config, forcePathStyle bool) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = forcePathStyle
+		// send requests for access point ARNs to their region
+		o.UseARNRegion = true
+	}, fs.FaultInjection.s3Option())
+}
+
+// newClient creates a client for cfg using the credentials of src. STS
+// calls of roles and profiles use the STS endpoint, retry policy and
+// credential timeout of fs.
+func (src CredentialSource) newClient(fs *FS, cfg aws.Config) (*s3.Client, error) {
+	var creds aws.CredentialsProvider
+	switch {
+	case src.Anonymous:
+		creds = aws.AnonymousCredentials{}
+	case src.RoleARN != "":
+		creds = timeoutCredentials{
+			provider: aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), src.RoleARN)),
+			timeout:  time.Duration(fs.CredentialTimeout),
+		}
+	case src.Profile != "":
+		profileCfg, err := fs.newConfig(cfg.Region, fs.Endpoint, src.Profile)
+		if err != nil {
+			return nil, err
+		}
+		creds = profileCfg.Credentials
+	default:
+		return nil, errors.New("credential source requires one of profile, role_arn or anonymous")
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Credentials = creds
+		o.UsePathStyle = fs.S3ForcePathStyle
+		o.UseARNRegion = true
+	}, fs.FaultInjection.s3Option()), nil
+}
+
+// Cleanup releases the filesystem once its config is unloaded.
+func (fs *FS) Cleanup() error {
+	unregisterInstance(fs)
+	defer fs.releaseConfigs()
+	switch s := fs.StatFS.(type) {
+	case *s3fs.S3FS:
+		return fs.release(s)
+	case *dynamicBuckets:
+		return s.close()
+	case *s3fs.Overlay:
+		var err error
+		for _, l := range s.Layers() {
+			s3, ok := l.(*s3fs.S3FS)
+			if !ok {
+				continue
+			}
+			if releaseErr := fs.release(s3); err == nil {
+				err = releaseErr
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// release waits up to DrainTimeout for in-flight downloads of s to complete
+// before stopping its background work.
+func (fs *FS) release(s *s3fs.S3FS) error {
+	var err error
+	if fs.DrainTimeout >= 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(fs.DrainTimeout))
+		err = s.Drain(ctx)
+		cancel()
+	}
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Stat answers calls failing fast on the open circuit breaker with 503, the
+// file_server answers other errors of Stat with 500.
+func (fs *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.StatFS.Stat(name)
+	if errors.Is(err, s3fs.ErrCircuitOpen) {
+		return nil, caddyhttp.Error(http.StatusServiceUnavailable, err)
+	}
+	return info, err
+}
+
+// WithContext returns a view of the filesystem cancelling its S3 calls once
+// ctx is done, for handlers reading on behalf of a request, e.g. with
+// r.Context(). With bucket placeholders the view reads the bucket resolved
+// for the request of ctx.
+func (fs *FS) WithContext(ctx context.Context) fs.StatFS {
+	switch s := fs.StatFS.(type) {
+	case *s3fs.S3FS:
+		return s.WithContext(ctx)
+	case *dynamicBuckets:
+		s3, err := s.forContext(ctx)
+		if err != nil {
+			return errFS{err}
+		}
+		return s3.WithContext(ctx)
+	case *s3fs.Overlay:
+		return s.WithContext(ctx)
+	}
+	return fs.StatFS
+}
+
+// OpenIntent opens name for intent, e.g. for handlers only listing a
+// directory. Filesystems serving several buckets open name like Open.
+func (fs *FS) OpenIntent(name string, intent s3fs.Intent) (fs.File, error) {
+	if s, ok := fs.StatFS.(*s3fs.S3FS); ok {
+		return s.OpenIntent(name, intent)
+	}
+	return fs.StatFS.Open(name)
+}
+
+// ReadDirPage exposes paginated directory listings to HTTP handlers, so huge
+// prefixes can be browsed page by page using the returned continuation token.
+func (fs *FS) ReadDirPage(name, token string, n int) ([]fs.DirEntry, string, error) {
+	return fs.StatFS.(s3fs.DirPager).ReadDirPage(name, token, n)
+}
+
+// StreamDir exposes incremental directory listings to HTTP handlers, so
+// browse pages of huge prefixes can be rendered while they are listed.
+func (fs *FS) StreamDir(ctx context.Context, name string, fn func(entries []fs.DirEntry) error) error {
+	return fs.StatFS.(s3fs.DirStreamer).StreamDir(ctx, name, fn)
+}
+
 // UnmarshalCaddyfile unmarshals a caddyfile.
 func (fs *FS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	if !d.Next() { // skip block beginning
@@ -108,6 +666,796 @@ func (fs *FS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			}
 		case "force_path_style":
 			fs.S3ForcePathStyle = true
+		case "origin_mode":
+			fs.OriginMode = true
+		case "collapse_requests":
+			fs.CollapseRequests = true
+		case "fallback":
+			fs.Fallback = new(Fallback)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "bucket":
+					if !d.AllArgs(&fs.Fallback.Bucket) {
+						return d.ArgErr()
+					}
+				case "region":
+					if !d.AllArgs(&fs.Fallback.Region) {
+						return d.ArgErr()
+					}
+				case "endpoint":
+					if !d.AllArgs(&fs.Fallback.Endpoint) {
+						return d.ArgErr()
+					}
+				case "force_path_style":
+					fs.Fallback.S3ForcePathStyle = true
+				case "failover_threshold":
+					if err := parseInt(d, &fs.Fallback.FailoverThreshold); err != nil {
+						return err
+					}
+				case "failover_cooldown":
+					var val string
+					if !d.AllArgs(&val) {
+						return d.ArgErr()
+					}
+					cooldown, err := caddy.ParseDuration(val)
+					if err != nil {
+						return d.Errf("invalid failover_cooldown %q: %v", val, err)
+					}
+					fs.Fallback.FailoverCooldown = caddy.Duration(cooldown)
+				default:
+					return d.Errf("%s not a valid fallback option", d.Val())
+				}
+			}
+		case "prefetch":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 3 {
+				return d.ArgErr()
+			}
+			count, err := strconv.Atoi(args[0])
+			if err != nil {
+				return d.Errf("invalid prefetch count %q: %v", args[0], err)
+			}
+			fs.PrefetchCount = count
+			if len(args) > 1 {
+				concurrency, err := strconv.Atoi(args[1])
+				if err != nil {
+					return d.Errf("invalid prefetch concurrency %q: %v", args[1], err)
+				}
+				fs.PrefetchConcurrency = concurrency
+			}
+			if len(args) > 2 {
+				ttl, err := caddy.ParseDuration(args[2])
+				if err != nil {
+					return d.Errf("invalid prefetch ttl %q: %v", args[2], err)
+				}
+				fs.PrefetchTTL = caddy.Duration(ttl)
+			}
+		case "stat_cache":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			ttl, err := caddy.ParseDuration(args[0])
+			if err != nil {
+				return d.Errf("invalid stat_cache ttl %q: %v", args[0], err)
+			}
+			fs.StatCacheTTL = caddy.Duration(ttl)
+			if len(args) > 1 {
+				size, err := strconv.Atoi(args[1])
+				if err != nil {
+					return d.Errf("invalid stat_cache size %q: %v", args[1], err)
+				}
+				fs.StatCacheSize = size
+			}
+		case "stale_fallback":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			budget, err := caddy.ParseDuration(args[0])
+			if err != nil {
+				return d.Errf("invalid stale_fallback budget %q: %v", args[0], err)
+			}
+			fs.StaleBudget = caddy.Duration(budget)
+			if len(args) > 1 {
+				maxAge, err := caddy.ParseDuration(args[1])
+				if err != nil {
+					return d.Errf("invalid stale_fallback max age %q: %v", args[1], err)
+				}
+				fs.StaleMaxAge = caddy.Duration(maxAge)
+			}
+		case "not_found_cache":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			ttl, err := caddy.ParseDuration(args[0])
+			if err != nil {
+				return d.Errf("invalid not_found_cache ttl %q: %v", args[0], err)
+			}
+			fs.NotFoundCacheTTL = caddy.Duration(ttl)
+			if len(args) > 1 {
+				size, err := strconv.Atoi(args[1])
+				if err != nil {
+					return d.Errf("invalid not_found_cache size %q: %v", args[1], err)
+				}
+				fs.NotFoundCacheSize = size
+			}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "exclude":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					fs.NotFoundCacheExclude = append(fs.NotFoundCacheExclude, args...)
+				default:
+					return d.Errf("%s not a valid not_found_cache option", d.Val())
+				}
+			}
+		case "prefix":
+			if !d.AllArgs(&fs.Prefix) {
+				return d.ArgErr()
+			}
+		case "prefix_layers":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			fs.PrefixLayers = append(fs.PrefixLayers, args...)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "miss_ttl":
+					var val string
+					if !d.AllArgs(&val) {
+						return d.ArgErr()
+					}
+					ttl, err := caddy.ParseDuration(val)
+					if err != nil {
+						return d.Errf("invalid miss_ttl %q: %v", val, err)
+					}
+					fs.PrefixLayerMissTTL = caddy.Duration(ttl)
+				default:
+					return d.Errf("%s not a valid prefix_layers option", d.Val())
+				}
+			}
+		case "overlay":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			src := OverlaySource{Bucket: args[0]}
+			if len(args) > 1 {
+				src.Prefix = args[1]
+			}
+			if err := parseOverlaySource(d, &src); err != nil {
+				return err
+			}
+			fs.Overlay = append(fs.Overlay, src)
+		case "overlay_dir":
+			src := OverlaySource{}
+			if !d.AllArgs(&src.Dir) {
+				return d.ArgErr()
+			}
+			if err := parseOverlaySource(d, &src); err != nil {
+				return err
+			}
+			fs.Overlay = append(fs.Overlay, src)
+		case "probe_candidates":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			fs.ProbeCandidates = append(fs.ProbeCandidates, args...)
+		case "content_cache":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(args[0])
+			if err != nil {
+				return d.Errf("invalid content_cache size %q: %v", args[0], err)
+			}
+			fs.ContentCacheSize = int64(size)
+			if len(args) > 1 {
+				maxObjectSize, err := humanize.ParseBytes(args[1])
+				if err != nil {
+					return d.Errf("invalid content_cache max object size %q: %v", args[1], err)
+				}
+				fs.ContentCacheMaxObjectSize = int64(maxObjectSize)
+			}
+		case "credential_fallback":
+			if d.NextArg() {
+				threshold, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid credential_fallback threshold %q: %v", d.Val(), err)
+				}
+				fs.CredentialFallbackThreshold = threshold
+			}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				var src CredentialSource
+				switch d.Val() {
+				case "profile":
+					if !d.AllArgs(&src.Profile) {
+						return d.ArgErr()
+					}
+				case "role":
+					if !d.AllArgs(&src.RoleARN) {
+						return d.ArgErr()
+					}
+				case "anonymous":
+					src.Anonymous = true
+				default:
+					return d.Errf("%s not a valid credential source", d.Val())
+				}
+				fs.CredentialFallback = append(fs.CredentialFallback, src)
+			}
+		case "latency_routing":
+			interval := "30s"
+			if d.NextArg() {
+				interval = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(interval)
+			if err != nil {
+				return d.Errf("invalid latency_routing interval %q: %v", interval, err)
+			}
+			fs.LatencyRouting = caddy.Duration(dur)
+		case "strict_consistency":
+			fs.StrictConsistency = true
+		case "forbidden_as_not_exist":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fs.ForbiddenAsNotExist = d.Val()
+		case "requester_pays":
+			fs.RequesterPays = true
+		case "writable":
+			fs.Writable = true
+		case "allow_remove_all":
+			fs.AllowRemoveAll = true
+		case "encryption":
+			fs.Encryption = new(Encryption)
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fs.Encryption.Type = d.Val()
+			if d.NextArg() {
+				fs.Encryption.KMSKeyID = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "bucket_key":
+					fs.Encryption.BucketKey = true
+				default:
+					return d.Errf("%s not a valid encryption option", d.Val())
+				}
+			}
+		case "customer_key":
+			fs.CustomerKey = new(CustomerKey)
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fs.CustomerKey.KeyFile = d.Val()
+			if d.NextArg() {
+				fs.CustomerKey.KeyMD5 = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "multipart":
+			fs.Multipart = new(Multipart)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				var err error
+				switch d.Val() {
+				case "threshold":
+					err = parseSize(d, &fs.Multipart.Threshold)
+				case "part_size":
+					err = parseSize(d, &fs.Multipart.PartSize)
+				case "concurrency":
+					err = parseInt(d, &fs.Multipart.Concurrency)
+				default:
+					return d.Errf("%s not a valid multipart option", d.Val())
+				}
+				if err != nil {
+					return err
+				}
+			}
+		case "versions_directory":
+			fs.VersionsDirectory = true
+		case "max_list_entries":
+			if err := parseInt(d, &fs.MaxListEntries); err != nil {
+				return err
+			}
+		case "max_list_pages":
+			if err := parseInt(d, &fs.MaxListPages); err != nil {
+				return err
+			}
+		case "max_concurrent_requests":
+			if err := parseInt(d, &fs.MaxConcurrentRequests); err != nil {
+				return err
+			}
+		case "adaptive_concurrency":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			min, err := strconv.Atoi(args[0])
+			if err != nil || min <= 0 {
+				return d.Errf("invalid minimum concurrency %q", args[0])
+			}
+			fs.MinConcurrentRequests = min
+			if len(args) == 2 {
+				dur, err := caddy.ParseDuration(args[1])
+				if err != nil {
+					return d.Errf("invalid latency target %q: %v", args[1], err)
+				}
+				fs.ConcurrencyLatencyTarget = caddy.Duration(dur)
+			}
+		case "rate_limit":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			rate, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "/s"), 64)
+			if err != nil || rate <= 0 {
+				return d.Errf("invalid request rate %q", args[0])
+			}
+			fs.RequestRate = rate
+			if len(args) == 2 {
+				burst, err := strconv.Atoi(args[1])
+				if err != nil || burst <= 0 {
+					return d.Errf("invalid burst %q", args[1])
+				}
+				fs.RequestBurst = burst
+			}
+		case "slow_stream_threshold":
+			if err := parseSize(d, &fs.SlowStreamThreshold); err != nil {
+				return err
+			}
+		case "mod_time_metadata":
+			if !d.AllArgs(&fs.ModTimeMetadata) {
+				return d.ArgErr()
+			}
+		case "mod_time_precision":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "round") {
+				return d.ArgErr()
+			}
+			precision, err := caddy.ParseDuration(args[0])
+			if err != nil {
+				return d.Errf("invalid mod_time_precision %q: %v", args[0], err)
+			}
+			fs.ModTimePrecision = caddy.Duration(precision)
+			fs.ModTimeRound = len(args) == 2
+		case "required_tag":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			fs.RequiredTag = args[0]
+			if len(args) == 2 {
+				ttl, err := caddy.ParseDuration(args[1])
+				if err != nil {
+					return d.Errf("invalid required_tag cache ttl %q: %v", args[1], err)
+				}
+				fs.TagCacheTTL = caddy.Duration(ttl)
+			}
+		case "expiry_metadata":
+			if !d.AllArgs(&fs.ExpiryMetadata) {
+				return d.ArgErr()
+			}
+		case "expiry_tag":
+			if !d.AllArgs(&fs.ExpiryTag) {
+				return d.ArgErr()
+			}
+		case "immutable":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			fs.Immutable = append(fs.Immutable, args...)
+		case "exact_ranges":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			fs.ExactRanges = append(fs.ExactRanges, args...)
+		case "readahead":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 || (args[0] == "off" && len(args) > 1) {
+				return d.ArgErr()
+			}
+			if args[0] == "off" {
+				fs.Readahead = -1
+				continue
+			}
+			size, err := humanize.ParseBytes(args[0])
+			if err != nil {
+				return d.Errf("invalid readahead %q: %v", args[0], err)
+			}
+			fs.Readahead = int64(size)
+			if len(args) > 1 {
+				max, err := humanize.ParseBytes(args[1])
+				if err != nil {
+					return d.Errf("invalid readahead max %q: %v", args[1], err)
+				}
+				fs.ReadaheadMax = int64(max)
+			}
+		case "parallel_download":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(args[0])
+			if err != nil {
+				return d.Errf("invalid parallel_download part size %q: %v", args[0], err)
+			}
+			fs.ParallelPartSize = int64(size)
+			if len(args) > 1 {
+				concurrency, err := strconv.Atoi(args[1])
+				if err != nil {
+					return d.Errf("invalid parallel_download concurrency %q: %v", args[1], err)
+				}
+				fs.ParallelConcurrency = concurrency
+			}
+		case "quota":
+			var q Quota
+			switch args := d.RemainingArgs(); len(args) {
+			case 0:
+			case 1:
+				q.Prefix = args[0]
+			default:
+				return d.ArgErr()
+			}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "daily":
+					if err := parseSize(d, &q.Daily); err != nil {
+						return err
+					}
+				case "monthly":
+					if err := parseSize(d, &q.Monthly); err != nil {
+						return err
+					}
+				default:
+					return d.Errf("%s not a valid quota option", d.Val())
+				}
+			}
+			fs.Quotas = append(fs.Quotas, q)
+		case "timeouts":
+			fs.Timeouts = new(Timeouts)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				directive := d.Val()
+				var val string
+				if !d.AllArgs(&val) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(val)
+				if err != nil {
+					return d.Errf("invalid %s timeout %q: %v", directive, val, err)
+				}
+				switch directive {
+				case "head":
+					fs.Timeouts.Head = caddy.Duration(dur)
+				case "first_byte":
+					fs.Timeouts.FirstByte = caddy.Duration(dur)
+				case "list":
+					fs.Timeouts.List = caddy.Duration(dur)
+				default:
+					return d.Errf("%s not a valid timeouts option", directive)
+				}
+			}
+		case "metrics":
+			fs.Metrics = true
+		case "tracing":
+			fs.Tracing = true
+		case "verbose_logging":
+			fs.VerboseLogging = true
+		case "metadata_only":
+			fs.MetadataOnly = true
+		case "probe_capabilities":
+			fs.ProbeCapabilities = true
+			if d.NextArg() {
+				fs.ProbeKey = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "preposition":
+			fs.Preposition = new(Preposition)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				directive := d.Val()
+				if directive == "extensions" {
+					fs.Preposition.Extensions = append(fs.Preposition.Extensions, d.RemainingArgs()...)
+					continue
+				}
+				var val string
+				if !d.AllArgs(&val) {
+					return d.ArgErr()
+				}
+				switch directive {
+				case "head_size", "cache_size":
+					size, err := humanize.ParseBytes(val)
+					if err != nil {
+						return d.Errf("invalid %s %q: %v", directive, val, err)
+					}
+					if directive == "head_size" {
+						fs.Preposition.HeadSize = int64(size)
+					} else {
+						fs.Preposition.CacheSize = int64(size)
+					}
+				case "max_objects", "concurrency":
+					n, err := strconv.Atoi(val)
+					if err != nil {
+						return d.Errf("invalid %s %q: %v", directive, val, err)
+					}
+					if directive == "max_objects" {
+						fs.Preposition.MaxObjects = n
+					} else {
+						fs.Preposition.Concurrency = n
+					}
+				default:
+					return d.Errf("%s not a valid preposition option", directive)
+				}
+			}
+		case "circuit_breaker":
+			fs.CircuitBreaker = new(CircuitBreaker)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				directive := d.Val()
+				var val string
+				if !d.AllArgs(&val) {
+					return d.ArgErr()
+				}
+				switch directive {
+				case "failures":
+					n, err := strconv.Atoi(val)
+					if err != nil {
+						return d.Errf("invalid failures %q: %v", val, err)
+					}
+					fs.CircuitBreaker.Failures = n
+				case "error_rate":
+					percent, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+					if err != nil {
+						return d.Errf("invalid error_rate %q: %v", val, err)
+					}
+					fs.CircuitBreaker.ErrorPercent = percent
+				case "window", "cooldown":
+					dur, err := caddy.ParseDuration(val)
+					if err != nil {
+						return d.Errf("invalid %s %q: %v", directive, val, err)
+					}
+					if directive == "window" {
+						fs.CircuitBreaker.Window = caddy.Duration(dur)
+					} else {
+						fs.CircuitBreaker.Cooldown = caddy.Duration(dur)
+					}
+				default:
+					return d.Errf("%s not a valid circuit_breaker option", directive)
+				}
+			}
+		case "retry":
+			fs.Retry = new(Retry)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "max_attempts":
+					var val string
+					if !d.AllArgs(&val) {
+						return d.ArgErr()
+					}
+					n, err := strconv.Atoi(val)
+					if err != nil {
+						return d.Errf("invalid max_attempts %q: %v", val, err)
+					}
+					fs.Retry.MaxAttempts = n
+				case "backoff":
+					args := d.RemainingArgs()
+					if len(args) < 1 || len(args) > 2 {
+						return d.ArgErr()
+					}
+					durs := make([]time.Duration, len(args))
+					for i, arg := range args {
+						dur, err := caddy.ParseDuration(arg)
+						if err != nil {
+							return d.Errf("invalid backoff %q: %v", arg, err)
+						}
+						durs[i] = dur
+					}
+					fs.Retry.BackoffBase = caddy.Duration(durs[0])
+					if len(durs) > 1 {
+						fs.Retry.BackoffCap = caddy.Duration(durs[1])
+					}
+				case "retry_on":
+					fs.Retry.Retryable = d.RemainingArgs()
+					if len(fs.Retry.Retryable) == 0 {
+						return d.ArgErr()
+					}
+				default:
+					return d.Errf("%s not a valid retry option", d.Val())
+				}
+			}
+		case "override":
+			o := Override{Match: d.RemainingArgs()}
+			if len(o.Match) == 0 {
+				return d.ArgErr()
+			}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "readahead":
+					var val string
+					if !d.AllArgs(&val) {
+						return d.ArgErr()
+					}
+					if val == "off" {
+						o.Readahead = -1
+						continue
+					}
+					size, err := humanize.ParseBytes(val)
+					if err != nil {
+						return d.Errf("invalid readahead %q: %v", val, err)
+					}
+					o.Readahead = int64(size)
+				case "cache_ttl":
+					var val string
+					if !d.AllArgs(&val) {
+						return d.ArgErr()
+					}
+					dur, err := caddy.ParseDuration(val)
+					if err != nil {
+						return d.Errf("invalid cache_ttl %q: %v", val, err)
+					}
+					o.CacheTTL = caddy.Duration(dur)
+				default:
+					return d.Errf("%s not a valid override option", d.Val())
+				}
+			}
+			fs.Overrides = append(fs.Overrides, o)
+		case "access_export":
+			export, err := unmarshalAccessExport(d)
+			if err != nil {
+				return err
+			}
+			fs.AccessExport = export
+		case "archive":
+			archive, err := unmarshalArchive(d)
+			if err != nil {
+				return err
+			}
+			fs.Archive = archive
+		case "verify_signatures":
+			verification, err := unmarshalSignatureVerification(d)
+			if err != nil {
+				return err
+			}
+			fs.SignatureVerification = verification
+		case "canary":
+			canary, err := unmarshalCanary(d)
+			if err != nil {
+				return err
+			}
+			fs.Canary = canary
+		case "disk_cache":
+			cache, err := unmarshalDiskCache(d)
+			if err != nil {
+				return err
+			}
+			fs.DiskCache = cache
+		case "fault_injection":
+			fs.FaultInjection = new(FaultInjection)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				directive := d.Val()
+				var val string
+				if !d.AllArgs(&val) {
+					return d.ArgErr()
+				}
+				if directive == "delay_duration" {
+					dur, err := caddy.ParseDuration(val)
+					if err != nil {
+						return d.Errf("invalid delay_duration %q: %v", val, err)
+					}
+					fs.FaultInjection.Delay = caddy.Duration(dur)
+					continue
+				}
+				percent, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+				if err != nil {
+					return d.Errf("invalid percentage %q for %s: %v", val, directive, err)
+				}
+				switch directive {
+				case "delay":
+					fs.FaultInjection.DelayPercent = percent
+				case "error":
+					fs.FaultInjection.ErrorPercent = percent
+				case "truncate":
+					fs.FaultInjection.TruncatePercent = percent
+				default:
+					return d.Errf("%s not a valid fault_injection option", directive)
+				}
+			}
+		case "partition_window":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			days, err := strconv.Atoi(args[0])
+			if err != nil {
+				return d.Errf("invalid partition_window days %q: %v", args[0], err)
+			}
+			fs.PartitionWindowDays = days
+			if len(args) == 2 {
+				fs.PartitionDateKey = args[1]
+			}
+		case "partition_display":
+			fs.PartitionDisplay = d.RemainingArgs()
+			if len(fs.PartitionDisplay) == 0 {
+				fs.PartitionDisplay = []string{"year", "month", "day", "hour"}
+			}
+		case "drain_timeout":
+			var val string
+			if !d.AllArgs(&val) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return d.Errf("invalid drain_timeout %q: %v", val, err)
+			}
+			fs.DrainTimeout = caddy.Duration(dur)
+		case "vpc_endpoint":
+			fs.VPCEndpoint = true
+		case "sts_region":
+			if !d.AllArgs(&fs.STSRegion) {
+				return d.ArgErr()
+			}
+		case "sts_endpoint":
+			if !d.AllArgs(&fs.STSEndpoint) {
+				return d.ArgErr()
+			}
+		case "credential_timeout":
+			var val string
+			if !d.AllArgs(&val) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return d.Errf("invalid credential_timeout %q: %v", val, err)
+			}
+			fs.CredentialTimeout = caddy.Duration(dur)
+		case "sort":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			fs.Sort = args[0]
+			if len(args) == 2 {
+				switch args[1] {
+				case "asc":
+				case "desc":
+					fs.SortDescending = true
+				default:
+					return d.Errf("invalid sort direction %q, must be asc or desc", args[1])
+				}
+			}
+		case "hot_keys":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			window, err := caddy.ParseDuration(args[0])
+			if err != nil {
+				return d.Errf("invalid hot_keys window %q: %v", args[0], err)
+			}
+			fs.HotKeysWindow = caddy.Duration(window)
+			if len(args) == 2 {
+				top, err := strconv.Atoi(args[1])
+				if err != nil {
+					return d.Errf("invalid hot_keys count %q: %v", args[1], err)
+				}
+				fs.HotKeysTop = top
+			}
 		default:
 			return d.Errf("%s not a valid caddy.fs.s3 option", d.Val())
 		}
@@ -115,3 +1463,49 @@ func (fs *FS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 	return nil
 }
+
+// parseOverlaySource parses the block of an overlay source.
+func parseOverlaySource(d *caddyfile.Dispenser, src *OverlaySource) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "above":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			src.Above = true
+		default:
+			return d.Errf("%s not a valid overlay option", d.Val())
+		}
+	}
+	return nil
+}
+
+// parseInt reads a single integer argument from the dispenser.
+func parseInt(d *caddyfile.Dispenser, target *int) error {
+	name := d.Val()
+	var val string
+	if !d.AllArgs(&val) {
+		return d.ArgErr()
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return d.Errf("invalid integer value %q for %s: %v", val, name, err)
+	}
+	*target = i
+	return nil
+}
+
+// parseSize reads a single size argument such as `64KiB` from the dispenser.
+func parseSize(d *caddyfile.Dispenser, target *int64) error {
+	name := d.Val()
+	var val string
+	if !d.AllArgs(&val) {
+		return d.ArgErr()
+	}
+	size, err := humanize.ParseBytes(val)
+	if err != nil {
+		return d.Errf("invalid size value %q for %s: %v", val, name, err)
+	}
+	*target = int64(size)
+	return nil
+}
@@ -0,0 +1,107 @@
+package caddys3fs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/floj/caddy-s3fs/s3fs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// s3Metrics are the collectors shared by all filesystems, registered with
+// the default registry served by Caddy's metrics handler.
+var s3Metrics = struct {
+	init     sync.Once
+	calls    *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+	cache    *prometheus.CounterVec
+}{}
+
+func initS3Metrics() {
+	const ns, sub = "caddy", "s3fs"
+
+	s3Metrics.calls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "requests_total",
+		Help:      "Number of S3 requests made.",
+	}, []string{"bucket", "operation"})
+	s3Metrics.errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "request_errors_total",
+		Help:      "Number of failed S3 requests by HTTP status, or timeout, canceled or network if there was no response.",
+	}, []string{"bucket", "operation", "status"})
+	s3Metrics.duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "request_duration_seconds",
+		Help:      "Time until S3 responded to a request.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"bucket", "operation"})
+	s3Metrics.bytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "response_bytes_total",
+		Help:      "Bytes of response bodies read from S3.",
+	}, []string{"bucket", "operation"})
+	s3Metrics.cache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "cache_requests_total",
+		Help:      "Lookups in the caches of the filesystem by result, hit or miss.",
+	}, []string{"bucket", "cache", "result"})
+}
+
+// metrics exports the measurements of the filesystem of bucket.
+type metrics struct {
+	bucket string
+}
+
+// newMetrics returns the metrics of bucket.
+func newMetrics(bucket string) metrics {
+	s3Metrics.init.Do(initS3Metrics)
+	return metrics{bucket: bucket}
+}
+
+func (m metrics) Call(operation string, latency time.Duration, err error) {
+	s3Metrics.calls.WithLabelValues(m.bucket, operation).Inc()
+	s3Metrics.duration.WithLabelValues(m.bucket, operation).Observe(latency.Seconds())
+	if err != nil {
+		s3Metrics.errors.WithLabelValues(m.bucket, operation, errorStatus(err)).Inc()
+	}
+}
+
+func (m metrics) Bytes(operation string, n int64) {
+	s3Metrics.bytes.WithLabelValues(m.bucket, operation).Add(float64(n))
+}
+
+func (m metrics) Cache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	s3Metrics.cache.WithLabelValues(m.bucket, cache, result).Inc()
+}
+
+// errorStatus returns the HTTP status of the response err was caused by,
+// or why there was none.
+func errorStatus(err error) string {
+	var respErr *awshttp.ResponseError
+	switch {
+	case errors.As(err, &respErr):
+		return strconv.Itoa(respErr.HTTPStatusCode())
+	case errors.Is(err, s3fs.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "network"
+}
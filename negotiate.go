@@ -0,0 +1,171 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/encode"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+func init() {
+	caddy.RegisterModule(Negotiate{})
+	httpcaddyfile.RegisterHandlerDirective("s3fs_negotiate", parseNegotiate)
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Negotiate)(nil)
+	_ caddyfile.Unmarshaler       = (*Negotiate)(nil)
+	_ caddy.Provisioner           = (*Negotiate)(nil)
+	_ caddy.Validator             = (*Negotiate)(nil)
+)
+
+// variantSuffixes maps content codings to the suffix of the keys storing
+// their variants.
+var variantSuffixes = map[string]string{
+	"br":   ".br",
+	"zstd": ".zst",
+	"gzip": ".gz",
+}
+
+// defaultVariantOrder is the server preference among equally acceptable
+// variants.
+var defaultVariantOrder = []string{"br", "zstd", "gzip"}
+
+// Negotiate is a middleware serving stored compressed variants of objects,
+// e.g. `app.js.gz` next to `app.js`, to clients accepting their encoding.
+// Unlike the precompressed option of the file_server, a variant is only
+// chosen if it was uploaded with the matching Content-Encoding, so
+// accidentally compressed or mislabeled objects are never sent encoded.
+// The request is rewritten to the variant, so a file_server serving the
+// filesystem of Bucket needs to follow this handler.
+type Negotiate struct {
+	// Bucket of the filesystem to look variants up in. May be omitted if
+	// only a single bucket is served.
+	Bucket string `json:"bucket,omitempty"`
+
+	// Root of the file_server. Defaults to `{http.vars.root}`, like the
+	// file_server.
+	Root string `json:"root,omitempty"`
+
+	// Encodings to negotiate, out of br, zstd and gzip, in order of
+	// preference. Defaults to all, in that order.
+	Encodings []string `json:"encodings,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Negotiate) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.s3fs_negotiate",
+		New: func() caddy.Module { return new(Negotiate) },
+	}
+}
+
+// Provision applies defaults.
+func (n *Negotiate) Provision(ctx caddy.Context) error {
+	if len(n.Encodings) == 0 {
+		n.Encodings = defaultVariantOrder
+	}
+	if n.Root == "" {
+		n.Root = "{http.vars.root}"
+	}
+	return nil
+}
+
+// Validate checks the configured encodings.
+func (n *Negotiate) Validate() error {
+	for _, enc := range n.Encodings {
+		if _, ok := variantSuffixes[enc]; !ok {
+			return fmt.Errorf("unsupported encoding %q, must be one of br, zstd or gzip", enc)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP rewrites the request to the best stored variant accepted by the
+// client, if any.
+func (n *Negotiate) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	fsys, err := Lookup(n.Bucket)
+	if err != nil {
+		return next.ServeHTTP(w, r)
+	}
+	// the response depends on Accept-Encoding whether a variant is
+	// chosen or not
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	root := repl.ReplaceAll(n.Root, ".")
+	filename := caddyhttp.SanitizedPathJoin(root, r.URL.Path)
+	variants := fsys.WithContext(r.Context())
+	for _, enc := range encode.AcceptedEncodings(r, n.Encodings) {
+		if !n.negotiates(enc) {
+			continue
+		}
+		suffix := variantSuffixes[enc]
+		info, err := variants.Stat(filename + suffix)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if obj, ok := info.Sys().(*s3fs.ObjectInfo); !ok || obj.ContentEncoding != enc {
+			continue
+		}
+		w.Header().Set("Content-Encoding", enc)
+		if typ := mime.TypeByExtension(path.Ext(filename)); typ != "" && w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", typ)
+		}
+		r.URL.Path += suffix
+		break
+	}
+	return next.ServeHTTP(w, r)
+}
+
+// negotiates reports whether enc is one of the configured encodings.
+func (n *Negotiate) negotiates(enc string) bool {
+	for _, e := range n.Encodings {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	s3fs_negotiate [<encodings...>] {
+//		bucket <name>
+//		root <path>
+//	}
+func (n *Negotiate) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		n.Encodings = append(n.Encodings, d.RemainingArgs()...)
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "bucket":
+				if !d.AllArgs(&n.Bucket) {
+					return d.ArgErr()
+				}
+			case "root":
+				if !d.AllArgs(&n.Root) {
+					return d.ArgErr()
+				}
+			default:
+				return d.Errf("%s not a valid s3fs_negotiate option", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// parseNegotiate unmarshals the s3fs_negotiate directive.
+func parseNegotiate(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	n := new(Negotiate)
+	err := n.UnmarshalCaddyfile(h.Dispenser)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package caddys3fs
+
+import (
+	"fmt"
+	iofs "io/fs"
+	"os"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/floj/caddy-s3fs/s3fs"
+	"go.uber.org/zap"
+)
+
+// newOverlay layers the sources of Overlay above and below s, creating the
+// filesystems of bucket sources with client and opts.
+func (fs *FS) newOverlay(ctx caddy.Context, s *s3fs.S3FS, client s3fs.Client, opts []s3fs.Option) (*s3fs.Overlay, error) {
+	var above, below []iofs.FS
+	for _, src := range fs.Overlay {
+		var layer iofs.FS
+		if src.Dir != "" {
+			info, err := os.Stat(src.Dir)
+			if err != nil {
+				return nil, fmt.Errorf("overlay directory: %v", err)
+			}
+			if !info.IsDir() {
+				return nil, fmt.Errorf("overlay directory %s is not a directory", src.Dir)
+			}
+			layer = os.DirFS(src.Dir)
+		} else {
+			bucket := src.Bucket
+			if bucket == "" {
+				bucket = fs.Bucket
+			}
+			layerOpts := append(opts[:len(opts):len(opts)], s3fs.WithPrefix(src.Prefix))
+			if fs.Metrics {
+				layerOpts = append(layerOpts, s3fs.WithMetrics(newMetrics(bucket)))
+			}
+			layer = s3fs.NewFS(bucket, client, ctx.Logger().With(zap.String("bucket", bucket)), layerOpts...)
+		}
+		if src.Above {
+			above = append(above, layer)
+		} else {
+			below = append(below, layer)
+		}
+	}
+	layers := append(append(above, s), below...)
+	return s3fs.NewOverlay(layers...), nil
+}
+
+// adoptCaches lets the filesystems of s adopt the caches of the ones of
+// prev, which has the same handoff key.
+func adoptCaches(s, prev iofs.StatFS) {
+	switch s := s.(type) {
+	case *s3fs.S3FS:
+		s.Adopt(prev.(*s3fs.S3FS))
+	case *s3fs.Overlay:
+		prevLayers := prev.(*s3fs.Overlay).Layers()
+		for i, l := range s.Layers() {
+			if l, ok := l.(*s3fs.S3FS); ok {
+				l.Adopt(prevLayers[i].(*s3fs.S3FS))
+			}
+		}
+	}
+}
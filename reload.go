@@ -0,0 +1,48 @@
+package caddys3fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// handoffKey hashes the settings determining which objects are served and
+// how they are accessed, i.e. the whole config except the settings only
+// tuning the caches, limits, retries and telemetry. Filesystems with the same
+// key may share their caches across config reloads.
+func (fs *FS) handoffKey() string {
+	c := fs.Config
+	// adopted caches, quota counters and limits keep working with other
+	// values of these
+	c.HotKeysWindow, c.HotKeysTop = 0, 0
+	c.PrefetchCount, c.PrefetchConcurrency, c.PrefetchTTL = 0, 0, 0
+	c.StatCacheTTL, c.StatCacheSize = 0, 0
+	c.StaleBudget, c.StaleMaxAge = 0, 0
+	c.NotFoundCacheTTL, c.NotFoundCacheSize = 0, 0
+	c.PrefixLayerMissTTL, c.TagCacheTTL = 0, 0
+	c.ContentCacheSize = 0
+	c.MaxConcurrentRequests, c.MinConcurrentRequests, c.ConcurrencyLatencyTarget = 0, 0, 0
+	c.RequestRate, c.RequestBurst, c.Quotas = 0, 0, nil
+	c.SlowStreamThreshold = 0
+	c.CredentialFallbackThreshold, c.CredentialTimeout = 0, 0
+	c.DrainTimeout = 0
+	c.Timeouts, c.Retry, c.CircuitBreaker = nil, nil, nil
+	c.Metrics, c.Tracing, c.VerboseLogging = false, false, false
+	c.AccessExport, c.Preposition = nil, nil
+	c.ProbeCapabilities, c.ProbeKey = false, ""
+	settings, _ := json.Marshal(c)
+	sum := sha256.Sum256(settings)
+	return hex.EncodeToString(sum[:])
+}
+
+// previousInstance returns a provisioned filesystem with the same handoff
+// key, usually the one of the config being replaced, or nil.
+func previousInstance(key string) *FS {
+	var prev *FS
+	eachInstance(func(fs *FS) {
+		if fs.handoff == key {
+			prev = fs
+		}
+	})
+	return prev
+}
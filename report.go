@@ -0,0 +1,35 @@
+package caddys3fs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+// logEffectiveConfig logs a single summary of the effective configuration,
+// including the values resolved by the AWS SDK, to ease diagnosing
+// misconfigurations.
+func (fs *FS) logEffectiveConfig(log *zap.Logger, cfg aws.Config) {
+	// the credentials were resolved by Provision and are served from the
+	// cache
+	credentialSource := "unavailable"
+	if cfg.Credentials != nil {
+		if creds, err := cfg.Credentials.Retrieve(context.Background()); err == nil {
+			credentialSource = creds.Source
+		}
+	}
+
+	endpoint := fs.Endpoint
+	if endpoint == "" {
+		endpoint = "default"
+	}
+
+	log.Info("provisioned s3 filesystem",
+		zap.String("bucket", fs.Bucket),
+		zap.String("region", cfg.Region),
+		zap.String("endpoint", endpoint),
+		zap.String("credential_source", credentialSource),
+		zap.Reflect("config", fs),
+	)
+}
@@ -0,0 +1,146 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Retryable error classes of Retry.
+const (
+	retryThrottle   = "throttle"
+	retryServer     = "server"
+	retryConnection = "connection"
+	retryTimeout    = "timeout"
+)
+
+// defaultRetryBackoffBase matches the backoff of the SDK.
+const defaultRetryBackoffBase = time.Second
+
+// Retry tunes how the S3 clients retry failed requests, e.g. to ride out
+// flaky networks or S3 503 SlowDown throttling.
+type Retry struct {
+	// Maximum number of attempts per request including the first one, 1
+	// disables retries. Defaults to 3.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// Backoff before the first retry, doubling with every further one. The
+	// actual delay is chosen randomly up to the backoff. Defaults to 1s.
+	BackoffBase caddy.Duration `json:"backoff_base,omitempty"`
+
+	// Maximum backoff between two attempts. Defaults to 20s.
+	BackoffCap caddy.Duration `json:"backoff_cap,omitempty"`
+
+	// Classes of errors retried: `throttle` (429, 503 SlowDown and other
+	// throttling codes), `server` (500, 502, 503 and 504), `connection`
+	// (resets, network timeouts and other transport errors) and `timeout`
+	// (RequestTimeout responses of S3). Defaults to all of them.
+	Retryable []string `json:"retryable,omitempty"`
+}
+
+// validate checks the policy for errors.
+func (r *Retry) validate() error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("invalid retry max_attempts %d", r.MaxAttempts)
+	}
+	if r.BackoffBase > 0 && r.BackoffCap > 0 && r.BackoffBase > r.BackoffCap {
+		return fmt.Errorf("retry backoff base %s exceeds its cap %s",
+			time.Duration(r.BackoffBase), time.Duration(r.BackoffCap))
+	}
+	for _, class := range r.Retryable {
+		switch class {
+		case retryThrottle, retryServer, retryConnection, retryTimeout:
+		default:
+			return fmt.Errorf("invalid retryable error class %q, must be one of throttle, server, connection or timeout", class)
+		}
+	}
+	return nil
+}
+
+// retryer returns the retryer of S3 clients, nil for the SDK default.
+func (r *Retry) retryer() func() aws.Retryer {
+	if r == nil {
+		return nil
+	}
+	base := time.Duration(r.BackoffBase)
+	if base <= 0 {
+		base = defaultRetryBackoffBase
+	}
+	max := time.Duration(r.BackoffCap)
+	if max <= 0 {
+		max = retry.DefaultMaxBackoff
+	}
+	if base > max {
+		base = max
+	}
+	return func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			if r.MaxAttempts > 0 {
+				o.MaxAttempts = r.MaxAttempts
+			}
+			o.MaxBackoff = max
+			o.Backoff = jitterBackoff{base: base, max: max}
+			if len(r.Retryable) > 0 {
+				o.Retryables = retryables(r.Retryable)
+			}
+		})
+	}
+}
+
+// retryables returns the checks retrying the given error classes. Cancelled
+// requests are never retried.
+func retryables(classes []string) []retry.IsErrorRetryable {
+	checks := []retry.IsErrorRetryable{retry.NoRetryCanceledError{}}
+	statuses := map[int]struct{}{}
+	codes := map[string]struct{}{}
+	for _, class := range classes {
+		switch class {
+		case retryThrottle:
+			statuses[http.StatusTooManyRequests] = struct{}{}
+			for code := range retry.DefaultThrottleErrorCodes {
+				codes[code] = struct{}{}
+			}
+		case retryServer:
+			for status := range retry.DefaultRetryableHTTPStatusCodes {
+				statuses[status] = struct{}{}
+			}
+		case retryConnection:
+			checks = append(checks, retry.RetryableError{}, retry.RetryableConnectionError{})
+		case retryTimeout:
+			for code := range retry.DefaultRetryableErrorCodes {
+				codes[code] = struct{}{}
+			}
+		}
+	}
+	if len(statuses) > 0 {
+		checks = append(checks, retry.RetryableHTTPStatusCode{Codes: statuses})
+	}
+	if len(codes) > 0 {
+		checks = append(checks, retry.RetryableErrorCode{Codes: codes})
+	}
+	return checks
+}
+
+// jitterBackoff delays retries by a random duration up to an exponentially
+// growing backoff, starting at base and capped at max.
+type jitterBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// BackoffDelay returns the delay before retrying the failed attempt.
+func (b jitterBackoff) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	backoff := b.base
+	for i := 1; i < attempt && backoff < b.max; i++ {
+		backoff *= 2
+	}
+	if backoff > b.max {
+		backoff = b.max
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1)), nil
+}
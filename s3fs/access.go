@@ -0,0 +1,86 @@
+package s3fs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrefixAccess summarizes the accesses to the objects below a prefix.
+type PrefixAccess struct {
+	Prefix     string    `json:"prefix"`
+	Requests   int64     `json:"requests"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// WithAccessStats counts the objects opened below every prefix made of the
+// first depth path components of their keys, e.g. `logs/2024/` for depth 2,
+// to report by AccessStats. Keys with fewer components are counted by their
+// parent directory.
+func WithAccessStats(depth int) Option {
+	return func(s *S3FS) {
+		if depth <= 0 {
+			return
+		}
+		s.access = &accessStats{depth: depth, prefixes: make(map[string]*PrefixAccess)}
+	}
+}
+
+// AccessStats returns the accesses per prefix since the filesystem was
+// created, ordered by prefix. It returns nil if access stats are disabled.
+func (s3fs *S3FS) AccessStats() []PrefixAccess {
+	a := s3fs.access
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := make([]PrefixAccess, 0, len(a.prefixes))
+	for _, p := range a.prefixes {
+		stats = append(stats, *p)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Prefix < stats[j].Prefix })
+	return stats
+}
+
+// accessStats counts accesses per key prefix.
+type accessStats struct {
+	depth int
+
+	mu       sync.Mutex
+	prefixes map[string]*PrefixAccess
+}
+
+// record counts an access to key.
+func (a *accessStats) record(key string) {
+	if a == nil {
+		return
+	}
+	prefix := keyPrefix(key, a.depth)
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.prefixes[prefix]
+	if !ok {
+		p = &PrefixAccess{Prefix: prefix}
+		a.prefixes[prefix] = p
+	}
+	p.Requests++
+	p.LastAccess = now
+}
+
+// keyPrefix returns the first depth directories of key including the
+// trailing slash, or "" for keys at the root.
+func keyPrefix(key string, depth int) string {
+	key = strings.TrimPrefix(key, "/")
+	end := 0
+	for i := 0; i < depth; i++ {
+		next := strings.IndexByte(key[end:], '/')
+		if next < 0 {
+			break
+		}
+		end += next + 1
+	}
+	return key[:end]
+}
@@ -0,0 +1,173 @@
+package s3fs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// NewAferoFs returns a read-only afero.Fs serving fsys, e.g. an S3FS or an
+// Overlay, for projects built on afero. Paths may be absolute, the root
+// being the root of fsys. Calls modifying the filesystem fail with
+// syscall.EPERM like those of afero.ReadOnlyFs.
+func NewAferoFs(fsys fs.FS) afero.Fs {
+	return aferoFs{fsys}
+}
+
+// aferoFs adapts an fs.FS to afero.Fs.
+type aferoFs struct {
+	fsys fs.FS
+}
+
+var _ afero.Fs = aferoFs{}
+
+// Name returns the name of the underlying filesystem if it has one.
+func (a aferoFs) Name() string {
+	if n, ok := a.fsys.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return "s3"
+}
+
+func (a aferoFs) Open(name string) (afero.File, error) {
+	f, err := a.fsys.Open(rootedName(filepath.ToSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	return &aferoFile{File: f, name: name}, nil
+}
+
+func (a aferoFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	return a.Open(name)
+}
+
+func (a aferoFs) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(a.fsys, rootedName(filepath.ToSlash(name)))
+}
+
+func (a aferoFs) Create(name string) (afero.File, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: syscall.EPERM}
+}
+
+func (a aferoFs) Mkdir(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (a aferoFs) MkdirAll(path string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: path, Err: syscall.EPERM}
+}
+
+func (a aferoFs) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (a aferoFs) RemoveAll(path string) error {
+	return &fs.PathError{Op: "remove", Path: path, Err: syscall.EPERM}
+}
+
+func (a aferoFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (a aferoFs) Chmod(name string, mode os.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (a aferoFs) Chown(name string, uid, gid int) error {
+	return &fs.PathError{Op: "chown", Path: name, Err: syscall.EPERM}
+}
+
+func (a aferoFs) Chtimes(name string, atime, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// aferoFile adapts an fs.File to afero.File.
+type aferoFile struct {
+	fs.File
+	name string // name is the name the file was opened with
+}
+
+var _ afero.File = (*aferoFile)(nil)
+
+func (f *aferoFile) Name() string {
+	return f.name
+}
+
+func (f *aferoFile) ReadAt(p []byte, off int64) (int, error) {
+	r, ok := f.File.(io.ReaderAt)
+	if !ok {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return r.ReadAt(p, off)
+}
+
+func (f *aferoFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return s.Seek(offset, whence)
+}
+
+// Readdir returns the FileInfo of up to count entries, or of all remaining
+// ones if count is not positive.
+func (f *aferoFile) Readdir(count int) ([]os.FileInfo, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	entries, err := dir.ReadDir(count)
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, infoErr := e.Info()
+		if infoErr != nil {
+			return infos, infoErr
+		}
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+// Readdirnames returns the names of up to n entries, or of all remaining
+// ones if n is not positive.
+func (f *aferoFile) Readdirnames(n int) ([]string, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	entries, err := dir.ReadDir(n)
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, err
+}
+
+func (f *aferoFile) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *aferoFile) WriteAt([]byte, int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *aferoFile) WriteString(string) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *aferoFile) Truncate(int64) error {
+	return &fs.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
+
+// Sync does nothing, as nothing is written.
+func (f *aferoFile) Sync() error {
+	return nil
+}
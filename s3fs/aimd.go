@@ -0,0 +1,135 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// aimdCooldown is the minimum time between two decreases of the
+	// adaptive concurrency limit, so a burst of failures of requests issued
+	// at the same time counts once.
+	aimdCooldown = time.Second
+
+	// aimdThrottleFactor scales the limit down when S3 throttles requests.
+	aimdThrottleFactor = 0.5
+
+	// aimdLatencyFactor scales the limit down when responses are slower
+	// than the latency target.
+	aimdLatencyFactor = 0.9
+)
+
+// WithAdaptiveConcurrency adapts the limit of WithConcurrencyLimit between
+// min and its maximum, additively increasing it while S3 responds in time
+// and multiplicatively decreasing it once S3 throttles requests (503
+// SlowDown) or takes longer than latencyTarget to respond. A zero
+// latencyTarget only reacts to throttling. It has no effect without a
+// concurrency limit.
+func WithAdaptiveConcurrency(min int, latencyTarget time.Duration) Option {
+	return func(s *S3FS) {
+		if min <= 0 {
+			return
+		}
+		s.adaptive = &aimd{min: min, target: latencyTarget, clock: systemClock{}}
+	}
+}
+
+// aimd holds the state of a limiter adapting its limit.
+type aimd struct {
+	min     int
+	ceiling int // ceiling is the configured limit
+	target  time.Duration
+	clock   Clock
+
+	successes int       // successes counts responses since the limit changed
+	decreased time.Time // decreased is the time of the last decrease
+}
+
+// adapt makes l adapt its limit between min and the current one.
+func (l *limiter) adapt(a *aimd) {
+	a.ceiling = l.max
+	if a.min > a.ceiling {
+		a.min = a.ceiling
+	}
+	l.adaptive = a
+}
+
+// isThrottled reports whether err is S3 asking to slow down, i.e. a 503
+// SlowDown, or a 429 of some S3 compatible stores.
+func isThrottled(err error) bool {
+	switch httpStatus(err) {
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// observe adapts the limit to the outcome of a call which took latency to
+// respond. It is a no-op unless the limiter is adaptive.
+func (l *limiter) observe(latency time.Duration, err error) {
+	if l == nil || l.adaptive == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a := l.adaptive
+	switch {
+	case isThrottled(err):
+		l.decrease(aimdThrottleFactor)
+	case a.target > 0 && latency > a.target:
+		l.decrease(aimdLatencyFactor)
+	default:
+		// raise the limit by one per limit responses in time
+		a.successes++
+		if a.successes >= l.max && l.max < a.ceiling {
+			l.max++
+			a.successes = 0
+			l.grant()
+		}
+	}
+}
+
+// decrease scales the limit down by factor, the lock must be held. Slots
+// beyond the new limit are not handed over once released.
+func (l *limiter) decrease(factor float64) {
+	a := l.adaptive
+	if a.clock.Now().Sub(a.decreased) < aimdCooldown {
+		return
+	}
+	max := int(float64(l.max) * factor)
+	if max < a.min {
+		max = a.min
+	}
+	l.max = max
+	a.decreased = a.clock.Now()
+	a.successes = 0
+}
+
+// grant hands free slots to waiters, the lock must be held.
+func (l *limiter) grant() {
+	for l.inUse < l.max {
+		next := l.next()
+		if next == nil {
+			return
+		}
+		l.inUse++
+		close(next)
+	}
+}
+
+// adopt takes over the limit learned by prev.
+func (l *limiter) adopt(prev *limiter) {
+	if l == nil || prev == nil || l.adaptive == nil || prev.adaptive == nil {
+		return
+	}
+	prev.mu.Lock()
+	max := prev.max
+	prev.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max >= l.adaptive.min && max < l.max {
+		l.max = max
+	}
+}
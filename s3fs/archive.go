@@ -0,0 +1,250 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// archiveDedupTTL is how long an archived object version is remembered, so
+// repeats are not checked against the archive on every open.
+const archiveDedupTTL = time.Hour
+
+// archiveDedupSize bounds the number of remembered object versions.
+const archiveDedupSize = 100000
+
+// Archiver stores copies of served objects. Every version of an object,
+// identified by its ETag, is stored once.
+type Archiver interface {
+	// Exists reports whether the version of key was archived before.
+	Exists(ctx context.Context, key, etag string) (bool, error)
+	// Store archives the content of the version of key read from body.
+	Store(ctx context.Context, key, etag string, body io.Reader) error
+}
+
+// WithArchive copies every opened object matching any of the patterns, or
+// all objects if none are given, asynchronously to a, e.g. for compliance
+// requirements to retain exact copies of delivered content. The copy is
+// fetched separately, made conditional on the ETag of the served version.
+// At most queueSize objects wait to be archived, further ones are dropped
+// with a warning. Archiving stops once ctx is done.
+func WithArchive(ctx context.Context, a Archiver, queueSize int, patterns ...string) Option {
+	return func(s *S3FS) {
+		if a == nil {
+			return
+		}
+		s.archive = &archive{
+			ctx:      ctx,
+			archiver: a,
+			patterns: keyPatterns(patterns),
+			queue:    make(chan archiveJob, queueSize),
+			done:     newTTLCache[struct{}](archiveDedupTTL, archiveDedupSize),
+		}
+	}
+}
+
+// archive queues served objects to be copied by an Archiver.
+type archive struct {
+	ctx      context.Context
+	archiver Archiver
+	patterns keyPatterns
+	queue    chan archiveJob
+	done     *ttlCache[struct{}] // done holds recently archived versions
+}
+
+type archiveJob struct {
+	key  string
+	etag string
+}
+
+// start begins archiving queued objects in the background.
+func (a *archive) start(s3fs *S3FS) {
+	a.ctx = s3fs.closer.bind(a.ctx)
+	s3fs.closer.run(func() {
+		for {
+			select {
+			case <-a.ctx.Done():
+				return
+			case job := <-a.queue:
+				if err := s3fs.archiveObject(job); err != nil {
+					s3fs.log.Warn("could not archive object",
+						zap.String("key", job.key),
+						zap.String("etag", job.etag),
+						zap.Error(err))
+				}
+			}
+		}
+	})
+}
+
+// archiveFile queues the object opened as f.
+func (s3fs *S3FS) archiveFile(f *s3File) {
+	a := s3fs.archive
+	if a == nil || f.versionID != "" {
+		return
+	}
+	if len(a.patterns) > 0 && !a.patterns.match(f.key) {
+		return
+	}
+	etag := objectETag(f.info)
+	if etag == "" {
+		return
+	}
+	job := archiveJob{key: f.key, etag: etag}
+	if _, ok := a.done.get(job.key + "\x00" + job.etag); ok {
+		return
+	}
+	select {
+	case a.queue <- job:
+	default:
+		s3fs.log.Warn("archive queue full, object not archived",
+			zap.String("key", job.key),
+			zap.String("etag", job.etag))
+	}
+}
+
+// archiveObject copies the version of the object described by job to the archive.
+func (s3fs *S3FS) archiveObject(job archiveJob) error {
+	a := s3fs.archive
+	ctx := a.ctx
+	ok, err := a.archiver.Exists(ctx, job.key, job.etag)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		b := s3fs.backendFor(job.key)
+		if err := s3fs.acquire(ctx, priorityLow); err != nil {
+			return err
+		}
+		start := time.Now()
+		rq := &s3.GetObjectInput{
+			Bucket:       aws.String(b.bucket),
+			Key:          aws.String(job.key),
+			RequestPayer: s3fs.requestPayer,
+		}
+		rq.SSECustomerAlgorithm, rq.SSECustomerKey, rq.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+		if s3fs.conditional() {
+			rq.IfMatch = aws.String(job.etag)
+		}
+		ctx, traced := s3fs.traceCall(ctx, "GetObject", b.bucket, job.key)
+		res, err := b.client().GetObject(ctx, rq)
+		traced.end(resultMetadata(res), err)
+		s3fs.observeCall(ctx, "GetObject", start, err)
+		b.observe(err)
+		if err != nil {
+			s3fs.limiter.release()
+			return s3fs.endpointPolicyError("s3:GetObject", job.key, err)
+		}
+		err = a.archiver.Store(ctx, job.key, job.etag, s3fs.metered("GetObject", res.Body))
+		res.Body.Close()
+		s3fs.limiter.release()
+		if err != nil {
+			return err
+		}
+	}
+	a.done.put(job.key+"\x00"+job.etag, struct{}{})
+	return nil
+}
+
+// objectETag returns the ETag of an object described by info, if known.
+func objectETag(info fs.FileInfo) string {
+	oi, ok := info.Sys().(*ObjectInfo)
+	if !ok {
+		return ""
+	}
+	return oi.ETag
+}
+
+// archiveName is the name of a version of key in an archive, keeping the
+// versions of a key next to each other below the key.
+func archiveName(key, etag string) string {
+	return path.Join(strings.TrimPrefix(key, "/"), strings.Trim(etag, `"`))
+}
+
+// DirArchiver archives objects below a local directory, storing every
+// version of an object at `<dir>/<key>/<etag>`.
+type DirArchiver string
+
+func (d DirArchiver) path(key, etag string) string {
+	return filepath.Join(string(d), filepath.FromSlash(archiveName(key, etag)))
+}
+
+// Exists implements Archiver.
+func (d DirArchiver) Exists(_ context.Context, key, etag string) (bool, error) {
+	_, err := os.Stat(d.path(key, etag))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Store implements Archiver. Content is written to a temporary file first, so
+// incomplete copies are never visible.
+func (d DirArchiver) Store(_ context.Context, key, etag string, body io.Reader) error {
+	name := d.path(key, etag)
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// BucketArchiver archives objects to a second bucket, storing every version
+// of an object at `<prefix><key>/<etag>`.
+type BucketArchiver struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+func (b *BucketArchiver) key(key, etag string) string {
+	return b.Prefix + archiveName(key, etag)
+}
+
+// Exists implements Archiver.
+func (b *BucketArchiver) Exists(ctx context.Context, key, etag string) (bool, error) {
+	_, err := b.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.key(key, etag)),
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Store implements Archiver.
+func (b *BucketArchiver) Store(ctx context.Context, key, etag string, body io.Reader) error {
+	_, err := manager.NewUploader(b.Client).Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.key(key, etag)),
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("archiving to bucket %s: %w", b.Bucket, err)
+	}
+	return nil
+}
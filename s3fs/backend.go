@@ -0,0 +1,141 @@
+package s3fs
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+// credentialRetry is the time after which a credential chain falls back to
+// its first source again.
+const credentialRetry = 10 * time.Minute
+
+// backend is a bucket together with the client used to access it.
+type backend struct {
+	s3     Client
+	bucket string
+
+	creds *credentialChain // creds holds alternative clients, nil if none are configured
+
+	events EventHandler // events receives origin errors, nil if disabled
+}
+
+// WithCredentialFallback configures clients using alternative credentials,
+// e.g. another role or anonymous access, in order of preference. Once the
+// active client received threshold consecutive 403 responses, requests
+// switch to the next one. After a while the chain starts over at the client
+// passed to NewFS.
+func WithCredentialFallback(threshold int, clients ...Client) Option {
+	return func(s *S3FS) {
+		if len(clients) == 0 {
+			return
+		}
+		if threshold <= 0 {
+			threshold = 1
+		}
+		s.primary.creds = &credentialChain{
+			clients:   append([]Client{s.primary.s3}, clients...),
+			threshold: threshold,
+			log:       s.log,
+			clock:     systemClock{},
+		}
+	}
+}
+
+// WithCredentialsProvider reports the expiry of the credentials provided by p,
+// the provider of the client passed to NewFS, in State.
+func WithCredentialsProvider(p aws.CredentialsProvider) Option {
+	return func(s *S3FS) {
+		s.credentials = p
+	}
+}
+
+// client returns the client to use for the next request.
+func (b *backend) client() Client {
+	if b.creds == nil {
+		return b.s3
+	}
+	client, reset := b.creds.client()
+	if reset {
+		b.events.emit(EventCredentialsRotated, map[string]interface{}{
+			"bucket": b.bucket,
+			"source": 0,
+		})
+	}
+	return client
+}
+
+// observe records the outcome of a request issued with client().
+func (b *backend) observe(err error) {
+	if isOriginError(err) {
+		b.events.emit(EventOriginError, map[string]interface{}{
+			"bucket": b.bucket,
+			"status": httpStatus(err),
+			"error":  err.Error(),
+		})
+	}
+	if b.creds == nil {
+		return
+	}
+	if source, switched := b.creds.observe(err); switched {
+		b.events.emit(EventCredentialsRotated, map[string]interface{}{
+			"bucket": b.bucket,
+			"source": source,
+		})
+	}
+}
+
+// credentialChain switches between clients with different credentials
+// whenever the active one is consistently denied access.
+type credentialChain struct {
+	clients   []Client
+	threshold int
+	log       *zap.Logger
+	clock     Clock
+
+	mu       sync.Mutex
+	active   int       // active is the index of the client in use
+	denied   int       // denied counts consecutive 403 responses of the active client
+	switched time.Time // switched is the time of the last fallback
+}
+
+// client returns the active client and whether the chain just started over
+// at the preferred one.
+func (c *credentialChain) client() (Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reset := false
+	if c.active > 0 && c.clock.Now().Sub(c.switched) > credentialRetry {
+		c.log.Info("retrying preferred credentials")
+		c.active, c.denied = 0, 0
+		reset = true
+	}
+	return c.clients[c.active], reset
+}
+
+// observe records the outcome of a request and reports whether it made the
+// chain switch to the next source, returning its index.
+func (c *credentialChain) observe(err error) (int, bool) {
+	forbidden := httpStatus(err) == http.StatusForbidden && !isForbiddenNotExist(err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !forbidden {
+		if err == nil {
+			c.denied = 0
+		}
+		return c.active, false
+	}
+	c.denied++
+	if c.denied < c.threshold || c.active == len(c.clients)-1 {
+		return c.active, false
+	}
+	c.active++
+	c.denied = 0
+	c.switched = c.clock.Now()
+	c.log.Warn("credentials consistently denied, falling back to next credential source",
+		zap.Int("source", c.active))
+	return c.active, true
+}
@@ -0,0 +1,156 @@
+package s3fs
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// benchmarkBufferSize is the size of the reads of a benchmark, the buffer
+// size of io.Copy.
+const benchmarkBufferSize = 32 << 10
+
+// BenchmarkStrategy is a read strategy compared by Benchmark.
+type BenchmarkStrategy struct {
+	// Name identifies the strategy in the results.
+	Name string
+	// Options set up the filesystem reading with the strategy.
+	Options []Option
+	// Stream reads files with io.Copy, i.e. as a single stream through
+	// WriteTo, instead of subsequent reads of benchmarkBufferSize bytes.
+	Stream bool
+}
+
+// BenchmarkStrategies returns the read strategies Benchmark compares by
+// default: ranged reads with the default readahead, with adaptive
+// readahead and without readahead, a single stream per file, parallel
+// ranged downloads and the content cache, which serves the rounds after
+// the first from memory.
+func BenchmarkStrategies() []BenchmarkStrategy {
+	return []BenchmarkStrategy{
+		{Name: "readahead"},
+		{Name: "adaptive_readahead", Options: []Option{WithAdaptiveReadahead(64 << 20)}},
+		{Name: "exact_ranges", Options: []Option{WithExactRanges("**")}},
+		{Name: "stream", Stream: true},
+		{Name: "parallel", Options: []Option{WithParallelDownload(8<<20, 4)}},
+		{Name: "content_cache", Options: []Option{WithContentCache(64<<20, 256<<20)}},
+	}
+}
+
+// BenchmarkResult holds the measurements of a strategy.
+type BenchmarkResult struct {
+	Strategy string `json:"strategy"`
+	// Reads is the number of files read completely.
+	Reads int `json:"reads"`
+	// Bytes is the number of bytes read.
+	Bytes int64 `json:"bytes"`
+	// Requests is the number of S3 calls issued.
+	Requests int `json:"requests"`
+	// Seconds is the total time taken.
+	Seconds float64 `json:"seconds"`
+	// Throughput is the number of bytes read per second.
+	Throughput float64 `json:"throughput"`
+	// FirstByte is the mean time from opening a file to its first byte.
+	FirstByte float64 `json:"first_byte_seconds"`
+	// Latency is the mean time S3 took to respond to a call.
+	Latency float64 `json:"latency_seconds"`
+	// Error is the error aborting the strategy, empty if none.
+	Error string `json:"error,omitempty"`
+}
+
+// Benchmark reads the objects of keys rounds times with each of strategies,
+// BenchmarkStrategies if none are given, one strategy after another, and
+// reports their throughput, request counts and latencies, so settings can
+// be picked empirically for the objects actually served. Each strategy
+// reads through a filesystem of its own without other caches. Benchmarks
+// issue real requests, which are billed like any other.
+func Benchmark(ctx context.Context, bucket string, client Client, log *zap.Logger, keys []string, rounds int, strategies ...BenchmarkStrategy) []BenchmarkResult {
+	if len(strategies) == 0 {
+		strategies = BenchmarkStrategies()
+	}
+	if rounds <= 0 {
+		rounds = 1
+	}
+	results := make([]BenchmarkResult, 0, len(strategies))
+	for _, strategy := range strategies {
+		if ctx.Err() != nil {
+			break
+		}
+		fsys := NewFS(bucket, client, log, strategy.Options...)
+		results = append(results, fsys.benchmark(ctx, strategy, keys, rounds))
+		fsys.Close()
+	}
+	return results
+}
+
+// benchmark reads keys rounds times with strategy.
+func (s3fs *S3FS) benchmark(ctx context.Context, strategy BenchmarkStrategy, keys []string, rounds int) BenchmarkResult {
+	ctx, stats := NewRequestContext(ctx)
+	fsys := s3fs.WithContext(ctx)
+	result := BenchmarkResult{Strategy: strategy.Name}
+	buf := make([]byte, benchmarkBufferSize)
+	var firstByte time.Duration
+	start := time.Now()
+	for round := 0; round < rounds && result.Error == ""; round++ {
+		for _, key := range keys {
+			n, ttfb, err := fsys.benchmarkRead(key, strategy.Stream, buf)
+			result.Bytes += n
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			result.Reads++
+			firstByte += ttfb
+		}
+	}
+	elapsed := time.Since(start)
+
+	summary := stats.Summary()
+	result.Requests = summary.Calls
+	result.Seconds = elapsed.Seconds()
+	if elapsed > 0 {
+		result.Throughput = float64(result.Bytes) / elapsed.Seconds()
+	}
+	if result.Reads > 0 {
+		result.FirstByte = (firstByte / time.Duration(result.Reads)).Seconds()
+	}
+	if summary.Calls > 0 {
+		result.Latency = (summary.Origin / time.Duration(summary.Calls)).Seconds()
+	}
+	return result
+}
+
+// benchmarkRead reads the object of key, returning the bytes read and the
+// time to the first byte.
+func (s3fs *S3FS) benchmarkRead(key string, stream bool, buf []byte) (int64, time.Duration, error) {
+	w := &firstByteWriter{start: time.Now()}
+	f, err := s3fs.Open(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	var n int64
+	if stream {
+		n, err = io.Copy(w, f)
+	} else {
+		// hide WriteTo, reading like a handler copying a range
+		n, err = io.CopyBuffer(w, struct{ io.Reader }{f}, buf)
+	}
+	return n, w.firstByte, err
+}
+
+// firstByteWriter discards what is written, recording the time until the
+// first byte.
+type firstByteWriter struct {
+	start     time.Time
+	firstByte time.Duration
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	if w.firstByte == 0 && len(p) > 0 {
+		w.firstByte = time.Since(w.start)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,217 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned without calling S3 while the circuit breaker of
+// WithCircuitBreaker is open. HTTP handlers should answer it with 503.
+var ErrCircuitOpen = errors.New("s3 circuit breaker open")
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerWindow   = 10 * time.Second
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// CircuitBreaker configures when S3 calls fail fast instead of piling up
+// while the bucket or endpoint is down.
+type CircuitBreaker struct {
+	// Failures is the number of failed calls within Window opening the
+	// breaker. Defaults to 5.
+	Failures int
+	// ErrorRate is the share of failed calls within Window, between 0 and
+	// 1, additionally required to open the breaker. 0 opens once Failures
+	// is reached.
+	ErrorRate float64
+	// Window is the period failures are counted over. Defaults to 10s.
+	Window time.Duration
+	// Cooldown is the time the breaker stays open before a single trial
+	// call is let through, closing it again on success. Defaults to 30s.
+	Cooldown time.Duration
+}
+
+// WithCircuitBreaker fails S3 calls with ErrCircuitOpen once too many of
+// them failed, see CircuitBreaker. Server errors, throttling, timeouts and
+// network errors count as failures, missing objects or denied access do
+// not. Cached metadata and contents are still served while the breaker is
+// open. Opening and closing the breaker emits EventCircuitOpened and
+// EventCircuitClosed, its state is reported in State.
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(s *S3FS) {
+		if cb.Failures <= 0 {
+			cb.Failures = defaultBreakerFailures
+		}
+		if cb.Window <= 0 {
+			cb.Window = defaultBreakerWindow
+		}
+		if cb.Cooldown <= 0 {
+			cb.Cooldown = defaultBreakerCooldown
+		}
+		s.breaker = &breaker{CircuitBreaker: cb, clock: systemClock{}}
+	}
+}
+
+// States of the circuit breaker.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// BreakerState describes the circuit breaker.
+type BreakerState struct {
+	// State is closed, open or half_open while a trial call is allowed.
+	State string `json:"state"`
+	// Opened is the time the breaker last opened, if it is not closed.
+	Opened *time.Time `json:"opened,omitempty"`
+	// Calls and Failures are counted in the current window.
+	Calls    int `json:"calls"`
+	Failures int `json:"failures"`
+}
+
+// breaker is a circuit breaker around S3 calls.
+type breaker struct {
+	CircuitBreaker
+	clock Clock
+
+	mu       sync.Mutex
+	state    string    // state is one of the breaker states, empty meaning closed
+	window   time.Time // window is the start of the window calls are counted in
+	calls    int
+	failures int
+	opened   time.Time // opened is the time the breaker last opened
+	trial    bool      // trial is set while the trial call of a half open breaker is in flight
+}
+
+// isBreakerFailure reports whether err indicates S3 being unavailable rather
+// than a failure of a single request.
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	status := httpStatus(err)
+	return status == 0 || status >= 500 || status == http.StatusTooManyRequests
+}
+
+// allow reports whether a call may be issued, returning ErrCircuitOpen if
+// not.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.clock.Now().Sub(b.opened) < b.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.trial = false
+		fallthrough
+	case breakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// abort gives up a call allowed without issuing it.
+func (b *breaker) abort() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+	b.mu.Unlock()
+}
+
+// observe records the outcome of a call, returning the state the breaker
+// switched to, empty if it did not.
+func (b *breaker) observe(err error) string {
+	if b == nil || errors.Is(err, context.Canceled) {
+		return ""
+	}
+	failed := isBreakerFailure(err)
+	now := b.clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		// calls issued before the breaker opened
+		return ""
+	case breakerHalfOpen:
+		b.trial = false
+		if failed {
+			b.state = breakerOpen
+			b.opened = now
+			return ""
+		}
+		b.state = breakerClosed
+		b.window, b.calls, b.failures = now, 0, 0
+		return breakerClosed
+	}
+	if now.Sub(b.window) > b.Window {
+		b.window, b.calls, b.failures = now, 0, 0
+	}
+	b.calls++
+	if !failed {
+		return ""
+	}
+	b.failures++
+	if b.failures < b.Failures || float64(b.failures) < b.ErrorRate*float64(b.calls) {
+		return ""
+	}
+	b.state = breakerOpen
+	b.opened = now
+	return breakerOpen
+}
+
+// stateOf returns the state of the breaker, nil if disabled.
+func (b *breaker) stateOf() *BreakerState {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := &BreakerState{State: b.state, Calls: b.calls, Failures: b.failures}
+	if state.State == "" {
+		state.State = breakerClosed
+	}
+	if state.State != breakerClosed {
+		opened := b.opened
+		state.Opened = &opened
+	}
+	return state
+}
+
+// observeBreaker feeds the outcome of a call to the circuit breaker,
+// reporting state changes.
+func (s3fs *S3FS) observeBreaker(err error) {
+	switch s3fs.breaker.observe(err) {
+	case breakerOpen:
+		s3fs.log.Warn("too many failed S3 calls, opening circuit breaker",
+			zap.Duration("cooldown", s3fs.breaker.Cooldown),
+			zap.Error(err))
+		s3fs.events.emit(EventCircuitOpened, map[string]interface{}{
+			"bucket": s3fs.primary.bucket,
+			"error":  err.Error(),
+		})
+	case breakerClosed:
+		s3fs.log.Info("S3 calls succeed again, closing circuit breaker")
+		s3fs.events.emit(EventCircuitClosed, map[string]interface{}{
+			"bucket": s3fs.primary.bucket,
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// tracerName identifies the spans of s3fs.
+const tracerName = "github.com/floj/caddy-s3fs/s3fs"
+
+// WithTracing emits an OpenTelemetry span for every S3 call issued on
+// behalf of a traced request, i.e. with a context set by WithContext or
+// NewRequestContext carrying a span, e.g. of Caddy's tracing handler. Spans are created with the tracer provider of
+// that span and carry the bucket, key, range, HTTP status and request ID.
+// Calls without a traced request are not traced.
+func WithTracing() Option {
+	return func(s *S3FS) {
+		s.tracing = true
+	}
+}
+
+// WithVerboseLogging logs every S3 call at debug level with its operation,
+// bucket, key, range, duration, HTTP status and request ID, to troubleshoot
+// slow or failing requests.
+func WithVerboseLogging() Option {
+	return func(s *S3FS) {
+		s.tunables.verbose.Store(true)
+	}
+}
+
+// callTrace records an S3 call as span of the traced request issuing it and
+// in the verbose log.
+type callTrace struct {
+	span  trace.Span  // span is nil if the request is not traced
+	log   *zap.Logger // log is nil without WithVerboseLogging
+	start time.Time
+	attrs []attribute.KeyValue
+}
+
+// traceCall starts recording a call of operation for key issued with ctx,
+// returning nil if the call is neither traced nor logged.
+func (s3fs *S3FS) traceCall(ctx context.Context, operation, bucket, key string, attrs ...attribute.KeyValue) (context.Context, *callTrace) {
+	verbose := s3fs.tunables.verbose.Load()
+	if !s3fs.tracing && !verbose {
+		return ctx, nil
+	}
+	attrs = append(attrs,
+		attribute.String("rpc.method", operation),
+		attribute.String("aws.s3.bucket", bucket),
+	)
+	if key != "" {
+		attrs = append(attrs, attribute.String("aws.s3.key", key))
+	}
+	t := &callTrace{start: time.Now(), attrs: attrs}
+	if verbose {
+		t.log = s3fs.log
+	}
+	if parent := trace.SpanFromContext(ctx); s3fs.tracing && parent.SpanContext().IsValid() {
+		ctx, t.span = parent.TracerProvider().Tracer(tracerName).Start(ctx, "S3."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attrs...),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "aws-api"),
+				attribute.String("rpc.service", "S3"),
+			))
+	}
+	if t.span == nil && t.log == nil {
+		return ctx, nil
+	}
+	return ctx, t
+}
+
+// end records the call returned the result metadata meta or failed with
+// err.
+func (t *callTrace) end(meta middleware.Metadata, err error) {
+	if t == nil {
+		return
+	}
+	var status int
+	var requestID string
+	var respErr *awshttp.ResponseError
+	switch {
+	case errors.As(err, &respErr):
+		status, requestID = respErr.HTTPStatusCode(), respErr.ServiceRequestID()
+	case err == nil:
+		if raw, ok := awsmiddleware.GetRawResponse(meta).(*smithyhttp.Response); ok {
+			status = raw.StatusCode
+		}
+		requestID, _ = awsmiddleware.GetRequestIDMetadata(meta)
+	}
+
+	if t.span != nil {
+		if status != 0 {
+			t.span.SetAttributes(attribute.Int("http.status_code", status))
+		}
+		if requestID != "" {
+			t.span.SetAttributes(attribute.String("aws.request_id", requestID))
+		}
+		if err != nil {
+			t.span.RecordError(err)
+			t.span.SetStatus(codes.Error, err.Error())
+		}
+		t.span.End()
+	}
+
+	if t.log != nil {
+		fields := make([]zap.Field, 0, len(t.attrs)+4)
+		for _, attr := range t.attrs {
+			fields = append(fields, zap.String(logKey(attr.Key), attr.Value.Emit()))
+		}
+		fields = append(fields,
+			zap.Duration("duration", time.Since(t.start)),
+			zap.Int("status", status),
+			zap.String("request_id", requestID))
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		}
+		t.log.Debug("S3 call", fields...)
+	}
+}
+
+// logKey returns the log field name of a span attribute, e.g. key for
+// aws.s3.key.
+func logKey(key attribute.Key) string {
+	name := string(key)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "method" {
+		return "operation"
+	}
+	return name
+}
+
+// resultMetadata returns the result metadata of the output of a traced
+// call, empty if it failed.
+func resultMetadata(out interface{}) middleware.Metadata {
+	switch out := out.(type) {
+	case *s3.HeadObjectOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.GetObjectOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.ListObjectsV2Output:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.ListObjectVersionsOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.GetObjectTaggingOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.PutObjectOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.DeleteObjectOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.DeleteObjectsOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.CopyObjectOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.CreateMultipartUploadOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.UploadPartOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.CompleteMultipartUploadOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.UploadPartCopyOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	case *s3.AbortMultipartUploadOutput:
+		if out != nil {
+			return out.ResultMetadata
+		}
+	}
+	return middleware.Metadata{}
+}
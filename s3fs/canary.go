@@ -0,0 +1,149 @@
+package s3fs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// canaryTimeout bounds a single canary check.
+const canaryTimeout = 10 * time.Second
+
+// WithCanary fetches the metadata of key every interval until ctx is done,
+// combining origin and deployment pipeline monitoring: the canary is
+// unhealthy while it can't be fetched or, if maxAge is set, its
+// LastModified is older than maxAge, e.g. because CI hasn't deployed for a
+// while. Becoming unhealthy logs a warning and emits EventCanaryFailed or
+// EventCanaryStale, becoming healthy again emits EventCanaryRecovered. The
+// outcome of the last check is reported in State.
+func WithCanary(ctx context.Context, key string, interval, maxAge time.Duration) Option {
+	return func(s *S3FS) {
+		if key == "" || interval <= 0 {
+			return
+		}
+		s.canary = &canary{ctx: ctx, key: key, interval: interval, maxAge: maxAge}
+	}
+}
+
+// CanaryState describes the outcome of the last canary check.
+type CanaryState struct {
+	Key          string     `json:"key"`
+	Checked      time.Time  `json:"checked"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Stale        bool       `json:"stale,omitempty"`
+}
+
+// canary periodically checks a canary object.
+type canary struct {
+	ctx      context.Context
+	key      string
+	interval time.Duration
+	maxAge   time.Duration
+
+	mu    sync.Mutex
+	state CanaryState
+}
+
+// start begins checking the canary in the background.
+func (c *canary) start(s3fs *S3FS) {
+	c.ctx = s3fs.closer.bind(c.ctx)
+	s3fs.closer.run(func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			c.check(s3fs)
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// check fetches the metadata of the canary and reports changes of its health.
+func (c *canary) check(s3fs *S3FS) {
+	lastModified, err := c.fetch(s3fs)
+	if c.ctx.Err() != nil {
+		return
+	}
+	state := CanaryState{Key: c.key, Checked: time.Now(), LastModified: lastModified}
+	if err != nil {
+		state.Error = err.Error()
+	} else if c.maxAge > 0 && lastModified != nil && time.Since(*lastModified) > c.maxAge {
+		state.Stale = true
+	}
+	c.mu.Lock()
+	prev := c.state
+	c.state = state
+	c.mu.Unlock()
+
+	data := map[string]interface{}{
+		"bucket": s3fs.primary.bucket,
+		"key":    c.key,
+	}
+	switch {
+	case state.Error != "" && prev.Error == "":
+		s3fs.log.Warn("canary check failed", zap.String("key", c.key), zap.Error(err))
+		data["error"] = state.Error
+		s3fs.events.emit(EventCanaryFailed, data)
+	case state.Stale && !prev.Stale && state.Error == "":
+		s3fs.log.Warn("canary is stale",
+			zap.String("key", c.key),
+			zap.Time("last_modified", *lastModified),
+			zap.Duration("max_age", c.maxAge))
+		data["last_modified"] = *lastModified
+		data["age"] = time.Since(*lastModified).String()
+		s3fs.events.emit(EventCanaryStale, data)
+	case state.Error == "" && !state.Stale && (prev.Error != "" || prev.Stale):
+		s3fs.log.Info("canary recovered", zap.String("key", c.key))
+		s3fs.events.emit(EventCanaryRecovered, data)
+	}
+}
+
+// fetch returns the LastModified of the canary, bypassing all caches and
+// the circuit breaker, so the canary keeps reporting while it is open.
+func (c *canary) fetch(s3fs *S3FS) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, canaryTimeout)
+	defer cancel()
+	b := &s3fs.primary
+	if err := s3fs.limiter.acquire(ctx, priorityHigh); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	ctx, traced := s3fs.traceCall(ctx, "HeadObject", b.bucket, c.key)
+	in := &s3.HeadObjectInput{
+		Bucket:       aws.String(b.bucket),
+		Key:          aws.String(c.key),
+		RequestPayer: s3fs.requestPayer,
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+	resp, err := b.client().HeadObject(ctx, in)
+	traced.end(resultMetadata(resp), err)
+	s3fs.limiter.release()
+	s3fs.observeCall(ctx, "HeadObject", start, err)
+	b.observe(err)
+	if err != nil {
+		return nil, s3fs.endpointPolicyError("s3:GetObject", c.key, err)
+	}
+	return resp.LastModified, nil
+}
+
+// stateOf returns the outcome of the last check, nil if there was none yet.
+func (c *canary) stateOf() *CanaryState {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Checked.IsZero() {
+		return nil
+	}
+	state := c.state
+	return &state
+}
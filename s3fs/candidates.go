@@ -0,0 +1,140 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// candidateEntries bounds the number of probes remembered per filesystem.
+const candidateEntries = 10000
+
+// WithCandidateProbing speeds up resolving index files and pretty URLs,
+// i.e. Caddy's file_server looking for index.html in a directory or
+// try_files falling back to {path}.html: whenever a name without extension
+// is looked up, the names with each of suffixes appended, e.g.
+// "/index.html" and ".html", are looked up in parallel. The caller's
+// following lookups of these candidates wait for the results, so resolving
+// takes the longest rather than the sum of the lookups. Results are kept
+// for ttl, a few seconds are enough for a request to stat its candidates.
+func WithCandidateProbing(ttl time.Duration, suffixes ...string) Option {
+	return func(s *S3FS) {
+		if ttl <= 0 || len(suffixes) == 0 {
+			return
+		}
+		s.candidates = &candidates{
+			suffixes: suffixes,
+			probes:   newTTLCache[*candidateProbe](ttl, candidateEntries),
+		}
+	}
+}
+
+// candidates holds the probes of WithCandidateProbing.
+type candidates struct {
+	suffixes []string
+
+	mu     sync.Mutex // mu serializes starting probes
+	probes *ttlCache[*candidateProbe]
+}
+
+// candidateProbe is a lookup of a candidate, done once done is closed.
+type candidateProbe struct {
+	done chan struct{}
+	info fs.FileInfo
+	err  error
+}
+
+// names returns the candidates probed when name is looked up.
+func (c *candidates) names(name string) []string {
+	if path.Ext(name) != "" && name != "." {
+		return nil
+	}
+	base := strings.TrimSuffix(name, "/")
+	if base == "." {
+		base = ""
+	}
+	names := make([]string, 0, len(c.suffixes))
+	for _, suffix := range c.suffixes {
+		if base == "" {
+			// the root only has index files
+			if !strings.HasPrefix(suffix, "/") {
+				continue
+			}
+			suffix = suffix[1:]
+		}
+		names = append(names, base+suffix)
+	}
+	return names
+}
+
+// wait returns the result of the probe of name, reporting whether there is
+// one.
+func (c *candidates) wait(name string) (fs.FileInfo, error, bool) {
+	p, ok := c.probes.get(name)
+	if !ok {
+		return nil, nil, false
+	}
+	<-p.done
+	return p.info, p.err, true
+}
+
+// probeCandidates starts looking up the candidates of name not probed yet.
+func (s3fs *S3FS) probeCandidates(name string) {
+	c := s3fs.candidates
+	names := c.names(name)
+	if len(names) == 0 {
+		return
+	}
+	// probes outlive the lookup starting them, not the request
+	s3fs = s3fs.detached()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, candidate := range names {
+		if _, ok := c.probes.get(candidate); ok {
+			continue
+		}
+		p := &candidateProbe{done: make(chan struct{})}
+		c.probes.put(candidate, p)
+		candidate := candidate
+		go func() {
+			defer close(p.done)
+			p.info, p.err = s3fs.statCaches(candidate)
+		}()
+	}
+}
+
+// StatFirst looks up names in parallel and returns the first of them, in
+// order, which exists, e.g. of the candidates of an index or pretty URL
+// resolution. It fails if none exists or the lookup of a name before the
+// first existing one failed otherwise.
+func (s3fs *S3FS) StatFirst(names ...string) (string, fs.FileInfo, error) {
+	type result struct {
+		info fs.FileInfo
+		err  error
+	}
+	results := make([]chan result, len(names))
+	for i, name := range names {
+		results[i] = make(chan result, 1)
+		go func(name string, ch chan<- result) {
+			info, err := s3fs.Stat(name)
+			ch <- result{info, err}
+		}(name, results[i])
+	}
+	for i, ch := range results {
+		r := <-ch
+		if r.err == nil {
+			return names[i], r.info, nil
+		}
+		if !errors.Is(r.err, fs.ErrNotExist) {
+			return names[i], nil, r.err
+		}
+	}
+	return "", nil, &fs.PathError{
+		Op:   "stat",
+		Path: strings.Join(names, ","),
+		Err:  fs.ErrNotExist,
+	}
+}
@@ -0,0 +1,157 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrNoProbeObject is returned by ProbeCapabilities if the bucket holds no
+// object of at least two bytes to probe reads with.
+var ErrNoProbeObject = errors.New("no object to probe capabilities with")
+
+// probeETag is an ETag no object has, for probing conditional requests.
+const probeETag = `"s3fs-capability-probe"`
+
+// Capabilities describes the S3 features a backend supports. S3 supports
+// all of them, S3 compatible stores vary.
+type Capabilities struct {
+	// KeyCount is set if ListObjectsV2 reports the KeyCount.
+	KeyCount bool `json:"key_count"`
+	// StartAfter is set if ListObjectsV2 honors StartAfter.
+	StartAfter bool `json:"start_after"`
+	// Ranges is set if GetObject honors byte ranges.
+	Ranges bool `json:"ranges"`
+	// Conditional is set if GetObject honors If-Match.
+	Conditional bool `json:"conditional"`
+	// Checksums is set if HeadObject returned a checksum of the probed
+	// object. It is only reported, as it also depends on how the object
+	// was uploaded.
+	Checksums bool `json:"checksums"`
+}
+
+// ProbeCapabilities detects the capabilities of the backend of client by
+// listing bucket and reading a few bytes of key, or of the first object
+// listed of at least two bytes if key is empty. If there is no such object
+// it returns ErrNoProbeObject with the list capabilities, assuming reads are
+// fully supported.
+func ProbeCapabilities(ctx context.Context, client Client, bucket, key string) (Capabilities, error) {
+	c := Capabilities{Ranges: true, Conditional: true}
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: 10,
+	})
+	if err != nil {
+		return c, err
+	}
+	c.KeyCount = list.KeyCount == int32(len(list.Contents))
+	c.StartAfter = true
+	if len(list.Contents) > 0 {
+		first := aws.ToString(list.Contents[0].Key)
+		after, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:     aws.String(bucket),
+			MaxKeys:    1,
+			StartAfter: aws.String(first),
+		})
+		if err != nil {
+			return c, err
+		}
+		c.StartAfter = len(after.Contents) == 0 || aws.ToString(after.Contents[0].Key) > first
+	}
+
+	if key == "" {
+		for _, obj := range list.Contents {
+			if obj.Size >= 2 {
+				key = aws.ToString(obj.Key)
+				break
+			}
+		}
+		if key == "" {
+			return c, ErrNoProbeObject
+		}
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return c, err
+	}
+	c.Checksums = head.ChecksumCRC32 != nil || head.ChecksumCRC32C != nil ||
+		head.ChecksumSHA1 != nil || head.ChecksumSHA256 != nil
+	if head.ContentLength < 2 {
+		return c, ErrNoProbeObject
+	}
+
+	res, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String("bytes=1-1"),
+	})
+	if err != nil {
+		return c, err
+	}
+	n, _ := io.Copy(io.Discard, io.LimitReader(res.Body, head.ContentLength))
+	res.Body.Close()
+	c.Ranges = n == 1
+
+	res, err = client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Range:   aws.String("bytes=0-0"),
+		IfMatch: aws.String(probeETag),
+	})
+	switch status := httpStatus(err); {
+	case err == nil:
+		// the condition was ignored
+		res.Body.Close()
+		c.Conditional = false
+	case status == 0:
+		return c, err
+	case status != http.StatusPreconditionFailed:
+		// the condition was rejected
+		c.Conditional = false
+	}
+	return c, nil
+}
+
+// WithCapabilities disables the features the backend does not support:
+// without Ranges objects are always read in full and parallel downloads are
+// disabled, without Conditional reads are never conditional, which disables
+// WithStrictConsistency, without StartAfter the listings of
+// WithPartitionWindow are not shortened and without KeyCount listings are
+// checked for keys directly.
+func WithCapabilities(c Capabilities) Option {
+	return func(s *S3FS) {
+		s.capabilities = &c
+	}
+}
+
+// ranges reports whether the backend honors byte ranges.
+func (s3fs *S3FS) ranges() bool {
+	return s3fs.capabilities == nil || s3fs.capabilities.Ranges
+}
+
+// conditional reports whether the backend honors conditional requests.
+func (s3fs *S3FS) conditional() bool {
+	return s3fs.capabilities == nil || s3fs.capabilities.Conditional
+}
+
+// startAfter reports whether the backend honors StartAfter in listings.
+func (s3fs *S3FS) startAfter() bool {
+	return s3fs.capabilities == nil || s3fs.capabilities.StartAfter
+}
+
+// emptyListing reports whether a listing returned no keys.
+func (s3fs *S3FS) emptyListing(out *s3.ListObjectsV2Output) bool {
+	if s3fs.capabilities == nil || s3fs.capabilities.KeyCount {
+		return out.KeyCount == 0
+	}
+	return len(out.Contents) == 0 && len(out.CommonPrefixes) == 0
+}
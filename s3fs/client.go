@@ -0,0 +1,54 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ErrNotSupported is returned by features needing an S3 operation the
+// Client passed to NewFS does not implement.
+var ErrNotSupported = errors.New("operation not supported by client")
+
+// Client is the subset of the S3 API S3FS is built on. It is implemented by
+// *s3.Client, and may be implemented by mocks, instrumented clients or
+// alternate backends.
+//
+// Optional features need further operations: WithRequiredTag and WithExpiry
+// on tags need GetObjectTagging, WithVersionsDirectory needs
+// ListObjectVersions, WithWrites needs PutObject, DeleteObject and
+// CopyObject, WithMultipartUpload the multipart upload operations,
+// WithRemoveAll DeleteObjects. Latency probes use HeadBucket if available.
+type Client interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// Interface guards
+var (
+	_ Client                         = (*s3.Client)(nil)
+	_ taggingClient                  = (*s3.Client)(nil)
+	_ writeClient                    = (*s3.Client)(nil)
+	_ multipartClient                = (*s3.Client)(nil)
+	_ deleteObjectsClient            = (*s3.Client)(nil)
+	_ headBucketClient               = (*s3.Client)(nil)
+	_ s3.ListObjectVersionsAPIClient = (*s3.Client)(nil)
+)
+
+// taggingClient is implemented by clients supporting object tags.
+type taggingClient interface {
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+}
+
+// headBucketClient is implemented by clients supporting HeadBucket.
+type headBucketClient interface {
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// notSupported is the error of a feature needing operation.
+func notSupported(operation string) error {
+	return fmt.Errorf("%w: %s", ErrNotSupported, operation)
+}
@@ -0,0 +1,192 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.uber.org/zap"
+)
+
+// fakeObject is an object of fakeClient. Its content is derived from the
+// offset of each byte, see fakeByte, so objects of any size take no memory.
+type fakeObject struct {
+	size    int64
+	etag    string
+	modTime time.Time
+}
+
+// fakeByte returns the byte at off of every fake object.
+func fakeByte(off int64) byte { return byte(off % 251) }
+
+// fakeClient serves fake objects, counting the calls of each operation.
+type fakeClient struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	calls   map[string]int
+	ranges  []string // ranges holds the Range of every GetObject
+	err     error    // err fails every call if set
+}
+
+func newFakeClient(objects map[string]fakeObject) *fakeClient {
+	return &fakeClient{objects: objects, calls: make(map[string]int)}
+}
+
+// newTestFS returns a filesystem of bucket test served by c.
+func newTestFS(c Client, opts ...Option) *S3FS {
+	return NewFS("test", c, zap.NewNop(), opts...)
+}
+
+// count returns the number of calls of operation.
+func (c *fakeClient) count(operation string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[operation]
+}
+
+// failWith fails all further calls with err, none if nil.
+func (c *fakeClient) failWith(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// object records a call of operation and returns the object key. Like the
+// SDK, it fails once ctx is done.
+func (c *fakeClient) object(ctx context.Context, operation, key string) (fakeObject, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[operation]++
+	if err := ctx.Err(); err != nil {
+		return fakeObject{}, err
+	}
+	if c.err != nil {
+		return fakeObject{}, c.err
+	}
+	o, ok := c.objects[key]
+	if !ok {
+		return o, errNotFound(operation)
+	}
+	return o, nil
+}
+
+// errNotFound returns the error of a 404 response to operation.
+func errNotFound(operation string) error {
+	return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusNotFound}},
+		Err:      fmt.Errorf("%s: NotFound", operation),
+	}}
+}
+
+func (c *fakeClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	o, err := c.object(ctx, "HeadObject", aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: o.size,
+		ETag:          aws.String(o.etag),
+		LastModified:  aws.Time(o.modTime),
+	}, nil
+}
+
+func (c *fakeClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	o, err := c.object(ctx, "GetObject", aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+	first, last := int64(0), o.size-1
+	if rng := aws.ToString(params.Range); rng != "" {
+		c.mu.Lock()
+		c.ranges = append(c.ranges, rng)
+		c.mu.Unlock()
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &first, &last); err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", rng, err)
+		}
+		if first > last || first >= o.size {
+			return nil, fmt.Errorf("unsatisfiable range %q of %d bytes", rng, o.size)
+		}
+		if last >= o.size {
+			last = o.size - 1
+		}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(&fakeBody{off: first, end: last + 1}),
+		ContentLength: last - first + 1,
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", first, last, o.size)),
+		ETag:          aws.String(o.etag),
+		LastModified:  aws.Time(o.modTime),
+	}, nil
+}
+
+func (c *fakeClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls["ListObjectsV2"]++
+	if c.err != nil {
+		return nil, c.err
+	}
+	prefix, delimiter := aws.ToString(params.Prefix), aws.ToString(params.Delimiter)
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := &s3.ListObjectsV2Output{Prefix: params.Prefix}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if params.MaxKeys > 0 && out.KeyCount == params.MaxKeys {
+			out.IsTruncated = true
+			break
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			p := key[:len(prefix)+i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(p)})
+				out.KeyCount++
+			}
+			continue
+		}
+		o := c.objects[key]
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         o.size,
+			ETag:         aws.String(o.etag),
+			LastModified: aws.Time(o.modTime),
+		})
+		out.KeyCount++
+	}
+	return out, nil
+}
+
+// fakeBody generates the bytes [off, end) of a fake object.
+type fakeBody struct {
+	off, end int64
+}
+
+func (b *fakeBody) Read(p []byte) (int, error) {
+	if b.off >= b.end {
+		return 0, io.EOF
+	}
+	if rest := b.end - b.off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	for i := range p {
+		p[i] = fakeByte(b.off + int64(i))
+	}
+	b.off += int64(len(p))
+	return len(p), nil
+}
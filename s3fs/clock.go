@@ -0,0 +1,91 @@
+package s3fs
+
+import "time"
+
+// Clock is the source of time of the caches, TTLs and limiters of an S3FS:
+// the expiry of cached entries, the budget of WithStaleFallback, the periods
+// of WithQuotas, the rate of WithRateLimit, the cooldowns of
+// WithCircuitBreaker, WithFailover, WithAdaptiveConcurrency and
+// WithCredentialFallback, and the expiry times of WithExpiry. Latencies of
+// S3 calls are always measured with the system clock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer firing once d passed on the clock.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// WithClock makes s3fs take the time from c instead of the system clock,
+// e.g. to simulate time in tests of expiry, stale serving and budget windows
+// without waiting for them.
+func WithClock(c Clock) Option {
+	return func(s *S3FS) {
+		if c != nil {
+			s.clock = c
+		}
+	}
+}
+
+// systemClock is the Clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+// systemTimer is a Timer of the time package.
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+
+func (t systemTimer) Stop() bool { return t.t.Stop() }
+
+// useClock hands the clock of s3fs to the subsystems configured by the
+// options, which are applied in any order.
+func (s3fs *S3FS) useClock() {
+	c := s3fs.clock
+	s3fs.statCache.setClock(c)
+	s3fs.notFound.setClock(c)
+	s3fs.tagCache.setClock(c)
+	s3fs.sortedListings.setClock(c)
+	if s3fs.layers != nil {
+		s3fs.layers.misses.setClock(c)
+	}
+	if s3fs.immutable != nil {
+		s3fs.immutable.cache.setClock(c)
+	}
+	if s3fs.candidates != nil {
+		s3fs.candidates.probes.setClock(c)
+	}
+	if s3fs.signatures != nil {
+		s3fs.signatures.verified.setClock(c)
+	}
+	if s3fs.archive != nil {
+		s3fs.archive.done.setClock(c)
+	}
+	if r := s3fs.tunables.rate.Load(); r != nil {
+		r.clock, r.last = c, c.Now()
+	}
+	for _, t := range s3fs.quotas {
+		t.clock = c
+	}
+	if s3fs.breaker != nil {
+		s3fs.breaker.clock = c
+	}
+	if s3fs.adaptive != nil {
+		s3fs.adaptive.clock = c
+	}
+	if s3fs.failover != nil {
+		s3fs.failover.clock = c
+	}
+	if s3fs.primary.creds != nil {
+		s3fs.primary.creds.clock = c
+	}
+}
@@ -0,0 +1,224 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock only advancing when told to.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// advance moves the clock forward by d, firing the timers due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+// pending returns the number of timers not fired yet.
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// fakeTimer is a Timer of a fakeClock.
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestTTLCacheExpiry(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := newTTLCache[int](time.Minute, 10)
+	c.keepStale = 5 * time.Minute
+	c.setClock(clock)
+
+	c.put("a", 1)
+	c.putTTL("b", 2, 10*time.Minute)
+	clock.advance(time.Minute)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("get(a) at its expiry = %d, %v, want 1", v, ok)
+	}
+	clock.advance(time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Error("a not expired after its TTL")
+	}
+	if v, ok := c.getStale("a"); !ok || v != 1 {
+		t.Errorf("getStale(a) = %d, %v, want 1 while kept stale", v, ok)
+	}
+	if v, ok := c.get("b"); !ok || v != 2 {
+		t.Errorf("get(b) = %d, %v, want 2 before its own TTL", v, ok)
+	}
+	clock.advance(5 * time.Minute)
+	if _, ok := c.getStale("a"); ok {
+		t.Error("a still served stale beyond keepStale")
+	}
+	clock.advance(5 * time.Minute)
+	if _, ok := c.get("b"); ok {
+		t.Error("b not expired after its TTL")
+	}
+}
+
+func TestStaleFallbackMaxAge(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := newFakeClient(map[string]fakeObject{"a.txt": {size: 10, etag: `"a"`}})
+	fsys := newTestFS(client,
+		WithStatCache(time.Minute, 100),
+		WithStaleFallback(time.Second, 10*time.Minute),
+		WithClock(clock),
+	)
+	if _, err := fsys.Stat("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	client.failWith(errors.New("connection reset"))
+
+	clock.advance(30 * time.Second)
+	if _, err := fsys.Stat("a.txt"); err != nil {
+		t.Fatalf("cached metadata: %v", err)
+	}
+	if heads := client.count("HeadObject"); heads != 1 {
+		t.Errorf("%d HeadObject calls within the TTL, want 1", heads)
+	}
+
+	clock.advance(5 * time.Minute)
+	info, err := fsys.Stat("a.txt")
+	if err != nil {
+		t.Fatalf("stale metadata not served within the max age: %v", err)
+	}
+	if info.Size() != 10 {
+		t.Errorf("stale size %d, want 10", info.Size())
+	}
+
+	clock.advance(10 * time.Minute)
+	if _, err := fsys.Stat("a.txt"); err == nil {
+		t.Error("stale metadata served beyond the max age")
+	}
+}
+
+func TestQuotaWindows(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 3, 31, 23, 30, 0, 0, time.UTC))
+	fsys := newTestFS(newFakeClient(nil),
+		WithQuotas(Quota{Prefix: "tenant/", Daily: 100, Monthly: 150}),
+		WithClock(clock),
+	)
+	tenant := fsys.tenantFor("tenant/file")
+	if tenant == nil {
+		t.Fatal("no tenant of tenant/file")
+	}
+	steps := []struct {
+		advance time.Duration
+		serve   int64
+		admit   bool
+	}{
+		{0, 100, false},                // daily quota used up on March 31
+		{time.Hour, 0, true},           // April 1 starts a new day and month
+		{0, 60, true},                  // 60 of 100 daily, 60 of 150 monthly
+		{24 * time.Hour, 80, true},     // April 2, 80 of 100 daily, 140 of 150 monthly
+		{0, 10, false},                 // monthly quota used up
+		{24 * time.Hour, 0, false},     // April 3, monthly quota still used up
+		{29 * 24 * time.Hour, 0, true}, // May 2
+	}
+	for i, step := range steps {
+		clock.advance(step.advance)
+		tenant.served(step.serve)
+		if got := fsys.admit(tenant); got != step.admit {
+			t.Errorf("step %d at %s: admit = %v, want %v", i, clock.Now().Format(time.RFC3339), got, step.admit)
+		}
+	}
+}
+
+func TestRateLimitWindow(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	fsys := newTestFS(newFakeClient(nil), WithRateLimit(1, 2), WithClock(clock))
+	r := fsys.tunables.rate.Load()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := r.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if clock.pending() != 0 {
+		t.Fatal("burst waited for the clock")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- r.wait(ctx) }()
+	for clock.pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.advance(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("call beyond the burst issued before its token was due")
+	default:
+	}
+	clock.advance(time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// a second later one token is available again, two later the burst
+	clock.advance(2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if err := r.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if clock.pending() != 0 {
+		t.Error("refilled burst waited for the clock")
+	}
+}
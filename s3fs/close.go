@@ -0,0 +1,103 @@
+package s3fs
+
+import (
+	"context"
+	"sync"
+)
+
+// idleCloser is implemented by clients and HTTP clients able to close their
+// idle connections, e.g. *http.Client.
+type idleCloser interface {
+	CloseIdleConnections()
+}
+
+// closer stops the background work of a filesystem.
+type closer struct {
+	once    sync.Once
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+	ctx     context.Context // ctx is done once the filesystem is closed
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newCloser() *closer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &closer{ctx: ctx, cancel: cancel}
+}
+
+// bind returns a context of ctx, nil meaning none, also done once the
+// filesystem is closed.
+func (c *closer) bind(ctx context.Context) context.Context {
+	if ctx == nil {
+		return c.ctx
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.cancels = append(c.cancels, cancel)
+	c.mu.Unlock()
+	return ctx
+}
+
+// run runs fn in a goroutine Close waits for, or not at all once the
+// filesystem is closed.
+func (c *closer) run(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed() {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn()
+	}()
+}
+
+// closed reports whether the filesystem was closed.
+func (c *closer) closed() bool {
+	return c.ctx.Err() != nil
+}
+
+// Close stops the background work of the filesystem, i.e. canary checks,
+// latency probes, prefetches, archiving and disk cache downloads, waits for
+// it to finish and closes the idle connections of the clients implementing
+// CloseIdleConnections. Further opens fail with ErrShuttingDown; files
+// already open remain readable, use Drain first to wait for them. Close is
+// meant for embedders discarding a filesystem in a long-running program and
+// is safe to call more than once; the caches of a closed filesystem can
+// still be adopted by its replacement.
+func (s3fs *S3FS) Close() error {
+	c := s3fs.closer
+	c.once.Do(func() {
+		s3fs.drain.stop()
+		c.mu.Lock()
+		c.cancel()
+		for _, cancel := range c.cancels {
+			cancel()
+		}
+		c.mu.Unlock()
+		c.wg.Wait()
+		for _, client := range s3fs.clients() {
+			if ic, ok := client.(idleCloser); ok {
+				ic.CloseIdleConnections()
+			}
+		}
+	})
+	return nil
+}
+
+// clients returns the clients of all buckets and credentials.
+func (s3fs *S3FS) clients() []Client {
+	var clients []Client
+	for _, b := range []*backend{&s3fs.primary, s3fs.replica} {
+		switch {
+		case b == nil:
+		case b.creds != nil:
+			clients = append(clients, b.creds.clients...)
+		default:
+			clients = append(clients, b.s3)
+		}
+	}
+	return clients
+}
@@ -0,0 +1,217 @@
+package s3fs
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WithContentCache keeps the content of objects of up to maxObjectSize bytes
+// in memory, using at most maxBytes in total, so hot small assets are served
+// without a GetObject per request. Entries are keyed by ETag and size, so
+// the same content reachable through several keys, e.g. aliases, locales or
+// tenants sharing assets, is stored only once. A changed object is fetched
+// again once its new ETag is known, and the least recently used contents
+// are evicted first.
+func WithContentCache(maxObjectSize, maxBytes int64) Option {
+	return func(s *S3FS) {
+		if maxBytes <= 0 || maxObjectSize <= 0 {
+			return
+		}
+		if maxObjectSize > maxBytes {
+			maxObjectSize = maxBytes
+		}
+		s.content = &contentCache{
+			maxObjectSize: maxObjectSize,
+			maxBytes:      maxBytes,
+			lru:           list.New(),
+			entries:       make(map[string]*list.Element),
+		}
+	}
+}
+
+// contentCache is an LRU cache of object contents bounded by their total size.
+type contentCache struct {
+	maxObjectSize int64
+	maxBytes      int64
+
+	mu      sync.Mutex
+	size    int64                    // size is the total length of the cached contents
+	lru     *list.List               // lru holds *contentEntry, most recently used first
+	entries map[string]*list.Element // entries indexes lru by ETag and size
+}
+
+type contentEntry struct {
+	id   string              // id is the ETag and size of the content
+	keys map[string]struct{} // keys holds the keys the content was requested for
+	data []byte
+}
+
+// contentID identifies a version of an object.
+func contentID(key, versionID, etag string) string {
+	return key + "\x00" + versionID + "\x00" + etag
+}
+
+// contentCacheID identifies content by the ETag and size of the objects
+// holding it, regardless of their key.
+func contentCacheID(etag string, size int64) string {
+	return etag + "\x00" + strconv.FormatInt(size, 10)
+}
+
+// get returns the cached content for id, recording that key holds it.
+func (c *contentCache) get(id, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	entry := e.Value.(*contentEntry)
+	entry.keys[key] = struct{}{}
+	return entry.data, true
+}
+
+// put caches data for id, held by key, evicting the least recently used
+// entries to stay within the memory budget.
+func (c *contentCache) put(id, key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		e.Value.(*contentEntry).keys[key] = struct{}{}
+		return
+	}
+	for c.size+int64(len(data)) > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	entry := &contentEntry{id: id, keys: map[string]struct{}{key: {}}, data: data}
+	c.entries[id] = c.lru.PushFront(entry)
+	c.size += int64(len(data))
+}
+
+// remove drops e, the lock must be held.
+func (c *contentCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*contentEntry)
+	delete(c.entries, entry.id)
+	c.size -= int64(len(entry.data))
+}
+
+// len returns the number of cached contents.
+func (c *contentCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// purge forgets all keys starting with prefix and returns their number.
+// Contents are removed once no key holding them remains.
+func (c *contentCache) purge(prefix string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*contentEntry)
+		for key := range entry.keys {
+			if strings.HasPrefix(key, prefix) {
+				delete(entry.keys, key)
+				n++
+			}
+		}
+		if len(entry.keys) == 0 {
+			c.remove(e)
+		}
+		e = next
+	}
+	return n
+}
+
+// copyFrom adds the entries of src, most recently used first, as long as
+// they fit into the budget of c.
+func (c *contentCache) copyFrom(src *contentCache) {
+	if c == nil || src == nil || c == src {
+		return
+	}
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := src.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*contentEntry)
+		if _, ok := c.entries[entry.id]; ok || int64(len(entry.data)) > c.maxObjectSize {
+			continue
+		}
+		if c.size+int64(len(entry.data)) > c.maxBytes {
+			return
+		}
+		keys := make(map[string]struct{}, len(entry.keys))
+		for key := range entry.keys {
+			keys[key] = struct{}{}
+		}
+		c.entries[entry.id] = c.lru.PushBack(&contentEntry{id: entry.id, keys: keys, data: entry.data})
+		c.size += int64(len(entry.data))
+	}
+}
+
+// openStream returns a reader for the content of f starting at from, served
+// from the content or disk cache if possible, reading at least amt bytes
+// from S3 otherwise, in parallel parts if enabled.
+func (f *s3File) openStream(from, amt int64) (io.ReadCloser, error) {
+	data, err := f.cachedContent()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if r, ok := f.diskCached(from); ok {
+			return r, nil
+		}
+		if r, ok := f.headStream(from, amt); ok {
+			return r, nil
+		}
+		if r, ok := f.parallelStream(from); ok {
+			return r, nil
+		}
+		return f.rangeReader(from, amt)
+	}
+	if from >= int64(len(data)) {
+		return nil, io.EOF
+	}
+	return io.NopCloser(bytes.NewReader(data[from:])), nil
+}
+
+// cachedContent returns the content of f from the content cache, fetching
+// and caching it on a miss. It returns nil if f is not cacheable. It does
+// not modify f, so it may be called concurrently.
+func (f *s3File) cachedContent() ([]byte, error) {
+	c := f.fs.content
+	if c == nil || f.info.Size() > c.maxObjectSize {
+		return nil, nil
+	}
+	oi, ok := f.info.Sys().(*ObjectInfo)
+	if !ok || oi.ETag == "" {
+		return nil, nil
+	}
+	id := contentCacheID(oi.ETag, f.info.Size())
+	data, ok := c.get(id, f.key)
+	f.fs.observeCache(CacheContent, ok)
+	if ok {
+		return data, nil
+	}
+	r, err := f.getRange(f.fs.callContext(), 0, f.info.Size()-1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data = make([]byte, f.info.Size())
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	c.put(id, f.key, data)
+	return data, nil
+}
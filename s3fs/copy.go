@@ -0,0 +1,114 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// maxCopySize is the largest object CopyObject copies, larger ones are
+	// copied in parts.
+	maxCopySize = 5 << 30
+
+	// minCopyPartSize is the smallest part large objects are copied in.
+	minCopyPartSize = 512 << 20
+
+	// maxParts is the most parts a multipart upload may have.
+	maxParts = 10000
+)
+
+// copySource returns the CopySource of key in bucket, which may be the ARN
+// of an access point.
+func copySource(bucket, key string) *string {
+	if arn.IsARN(bucket) {
+		return aws.String(url.PathEscape(bucket + "/object/" + key))
+	}
+	return aws.String(url.PathEscape(bucket + "/" + key))
+}
+
+// copyObject copies the object at from of size bytes to the key to, in
+// parts if it is too large for a single CopyObject. Parts are copied as
+// long as the object keeps the ETag etag, if set.
+func (s3fs *S3FS) copyObject(from, to string, size int64, etag string) error {
+	if size > maxCopySize {
+		return s3fs.copyParts(from, to, size, etag)
+	}
+	_, err := writeCall(s3fs, "CopyObject", to, 0, func(ctx context.Context, client writeClient, bucket string) (*s3.CopyObjectOutput, error) {
+		in := &s3.CopyObjectInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(to),
+			CopySource: copySource(bucket, from),
+		}
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = s3fs.encryption.headers()
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+		in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = s3fs.customerKey.headers()
+		if etag != "" {
+			in.CopySourceIfMatch = aws.String(etag)
+		}
+		return client.CopyObject(ctx, in)
+	})
+	return err
+}
+
+// copyParts copies the object at from of size bytes to the key to with
+// UploadPartCopy. Unlike CopyObject it does not keep the metadata of the
+// object, the content type is derived from the extension of to.
+func (s3fs *S3FS) copyParts(from, to string, size int64, etag string) error {
+	partSize := int64(minCopyPartSize)
+	if n := (size + maxParts - 1) / maxParts; n > partSize {
+		partSize = n
+	}
+	concurrency := defaultPartConcurrency
+	if s3fs.multipart != nil {
+		concurrency = s3fs.multipart.concurrency
+	}
+	u, err := s3fs.startUpload(to, concurrency)
+	if err != nil {
+		return err
+	}
+	var n int32
+	for first := int64(0); first < size && u.failed() == nil; first += partSize {
+		last := first + partSize - 1
+		if last >= size {
+			last = size - 1
+		}
+		n++
+		u.copyPart(n, from, first, last, etag)
+	}
+	return u.complete()
+}
+
+// copyPart copies the bytes first to last of the object at from as part n
+// in the background, waiting for a free slot.
+func (u *multipartUpload) copyPart(n int32, from string, first, last int64, etag string) {
+	u.run(n, func() (*string, error) {
+		out, err := writeCall(u.fs, "UploadPartCopy", u.key, 0, func(ctx context.Context, client multipartClient, bucket string) (*s3.UploadPartCopyOutput, error) {
+			in := &s3.UploadPartCopyInput{
+				Bucket:          aws.String(bucket),
+				Key:             aws.String(u.key),
+				UploadId:        aws.String(u.id),
+				PartNumber:      n,
+				CopySource:      copySource(bucket, from),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+			}
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.fs.customerKey.headers()
+			in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = u.fs.customerKey.headers()
+			if etag != "" {
+				in.CopySourceIfMatch = aws.String(etag)
+			}
+			return client.UploadPartCopy(ctx, in)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out.CopyPartResult == nil {
+			return nil, fmt.Errorf("missing result of copying part %d", n)
+		}
+		return out.CopyPartResult.ETag, nil
+	})
+}
@@ -0,0 +1,73 @@
+package s3fs
+
+import (
+	"context"
+	"io/fs"
+)
+
+// DirStreamer is implemented by filesystems that can list a directory
+// incrementally, e.g. for browse pages to start rendering entries of
+// directories with hundreds of thousands of children before the listing
+// is complete.
+type DirStreamer interface {
+	StreamDir(ctx context.Context, name string, fn func(entries []fs.DirEntry) error) error
+}
+
+var _ DirStreamer = (*S3FS)(nil)
+
+// streamPageSize is the number of entries requested per page when streaming
+// a directory.
+const streamPageSize = 1000
+
+// StreamDir lists the named directory page by page, calling fn with the
+// entries of every page in key order as soon as they arrive. The next page
+// is fetched while fn processes the current one. Listing stops at the first
+// error returned by fn, which is returned, or once ctx is done. Unlike
+// ReadDir(-1) the listing is neither sorted nor subject to the listing
+// limits, fn decides how many entries to consume.
+func (s3fs *S3FS) StreamDir(ctx context.Context, name string, fn func(entries []fs.DirEntry) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type page struct {
+		entries []fs.DirEntry
+		err     error
+	}
+	pages := make(chan page, 1)
+	go func() {
+		defer close(pages)
+		token := ""
+		for {
+			entries, next, err := s3fs.WithContext(ctx).ReadDirPage(name, token, streamPageSize)
+			select {
+			case pages <- page{entries: entries, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil || next == "" {
+				return
+			}
+			token = next
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-pages:
+			if !ok {
+				return nil
+			}
+			if p.err != nil {
+				return p.err
+			}
+			if len(p.entries) == 0 {
+				continue
+			}
+			if err := fn(p.entries); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package s3fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// diskCacheQueueSize is the number of objects waiting to be downloaded into
+// the disk cache before further ones are skipped.
+const diskCacheQueueSize = 100
+
+// diskCacheTempPrefix prefixes files being downloaded into the disk cache.
+const diskCacheTempPrefix = ".fill-"
+
+// diskCacheStaleTemp is the age after which leftover temporary files of
+// interrupted downloads are removed.
+const diskCacheStaleTemp = time.Hour
+
+// WithDiskCache keeps whole objects of at least minObjectSize bytes in dir,
+// using at most maxBytes in total, so repeated downloads of big files are
+// served from local disk. Objects are downloaded in the background after
+// being opened, conditional on the ETag of the opened version, and are
+// only served while that ETag is current. The least recently used objects
+// are evicted first. Downloads stop once ctx is done.
+func WithDiskCache(ctx context.Context, dir string, maxBytes, minObjectSize int64) Option {
+	return func(s *S3FS) {
+		if dir == "" || maxBytes <= 0 {
+			return
+		}
+		s.disk = &diskCache{
+			ctx:           ctx,
+			dir:           dir,
+			maxBytes:      maxBytes,
+			minObjectSize: minObjectSize,
+			queue:         make(chan diskCacheJob, diskCacheQueueSize),
+			files:         make(map[string]*diskCacheFile),
+			pending:       make(map[string]struct{}),
+		}
+	}
+}
+
+// diskCache is an LRU cache of whole objects in a local directory, bounded
+// by their total size.
+type diskCache struct {
+	ctx           context.Context
+	dir           string
+	maxBytes      int64
+	minObjectSize int64
+	queue         chan diskCacheJob
+	crypt         *diskCrypter // crypt encrypts cached content, nil if disabled
+
+	mu      sync.Mutex
+	size    int64                     // size is the total size of the cached files
+	files   map[string]*diskCacheFile // files indexes the cached files by name
+	pending map[string]struct{}       // pending holds the names of queued downloads
+}
+
+type diskCacheFile struct {
+	size int64
+	used time.Time
+}
+
+type diskCacheJob struct {
+	name      string
+	key       string
+	versionID string
+	etag      string
+	size      int64
+}
+
+// diskCacheName is the file name of a version of an object in the disk cache.
+func diskCacheName(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// start indexes the files cached by earlier runs and begins downloading
+// queued objects in the background.
+func (c *diskCache) start(s3fs *S3FS) {
+	if s3fs.diskEncryption != nil {
+		c.crypt = &diskCrypter{aead: s3fs.diskEncryption}
+	}
+	if err := c.load(); err != nil {
+		s3fs.log.Warn("could not index disk cache", zap.String("directory", c.dir), zap.Error(err))
+	}
+	c.ctx = s3fs.closer.bind(c.ctx)
+	s3fs.closer.run(func() {
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case job := <-c.queue:
+				if err := s3fs.fillDiskCache(job); err != nil {
+					s3fs.log.Warn("could not cache object on disk",
+						zap.String("key", job.key),
+						zap.String("etag", job.etag),
+						zap.Error(err))
+				}
+				c.mu.Lock()
+				delete(c.pending, job.name)
+				c.mu.Unlock()
+			}
+		}
+	})
+}
+
+// load indexes the files in the cache directory, using their modification
+// time as last use, and removes stale temporary files. Other dot files are
+// left alone.
+func (c *diskCache) load() error {
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), diskCacheTempPrefix) {
+			if time.Since(info.ModTime()) > diskCacheStaleTemp {
+				os.Remove(filepath.Join(c.dir, e.Name()))
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		c.files[e.Name()] = &diskCacheFile{size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+	c.evict(0)
+	return nil
+}
+
+// diskFile is an open disk cache file.
+type diskFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// storedSize returns the size of the file caching size bytes of content.
+func (c *diskCache) storedSize(size int64) int64 {
+	if c.crypt == nil {
+		return size
+	}
+	return c.crypt.storedSize(size)
+}
+
+// open returns the cached file name if it holds size bytes of content.
+// Encrypted files failing authentication, e.g. written with another key,
+// are removed.
+func (c *diskCache) open(name string, size int64) (diskFile, bool) {
+	c.mu.Lock()
+	entry, ok := c.files[name]
+	if ok {
+		entry.used = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok || entry.size != c.storedSize(size) {
+		return nil, false
+	}
+	f, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		// evicted by another instance sharing the directory
+		c.mu.Lock()
+		c.remove(name)
+		c.mu.Unlock()
+		return nil, false
+	}
+	if c.crypt == nil {
+		return f, true
+	}
+	sealed, err := c.crypt.open(f, name, size)
+	if err != nil {
+		f.Close()
+		c.mu.Lock()
+		c.remove(name)
+		c.mu.Unlock()
+		os.Remove(filepath.Join(c.dir, name))
+		return nil, false
+	}
+	return sealed, true
+}
+
+// enqueue queues job unless it is already queued or the queue is full.
+func (c *diskCache) enqueue(job diskCacheJob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pending[job.name]; ok {
+		return
+	}
+	select {
+	case c.queue <- job:
+		c.pending[job.name] = struct{}{}
+	default:
+	}
+}
+
+// add indexes a newly cached file, evicting the least recently used ones to
+// stay within the budget.
+func (c *diskCache) add(name string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(name)
+	c.evict(size)
+	c.files[name] = &diskCacheFile{size: size, used: time.Now()}
+	c.size += size
+}
+
+// evict removes the least recently used files until room bytes fit into
+// the budget, the lock must be held.
+func (c *diskCache) evict(room int64) {
+	for c.size+room > c.maxBytes && len(c.files) > 0 {
+		var victim string
+		var oldest time.Time
+		for name, f := range c.files {
+			if victim == "" || f.used.Before(oldest) {
+				victim, oldest = name, f.used
+			}
+		}
+		c.remove(victim)
+		os.Remove(filepath.Join(c.dir, victim))
+	}
+}
+
+// remove drops name from the index, the lock must be held.
+func (c *diskCache) remove(name string) {
+	if f, ok := c.files[name]; ok {
+		c.size -= f.size
+		delete(c.files, name)
+	}
+}
+
+// len returns the number of cached files.
+func (c *diskCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.files)
+}
+
+// diskCached returns a reader for the content of f starting at from if it is
+// cached on disk, queueing it to be cached otherwise.
+func (f *s3File) diskCached(from int64) (io.ReadCloser, bool) {
+	file, ok := f.diskCachedFile()
+	if !ok {
+		return nil, false
+	}
+	size := f.info.Size()
+	return &sectionReadCloser{SectionReader: io.NewSectionReader(file, from, size-from), file: file}, true
+}
+
+// diskCachedFile opens the disk cache file holding the content of f, queueing
+// f to be cached if there is none. It does not modify f, so it may be called
+// concurrently.
+func (f *s3File) diskCachedFile() (diskFile, bool) {
+	c := f.fs.disk
+	size := f.info.Size()
+	if c == nil || size < c.minObjectSize || size > c.maxBytes {
+		return nil, false
+	}
+	etag := objectETag(f.info)
+	if etag == "" {
+		return nil, false
+	}
+	name := diskCacheName(contentID(f.key, f.versionID, etag))
+	file, ok := c.open(name, size)
+	f.fs.observeCache(CacheDisk, ok)
+	if !ok {
+		c.enqueue(diskCacheJob{name: name, key: f.key, versionID: f.versionID, etag: etag, size: size})
+		return nil, false
+	}
+	return file, true
+}
+
+// sectionReadCloser reads a section of a file, closing it once done.
+type sectionReadCloser struct {
+	*io.SectionReader
+	file diskFile
+}
+
+func (r *sectionReadCloser) Close() error {
+	return r.file.Close()
+}
+
+// fillDiskCache downloads the version of the object described by job into
+// the disk cache.
+func (s3fs *S3FS) fillDiskCache(job diskCacheJob) error {
+	c := s3fs.disk
+	rq := &s3.GetObjectInput{
+		Key:          aws.String(job.key),
+		RequestPayer: s3fs.requestPayer,
+	}
+	rq.SSECustomerAlgorithm, rq.SSECustomerKey, rq.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+	if s3fs.conditional() {
+		rq.IfMatch = aws.String(job.etag)
+	}
+	if job.versionID != "" {
+		rq.VersionId = aws.String(job.versionID)
+	}
+	res, err := s3fs.sendGetObject(c.ctx, job.key, rq, priorityLow)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	tmp, err := os.CreateTemp(c.dir, diskCacheTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	var w io.WriteCloser = tmp
+	if c.crypt != nil {
+		if w, err = c.crypt.writer(tmp, job.name); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	n, err := io.Copy(w, res.Body)
+	if err == nil && c.crypt != nil {
+		err = w.Close()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if n != job.size {
+		return fmt.Errorf("%w: %s: expected %d bytes, got %d", ErrTruncatedResponse, job.key, job.size, n)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, job.name)); err != nil {
+		return err
+	}
+	c.add(job.name, c.storedSize(n))
+	return nil
+}
@@ -0,0 +1,203 @@
+package s3fs
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// diskChunkSize is the amount of content sealed at once in encrypted disk
+// cache files, so ranges can be decrypted without reading whole files.
+const diskChunkSize = 64 << 10
+
+// diskHeaderMagic starts encrypted disk cache files.
+const diskHeaderMagic = "s3fsenc1"
+
+// errDiskCacheAuth reports disk cache content failing authentication.
+var errDiskCacheAuth = errors.New("disk cache content failed authentication")
+
+// WithDiskCacheEncryption encrypts the content of the disk cache using aead,
+// e.g. AES-GCM, so enabling it doesn't leave sensitive objects readable on
+// local disk. Content is sealed in chunks of 64KiB, each bound to its
+// position and cache file, so ranges can be read without decrypting whole
+// files. Files written with another key are not served and eventually
+// evicted. aead must use nonces of at least 8 bytes, the disk cache is
+// disabled otherwise.
+func WithDiskCacheEncryption(aead cipher.AEAD) Option {
+	return func(s *S3FS) {
+		s.diskEncryption = aead
+	}
+}
+
+// diskCrypter seals and opens the content of disk cache files. A file
+// consists of the magic, a random nonce and a tag authenticating both
+// followed by the sealed chunks. The nonces of the chunks and of the tag are
+// derived from the random one and the chunk index, the tag using the index
+// math.MaxUint64 no chunk has, so no nonce is used twice.
+type diskCrypter struct {
+	aead cipher.AEAD
+}
+
+// headerSize returns the size of the header of an encrypted file.
+func (c *diskCrypter) headerSize() int64 {
+	return int64(len(diskHeaderMagic) + c.aead.NonceSize() + c.aead.Overhead())
+}
+
+// storedSize returns the size of the file holding size bytes of content.
+func (c *diskCrypter) storedSize(size int64) int64 {
+	chunks := (size + diskChunkSize - 1) / diskChunkSize
+	return c.headerSize() + size + chunks*int64(c.aead.Overhead())
+}
+
+// nonce returns the nonce of chunk index of a file with the base nonce.
+func (c *diskCrypter) nonce(base []byte, index uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^index)
+	return nonce
+}
+
+// additionalData binds chunk index to the file name, so chunks can't be
+// moved between positions or files.
+func additionalData(name string, index uint64) []byte {
+	ad := make([]byte, 0, len(name)+8)
+	ad = append(ad, name...)
+	return binary.BigEndian.AppendUint64(ad, index)
+}
+
+// writer returns a writer encrypting content into w, the file named name.
+// It must be closed to write the last chunk.
+func (c *diskCrypter) writer(w io.Writer, name string) (io.WriteCloser, error) {
+	base := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(base); err != nil {
+		return nil, err
+	}
+	header := append([]byte(diskHeaderMagic), base...)
+	header = c.aead.Seal(header, c.nonce(base, math.MaxUint64), nil, additionalData(name, math.MaxUint64))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &sealWriter{crypter: c, w: w, name: name, base: base, buf: make([]byte, 0, diskChunkSize)}, nil
+}
+
+// sealWriter encrypts content chunk by chunk.
+type sealWriter struct {
+	crypter *diskCrypter
+	w       io.Writer
+	name    string
+	base    []byte
+	buf     []byte // buf collects the content of the current chunk
+	index   uint64 // index is the index of the current chunk
+	sealed  []byte // sealed is reused for sealing chunks
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		m := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+m]
+		p = p[m:]
+		n += m
+		if len(s.buf) == cap(s.buf) {
+			if err := s.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush seals and writes the current chunk.
+func (s *sealWriter) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	aead := s.crypter.aead
+	s.sealed = aead.Seal(s.sealed[:0], s.crypter.nonce(s.base, s.index), s.buf, additionalData(s.name, s.index))
+	s.buf = s.buf[:0]
+	s.index++
+	_, err := s.w.Write(s.sealed)
+	return err
+}
+
+func (s *sealWriter) Close() error {
+	return s.flush()
+}
+
+// open verifies the header of file, the cache file named name, and returns
+// a reader of its size bytes of content.
+func (c *diskCrypter) open(file *os.File, name string, size int64) (*sealedFile, error) {
+	header := make([]byte, c.headerSize())
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:len(diskHeaderMagic)]) != diskHeaderMagic {
+		return nil, errDiskCacheAuth
+	}
+	base := header[len(diskHeaderMagic) : len(diskHeaderMagic)+c.aead.NonceSize()]
+	tag := header[len(diskHeaderMagic)+c.aead.NonceSize():]
+	if _, err := c.aead.Open(nil, c.nonce(base, math.MaxUint64), tag, additionalData(name, math.MaxUint64)); err != nil {
+		return nil, errDiskCacheAuth
+	}
+	return &sealedFile{crypter: c, file: file, name: name, base: base, size: size}, nil
+}
+
+// sealedFile decrypts the content of an encrypted disk cache file.
+type sealedFile struct {
+	crypter *diskCrypter
+	file    *os.File
+	name    string
+	base    []byte
+	size    int64
+}
+
+// ReadAt decrypts the chunks holding the requested range. It may be called
+// concurrently.
+func (o *sealedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	aead := o.crypter.aead
+	overhead := int64(aead.Overhead())
+	var chunk, sealed []byte
+	n := 0
+	for len(p) > 0 {
+		if off >= o.size {
+			return n, io.EOF
+		}
+		index := off / diskChunkSize
+		length := o.size - index*diskChunkSize
+		if length > diskChunkSize {
+			length = diskChunkSize
+		}
+		if sealed == nil {
+			sealed = make([]byte, diskChunkSize+overhead)
+		}
+		sealed = sealed[:length+overhead]
+		pos := o.crypter.headerSize() + index*(diskChunkSize+overhead)
+		if _, err := o.file.ReadAt(sealed, pos); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		var err error
+		chunk, err = aead.Open(chunk[:0], o.crypter.nonce(o.base, uint64(index)), sealed, additionalData(o.name, uint64(index)))
+		if err != nil {
+			return n, errDiskCacheAuth
+		}
+		m := copy(p, chunk[off-index*diskChunkSize:])
+		p = p[m:]
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+func (o *sealedFile) Close() error {
+	return o.file.Close()
+}
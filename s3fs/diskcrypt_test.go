@@ -0,0 +1,242 @@
+package s3fs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCrypter(t *testing.T) *diskCrypter {
+	t.Helper()
+	block, err := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &diskCrypter{aead: aead}
+}
+
+// sealTestFile encrypts content into a file named name in dir.
+func sealTestFile(t *testing.T, c *diskCrypter, dir, name string, content []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := c.writer(f, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write in odd sizes to cross chunk boundaries within writes
+	for rest := content; len(rest) > 0; {
+		n := 1000 + rand.Intn(3*diskChunkSize)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() != c.storedSize(int64(len(content))) {
+		t.Fatalf("stored %d bytes, storedSize reports %d", st.Size(), c.storedSize(int64(len(content))))
+	}
+	return p
+}
+
+func openTestFile(t *testing.T, c *diskCrypter, p, name string, size int64) (*sealedFile, error) {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.open(f, name, size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.Cleanup(func() { sealed.Close() })
+	return sealed, nil
+}
+
+func TestDiskCryptRoundTrip(t *testing.T) {
+	c := newTestCrypter(t)
+	dir := t.TempDir()
+	for _, size := range []int{0, 1, diskChunkSize - 1, diskChunkSize, diskChunkSize + 1, 3*diskChunkSize + 17} {
+		content := make([]byte, size)
+		rand.Read(content)
+		name := "object"
+		p := sealTestFile(t, c, dir, name, content)
+		sealed, err := openTestFile(t, c, p, name, int64(size))
+		if err != nil {
+			t.Fatalf("size %d: open: %v", size, err)
+		}
+		got, err := io.ReadAll(io.NewSectionReader(sealed, 0, int64(size)))
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("size %d: content differs", size)
+		}
+		for i := 0; i < 20 && size > 0; i++ {
+			off := rand.Intn(size)
+			buf := make([]byte, rand.Intn(size-off)+1)
+			n, err := sealed.ReadAt(buf, int64(off))
+			if err != nil && !(err == io.EOF && off+n == size) {
+				t.Fatalf("size %d: ReadAt(%d, %d): %v", size, len(buf), off, err)
+			}
+			if !bytes.Equal(buf[:n], content[off:off+n]) {
+				t.Fatalf("size %d: ReadAt(%d, %d) returned wrong content", size, len(buf), off)
+			}
+		}
+		if n, err := sealed.ReadAt(make([]byte, 1), int64(size)); n != 0 || err != io.EOF {
+			t.Fatalf("size %d: ReadAt at end = %d, %v", size, n, err)
+		}
+	}
+}
+
+func TestDiskCryptNonces(t *testing.T) {
+	c := newTestCrypter(t)
+	base := make([]byte, c.aead.NonceSize())
+	rand.Read(base)
+	seen := map[string]uint64{}
+	for _, index := range []uint64{0, 1, 2, 1 << 32, math.MaxUint64 - 1, math.MaxUint64} {
+		nonce := string(c.nonce(base, index))
+		if other, ok := seen[nonce]; ok {
+			t.Fatalf("chunks %d and %d share a nonce", other, index)
+		}
+		seen[nonce] = index
+	}
+	if string(c.nonce(base, 0)) != string(base) {
+		t.Fatal("nonce of chunk 0 is not the base nonce")
+	}
+}
+
+func TestDiskCryptTamper(t *testing.T) {
+	c := newTestCrypter(t)
+	content := make([]byte, 2*diskChunkSize+100)
+	rand.Read(content)
+	size := int64(len(content))
+	overhead := int64(c.aead.Overhead())
+
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, p string)
+		open   string // open is the name the file is opened as, if not its own
+		read   int64  // read is an offset within the tampered chunk
+	}{
+		{
+			name:   "header tag",
+			modify: flipByte(c.headerSize() - 1),
+		},
+		{
+			name:   "header nonce",
+			modify: flipByte(int64(len(diskHeaderMagic))),
+		},
+		{
+			name:   "magic",
+			modify: flipByte(0),
+		},
+		{
+			name: "renamed",
+			open: "other",
+		},
+		{
+			name:   "first chunk",
+			modify: flipByte(c.headerSize() + 10),
+			read:   10,
+		},
+		{
+			name:   "last chunk",
+			modify: flipByte(c.headerSize() + 2*(diskChunkSize+overhead) + 5),
+			read:   2*diskChunkSize + 5,
+		},
+		{
+			name: "swapped chunks",
+			modify: func(t *testing.T, p string) {
+				b, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				first := b[c.headerSize() : c.headerSize()+diskChunkSize+overhead]
+				second := b[c.headerSize()+diskChunkSize+overhead : c.headerSize()+2*(diskChunkSize+overhead)]
+				swapped := append(append([]byte(nil), second...), first...)
+				copy(b[c.headerSize():], swapped)
+				if err := os.WriteFile(p, b, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			read: diskChunkSize,
+		},
+		{
+			name: "truncated",
+			modify: func(t *testing.T, p string) {
+				if err := os.Truncate(p, c.storedSize(size)-1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			read: size - 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := sealTestFile(t, c, dir, "object", content)
+			if tt.modify != nil {
+				tt.modify(t, p)
+			}
+			name := "object"
+			if tt.open != "" {
+				name = tt.open
+			}
+			sealed, err := openTestFile(t, c, p, name, size)
+			if tt.read == 0 {
+				if !errors.Is(err, errDiskCacheAuth) {
+					t.Fatalf("open = %v, want %v", err, errDiskCacheAuth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			if _, err := sealed.ReadAt(make([]byte, 1), tt.read); err == nil {
+				t.Fatal("read tampered content")
+			} else if !errors.Is(err, errDiskCacheAuth) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("ReadAt = %v", err)
+			}
+		})
+	}
+}
+
+// flipByte returns a modification of a file flipping the byte at off.
+func flipByte(off int64) func(t *testing.T, p string) {
+	return func(t *testing.T, p string) {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[off] ^= 0x80
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
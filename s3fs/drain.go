@@ -0,0 +1,79 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// ErrShuttingDown is returned by Open once the filesystem is being drained.
+var ErrShuttingDown = errors.New("filesystem is shutting down")
+
+// drainer tracks open files so shutdown can wait for in-flight streams.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	open     int
+	idle     chan struct{} // idle is closed once draining and no file is open
+}
+
+// acquire registers an open file, failing once draining started.
+func (d *drainer) acquire() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.open++
+	return true
+}
+
+// release unregisters an open file.
+func (d *drainer) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.open--
+	if d.draining && d.open == 0 {
+		close(d.idle)
+	}
+}
+
+// stop rejects further opens, returning the channel closed once no file is
+// open.
+func (d *drainer) stop() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining {
+		d.draining = true
+		d.idle = make(chan struct{})
+		if d.open == 0 {
+			close(d.idle)
+		}
+	}
+	return d.idle
+}
+
+// Drain rejects further opens with ErrShuttingDown and waits until all open
+// files are closed or ctx is done, e.g. to let downloads complete on reload.
+// Files opened before remain readable; if ctx expires first, the number of
+// files still open is reported.
+func (s3fs *S3FS) Drain(ctx context.Context) error {
+	d := s3fs.drain
+	idle := d.stop()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		open := d.open
+		d.mu.Unlock()
+		return fmt.Errorf("draining s3 filesystem: %w with %d files still open", ctx.Err(), open)
+	}
+}
+
+// shuttingDown is the error of an Open rejected while draining.
+func shuttingDown(name string) error {
+	return &fs.PathError{Op: "open", Path: name, Err: ErrShuttingDown}
+}
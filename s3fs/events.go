@@ -0,0 +1,116 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Names of the events passed to the EventHandler of WithEvents.
+const (
+	// EventOriginError is emitted for failed S3 requests, except for
+	// missing objects and failed preconditions. Data holds the bucket, the
+	// HTTP status if a response was received, and the error.
+	EventOriginError = "origin_error"
+	// EventCachePurged is emitted by PurgeCache. Data holds the bucket, the
+	// purged prefix and the number of purged entries.
+	EventCachePurged = "cache_purged"
+	// EventCredentialsRotated is emitted whenever the credential fallback
+	// switches to another credential source. Data holds the bucket and the
+	// index of the source now in use.
+	EventCredentialsRotated = "credentials_rotated"
+	// EventQuotaExceeded is emitted once per period when a tenant of
+	// WithQuotas exceeded its quota. Data holds the bucket, the prefix of
+	// the tenant and the bytes served today and this month.
+	EventQuotaExceeded = "quota_exceeded"
+	// EventCanaryFailed is emitted when the canary of WithCanary can no
+	// longer be fetched. Data holds the bucket, the key and the error.
+	EventCanaryFailed = "canary_failed"
+	// EventCanaryStale is emitted when the canary of WithCanary became
+	// older than its maximum age. Data holds the bucket, the key, its last
+	// modification and its age.
+	EventCanaryStale = "canary_stale"
+	// EventCanaryRecovered is emitted when the canary of WithCanary is
+	// healthy again. Data holds the bucket and the key.
+	EventCanaryRecovered = "canary_recovered"
+	// EventCircuitOpened is emitted when the circuit breaker of
+	// WithCircuitBreaker opens. Data holds the bucket and the last error.
+	EventCircuitOpened = "circuit_opened"
+	// EventCircuitClosed is emitted when the circuit breaker closes again
+	// after a successful trial call. Data holds the bucket.
+	EventCircuitClosed = "circuit_closed"
+	// EventFailover is emitted when WithFailover sends all reads to the
+	// replica. Data holds the bucket, the replica and the last error.
+	EventFailover = "failover"
+	// EventFailback is emitted when the primary bucket responds again after
+	// a failover. Data holds the bucket.
+	EventFailback = "failback"
+	// EventSettingsChanged is emitted when SetSettings changed a setting.
+	// Data holds the bucket and the new Settings.
+	EventSettingsChanged = "settings_changed"
+)
+
+// EventHandler is called synchronously for notable events, e.g. to emit them
+// as Caddy events.
+type EventHandler func(name string, data map[string]interface{})
+
+// WithEvents calls h for the events named by the Event constants.
+func WithEvents(h EventHandler) Option {
+	return func(s *S3FS) {
+		s.events = h
+	}
+}
+
+// emit calls h, if set.
+func (h EventHandler) emit(name string, data map[string]interface{}) {
+	if h != nil {
+		h(name, data)
+	}
+}
+
+// isOriginError reports whether err is a failure of S3 rather than an
+// expected outcome of a request.
+func isOriginError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || isForbiddenNotExist(err) {
+		return false
+	}
+	switch httpStatus(err) {
+	case http.StatusNotFound, http.StatusNotModified, http.StatusPreconditionFailed:
+		return false
+	}
+	return true
+}
+
+// PurgeCache drops the cached metadata, tags and contents of all keys starting with
+// prefix, or of all keys if prefix is empty, and returns the number of
+// purged entries.
+func (s3fs *S3FS) PurgeCache(prefix string) int {
+	n := s3fs.purge(prefix)
+	s3fs.events.emit(EventCachePurged, map[string]interface{}{
+		"bucket":  s3fs.primary.bucket,
+		"prefix":  prefix,
+		"entries": n,
+	})
+	return n
+}
+
+// purge drops the cached entries of all keys starting with prefix and
+// returns their number.
+func (s3fs *S3FS) purge(prefix string) int {
+	n := 0
+	n += s3fs.statCache.purge(prefix)
+	n += s3fs.notFound.purge(prefix)
+	if s3fs.layers != nil {
+		n += s3fs.layers.misses.purge(prefix)
+	}
+	if s3fs.candidates != nil {
+		n += s3fs.candidates.probes.purge(prefix)
+	}
+	if s3fs.immutable != nil {
+		n += s3fs.immutable.cache.purge(prefix)
+	}
+	n += s3fs.tagCache.purge(prefix)
+	n += s3fs.content.purge(prefix)
+	n += s3fs.heads.purge(prefix)
+	return n
+}
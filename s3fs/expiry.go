@@ -0,0 +1,51 @@
+package s3fs
+
+import (
+	"strings"
+	"time"
+)
+
+// WithExpiry treats objects as nonexistent once the point in time recorded
+// in their user metadata field (x-amz-meta-<metadata>) or in their tag has
+// passed, enabling time-limited downloads without lifecycle deletions.
+// Values may be RFC 3339 timestamps or unix seconds. Either name may be
+// empty. Only tag based expiry hides objects from listings, as listings
+// carry no user metadata; tags are cached for tagTTL.
+func WithExpiry(metadata, tag string, tagTTL time.Duration) Option {
+	return func(s *S3FS) {
+		s.expiry.metadata = metadata
+		s.expiry.tag = tag
+		if tag != "" {
+			s.enableTagCache(tagTTL)
+		}
+	}
+}
+
+// expiry names the metadata field and tag holding the expiry time of objects.
+type expiry struct {
+	metadata string
+	tag      string
+}
+
+// expired reports whether the expiry time value has passed. Empty or
+// unparsable values never expire.
+func (s3fs *S3FS) expired(value string) bool {
+	if value == "" {
+		return false
+	}
+	t, ok := parseModTime(value)
+	return ok && s3fs.clock.Now().After(t)
+}
+
+// metadataExpired reports whether the object with the given user metadata has expired.
+func (s3fs *S3FS) metadataExpired(metadata map[string]string) bool {
+	if s3fs.expiry.metadata == "" {
+		return false
+	}
+	for k, v := range metadata {
+		if strings.EqualFold(k, s3fs.expiry.metadata) {
+			return s3fs.expired(v)
+		}
+	}
+	return false
+}
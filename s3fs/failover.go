@@ -0,0 +1,144 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFailoverThreshold = 3
+	defaultFailoverCooldown  = 30 * time.Second
+)
+
+// WithFailover retries reads failing on the primary bucket with a server
+// error, throttling, a timeout or a network error on the replica of
+// WithReplica. Once threshold consecutive calls of the primary failed, it is
+// considered down and all reads go to the replica for cooldown, after which
+// the primary is tried again; another failure sends the reads back to the
+// replica right away. Marking the primary down emits EventFailover, its
+// recovery EventFailback, the state is reported in State. The threshold
+// should be below the failures of WithCircuitBreaker, which counts the calls
+// of both buckets. It has no effect without WithReplica.
+func WithFailover(threshold int, cooldown time.Duration) Option {
+	return func(s *S3FS) {
+		if threshold <= 0 {
+			threshold = defaultFailoverThreshold
+		}
+		if cooldown <= 0 {
+			cooldown = defaultFailoverCooldown
+		}
+		s.failover = &failover{threshold: threshold, cooldown: cooldown, clock: systemClock{}}
+	}
+}
+
+// FailoverState describes the health of the primary bucket tracked by
+// WithFailover.
+type FailoverState struct {
+	// Down is set while reads go to the replica.
+	Down bool `json:"down"`
+	// Since is the time the primary was last marked down, if it is not
+	// healthy.
+	Since *time.Time `json:"since,omitempty"`
+	// Failures is the number of consecutive failed calls of the primary.
+	Failures int `json:"failures"`
+}
+
+// failover tracks the health of the primary bucket.
+type failover struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	mu       sync.Mutex
+	failures int       // failures is the number of consecutive failed calls of the primary
+	down     time.Time // down is the time the primary was last marked down, zero while healthy
+}
+
+// primaryDown reports whether reads should skip the primary.
+func (f *failover) primaryDown() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.down.IsZero() && f.clock.Now().Sub(f.down) < f.cooldown
+}
+
+// observe records the outcome of a call of the primary, returning the
+// event to emit if its health changed.
+func (f *failover) observe(err error) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !isBreakerFailure(err) {
+		f.failures = 0
+		if f.down.IsZero() {
+			return ""
+		}
+		f.down = time.Time{}
+		return EventFailback
+	}
+	f.failures++
+	if f.failures < f.threshold || f.clock.Now().Sub(f.down) < f.cooldown {
+		return ""
+	}
+	f.down = f.clock.Now()
+	return EventFailover
+}
+
+func (f *failover) stateOf() *FailoverState {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state := &FailoverState{
+		Down:     !f.down.IsZero() && f.clock.Now().Sub(f.down) < f.cooldown,
+		Failures: f.failures,
+	}
+	if !f.down.IsZero() {
+		since := f.down
+		state.Since = &since
+	}
+	return state
+}
+
+// withFailover calls fn with b, and again with the replica if b is the
+// primary and the call failed over. Calls not issued because of the circuit
+// breaker or ending with the context of the caller would fail on the
+// replica as well, and say nothing about the health of the primary.
+func withFailover[T any](s3fs *S3FS, b *backend, fn func(*backend) (T, error)) (T, error) {
+	v, err := fn(b)
+	f := s3fs.failover
+	if f == nil || s3fs.replica == nil || b != &s3fs.primary ||
+		errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return v, err
+	}
+	switch event := f.observe(err); event {
+	case EventFailover:
+		s3fs.log.Warn("primary bucket failing, failing over to replica",
+			zap.String("replica", s3fs.replica.bucket),
+			zap.Duration("cooldown", f.cooldown),
+			zap.Error(err))
+		s3fs.events.emit(event, map[string]interface{}{
+			"bucket":  s3fs.primary.bucket,
+			"replica": s3fs.replica.bucket,
+			"error":   err.Error(),
+		})
+	case EventFailback:
+		s3fs.log.Info("primary bucket recovered", zap.String("bucket", s3fs.primary.bucket))
+		s3fs.events.emit(event, map[string]interface{}{
+			"bucket": s3fs.primary.bucket,
+		})
+	}
+	if !isBreakerFailure(err) {
+		return v, err
+	}
+	s3fs.log.Debug("retrying failed call on replica",
+		zap.String("replica", s3fs.replica.bucket),
+		zap.Error(err))
+	return fn(s3fs.replica)
+}
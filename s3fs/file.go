@@ -1,15 +1,11 @@
 package s3fs
 
 import (
-	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
-	"path"
-	"strings"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"time"
 )
 
 // s3File represents a file in S3.
@@ -18,6 +14,11 @@ type s3File struct {
 
 	fs   *S3FS  // Parent file system
 	name string // Name of the file
+	key  string // Key of the object
+
+	versionID      string        // versionID selects a prior version of the object, if set
+	versionsOf     string        // versionsOf is the key whose versions this virtual directory lists
+	versionEntries []fs.DirEntry // versionEntries holds the versions not yet returned by ReadDir
 
 	readdirContinuationToken *string // readdirContinuationToken is used to perform files listing across calls
 	readdirNotTruncated      bool    // readdirNotTruncated is set when we shall continue reading
@@ -26,21 +27,48 @@ type s3File struct {
 
 	stream io.ReadCloser // streamRead is the underlying stream we are reading from
 	closed bool
+
+	window   int64 // window is the readahead of the last range requested
+	rangeEnd int64 // rangeEnd is the offset following the last range requested
+
+	pinned bool // pinned makes reads conditional on the ETag, e.g. after verifying the content
+
+	tenant *tenant // tenant is charged for the bytes read, nil if no quota applies
+
+	stats *readStats // stats tracks the throughput of this file, nil if disabled
+
+	draining bool // draining is set if closing the file releases it from the filesystem's drainer
+
+	indexOf string // indexOf is the directory this index file was opened for, see WithDirectoryIndex
+
+	statOnly bool // statOnly is set for files opened with IntentStat
+	listOnly bool // listOnly is set for directories opened with IntentList until they were found to exist
 }
 
-const READAHEAD = 1024 * 64 // 64kb readahead
+const READAHEAD = 1024 * 64 // 64kb default readahead, see WithReadahead
+
+// ErrListingTooLarge is returned by ReadDir(n <= 0) if the directory holds more
+// entries than the configured listing limits allow.
+var ErrListingTooLarge = errors.New("directory listing exceeds configured limit")
 
 // newFile initializes an File object.
 func newFile(fs *S3FS, name string) *s3File {
 	return &s3File{
 		fs:   fs,
 		name: name,
+		key:  name,
 	}
 }
 
 // Name returns the filename, i.e. S3 path without the bucket name.
 func (f *s3File) Name() string { return f.name }
 
+// IndexOf returns the directory the file was opened for as its index file,
+// empty if it was opened by its own name.
+func (f *s3File) IndexOf() string { return f.indexOf }
+
+var _ IndexFile = (*s3File)(nil)
+
 // Readdir reads the contents of the directory associated with file and
 // returns a slice of up to n FileInfo values, as would be returned
 // by ListObjects, in directory order. Subsequent calls on the same file will yield further FileInfos.
@@ -56,69 +84,87 @@ func (f *s3File) Name() string { return f.name }
 // directory, Readdir returns the FileInfo read until that point
 // and a non-nil error.
 func (f *s3File) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.versionsOf != "" {
+		return f.readVersions(n)
+	}
 	if f.readdirNotTruncated {
 		return nil, io.EOF
 	}
 	if n <= 0 {
 		return f.readDirAll()
 	}
-	// ListObjects treats leading slashes as part of the directory name
-	// It also needs a trailing slash to list contents of a directory.
-	name := strings.TrimPrefix(f.Name(), "/") // + "/"
-
-	// For the root of the bucket, we need to remove any prefix
-	if name != "" && !strings.HasSuffix(name, "/") {
-		name += "/"
-	}
-	output, err := f.fs.s3.ListObjectsV2WithContext(context.TODO(), &s3.ListObjectsV2Input{
-		ContinuationToken: f.readdirContinuationToken,
-		Bucket:            aws.String(f.fs.bucket),
-		Prefix:            aws.String(name),
-		Delimiter:         aws.String("/"),
-		MaxKeys:           aws.Int64(int64(n)),
-	})
+	fis, next, err := f.fs.listPage(f.Name(), f.readdirContinuationToken, n)
 	if err != nil {
 		return nil, err
 	}
-	f.readdirContinuationToken = output.NextContinuationToken
-	if !(*output.IsTruncated) {
-		f.readdirNotTruncated = true
+	if f.listOnly {
+		if len(fis) == 0 && next == nil {
+			return nil, &fs.PathError{Op: "readdir", Path: f.Name(), Err: fs.ErrNotExist}
+		}
+		f.listOnly = false
 	}
-	var fis = make([]fs.DirEntry, 0, len(output.CommonPrefixes)+len(output.Contents))
-	for _, subfolder := range output.CommonPrefixes {
-		fis = append(fis, newDirEntry(path.Base("/"+*subfolder.Prefix)))
+	if f.readdirContinuationToken == nil {
+		// the first page makes up the first screenful of a browse page
+		f.fs.prefetchEntries(f.Name(), fis)
 	}
-	for _, fileObject := range output.Contents {
-		if strings.HasSuffix(*fileObject.Key, "/") {
-			continue
-		}
-		fis = append(fis, newFileInfo(path.Base("/"+*fileObject.Key), *fileObject.Size, *fileObject.LastModified))
+	f.readdirContinuationToken = next
+	if next == nil {
+		f.readdirNotTruncated = true
 	}
-
 	return fis, nil
 }
 
+// ReadDirPage lists a single page of up to n entries starting at the given
+// continuation token, independent of the position of previous ReadDir calls.
+// An empty token starts at the beginning of the directory, an empty next
+// token signals the last page.
+func (f *s3File) ReadDirPage(token string, n int) (entries []fs.DirEntry, next string, err error) {
+	return f.fs.readDirPage(f.Name(), token, n)
+}
+
 // ReaddirAll provides list of file cachedInfo.
+// It fails with ErrListingTooLarge once the configured entry or page limit is exceeded.
+// Like fs.ReadDirFile, it returns the entries read so far with an error.
 func (f *s3File) readDirAll() ([]fs.DirEntry, error) {
 	var fileInfos []fs.DirEntry
-	for {
-		infos, err := f.ReadDir(1000)
+	var err error
+	for pages := 1; ; pages++ {
+		var infos []fs.DirEntry
+		infos, err = f.ReadDir(1000)
 		fileInfos = append(fileInfos, infos...)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				break
+				err = nil
 			}
-			return nil, err
+			break
+		}
+		if f.fs.maxListEntries > 0 && len(fileInfos) > f.fs.maxListEntries {
+			err = f.listingTooLarge(fmt.Sprintf("more than %d entries", f.fs.maxListEntries))
+			break
 		}
+		if f.fs.maxListPages > 0 && pages >= f.fs.maxListPages && !f.readdirNotTruncated {
+			err = f.listingTooLarge(fmt.Sprintf("more than %d pages", f.fs.maxListPages))
+			break
+		}
+	}
+	f.fs.sortEntries(f.Name(), fileInfos)
+	return fileInfos, err
+}
+
+func (f *s3File) listingTooLarge(reason string) error {
+	return &fs.PathError{
+		Op:   "readdir",
+		Path: f.Name(),
+		Err:  fmt.Errorf("%w: %s", ErrListingTooLarge, reason),
 	}
-	return fileInfos, nil
 }
 
 // Stat returns the FileInfo structure describing file.
 // If there is an error, it will be of type *PathError.
 func (f *s3File) Stat() (fs.FileInfo, error) {
 	if f.info == nil {
-		info, err := f.fs.Stat(f.Name())
+		// the name is final, Stat would map it again
+		info, err := f.fs.cachedStat(f.Name())
 		if err != nil {
 			return nil, err
 		}
@@ -130,7 +176,13 @@ func (f *s3File) Stat() (fs.FileInfo, error) {
 // Close closes the File, rendering it unusable for I/O.
 // It returns an error, if any.
 func (f *s3File) Close() error {
+	if f.draining {
+		f.draining = false
+		f.fs.drain.release()
+	}
 	f.closed = true
+	f.fs.streams.close(f.stats, f.fs.log)
+	f.stats = nil
 	// Closing a reading stream
 	if f.stream == nil {
 		return nil
@@ -145,27 +197,105 @@ func (f *s3File) Close() error {
 // It returns the number of bytes read and the error, if any.
 // ReadAt always returns a non-nil error when n < len(b).
 // At end of file, that error is io.EOF.
-func (f *s3File) ReadAt(p []byte, off int64) (n int, err error) {
-	_, err = f.Seek(off, io.SeekStart)
+// ReadAt requests exactly the bytes read, independent of the offset used by
+// Read and Seek, so it may be called concurrently.
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.statOnly {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: ErrStatOnly}
+	}
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size := f.info.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := size
+	if remaining := size - off; int64(len(p)) < remaining {
+		end = off + int64(len(p))
+	}
+	n, err := f.readAt(p[:end-off], off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	f.fs.hotKeys.record(f.name, 0, int64(n))
+	f.tenant.served(int64(n))
+	if f.stats != nil {
+		f.stats.bytes.Add(int64(n))
+	}
+	return n, err
+}
+
+// readAt fills p with the content of f starting at off, which must not
+// extend beyond the end of f.
+func (f *s3File) readAt(p []byte, off int64) (int, error) {
+	data, err := f.cachedContent()
+	if err != nil {
+		return 0, err
+	}
+	if data != nil {
+		return copy(p, data[off:]), nil
+	}
+	if file, ok := f.diskCachedFile(); ok {
+		defer file.Close()
+		return file.ReadAt(p, off)
+	}
+	if head := f.cachedHead(); off+int64(len(p)) <= int64(len(head)) {
+		return copy(p, head[off:]), nil
+	}
+	start := time.Now()
+	body, err := f.getRange(f.fs.callContext(), off, off+int64(len(p))-1)
 	if err != nil {
-		return
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p)
+	if f.stats != nil {
+		f.stats.originNanos.Add(int64(time.Since(start)))
 	}
-	n, err = f.Read(p)
-	return
+	return n, err
+}
+
+// SectionReader returns a reader of the n bytes of the file starting at off.
+// Section readers use ReadAt, so any number of them may read concurrently,
+// e.g. to fetch parts of an object in parallel.
+func (f *s3File) SectionReader(off, n int64) *io.SectionReader {
+	return io.NewSectionReader(f, off, n)
 }
 
 // Read reads up to len(b) bytes from the File.
 // It returns the number of bytes read and an error, if any.
 // EOF is signaled by a zero count with err set to io.EOF.
 func (f *s3File) Read(p []byte) (int, error) {
+	if f.statOnly {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: ErrStatOnly}
+	}
+	if f.info.Size() == 0 {
+		// zero-byte objects have no valid byte range to request
+		return 0, io.EOF
+	}
 	var err error
+	start := time.Now()
 	if f.stream == nil {
-		f.stream, err = f.rangeReader(f.offset, int64(len(p)))
+		f.stream, err = f.openStream(f.offset, int64(len(p)))
 		if err != nil {
 			return 0, err
 		}
 	}
 	n, err := f.stream.Read(p)
+	if f.stats != nil {
+		f.stats.originNanos.Add(int64(time.Since(start)))
+		f.stats.bytes.Add(int64(n))
+		if lr, ok := f.stream.(*lengthCheckingReader); ok {
+			f.stats.buffered.Store(lr.remaining)
+		}
+	}
 	if err == io.EOF {
 		if f.stream != nil {
 			f.stream.Close()
@@ -174,12 +304,61 @@ func (f *s3File) Read(p []byte) (int, error) {
 		err = nil
 	}
 	f.offset += int64(n)
+	f.fs.hotKeys.record(f.name, 0, int64(n))
+	f.tenant.served(int64(n))
 	if f.offset >= f.info.Size() {
 		return int(n), io.EOF
 	}
 	return n, err
 }
 
+// writeToBufferSize is the size of the chunks copied by WriteTo.
+const writeToBufferSize = 32 << 10
+
+// WriteTo writes the remaining content of the file to w, requesting it from
+// S3 as a single stream instead of the ranges of subsequent Reads, so
+// io.Copy of a whole file does not issue a GetObject per readahead window.
+func (f *s3File) WriteTo(w io.Writer) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.statOnly {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: ErrStatOnly}
+	}
+	size := f.info.Size()
+	buf := make([]byte, writeToBufferSize)
+	var written int64
+	for f.offset < size {
+		if f.stream == nil {
+			stream, err := f.openStream(f.offset, size-f.offset)
+			if err != nil {
+				return written, err
+			}
+			f.stream = stream
+		}
+		n, err := f.Read(buf)
+		if n > 0 {
+			nw, werr := w.Write(buf[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+var _ io.WriterTo = (*s3File)(nil)
+
 // Seek sets the offset for the next Read or Write on file to offset, interpreted
 // according to whence: 0 means relative to the origin of the file, 1 means
 // relative to the current offset, and 2 means relative to the end.
@@ -197,18 +376,21 @@ func (f *s3File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		startByte = f.offset + offset
 	case io.SeekEnd:
-		startByte = f.info.Size() - offset
+		startByte = f.info.Size() + offset
+	default:
+		return f.offset, fs.ErrInvalid
 	}
 	if startByte < 0 {
 		return startByte, fs.ErrInvalid
 	}
-	if f.offset < startByte {
-		if f.stream != nil {
-			f.stream.Close()
-			f.stream = nil
-		}
+	if startByte == f.offset {
+		// keep the current stream, e.g. for Seek(0, io.SeekCurrent)
+		return startByte, nil
+	}
+	if f.stream != nil {
+		f.stream.Close()
+		f.stream = nil
 	}
 	f.offset = startByte
-	f.stream = nil
 	return startByte, nil
 }
@@ -0,0 +1,68 @@
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func TestReadZeroByteObject(t *testing.T) {
+	client := newFakeClient(map[string]fakeObject{"empty.txt": {etag: `"d41d8cd98f00b204e9800998ecf8427e"`}})
+	fsys := newTestFS(client)
+	f, err := fsys.Open("empty.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, err := f.Read(make([]byte, 512))
+	if n != 0 || err != io.EOF {
+		t.Errorf("Read = (%d, %v), want (0, EOF)", n, err)
+	}
+	if calls := client.count("GetObject"); calls != 0 {
+		t.Errorf("%d GetObject calls, want none", calls)
+	}
+}
+
+func TestMarkerOnlyDirectory(t *testing.T) {
+	client := newFakeClient(map[string]fakeObject{"dir/": {etag: `"d41d8cd98f00b204e9800998ecf8427e"`}})
+	fsys := newTestFS(client)
+	info, err := fsys.Stat("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("dir stats as %v, want a directory", info.Mode())
+	}
+	entries, err := fs.ReadDir(fsys, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("dir lists %d entries, want none", len(entries))
+	}
+	if calls := client.count("GetObject"); calls != 0 {
+		t.Errorf("%d GetObject calls, want none", calls)
+	}
+}
+
+func TestReadDirAllTooLarge(t *testing.T) {
+	objects := make(map[string]fakeObject)
+	for i := 0; i < 20; i++ {
+		objects[fmt.Sprintf("dir/%02d.txt", i)] = fakeObject{size: 1, etag: `"etag"`}
+	}
+	fsys := newTestFS(newFakeClient(objects), WithListLimits(10, 0))
+	f, err := fsys.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := f.(fs.ReadDirFile).ReadDir(-1)
+	if !errors.Is(err, ErrListingTooLarge) {
+		t.Fatalf("ReadDir(-1) error %v, want ErrListingTooLarge", err)
+	}
+	if len(entries) != 20 {
+		t.Errorf("ReadDir(-1) returned %d entries with the error, want the 20 read", len(entries))
+	}
+}
@@ -3,21 +3,79 @@ package s3fs
 import (
 	"io/fs"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// ObjectInfo is returned by the Sys method of FileInfos describing S3 objects.
+type ObjectInfo struct {
+	// ETag is the entity tag of the object as reported by S3, a strong
+	// validator for its content.
+	ETag string
+
+	// ContentEncoding is the Content-Encoding stored with the object. It is
+	// only known for objects looked up individually, not for listings.
+	ContentEncoding string
+
+	// ObjectLock is the Object Lock status of the object, nil if it is
+	// neither retained nor under legal hold. Like ContentEncoding it is
+	// only known for objects looked up individually, and only reported by
+	// S3 to callers allowed s3:GetObjectRetention and
+	// s3:GetObjectLegalHold.
+	ObjectLock *ObjectLock
+}
+
+// ObjectLock describes the Object Lock retention and legal hold of an
+// object, e.g. for handlers restricting downloads of records under
+// retention.
+type ObjectLock struct {
+	// Mode is the retention mode, GOVERNANCE or COMPLIANCE, empty if the
+	// object has no retention period.
+	Mode string
+	// RetainUntil is the end of the retention period.
+	RetainUntil time.Time
+	// LegalHold is set while the object is under legal hold.
+	LegalHold bool
+}
+
+// Retained reports whether the object can't be deleted or overwritten at
+// t, as it is under legal hold or within its retention period.
+func (l *ObjectLock) Retained(t time.Time) bool {
+	return l != nil && (l.LegalHold || l.Mode != "" && t.Before(l.RetainUntil))
+}
+
+// newObjectLock returns the Object Lock status reported for an object, nil
+// if it has none.
+func newObjectLock(mode types.ObjectLockMode, retainUntil *time.Time, legalHold types.ObjectLockLegalHoldStatus) *ObjectLock {
+	if mode == "" && legalHold != types.ObjectLockLegalHoldStatusOn {
+		return nil
+	}
+	return &ObjectLock{
+		Mode:        string(mode),
+		RetainUntil: aws.ToTime(retainUntil),
+		LegalHold:   legalHold == types.ObjectLockLegalHoldStatusOn,
+	}
+}
+
 // fileInfo implements os.fileInfo for a file in S3.
 type fileInfo struct {
 	mTime time.Time
 	name  string
 	size  int64
+	etag  string
+
+	contentEncoding string
+	objectLock      *ObjectLock
 }
 
 // newFileInfo creates file cachedInfo.
-func newFileInfo(name string, size int64, mTime time.Time) fileInfo {
+func newFileInfo(name string, size int64, mTime time.Time, etag string) fileInfo {
 	return fileInfo{
 		name:  name,
 		size:  size,
 		mTime: mTime,
+		etag:  etag,
 	}
 }
 
@@ -57,7 +115,11 @@ func (fi fileInfo) IsDir() bool {
 	return false
 }
 
-// Sys provides the underlying data source (can return nil)
+// Sys provides the underlying data source, an *ObjectInfo.
 func (fi fileInfo) Sys() interface{} {
-	return nil
+	return &ObjectInfo{
+		ETag:            fi.etag,
+		ContentEncoding: fi.contentEncoding,
+		ObjectLock:      fi.objectLock,
+	}
 }
@@ -0,0 +1,144 @@
+package s3fs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// forbiddenProbeTimeout bounds the HeadObject detecting how missing keys are
+// reported.
+const forbiddenProbeTimeout = 5 * time.Second
+
+// ForbiddenMode selects how access denied responses of S3 are reported.
+type ForbiddenMode string
+
+const (
+	// ForbiddenDenied reports access denied responses as errors.
+	ForbiddenDenied ForbiddenMode = ""
+	// ForbiddenAlways reports all access denied responses to reads and
+	// listings as fs.ErrNotExist.
+	ForbiddenAlways ForbiddenMode = "always"
+	// ForbiddenDetect reports access denied responses as fs.ErrNotExist if
+	// S3 also denies reading a key that certainly does not exist, probed
+	// once on the first access denied response.
+	ForbiddenDetect ForbiddenMode = "detect"
+)
+
+// ParseForbiddenMode validates the textual representation of a
+// ForbiddenMode.
+func ParseForbiddenMode(s string) (ForbiddenMode, error) {
+	switch m := ForbiddenMode(s); m {
+	case ForbiddenDenied, ForbiddenAlways, ForbiddenDetect:
+		return m, nil
+	}
+	return ForbiddenDenied, fmt.Errorf("unknown forbidden mode %q", s)
+}
+
+// WithForbiddenAsNotExist reports access denied responses according to
+// mode. S3 denies reading missing keys with 403 instead of 404 if the caller
+// lacks s3:ListBucket, so sites running with only s3:GetObject need
+// ForbiddenAlways or ForbiddenDetect to serve 404 pages for missing files.
+// Directories can't be listed without s3:ListBucket, and are reported as
+// missing as well. Access denied responses taken as missing keys neither
+// make WithCredentialSources switch sources nor emit EventOriginError.
+func WithForbiddenAsNotExist(mode ForbiddenMode) Option {
+	return func(s *S3FS) {
+		if mode == ForbiddenDenied {
+			s.forbidden = nil
+			return
+		}
+		s.forbidden = &forbiddenMissing{mode: mode}
+	}
+}
+
+// forbiddenMissing decides whether access denied responses stem from missing
+// keys.
+type forbiddenMissing struct {
+	mode ForbiddenMode
+
+	mu      sync.Mutex
+	decided bool // decided is set once the probe of ForbiddenDetect was answered
+	missing bool // missing is set if the probe was denied as well
+}
+
+// notExistError is an access denied response taken for a missing key.
+type notExistError struct {
+	err error
+}
+
+func (e notExistError) Error() string { return e.err.Error() }
+
+func (e notExistError) Unwrap() error { return e.err }
+
+func (e notExistError) Is(target error) bool { return target == fs.ErrNotExist }
+
+// isForbiddenNotExist reports whether err is an access denied response
+// taken for a missing key.
+func isForbiddenNotExist(err error) bool {
+	var notExist notExistError
+	return errors.As(err, &notExist)
+}
+
+// forbiddenAsNotExist returns err as a missing key if it is an access denied
+// response of b to a request for key that is reported as fs.ErrNotExist.
+func (s3fs *S3FS) forbiddenAsNotExist(b *backend, key string, err error) error {
+	f := s3fs.forbidden
+	if f == nil || httpStatus(err) != http.StatusForbidden || !f.deniesMissing(s3fs, b, key) {
+		return err
+	}
+	return notExistError{err}
+}
+
+// deniesMissing reports whether access denied responses of b are taken for
+// missing keys, probing a key next to key, which does not exist, if
+// undecided.
+func (f *forbiddenMissing) deniesMissing(s3fs *S3FS, b *backend, key string) bool {
+	if f.mode == ForbiddenAlways {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.decided {
+		return f.missing
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return false
+	}
+	probe := key + ".s3fs-probe-" + hex.EncodeToString(suffix)
+	ctx, cancel := context.WithTimeout(context.Background(), forbiddenProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	ctx, traced := s3fs.traceCall(ctx, "HeadObject", b.bucket, probe)
+	resp, err := b.client().HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(b.bucket),
+		Key:          aws.String(probe),
+		RequestPayer: s3fs.requestPayer,
+	})
+	traced.end(resultMetadata(resp), err)
+	s3fs.observeCall(ctx, "HeadObject", start, err)
+	switch httpStatus(err) {
+	case http.StatusForbidden:
+		f.decided, f.missing = true, true
+		s3fs.log.Info("missing keys are denied, likely for lack of s3:ListBucket, reporting access denied as not found",
+			zap.String("bucket", b.bucket))
+	case http.StatusNotFound:
+		f.decided = true
+		s3fs.log.Info("missing keys are reported as not found, reporting access denied as error",
+			zap.String("bucket", b.bucket))
+	default:
+		s3fs.log.Debug("probing how missing keys are reported failed", zap.Error(err))
+	}
+	return f.missing
+}
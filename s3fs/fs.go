@@ -2,62 +2,489 @@ package s3fs
 
 import (
 	"context"
+	"crypto/cipher"
 	"errors"
 	"io/fs"
+	"net/http"
 	"path"
+	"regexp"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 // S3FS is an FS object backed by S3.
 type S3FS struct {
-	s3     *s3.S3
-	bucket string // Bucket name
-	log    *zap.Logger
+	primary backend // Bucket and client of the primary bucket
+	log     *zap.Logger
+
+	credentials aws.CredentialsProvider // credentials of the primary client, nil if unknown
+
+	replica  *backend       // replica is an optional copy of the primary bucket
+	routing  *latencyRouter // routing distributes keys between primary and replica, nil if disabled
+	failover *failover      // failover tracks the health of the primary, nil if disabled
+
+	maxListEntries int // maxListEntries caps the entries collected by readDirAll
+	maxListPages   int // maxListPages caps the ListObjectsV2 calls issued by readDirAll
+
+	listOrder      ListOrder                // listOrder sorts the result of readDirAll
+	listDescending bool                     // listDescending reverses listOrder
+	sortedListings *ttlCache[sortedListing] // sortedListings memoizes the order of listings, nil if unsorted
+
+	hotKeys *hotKeys // hotKeys tracks the most requested keys, nil if disabled
+
+	noDirectoryOpen bool     // noDirectoryOpen rejects opening directories, disabling listings
+	indexFiles      []string // indexFiles are opened in place of the directories containing them
+
+	collapse *singleflight.Group // collapse deduplicates concurrent lookups, nil if disabled
+
+	strictConsistency bool // strictConsistency makes GETs conditional on the stat result
+
+	requestPayer types.RequestPayer // requestPayer acknowledges Requester Pays buckets, empty if not
+
+	forbidden *forbiddenMissing // forbidden reports access denied responses as missing keys, nil if disabled
+
+	writable    bool             // writable enables the methods modifying the bucket
+	removeAll   bool             // removeAll enables RemoveAll
+	encryption  *Encryption      // encryption is the server-side encryption of written objects, nil for the bucket default
+	customerKey *CustomerKey     // customerKey is the SSE-C key of reads, nil if objects are not encrypted with SSE-C
+	multipart   *multipartConfig // multipart streams large writes as multipart uploads, nil if disabled
+
+	prefetch  *prefetcher            // prefetch warms metadata of listed files, nil if disabled
+	statCache *ttlCache[fs.FileInfo] // statCache holds stat results, nil if disabled
+	stale     *staleFallback         // stale serves expired stat results while S3 is slow, nil if disabled
+	notFound  *ttlCache[struct{}]    // notFound holds names known to not exist, nil if disabled
+	content   *contentCache          // content holds the content of small objects, nil if disabled
+	heads     *contentCache          // heads holds the first bytes of prepositioned objects, nil if disabled
+	disk      *diskCache             // disk holds whole objects on local disk, nil if disabled
+
+	notFoundExclude []*regexp.Regexp // notFoundExclude matches names never cached as missing
+
+	diskEncryption cipher.AEAD // diskEncryption encrypts the disk cache, nil if disabled
+
+	limiter  *limiter // limiter bounds concurrent S3 calls, nil if unlimited
+	adaptive *aimd    // adaptive adapts the limit of limiter, nil if static
+	breaker  *breaker // breaker fails S3 calls fast while S3 is down, nil if disabled
+
+	tunables *tunables // tunables holds the settings changed at runtime, shared by all views
+	clock    Clock     // clock is the source of time of the caches and limiters
+
+	streams *streamRegistry // streams tracks read statistics of open files, nil if disabled
+
+	modTimeMetadata  string        // modTimeMetadata names the user metadata field holding the modification time
+	modTimePrecision time.Duration // modTimePrecision truncates or rounds modification times, if set
+	modTimeRound     bool          // modTimeRound rounds instead of truncating to modTimePrecision
+
+	versions bool // versions exposes object versions through virtual directories
+
+	requiredTag *tagFilter                   // requiredTag hides objects not carrying a tag, nil if disabled
+	expiry      expiry                       // expiry hides objects past their expiry time
+	tagCache    *ttlCache[map[string]string] // tagCache holds object tags, nil if no feature needs them
+
+	immutable *immutable // immutable caches metadata of never changing objects, nil if disabled
+
+	exactRanges  keyPatterns // exactRanges matches keys read without readahead
+	readaheadMax int64       // readaheadMax caps the readahead grown on sequential reads, 0 if fixed
+
+	parallel *parallelDownload // parallel fetches large objects in concurrent parts, nil if disabled
+
+	partitions    *partitionWindow // partitions limits listings of date partitions, nil if disabled
+	partitionKeys []string         // partitionKeys are the Hive partition keys listed by value only
+
+	vpcEndpoint bool // vpcEndpoint annotates denied requests with the action the VPC endpoint policy must allow
+
+	drain *drainer // drain tracks open files for a graceful shutdown
+
+	closer *closer // closer stops the background work on Close
+
+	archive *archive // archive copies served objects, nil if disabled
+
+	signatures *signatures // signatures verifies objects before serving them, nil if disabled
+
+	quotas []*tenant // quotas limit the bytes served per tenant, longest prefix first
+
+	access *accessStats // access counts opened objects per prefix, nil if disabled
+
+	canary *canary // canary checks the health of a canary object, nil if disabled
+
+	events EventHandler // events receives notable events, nil if disabled
+
+	overrides []Override // overrides change parameters for matching keys
+
+	prefix        string                                    // prefix is the key prefix names are looked up below, empty for the bucket root
+	resolvePrefix func(ctx context.Context) (string, error) // resolvePrefix resolves the prefix per request, nil if static
+	layers        *layers                                   // layers are the prefixes names are looked up below, nil if disabled
+
+	timeouts Timeouts // timeouts bound S3 calls
+
+	metrics Metrics // metrics receives measurements of S3 calls and caches, nil if disabled
+
+	tracing bool // tracing emits spans of S3 calls on behalf of traced requests
+
+	metadataOnly bool // metadataOnly rejects opening files, only metadata is served
+
+	candidates *candidates // candidates probes index and pretty URL candidates in parallel, nil if disabled
+
+	capabilities *Capabilities // capabilities are the features of the backend, nil if all are supported
+
+	ctx context.Context // ctx is the context of S3 calls set by WithContext, nil for none
 }
 
 // NewFs creates a new Fs object writing files to a given S3 bucket.
-func NewFS(bucket string, s3 *s3.S3, log *zap.Logger) *S3FS {
-	return &S3FS{
-		bucket: bucket,
-		s3:     s3,
-		log:    log,
+func NewFS(bucket string, s3 Client, log *zap.Logger, opts ...Option) *S3FS {
+	s3fs := &S3FS{
+		primary: backend{s3: s3, bucket: bucket},
+		log:     log,
+		drain:   &drainer{},
+		closer:  newCloser(),
+
+		tunables: &tunables{},
+		clock:    systemClock{},
+	}
+	s3fs.tunables.readahead.Store(READAHEAD)
+	for _, opt := range opts {
+		opt(s3fs)
+	}
+	if s3fs.prefetch != nil && s3fs.statCache == nil {
+		s3fs.statCache = newTTLCache[fs.FileInfo](s3fs.prefetch.ttl, s3fs.prefetch.count*cap(s3fs.prefetch.sem)*16)
+	}
+	if s3fs.stale != nil && s3fs.statCache == nil {
+		s3fs.stale = nil
+	}
+	if s3fs.stale != nil {
+		s3fs.statCache.keepStale = s3fs.stale.maxStale
+	}
+	if s3fs.limiter != nil && s3fs.adaptive != nil {
+		s3fs.limiter.adapt(s3fs.adaptive)
+	}
+	if s3fs.resolvePrefix != nil && s3fs.layers != nil {
+		log.Error("prefix layers can't be combined with a prefix resolved per request, disabling the layers")
+		s3fs.layers = nil
+	}
+	if s3fs.prefix != "" && s3fs.layers != nil {
+		for i, p := range s3fs.layers.prefixes {
+			s3fs.layers.prefixes[i] = s3fs.prefix + p
+		}
+	}
+	if !s3fs.ranges() {
+		s3fs.parallel = nil
+	}
+	if !s3fs.conditional() {
+		s3fs.strictConsistency = false
+	}
+	s3fs.useClock()
+	s3fs.primary.events = s3fs.events
+	if s3fs.replica != nil {
+		s3fs.replica.events = s3fs.events
+	}
+	if s3fs.replica != nil && s3fs.routing != nil {
+		s3fs.routing.start(s3fs)
 	}
+	if s3fs.archive != nil {
+		s3fs.archive.start(s3fs)
+	}
+	if s3fs.disk != nil && s3fs.diskEncryption != nil && s3fs.diskEncryption.NonceSize() < 8 {
+		log.Error("disk cache encryption requires nonces of at least 8 bytes, disabling the disk cache")
+		s3fs.disk = nil
+	}
+	if s3fs.disk != nil {
+		s3fs.disk.start(s3fs)
+	}
+	if s3fs.canary != nil {
+		s3fs.canary.start(s3fs)
+	}
+	return s3fs
+}
+
+// DirPager is implemented by filesystems and directories that can list their
+// contents page by page using opaque continuation tokens, e.g. to render
+// paginated directory listings for huge prefixes.
+type DirPager interface {
+	ReadDirPage(name, token string, n int) (entries []fs.DirEntry, next string, err error)
+}
+
+var _ DirPager = (*S3FS)(nil)
+
+// WithContext returns a view of s3fs issuing its S3 calls with ctx, e.g. the
+// context of the HTTP request being served, so they are cancelled once the
+// client disconnects or ctx times out. The view shares all caches, limits
+// and files with s3fs, files opened through it keep reading with ctx.
+// Lookups shared between concurrent callers and background work are not
+// cancelled with ctx.
+func (s3fs *S3FS) WithContext(ctx context.Context) *S3FS {
+	view := *s3fs
+	view.ctx = ctx
+	return &view
+}
+
+// detached returns s3fs issuing its calls with the values but not the
+// cancellation of the context set by WithContext, for calls shared with
+// other callers.
+func (s3fs *S3FS) detached() *S3FS {
+	if s3fs.ctx == nil {
+		return s3fs
+	}
+	return s3fs.WithContext(detachedContext{s3fs.ctx})
+}
+
+// detachedContext carries the values of a context which is never done.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// callContext returns the context of S3 calls.
+func (s3fs *S3FS) callContext() context.Context {
+	if s3fs.ctx == nil {
+		return context.Background()
+	}
+	return s3fs.ctx
 }
 
 // Name returns the type of FS object this is: Fs.
 func (S3FS) Name() string { return "s3" }
 
+// Bucket returns the name of the bucket served.
+func (s3fs *S3FS) Bucket() string { return s3fs.primary.bucket }
+
 // Open a file for reading.
 func (s3fs *S3FS) Open(name string) (fs.File, error) {
+	s3fs, name, err := s3fs.bound("open", name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s3fs.prefixed("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := withPartitionPath(s3fs, key, func(name string) (fs.File, error) {
+		return withLayers(s3fs, name, s3fs.open)
+	})
+	if f, ok := file.(*s3File); ok && f.indexOf != "" {
+		// report the name opened rather than the key of the directory
+		f.indexOf = name
+	}
+	return file, err
+}
+
+func (s3fs *S3FS) open(name string) (fs.File, error) {
+	if !s3fs.drain.acquire() {
+		return nil, shuttingDown(name)
+	}
+	file, err := s3fs.openFile(name)
+	if err != nil {
+		s3fs.drain.release()
+		return nil, err
+	}
+	if file.info.IsDir() {
+		// only streams of regular files are awaited on shutdown
+		s3fs.drain.release()
+		return file, nil
+	}
+	file.draining = true
+	return file, nil
+}
+
+// newFileAt returns the file of name, which may be a path of
+// WithVersionsDirectory.
+func (s3fs *S3FS) newFileAt(name string) *s3File {
 	file := newFile(s3fs, name)
+	if key, versionID, ok := s3fs.versionPath(name); ok {
+		if versionID == "" {
+			file.versionsOf = key
+		} else {
+			file.key, file.versionID = key, versionID
+		}
+	}
+	return file
+}
 
+// openFile opens name, which may also be a directory.
+func (s3fs *S3FS) openFile(name string) (*s3File, error) {
+	file := s3fs.newFileAt(name)
 	info, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
 
 	if info.IsDir() {
+		for _, index := range s3fs.indexFiles {
+			f, err := s3fs.openFile(path.Join(name, index))
+			if err == nil {
+				f.indexOf = name
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return f, err
+			}
+		}
+		if s3fs.noDirectoryOpen {
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  fs.ErrPermission,
+			}
+		}
 		return file, nil
 	}
+	if s3fs.metadataOnly {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  ErrMetadataOnly,
+		}
+	}
 
+	if t := s3fs.tenantFor(file.key); t != nil {
+		if !s3fs.admit(t) {
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  ErrQuotaExceeded,
+			}
+		}
+		file.tenant = t
+	}
+	if err := s3fs.verifySignature(file); err != nil {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  err,
+		}
+	}
+
+	s3fs.hotKeys.record(name, 1, 0)
+	s3fs.access.record(file.key)
+	file.stats = s3fs.streams.open(name)
+	s3fs.archiveFile(file)
+	requestStats(s3fs.ctx).opened(file.key, objectETag(info))
 	return file, nil
 }
 
 // Stat returns a FileInfo describing the named file.
 // If there is an error, it will be of type *os.PathError.
-func (s3fs S3FS) Stat(name string) (fs.FileInfo, error) {
-	resp, err := s3fs.s3.HeadObjectWithContext(context.TODO(), &s3.HeadObjectInput{
-		Bucket: aws.String(s3fs.bucket),
-		Key:    aws.String(name),
+func (s3fs *S3FS) Stat(name string) (fs.FileInfo, error) {
+	s3fs, name, err := s3fs.bound("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	name, err = s3fs.prefixed("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return withPartitionPath(s3fs, name, func(name string) (fs.FileInfo, error) {
+		return withLayers(s3fs, name, s3fs.cachedStat)
+	})
+}
+
+// cachedStat stats name, serving the result from the metadata caches if possible.
+func (s3fs *S3FS) cachedStat(name string) (fs.FileInfo, error) {
+	if c := s3fs.candidates; c != nil {
+		if info, err, ok := c.wait(name); ok {
+			return info, err
+		}
+		s3fs.probeCandidates(name)
+	}
+	return s3fs.statCaches(name)
+}
+
+// statCaches stats name through the metadata caches, without waiting for
+// candidate probes.
+func (s3fs *S3FS) statCaches(name string) (fs.FileInfo, error) {
+	if err := s3fs.cachedNotFound(name); err != nil {
+		return nil, err
+	}
+	info, err := s3fs.cachedLookup(name)
+	s3fs.observeNotFound(name, err)
+	return info, err
+}
+
+// cachedLookup looks up name in the stat caches before asking S3.
+func (s3fs *S3FS) cachedLookup(name string) (fs.FileInfo, error) {
+	if s3fs.isImmutable(name) {
+		info, ok := s3fs.immutable.cache.get(name)
+		s3fs.observeCache(CacheImmutable, ok)
+		if ok {
+			return info, nil
+		}
+		info, err := s3fs.lookup(name)
+		if err == nil && !info.IsDir() {
+			s3fs.immutable.cache.put(name, info)
+		}
+		return info, err
+	}
+	if s3fs.statCache != nil {
+		info, ok := s3fs.statCache.get(name)
+		s3fs.observeCache(CacheStat, ok)
+		if ok {
+			return info, nil
+		}
+		if stale, ok := s3fs.statCache.getStale(name); ok && s3fs.stale != nil {
+			return s3fs.staleLookup(name, stale)
+		}
+	}
+	info, err := s3fs.lookup(name)
+	if err == nil {
+		s3fs.statCache.putTTL(name, info, s3fs.cacheTTL(name))
+	}
+	return info, err
+}
+
+// lookup stats name, sharing the result between concurrent callers if
+// request collapsing is enabled.
+func (s3fs *S3FS) lookup(name string) (fs.FileInfo, error) {
+	if s3fs.collapse == nil {
+		return s3fs.stat(name)
+	}
+	info, err, _ := s3fs.collapse.Do(name, func() (interface{}, error) {
+		// shared with callers whose context may outlive the one of s3fs
+		return s3fs.detached().stat(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info.(fs.FileInfo), nil
+}
+
+func (s3fs *S3FS) stat(name string) (fs.FileInfo, error) {
+	if key, versionID, ok := s3fs.versionPath(name); ok {
+		return s3fs.statVersion(name, key, versionID)
+	}
+	resp, err := withFailover(s3fs, s3fs.backendFor(name), func(b *backend) (*s3.HeadObjectOutput, error) {
+		ctx := s3fs.callContext()
+		if err := s3fs.acquire(ctx, priorityHigh); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		ctx, traced := s3fs.traceCall(ctx, "HeadObject", b.bucket, name)
+		ctx, call := bound(ctx, "HeadObject", s3fs.timeouts.Head)
+		in := &s3.HeadObjectInput{
+			Bucket:       aws.String(b.bucket),
+			Key:          aws.String(name),
+			RequestPayer: s3fs.requestPayer,
+		}
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+		resp, err := b.client().HeadObject(ctx, in)
+		err = call.responded(err)
+		call.done()
+		traced.end(resultMetadata(resp), err)
+		s3fs.limiter.release()
+		s3fs.observeCall(ctx, "HeadObject", start, err)
+		err = s3fs.forbiddenAsNotExist(b, name, err)
+		b.observe(err)
+		return resp, s3fs.endpointPolicyError("s3:GetObject", name, err)
 	})
 	if err != nil {
-		var awsErr awserr.RequestFailure
-		if errors.As(err, &awsErr) && awsErr.StatusCode() == 404 {
+		if isNotFound(err) {
 			statDir, errStat := s3fs.statDirectory(name)
 			return statDir, errStat
 		}
@@ -69,20 +496,64 @@ func (s3fs S3FS) Stat(name string) (fs.FileInfo, error) {
 	}
 
 	if strings.HasSuffix(name, "/") {
-		// accept invisible directories as directories
-		return newDirEntry(name), nil
+		// accept invisible directories as directories, i.e. zero-byte
+		// marker objects with a trailing slash
+		return newDirEntry(path.Base(name)), nil
 	}
 
-	return newFileInfo(path.Base(name), *resp.ContentLength, *resp.LastModified), nil
+	if s3fs.metadataExpired(resp.Metadata) {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  fs.ErrNotExist,
+		}
+	}
+	if ok, err := s3fs.visible(name); err != nil || !ok {
+		if err == nil {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  err,
+		}
+	}
+
+	info := newFileInfo(path.Base(name), resp.ContentLength, s3fs.modTime(resp.Metadata, aws.ToTime(resp.LastModified)), aws.ToString(resp.ETag))
+	info.contentEncoding = aws.ToString(resp.ContentEncoding)
+	info.objectLock = newObjectLock(resp.ObjectLockMode, resp.ObjectLockRetainUntilDate, resp.ObjectLockLegalHoldStatus)
+	return info, nil
+}
+
+// isNotFound reports whether err is a 404 response of S3, or an access
+// denied response taken for a missing key.
+func isNotFound(err error) bool {
+	return httpStatus(err) == http.StatusNotFound || isForbiddenNotExist(err)
 }
 
-func (s3fs S3FS) statDirectory(name string) (fs.FileInfo, error) {
+// httpStatus returns the HTTP status code of a failed S3 response, or 0 if
+// err does not stem from a response.
+func httpStatus(err error) int {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+// statDirectory reports name as directory if at least one key, including a
+// zero-byte directory marker, exists below it. The root always exists.
+func (s3fs *S3FS) statDirectory(name string) (fs.FileInfo, error) {
 	name = path.Clean(name)
-	resp, err := s3fs.s3.ListObjectsV2WithContext(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket:  aws.String(s3fs.bucket),
-		Prefix:  aws.String(strings.TrimPrefix(name, "/")),
-		MaxKeys: aws.Int64(1),
-	})
+	prefix := dirPrefix(name)
+	if prefix == "" {
+		return newDirEntry(name), nil
+	}
+	empty, err := s3fs.prefixEmpty(prefix)
+	if isNotFound(err) {
+		// listing denied for lack of s3:ListBucket
+		empty, err = true, nil
+	}
 	if err != nil {
 		return nil, &fs.PathError{
 			Op:   "stat",
@@ -90,7 +561,7 @@ func (s3fs S3FS) statDirectory(name string) (fs.FileInfo, error) {
 			Err:  err,
 		}
 	}
-	if *resp.KeyCount == 0 && name != "" {
+	if empty {
 		return nil, &fs.PathError{
 			Op:   "stat",
 			Path: name,
@@ -99,3 +570,178 @@ func (s3fs S3FS) statDirectory(name string) (fs.FileInfo, error) {
 	}
 	return newDirEntry(path.Base(name)), nil
 }
+
+// prefixEmpty reports whether no key starts with prefix, sharing the result
+// between concurrent callers if request collapsing is enabled, so names
+// differing only in a trailing slash list the prefix once.
+func (s3fs *S3FS) prefixEmpty(prefix string) (bool, error) {
+	fsys := s3fs
+	list := func() (interface{}, error) {
+		resp, err := fsys.listObjects(prefix, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(fsys.backendFor(prefix).bucket),
+			Prefix:  aws.String(prefix),
+			MaxKeys: 1,
+		})
+		if err != nil {
+			return false, err
+		}
+		return fsys.emptyListing(resp), nil
+	}
+	if s3fs.collapse == nil {
+		empty, err := list()
+		return empty.(bool), err
+	}
+	// shared with callers whose context may outlive the one of s3fs
+	fsys = s3fs.detached()
+	// NUL keeps prefixes apart from the names of lookups
+	empty, err, _ := s3fs.collapse.Do("\x00"+prefix, list)
+	return empty.(bool), err
+}
+
+// ReadDirPage lists a single page of up to n entries of the named directory,
+// starting at the given continuation token. An empty token starts at the
+// beginning of the directory, an empty next token signals the last page.
+func (s3fs *S3FS) ReadDirPage(name, token string, n int) (entries []fs.DirEntry, next string, err error) {
+	s3fs, name, err = s3fs.bound("readdir", name)
+	if err != nil {
+		return nil, "", err
+	}
+	name, err = s3fs.prefixed("readdir", name)
+	if err != nil {
+		return nil, "", err
+	}
+	if mapped, ok := s3fs.partitionPath(name); ok {
+		if _, err := withLayers(s3fs, mapped, s3fs.cachedStat); err == nil {
+			name = mapped
+		}
+	}
+	return s3fs.readDirPage(s3fs.layeredDir(name), token, n)
+}
+
+// readDirPage lists a page of the directory with the key name.
+func (s3fs *S3FS) readDirPage(name, token string, n int) (entries []fs.DirEntry, next string, err error) {
+	if n <= 0 {
+		n = 1000
+	}
+	var continuation *string
+	if token != "" {
+		continuation = aws.String(token)
+	}
+	entries, nextToken, err := s3fs.listPage(name, continuation, n)
+	if err != nil {
+		return nil, "", &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  err,
+		}
+	}
+	s3fs.prefetchEntries(name, entries)
+	return entries, aws.ToString(nextToken), nil
+}
+
+// listPage issues a single ListObjectsV2 call for the directory name and
+// returns its entries together with the continuation token of the next page,
+// which is nil if the listing is complete.
+func (s3fs *S3FS) listPage(name string, token *string, n int) ([]fs.DirEntry, *string, error) {
+	prefix := dirPrefix(name)
+	var partitions partitionState
+	if s3fs.partitions != nil {
+		partitions = s3fs.partitions.state(prefix, time.Now())
+		if partitions.outside {
+			return []fs.DirEntry{}, nil, nil
+		}
+	}
+	input := &s3.ListObjectsV2Input{
+		ContinuationToken: token,
+		Bucket:            aws.String(s3fs.backendFor(prefix).bucket),
+		Prefix:            aws.String(prefix),
+		Delimiter:         aws.String("/"),
+		MaxKeys:           int32(n),
+	}
+	output, err := s3fs.listObjects(prefix, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fis = make([]fs.DirEntry, 0, len(output.CommonPrefixes)+len(output.Contents))
+	if token == nil && partitions.restricted && len(output.CommonPrefixes) > 0 && output.IsTruncated && s3fs.startAfter() {
+		first := strings.TrimSuffix(strings.TrimPrefix(*output.CommonPrefixes[0].Prefix, prefix), "/")
+		if startAfter := partitions.skipTo(prefix, first); startAfter != "" {
+			// keep the files sorting before the partitions, start over after the old partitions
+			for _, fileObject := range output.Contents {
+				if *fileObject.Key < startAfter {
+					fis = appendObject(fis, fileObject)
+				}
+			}
+			input.StartAfter = aws.String(startAfter)
+			output, err = s3fs.listObjects(prefix, input)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for _, subfolder := range output.CommonPrefixes {
+		fis = append(fis, newDirEntry(path.Base("/"+*subfolder.Prefix)))
+	}
+	for _, fileObject := range output.Contents {
+		fis = appendObject(fis, fileObject)
+	}
+	fis = partitions.filter(fis)
+	fis, err = s3fs.filterVisible(name, fis)
+	if err != nil {
+		return nil, nil, err
+	}
+	s3fs.displayPartitions(fis)
+	if !output.IsTruncated {
+		return fis, nil, nil
+	}
+	return fis, output.NextContinuationToken, nil
+}
+
+// listObjects issues a single ListObjectsV2 call for prefix.
+func (s3fs *S3FS) listObjects(prefix string, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return withFailover(s3fs, s3fs.backendFor(prefix), func(b *backend) (*s3.ListObjectsV2Output, error) {
+		ctx := s3fs.callContext()
+		if err := s3fs.acquire(ctx, priorityHigh); err != nil {
+			return nil, err
+		}
+		in := *input
+		in.Bucket = aws.String(b.bucket)
+		in.RequestPayer = s3fs.requestPayer
+		start := time.Now()
+		ctx, traced := s3fs.traceCall(ctx, "ListObjectsV2", b.bucket, "",
+			attribute.String("aws.s3.prefix", aws.ToString(in.Prefix)))
+		ctx, call := bound(ctx, "ListObjectsV2", s3fs.timeouts.List)
+		output, err := b.client().ListObjectsV2(ctx, &in)
+		err = call.responded(err)
+		call.done()
+		traced.end(resultMetadata(output), err)
+		s3fs.limiter.release()
+		s3fs.observeCall(ctx, "ListObjectsV2", start, err)
+		err = s3fs.forbiddenAsNotExist(b, prefix, err)
+		b.observe(err)
+		return output, s3fs.endpointPolicyError("s3:ListBucket", prefix, err)
+	})
+}
+
+// appendObject appends the file entry of a listed object, skipping directory markers.
+func appendObject(fis []fs.DirEntry, fileObject types.Object) []fs.DirEntry {
+	if strings.HasSuffix(*fileObject.Key, "/") {
+		return fis
+	}
+	return append(fis, newFileInfo(path.Base("/"+*fileObject.Key), fileObject.Size, aws.ToTime(fileObject.LastModified), aws.ToString(fileObject.ETag)))
+}
+
+// dirPrefix converts a directory name into the key prefix listing its contents.
+// ListObjects treats leading slashes as part of the directory name and needs
+// a trailing slash to list the contents of a directory.
+func dirPrefix(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	// For the root of the bucket, we need to remove any prefix
+	if name == "." {
+		return ""
+	}
+	if name != "" && !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+	return name
+}
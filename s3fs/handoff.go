@@ -0,0 +1,22 @@
+package s3fs
+
+// Adopt takes over the cached metadata, tags, contents and heads, the quota
+// counters and the adaptive concurrency limit of prev, a filesystem of the
+// same bucket and credentials being replaced, e.g. on a config reload, so
+// s3fs does not start cold. Caches disabled on s3fs are left alone, entries
+// keep expiring according to the TTLs of s3fs.
+func (s3fs *S3FS) Adopt(prev *S3FS) {
+	if prev == nil || prev == s3fs {
+		return
+	}
+	if s3fs.immutable != nil && prev.immutable != nil {
+		s3fs.immutable.cache.copyFrom(prev.immutable.cache)
+	}
+	s3fs.statCache.copyFrom(prev.statCache)
+	s3fs.notFound.copyFrom(prev.notFound)
+	s3fs.tagCache.copyFrom(prev.tagCache)
+	s3fs.content.copyFrom(prev.content)
+	s3fs.heads.copyFrom(prev.heads)
+	s3fs.limiter.adopt(prev.limiter)
+	s3fs.adoptQuotas(prev)
+}
@@ -0,0 +1,86 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// WithPartitionDisplay presents Hive-style partition directories of the given
+// keys by their value only, e.g. `year=2024/month=06` is listed as
+// `2024/06`, and maps such paths back when opening them. A sequence of
+// all-digit path segments is assumed to address the keys in the given order;
+// if the mapped path does not exist, the literal path is used instead.
+func WithPartitionDisplay(keys ...string) Option {
+	return func(s *S3FS) {
+		s.partitionKeys = keys
+	}
+}
+
+// partitionPath maps a path using friendly partition names back to the
+// Hive-style key path. It reports false if the path does not change.
+func (s3fs *S3FS) partitionPath(name string) (string, bool) {
+	if len(s3fs.partitionKeys) == 0 {
+		return name, false
+	}
+	segs := strings.Split(name, "/")
+	changed := false
+	level := 0
+	for i, seg := range segs {
+		if !isDigits(seg) || level >= len(s3fs.partitionKeys) {
+			level = 0
+			continue
+		}
+		segs[i] = s3fs.partitionKeys[level] + "=" + seg
+		level++
+		changed = true
+	}
+	return strings.Join(segs, "/"), changed
+}
+
+// withPartitionPath calls fn with the Hive-style path of name, and with name
+// itself if that does not exist.
+func withPartitionPath[T any](s3fs *S3FS, name string, fn func(string) (T, error)) (T, error) {
+	if mapped, ok := s3fs.partitionPath(name); ok {
+		v, err := fn(mapped)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return v, err
+		}
+	}
+	return fn(name)
+}
+
+// displayPartitions renames Hive-style partition directories to their value.
+func (s3fs *S3FS) displayPartitions(entries []fs.DirEntry) {
+	if len(s3fs.partitionKeys) == 0 {
+		return
+	}
+	for i, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		k, v, ok := strings.Cut(e.Name(), "=")
+		if !ok || !isDigits(v) {
+			continue
+		}
+		for _, key := range s3fs.partitionKeys {
+			if k == key {
+				entries[i] = newDirEntry(v)
+				break
+			}
+		}
+	}
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
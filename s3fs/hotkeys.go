@@ -0,0 +1,121 @@
+package s3fs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// hotKeySlots is the number of buckets the sliding window is divided into.
+const hotKeySlots = 12
+
+// KeyStat summarizes the traffic of a single key over the hot-key window.
+type KeyStat struct {
+	Key      string `json:"key"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// WithHotKeys tracks requests and bytes served per key over a sliding window
+// so the most requested keys can be reported by HotKeys.
+func WithHotKeys(window time.Duration, topN int) Option {
+	return func(s *S3FS) {
+		if window <= 0 || topN <= 0 {
+			return
+		}
+		s.hotKeys = newHotKeys(window, topN)
+	}
+}
+
+// HotKeys returns the most requested keys within the configured window,
+// ordered by request count. It returns nil if hot-key tracking is disabled.
+func (s3fs *S3FS) HotKeys() []KeyStat {
+	if s3fs.hotKeys == nil {
+		return nil
+	}
+	return s3fs.hotKeys.top(time.Now())
+}
+
+// hotKeys counts key accesses in a ring of time slots.
+type hotKeys struct {
+	mu       sync.Mutex
+	slotSize time.Duration
+	topN     int
+	slots    [hotKeySlots]hotKeySlot
+}
+
+type hotKeySlot struct {
+	start time.Time
+	keys  map[string]*KeyStat
+}
+
+func newHotKeys(window time.Duration, topN int) *hotKeys {
+	slotSize := window / hotKeySlots
+	if slotSize <= 0 {
+		slotSize = 1
+	}
+	return &hotKeys{
+		slotSize: slotSize,
+		topN:     topN,
+	}
+}
+
+// record adds requests and bytes served for key to the current slot.
+func (h *hotKeys) record(key string, requests, bytes int64) {
+	if h == nil {
+		return
+	}
+	now := time.Now()
+	start := now.Truncate(h.slotSize)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	slot := &h.slots[int(start.UnixNano()/int64(h.slotSize))%hotKeySlots]
+	if !slot.start.Equal(start) {
+		slot.start = start
+		slot.keys = make(map[string]*KeyStat)
+	}
+	stat, ok := slot.keys[key]
+	if !ok {
+		stat = &KeyStat{Key: key}
+		slot.keys[key] = stat
+	}
+	stat.Requests += requests
+	stat.Bytes += bytes
+}
+
+// top merges all slots still inside the window and returns the topN keys.
+func (h *hotKeys) top(now time.Time) []KeyStat {
+	oldest := now.Add(-h.slotSize * hotKeySlots)
+	merged := make(map[string]*KeyStat)
+	h.mu.Lock()
+	for _, slot := range h.slots {
+		if !slot.start.After(oldest) {
+			continue
+		}
+		for key, stat := range slot.keys {
+			m, ok := merged[key]
+			if !ok {
+				m = &KeyStat{Key: key}
+				merged[key] = m
+			}
+			m.Requests += stat.Requests
+			m.Bytes += stat.Bytes
+		}
+	}
+	h.mu.Unlock()
+
+	stats := make([]KeyStat, 0, len(merged))
+	for _, stat := range merged {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Requests != stats[j].Requests {
+			return stats[i].Requests > stats[j].Requests
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if len(stats) > h.topN {
+		stats = stats[:h.topN]
+	}
+	return stats
+}
@@ -0,0 +1,121 @@
+package s3fs
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is the number of bytes net/http reads to detect the content type
+// of a file before seeking back to its start.
+const sniffLen = 512
+
+// NewHTTPFileSystem returns an http.FileSystem serving fsys, e.g. an S3FS or
+// an Overlay, for use with http.FileServer outside of Caddy. Unlike
+// http.FS, the bytes read to detect the content type are replayed after
+// seeking back to the start rather than requested from S3 again, and
+// directories are listed page by page for Readdir with a positive count.
+// To cancel the S3 calls of a request once its client is gone, serve an
+// http.FileSystem of s3fs.WithContext(r.Context()) per request.
+func NewHTTPFileSystem(fsys fs.FS) http.FileSystem {
+	return httpFS{fsys}
+}
+
+// httpFS adapts an fs.FS to http.FileSystem.
+type httpFS struct {
+	fsys fs.FS
+}
+
+func (h httpFS) Open(name string) (http.File, error) {
+	f, err := h.fsys.Open(rootedName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &httpFile{File: f}, nil
+}
+
+// rootedName converts a slash separated path relative to the root, possibly
+// with a leading slash, into a name valid for fs.FS.
+func rootedName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// httpFile adapts an fs.File to http.File.
+type httpFile struct {
+	fs.File
+	head   []byte // head holds the bytes read from the start of the file, up to sniffLen
+	replay int    // replay is the offset within head read from next, -1 once the file was read or seeked past it
+	offset int64  // offset is the offset of the underlying file, the end of head unless replay is -1
+}
+
+var _ http.File = (*httpFile)(nil)
+
+func (f *httpFile) Read(p []byte) (int, error) {
+	if f.replay >= 0 && f.replay < len(f.head) {
+		n := copy(p, f.head[f.replay:])
+		f.replay += n
+		return n, nil
+	}
+	n, err := f.File.Read(p)
+	if f.replay >= 0 && f.offset == int64(len(f.head)) && len(f.head)+n <= sniffLen {
+		f.head = append(f.head, p[:n]...)
+		f.replay = len(f.head)
+	} else {
+		f.replay = -1
+	}
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *httpFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent && f.replay >= 0 {
+		offset += int64(f.replay)
+		whence = io.SeekStart
+	}
+	if whence == io.SeekStart && f.replay >= 0 && offset >= 0 && offset <= int64(len(f.head)) {
+		// read the sniffed bytes again, keeping the underlying stream
+		f.replay = int(offset)
+		return offset, nil
+	}
+	s, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, &fs.PathError{Op: "seek", Path: f.name(), Err: fs.ErrInvalid}
+	}
+	n, err := s.Seek(offset, whence)
+	if err == nil {
+		f.offset = n
+		f.replay = -1
+	}
+	return n, err
+}
+
+func (f *httpFile) Readdir(count int) ([]fs.FileInfo, error) {
+	dir, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name(), Err: fs.ErrInvalid}
+	}
+	entries, err := dir.ReadDir(count)
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, infoErr := e.Info()
+		if infoErr != nil {
+			return infos, infoErr
+		}
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+// name returns the name of the file for errors.
+func (f *httpFile) name() string {
+	if info, err := f.File.Stat(); err == nil {
+		return info.Name()
+	}
+	return ""
+}
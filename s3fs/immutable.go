@@ -0,0 +1,41 @@
+package s3fs
+
+import (
+	"io/fs"
+	"time"
+)
+
+// immutableTTL is the lifetime of cached metadata of immutable objects,
+// effectively forever.
+const immutableTTL = 100 * 365 * 24 * time.Hour
+
+// immutableCacheSize bounds the number of immutable objects whose metadata is cached.
+const immutableCacheSize = 100000
+
+// WithImmutablePrefixes marks objects matching any of the patterns as
+// immutable, e.g. fingerprinted assets below `assets/hashed/**`. Their
+// metadata is cached after the first lookup and never revalidated, and reads
+// are never made conditional. Patterns ending in `/**` match everything
+// below the prefix, others are matched using path.Match.
+func WithImmutablePrefixes(patterns ...string) Option {
+	return func(s *S3FS) {
+		if len(patterns) == 0 {
+			return
+		}
+		s.immutable = &immutable{
+			patterns: keyPatterns(patterns),
+			cache:    newTTLCache[fs.FileInfo](immutableTTL, immutableCacheSize),
+		}
+	}
+}
+
+// immutable caches the metadata of objects that never change.
+type immutable struct {
+	patterns keyPatterns
+	cache    *ttlCache[fs.FileInfo]
+}
+
+// isImmutable reports whether name matches an immutable pattern.
+func (s3fs *S3FS) isImmutable(name string) bool {
+	return s3fs.immutable != nil && s3fs.immutable.patterns.match(name)
+}
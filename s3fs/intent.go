@@ -0,0 +1,77 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+)
+
+// ErrStatOnly is returned for reads of files opened with IntentStat.
+var ErrStatOnly = errors.New("file opened for stat only")
+
+// Intent tells OpenIntent what an opened file is used for, so work only
+// needed for other uses can be skipped.
+type Intent int
+
+const (
+	// IntentRead opens files for reading, like Open.
+	IntentRead Intent = iota
+	// IntentStat opens files only to call Stat on them: index files are
+	// not resolved, quotas, signatures and access statistics are not
+	// checked or recorded, and reads fail with ErrStatOnly.
+	IntentStat
+	// IntentList opens directories only to list them, without looking up
+	// their metadata first, which saves a HeadObject and a ListObjectsV2
+	// call for uncached directories. The first ReadDir fails with
+	// fs.ErrNotExist if no object is below the name, including if the name
+	// is a file or an empty directory of a marker object only. With
+	// WithPrefixLayers or WithVersionsDirectory names are opened like
+	// with IntentRead, as their layer or kind has to be looked up.
+	IntentList
+)
+
+// OpenIntent opens name for intent.
+func (s3fs *S3FS) OpenIntent(name string, intent Intent) (fs.File, error) {
+	if intent == IntentRead || intent == IntentList && (s3fs.layers != nil || s3fs.versions) {
+		return s3fs.Open(name)
+	}
+	s3fs, name, err := s3fs.bound("open", name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s3fs.prefixed("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return withPartitionPath(s3fs, key, func(name string) (fs.File, error) {
+		if intent == IntentStat {
+			return withLayers(s3fs, name, s3fs.openStat)
+		}
+		return s3fs.openList(name)
+	})
+}
+
+// openStat opens name for IntentStat.
+func (s3fs *S3FS) openStat(name string) (fs.File, error) {
+	file := s3fs.newFileAt(name)
+	if _, err := file.Stat(); err != nil {
+		return nil, err
+	}
+	file.statOnly = true
+	return file, nil
+}
+
+// openList opens the directory name for IntentList.
+func (s3fs *S3FS) openList(name string) (fs.File, error) {
+	if s3fs.noDirectoryOpen {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  fs.ErrPermission,
+		}
+	}
+	file := newFile(s3fs, name)
+	file.info = newDirEntry(path.Base(name))
+	file.listOnly = dirPrefix(path.Clean(name)) != ""
+	return file, nil
+}
@@ -0,0 +1,104 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// layerMissEntries bounds the number of misses remembered per filesystem.
+const layerMissEntries = 10000
+
+// WithPrefixLayers serves names from an ordered list of key prefixes within
+// the bucket, e.g. "overrides/", "current/" and "defaults/": every lookup
+// tries the name below each prefix in turn and the first one existing is
+// served. An empty prefix stands for the bucket root. The miss of each layer
+// is remembered for missTTL, so a file only present in the last layer costs
+// lookups in the layers before it once per missTTL rather than on every
+// request; a zero missTTL disables remembering misses. Directories are
+// listed from the first layer they exist in, listings of the layers are not
+// merged. Patterns of other options match the keys including the prefix.
+func WithPrefixLayers(missTTL time.Duration, prefixes ...string) Option {
+	return func(s *S3FS) {
+		if len(prefixes) == 0 {
+			return
+		}
+		l := &layers{}
+		for _, p := range prefixes {
+			p = strings.Trim(p, "/")
+			if p != "" {
+				p += "/"
+			}
+			l.prefixes = append(l.prefixes, p)
+		}
+		if missTTL > 0 {
+			l.misses = newTTLCache[struct{}](missTTL, layerMissEntries)
+		}
+		s.layers = l
+	}
+}
+
+// layers holds the prefixes of WithPrefixLayers.
+type layers struct {
+	prefixes []string
+	misses   *ttlCache[struct{}] // misses holds layered names known to not exist, nil if disabled
+}
+
+// layerName returns name below prefix.
+func layerName(prefix, name string) string {
+	if name == "." || name == "" {
+		if prefix == "" {
+			return "."
+		}
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return path.Join(prefix, name)
+}
+
+// withLayers calls fn with name below each layer prefix in order until it
+// reports a result other than fs.ErrNotExist, or with name itself if no
+// layers are configured.
+func withLayers[T any](s3fs *S3FS, name string, fn func(string) (T, error)) (T, error) {
+	l := s3fs.layers
+	if l == nil {
+		return fn(name)
+	}
+	for _, prefix := range l.prefixes {
+		layered := layerName(prefix, name)
+		if _, ok := l.misses.get(layered); ok {
+			continue
+		}
+		v, err := fn(layered)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return v, err
+		}
+		if s3fs.cacheableNotFound(layered) {
+			l.misses.put(layered, struct{}{})
+		}
+	}
+	var zero T
+	return zero, &fs.PathError{
+		Op:   "stat",
+		Path: name,
+		Err:  fs.ErrNotExist,
+	}
+}
+
+// layeredDir returns the name of the directory name in the first layer it
+// exists in, or name itself if no layers are configured.
+func (s3fs *S3FS) layeredDir(name string) string {
+	if s3fs.layers == nil {
+		return name
+	}
+	layered := layerName(s3fs.layers.prefixes[0], name)
+	withLayers(s3fs, name, func(n string) (fs.FileInfo, error) {
+		info, err := s3fs.cachedStat(n)
+		if err == nil {
+			layered = n
+		}
+		return info, err
+	})
+	return layered
+}
@@ -0,0 +1,130 @@
+package s3fs
+
+import (
+	"context"
+	"sync"
+)
+
+// priority classifies S3 calls for the concurrency limiter.
+type priority int
+
+const (
+	// priorityHigh is used for metadata calls and small objects such as
+	// HTML, CSS or scripts needed to render pages.
+	priorityHigh priority = iota
+	// priorityLow is used for range fetches of large objects, e.g. media.
+	priorityLow
+
+	numPriorities
+)
+
+// smallObjectSize is the largest object still fetched with priorityHigh.
+const smallObjectSize = 1 << 20
+
+// WithConcurrencyLimit bounds the number of concurrent S3 calls. Once the
+// limit is reached, waiting metadata requests and reads of small objects are
+// served before range fetches of large objects.
+func WithConcurrencyLimit(max int) Option {
+	return func(s *S3FS) {
+		if max <= 0 {
+			return
+		}
+		s.limiter = &limiter{max: max}
+	}
+}
+
+// limiter is a concurrency limiter handing freed slots to waiters in
+// priority order, FIFO within the same priority.
+type limiter struct {
+	mu      sync.Mutex
+	max     int
+	inUse   int
+	waiting [numPriorities][]chan struct{}
+
+	adaptive *aimd // adaptive adapts max to the responses of S3, nil if static
+}
+
+// acquire admits an S3 call with priority p, failing fast while the circuit
+// breaker is open, and waits for its turn under the rate limit and for a
+// slot of the concurrency limiter.
+func (s3fs *S3FS) acquire(ctx context.Context, p priority) error {
+	if err := s3fs.breaker.allow(); err != nil {
+		return err
+	}
+	if err := s3fs.tunables.rate.Load().wait(ctx); err != nil {
+		s3fs.breaker.abort()
+		return err
+	}
+	if err := s3fs.limiter.acquire(ctx, p); err != nil {
+		s3fs.breaker.abort()
+		return err
+	}
+	return nil
+}
+
+// acquire blocks until a slot is available or ctx is done.
+func (l *limiter) acquire(ctx context.Context, p priority) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.inUse < l.max {
+		l.inUse++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiting[p] = append(l.waiting[p], ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, w := range l.waiting[p] {
+			if w == ready {
+				l.waiting[p] = append(l.waiting[p][:i], l.waiting[p][i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// the slot was handed over concurrently, pass it on
+		l.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot acquired by acquire.
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *limiter) releaseLocked() {
+	if l.inUse <= l.max {
+		if next := l.next(); next != nil {
+			// hand the slot over directly, inUse stays unchanged
+			close(next)
+			return
+		}
+	}
+	l.inUse--
+}
+
+// next dequeues the first waiter of the highest priority, the lock must be
+// held. It returns nil if nobody is waiting.
+func (l *limiter) next() chan struct{} {
+	for p := range l.waiting {
+		if len(l.waiting[p]) > 0 {
+			next := l.waiting[p][0]
+			l.waiting[p] = l.waiting[p][1:]
+			return next
+		}
+	}
+	return nil
+}
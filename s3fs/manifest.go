@@ -0,0 +1,86 @@
+package s3fs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ManifestEntry describes an object expected in the bucket.
+type ManifestEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// ETag is compared if set, quotes are optional.
+	ETag string `json:"etag,omitempty"`
+}
+
+// ManifestChange describes an object differing from its manifest entry.
+type ManifestChange struct {
+	Expected ManifestEntry `json:"expected"`
+	Actual   ManifestEntry `json:"actual"`
+}
+
+// ManifestDiff lists the differences between a manifest and the bucket.
+type ManifestDiff struct {
+	// Missing are the entries of the manifest not found in the bucket.
+	Missing []ManifestEntry `json:"missing"`
+	// Extra are the objects of the bucket not listed in the manifest.
+	Extra []ManifestEntry `json:"extra"`
+	// Changed are the objects whose size or ETag differ from the manifest.
+	Changed []ManifestChange `json:"changed"`
+}
+
+// DiffManifest compares all objects below prefix against the expected ones
+// of manifest, e.g. to validate a deployment is complete before switching
+// traffic to it. Manifest entries outside of prefix are ignored. Directory
+// markers are not considered objects.
+func (s3fs *S3FS) DiffManifest(prefix string, manifest []ManifestEntry) (*ManifestDiff, error) {
+	expected := make(map[string]ManifestEntry, len(manifest))
+	for _, e := range manifest {
+		if strings.HasPrefix(e.Key, prefix) {
+			expected[e.Key] = e
+		}
+	}
+	diff := &ManifestDiff{
+		Missing: []ManifestEntry{},
+		Extra:   []ManifestEntry{},
+		Changed: []ManifestChange{},
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3fs.backendFor(prefix).bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		output, err := s3fs.listObjects(prefix, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range output.Contents {
+			key := aws.ToString(obj.Key)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			actual := ManifestEntry{Key: key, Size: obj.Size, ETag: aws.ToString(obj.ETag)}
+			want, ok := expected[key]
+			if !ok {
+				diff.Extra = append(diff.Extra, actual)
+				continue
+			}
+			delete(expected, key)
+			if want.Size != actual.Size || (want.ETag != "" && strings.Trim(want.ETag, `"`) != strings.Trim(actual.ETag, `"`)) {
+				diff.Changed = append(diff.Changed, ManifestChange{Expected: want, Actual: actual})
+			}
+		}
+		if !output.IsTruncated {
+			break
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+	for _, e := range expected {
+		diff.Missing = append(diff.Missing, e)
+	}
+	sort.Slice(diff.Missing, func(i, j int) bool { return diff.Missing[i].Key < diff.Missing[j].Key })
+	return diff, nil
+}
@@ -0,0 +1,21 @@
+package s3fs
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// ErrMetadataOnly is returned by Open for the files of a filesystem created
+// with WithMetadataOnly. It wraps fs.ErrPermission, so HTTP handlers answer
+// it with 403.
+var ErrMetadataOnly = fmt.Errorf("contents are not served in metadata-only mode: %w", fs.ErrPermission)
+
+// WithMetadataOnly serves file metadata and directory listings without ever
+// reading object contents, for credentials only allowed to list the bucket
+// and head objects, e.g. of a catalog of restricted data. Stat and listings
+// work as usual, opening a file fails with ErrMetadataOnly.
+func WithMetadataOnly() Option {
+	return func(s *S3FS) {
+		s.metadataOnly = true
+	}
+}
@@ -0,0 +1,68 @@
+package s3fs
+
+import (
+	"io"
+	"time"
+)
+
+// Names of the caches reported to Metrics.
+const (
+	CacheStat      = "stat"
+	CacheNotFound  = "not_found"
+	CacheImmutable = "immutable"
+	CacheContent   = "content"
+	CacheDisk      = "disk"
+)
+
+// Metrics receives measurements of the S3 calls and caches of a filesystem,
+// e.g. to export them as Prometheus metrics. It must be safe for concurrent
+// use and should not block.
+type Metrics interface {
+	// Call records an S3 call of operation, e.g. GetObject, which took
+	// latency to respond and failed with err, nil on success.
+	Call(operation string, latency time.Duration, err error)
+	// Bytes records n bytes of a response body of operation read from S3.
+	Bytes(operation string, n int64)
+	// Cache records a lookup in the cache named by one of the Cache
+	// constants.
+	Cache(cache string, hit bool)
+}
+
+// WithMetrics reports S3 calls, the bytes transferred and cache lookups to
+// m.
+func WithMetrics(m Metrics) Option {
+	return func(s *S3FS) {
+		s.metrics = m
+	}
+}
+
+// observeCache reports a lookup in cache.
+func (s3fs *S3FS) observeCache(cache string, hit bool) {
+	if s3fs.metrics != nil {
+		s3fs.metrics.Cache(cache, hit)
+	}
+}
+
+// metered returns body reporting the bytes read from it as transferred by
+// operation.
+func (s3fs *S3FS) metered(operation string, body io.ReadCloser) io.ReadCloser {
+	if s3fs.metrics == nil {
+		return body
+	}
+	return &meteredBody{ReadCloser: body, operation: operation, metrics: s3fs.metrics}
+}
+
+// meteredBody reports the bytes read from a response body.
+type meteredBody struct {
+	io.ReadCloser
+	operation string
+	metrics   Metrics
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.metrics.Bytes(b.operation, int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,77 @@
+package s3fs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithModTimeMetadata sources the modification time reported by Stat from
+// the named user metadata field (x-amz-meta-<key>) instead of the S3
+// LastModified timestamp, e.g. to report the original file mtime recorded at
+// upload. Values may be RFC 3339 timestamps or (fractional) unix seconds.
+// Objects without a parsable value fall back to LastModified, as do
+// directory listings, which carry no user metadata.
+func WithModTimeMetadata(key string) Option {
+	return func(s *S3FS) {
+		s.modTimeMetadata = key
+	}
+}
+
+// WithModTimePrecision truncates modification times reported by Stat to
+// multiples of precision, or rounds them if round is set, e.g. to agree on
+// Last-Modified with other origins storing times at a coarser precision.
+func WithModTimePrecision(precision time.Duration, round bool) Option {
+	return func(s *S3FS) {
+		s.modTimePrecision = precision
+		s.modTimeRound = round
+	}
+}
+
+// modTime returns the modification time recorded in metadata, or
+// lastModified, at the configured precision.
+func (s3fs *S3FS) modTime(metadata map[string]string, lastModified time.Time) time.Time {
+	t := s3fs.sourceModTime(metadata, lastModified)
+	switch {
+	case s3fs.modTimePrecision <= 0:
+		return t
+	case s3fs.modTimeRound:
+		return t.Round(s3fs.modTimePrecision)
+	default:
+		return t.Truncate(s3fs.modTimePrecision)
+	}
+}
+
+// sourceModTime returns the modification time recorded in metadata, or lastModified.
+func (s3fs *S3FS) sourceModTime(metadata map[string]string, lastModified time.Time) time.Time {
+	if s3fs.modTimeMetadata == "" {
+		return lastModified
+	}
+	for k, v := range metadata {
+		if !strings.EqualFold(k, s3fs.modTimeMetadata) {
+			continue
+		}
+		if t, ok := parseModTime(v); ok {
+			return t
+		}
+		s3fs.log.Debug("ignoring unparsable modification time metadata",
+			zap.String("field", k), zap.String("value", v))
+		break
+	}
+	return lastModified
+}
+
+// parseModTime parses RFC 3339 timestamps and (fractional) unix seconds.
+func parseModTime(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		whole := int64(secs)
+		return time.Unix(whole, int64((secs-float64(whole))*1e9)), true
+	}
+	return time.Time{}, false
+}
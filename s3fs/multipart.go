@@ -0,0 +1,249 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// minPartSize is the smallest part S3 accepts but for the last one.
+	minPartSize = 5 << 20
+
+	defaultPartSize        = 8 << 20
+	defaultPartConcurrency = 4
+)
+
+// WithMultipartUpload streams files written with WithWrites to S3 as
+// multipart uploads once more than threshold bytes were written, instead of
+// buffering them in memory until they are closed. Parts of partSize bytes,
+// at least 5MiB, are uploaded by up to concurrency goroutines while the file
+// is written, writes block while all of them are busy, so a file needs at
+// most threshold plus concurrency parts of memory. If a part fails, the
+// upload is aborted right away, so no parts are left behind, no further
+// parts are uploaded and the following writes and Close report the error.
+// The threshold is at least partSize, which defaults to 8MiB, concurrency
+// defaults to 4. The client has to implement the multipart operations like
+// *s3.Client.
+func WithMultipartUpload(threshold, partSize int64, concurrency int) Option {
+	return func(s *S3FS) {
+		if partSize <= 0 {
+			partSize = defaultPartSize
+		}
+		if partSize < minPartSize {
+			partSize = minPartSize
+		}
+		if threshold < partSize {
+			threshold = partSize
+		}
+		if concurrency <= 0 {
+			concurrency = defaultPartConcurrency
+		}
+		s.multipart = &multipartConfig{threshold: threshold, partSize: partSize, concurrency: concurrency}
+	}
+}
+
+// multipartClient is implemented by clients supporting multipart uploads.
+type multipartClient interface {
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// multipartConfig holds the settings of WithMultipartUpload.
+type multipartConfig struct {
+	threshold   int64
+	partSize    int64
+	concurrency int
+}
+
+// multipartUpload is a multipart upload in progress.
+type multipartUpload struct {
+	fs  *S3FS
+	key string
+	id  string
+	sem chan struct{} // sem limits the parts uploaded at once
+	wg  sync.WaitGroup
+
+	aborted sync.Once
+
+	mu    sync.Mutex
+	parts []types.CompletedPart
+	err   error // err is the first error of a part
+}
+
+// startUpload starts a multipart upload of key with concurrency parts in
+// flight.
+func (s3fs *S3FS) startUpload(key string, concurrency int) (*multipartUpload, error) {
+	out, err := writeCall(s3fs, "CreateMultipartUpload", key, 0, func(ctx context.Context, client multipartClient, bucket string) (*s3.CreateMultipartUploadOutput, error) {
+		in := &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = s3fs.encryption.headers()
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+		if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+			in.ContentType = aws.String(ct)
+		}
+		return client.CreateMultipartUpload(ctx, in)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{
+		fs:  s3fs,
+		key: key,
+		id:  aws.ToString(out.UploadId),
+		sem: make(chan struct{}, concurrency),
+	}, nil
+}
+
+// upload uploads data as part n in the background, waiting for a free slot.
+func (u *multipartUpload) upload(n int32, data []byte) {
+	u.run(n, func() (*string, error) {
+		out, err := writeCall(u.fs, "UploadPart", u.key, 0, func(ctx context.Context, client multipartClient, bucket string) (*s3.UploadPartOutput, error) {
+			in := &s3.UploadPartInput{
+				Bucket:        aws.String(bucket),
+				Key:           aws.String(u.key),
+				UploadId:      aws.String(u.id),
+				PartNumber:    n,
+				Body:          bytes.NewReader(data),
+				ContentLength: int64(len(data)),
+			}
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.fs.customerKey.headers()
+			return client.UploadPart(ctx, in)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.ETag, nil
+	})
+}
+
+// run issues the call of part n in the background, waiting for a free slot,
+// and records the ETag it returns. Once a part failed, no further calls are
+// issued and the upload is aborted.
+func (u *multipartUpload) run(n int32, call func() (*string, error)) {
+	u.sem <- struct{}{}
+	u.mu.Lock()
+	if u.err != nil {
+		u.mu.Unlock()
+		<-u.sem
+		return
+	}
+	// added under mu, so abort never waits while parts are started
+	u.wg.Add(1)
+	u.mu.Unlock()
+	go func() {
+		defer func() {
+			<-u.sem
+			u.wg.Done()
+		}()
+		etag, err := call()
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if err != nil {
+			if u.err == nil {
+				u.err = err
+				go u.abort()
+			}
+			return
+		}
+		u.parts = append(u.parts, types.CompletedPart{ETag: etag, PartNumber: n})
+	}()
+}
+
+// failed returns the error of the first failed part.
+func (u *multipartUpload) failed() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+// complete waits for all parts and completes the upload, aborting it if a
+// part failed.
+func (u *multipartUpload) complete() error {
+	u.wg.Wait()
+	if err := u.failed(); err != nil {
+		u.abort()
+		return err
+	}
+	sort.Slice(u.parts, func(i, j int) bool { return u.parts[i].PartNumber < u.parts[j].PartNumber })
+	_, err := writeCall(u.fs, "CompleteMultipartUpload", u.key, 0, func(ctx context.Context, client multipartClient, bucket string) (*s3.CompleteMultipartUploadOutput, error) {
+		return client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(u.key),
+			UploadId:        aws.String(u.id),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+		})
+	})
+	if err != nil {
+		u.abort()
+	}
+	return err
+}
+
+// abort waits for the parts in flight and aborts the upload once, dropping
+// the parts uploaded.
+func (u *multipartUpload) abort() {
+	u.aborted.Do(u.abortUpload)
+}
+
+func (u *multipartUpload) abortUpload() {
+	u.wg.Wait()
+	_, err := writeCall(u.fs, "AbortMultipartUpload", u.key, 0, func(ctx context.Context, client multipartClient, bucket string) (*s3.AbortMultipartUploadOutput, error) {
+		return client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(u.key),
+			UploadId: aws.String(u.id),
+		})
+	})
+	if err != nil {
+		u.fs.log.Warn("aborting multipart upload failed, parts are kept until a lifecycle rule removes them",
+			zap.String("key", u.key),
+			zap.String("upload_id", u.id),
+			zap.Error(err))
+	}
+}
+
+// flushParts uploads the buffered content of f in parts once it exceeds
+// the threshold, leaving less than a part buffered, or all of it if last.
+// Once a part failed, the content is dropped instead.
+func (f *writeFile) flushParts(last bool) error {
+	m := f.fs.multipart
+	if f.upload == nil {
+		if int64(f.buf.Len()) <= m.threshold {
+			return nil
+		}
+		upload, err := f.fs.startUpload(f.key, m.concurrency)
+		if err != nil {
+			return err
+		}
+		f.upload = upload
+	}
+	for int64(f.buf.Len()) >= m.partSize || last && f.buf.Len() > 0 {
+		if err := f.upload.failed(); err != nil {
+			f.buf.Reset()
+			return err
+		}
+		n := int64(f.buf.Len())
+		if n > m.partSize {
+			n = m.partSize
+		}
+		data := make([]byte, n)
+		f.buf.Read(data)
+		f.parts++
+		f.upload.upload(f.parts, data)
+	}
+	return f.upload.failed()
+}
@@ -0,0 +1,93 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeMultipartClient accepts multipart uploads, failing the part failPart.
+type fakeMultipartClient struct {
+	*fakeClient
+	failPart int32
+}
+
+// call records a call of operation, failing with the error set by failWith.
+func (c *fakeMultipartClient) call(operation string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[operation]++
+	return c.err
+}
+
+func (c *fakeMultipartClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return &s3.PutObjectOutput{}, c.call("PutObject")
+}
+
+func (c *fakeMultipartClient) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return &s3.DeleteObjectOutput{}, c.call("DeleteObject")
+}
+
+func (c *fakeMultipartClient) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	return &s3.CopyObjectOutput{}, c.call("CopyObject")
+}
+
+func (c *fakeMultipartClient) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, c.call("CreateMultipartUpload")
+}
+
+func (c *fakeMultipartClient) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if err := c.call("UploadPart"); err != nil {
+		return nil, err
+	}
+	if params.PartNumber == c.failPart {
+		return nil, fmt.Errorf("part %d: connection reset", params.PartNumber)
+	}
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"%d"`, params.PartNumber))}, nil
+}
+
+func (c *fakeMultipartClient) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return &s3.CompleteMultipartUploadOutput{}, c.call("CompleteMultipartUpload")
+}
+
+func (c *fakeMultipartClient) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	return &s3.UploadPartCopyOutput{}, c.call("UploadPartCopy")
+}
+
+func (c *fakeMultipartClient) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return &s3.AbortMultipartUploadOutput{}, c.call("AbortMultipartUpload")
+}
+
+func TestMultipartStopsAfterFailedPart(t *testing.T) {
+	client := &fakeMultipartClient{fakeClient: newFakeClient(nil), failPart: 1}
+	fsys := newTestFS(client, WithWrites(), WithMultipartUpload(0, minPartSize, 1))
+	f, err := fsys.Create("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part := bytes.Repeat([]byte{1}, minPartSize)
+	var writeErr error
+	for i := 0; i < 10 && writeErr == nil; i++ {
+		_, writeErr = f.Write(part)
+	}
+	if writeErr == nil {
+		t.Fatal("writes succeeded after a failed part")
+	}
+	if err := f.Close(); err == nil {
+		t.Error("Close succeeded after a failed part")
+	}
+	// with one part in flight, the failure is recorded before the next starts
+	if parts := client.count("UploadPart"); parts != 1 {
+		t.Errorf("%d parts uploaded, want no more after the failure", parts)
+	}
+	if aborts := client.count("AbortMultipartUpload"); aborts != 1 {
+		t.Errorf("%d aborts, want 1", aborts)
+	}
+	if completes := client.count("CompleteMultipartUpload"); completes != 0 {
+		t.Errorf("%d completes of a failed upload", completes)
+	}
+}
@@ -0,0 +1,68 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"regexp"
+	"time"
+)
+
+// WithNegativeCache remembers for ttl that a name does not exist, so repeated
+// requests for missing files, e.g. favicon.ico, probes or try_files
+// candidates, do not each issue a HeadObject and a ListObjectsV2. At most
+// maxEntries names are kept. Keep ttl short, as files uploaded in the
+// meantime stay hidden until it expires.
+func WithNegativeCache(ttl time.Duration, maxEntries int) Option {
+	return func(s *S3FS) {
+		if ttl <= 0 {
+			return
+		}
+		s.notFound = newTTLCache[struct{}](ttl, maxEntries)
+	}
+}
+
+// WithNegativeCacheExclusions never remembers names matching any of
+// patterns as missing, e.g. upload destinations where new keys appear
+// constantly and must become visible immediately, while missing files of
+// the rest of the site stay cached. Patterns match keys without a leading
+// slash, and also apply to the misses of WithPrefixLayers.
+func WithNegativeCacheExclusions(patterns ...*regexp.Regexp) Option {
+	return func(s *S3FS) {
+		s.notFoundExclude = append(s.notFoundExclude, patterns...)
+	}
+}
+
+// cacheableNotFound reports whether name may be remembered as missing.
+func (s3fs *S3FS) cacheableNotFound(name string) bool {
+	for _, re := range s3fs.notFoundExclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedNotFound reports name as missing if a previous lookup found it to
+// not exist.
+func (s3fs *S3FS) cachedNotFound(name string) error {
+	if s3fs.notFound == nil {
+		return nil
+	}
+	_, ok := s3fs.notFound.get(name)
+	s3fs.observeCache(CacheNotFound, ok)
+	if !ok {
+		return nil
+	}
+	return &fs.PathError{
+		Op:   "stat",
+		Path: name,
+		Err:  fs.ErrNotExist,
+	}
+}
+
+// observeNotFound caches the outcome of looking up name if it does not exist.
+func (s3fs *S3FS) observeNotFound(name string, err error) {
+	if errors.Is(err, fs.ErrNotExist) && s3fs.cacheableNotFound(name) {
+		s3fs.notFound.put(name, struct{}{})
+	}
+}
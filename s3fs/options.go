@@ -0,0 +1,114 @@
+package s3fs
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/singleflight"
+)
+
+// Option configures optional behaviour of an S3FS.
+type Option func(*S3FS)
+
+// WithListLimits caps the number of entries and ListObjectsV2 pages a single
+// unbounded ReadDir may collect. A value <= 0 disables the respective limit.
+func WithListLimits(maxEntries, maxPages int) Option {
+	return func(s *S3FS) {
+		s.maxListEntries = maxEntries
+		s.maxListPages = maxPages
+	}
+}
+
+// WithDirectoryListing controls whether directories may be opened, and thus
+// listed. Stat of directories keeps working if disabled, so index files are
+// still resolved.
+func WithDirectoryListing(enabled bool) Option {
+	return func(s *S3FS) {
+		s.noDirectoryOpen = !enabled
+	}
+}
+
+// WithDirectoryIndex opens the first of names existing in a directory, e.g.
+// "index.html", in place of the directory, so embedders passing the
+// filesystem to code unaware of index files get them without resolving them
+// on their own. The returned file is the index file, Stat reports its info
+// and IndexOf the directory it was opened for, e.g. to redirect to the
+// directory path with a trailing slash. Directories without index file are
+// opened as usual. Caddy's file_server and http.FileServer resolve index
+// files themselves and need not use it.
+func WithDirectoryIndex(names ...string) Option {
+	return func(s *S3FS) {
+		s.indexFiles = names
+	}
+}
+
+// IndexFile is implemented by the files opened by S3FS. IndexOf returns the
+// name of the directory opened if WithDirectoryIndex opened the file in its
+// place, empty otherwise.
+type IndexFile interface {
+	IndexOf() string
+}
+
+// WithRequestCollapsing shares the result of a lookup between all concurrent
+// callers asking for the same name, so a burst of requests for one key only
+// issues a single request to S3. Probing whether a directory exists is shared
+// the same way.
+func WithRequestCollapsing() Option {
+	return func(s *S3FS) {
+		s.collapse = &singleflight.Group{}
+	}
+}
+
+// WithRequesterPays acknowledges that the requester pays for the requests
+// and the data transferred, sending x-amz-request-payer: requester with
+// every HeadObject, GetObject, GetObjectTagging and listing, so buckets with
+// Requester Pays enabled can be served instead of denying every request.
+func WithRequesterPays() Option {
+	return func(s *S3FS) {
+		s.requestPayer = types.RequestPayerRequester
+	}
+}
+
+// WithStrictConsistency makes every ranged GET conditional on the ETag and
+// modification time observed by Stat, so reads fail with ErrObjectChanged
+// instead of mixing bytes of different object versions.
+func WithStrictConsistency() Option {
+	return func(s *S3FS) {
+		s.strictConsistency = true
+	}
+}
+
+// WithExactRanges disables readahead for keys matching any of the patterns,
+// so only the bytes actually read are requested, e.g. for backends billing
+// per byte-range returned or object-lambda transforms. Patterns follow the
+// rules of WithImmutablePrefixes.
+func WithExactRanges(patterns ...string) Option {
+	return func(s *S3FS) {
+		s.exactRanges = keyPatterns(patterns)
+	}
+}
+
+// WithReadahead sets the number of bytes requested beyond each read,
+// READAHEAD by default. Small values suit tiny files, large ones long
+// sequential reads such as video over range requests. A negative size
+// disables readahead, 0 keeps the default.
+func WithReadahead(size int64) Option {
+	return func(s *S3FS) {
+		switch {
+		case size < 0:
+			s.tunables.readahead.Store(0)
+		case size > 0:
+			s.tunables.readahead.Store(size)
+		}
+	}
+}
+
+// WithAdaptiveReadahead grows the readahead of a file while it is read
+// sequentially, similar to the readahead of operating systems: every range
+// requested right where the previous one ended doubles the readahead, up to
+// max bytes. Any other read starts over at the configured readahead. This
+// cuts the number of requests of large sequential downloads without
+// fetching excess bytes for small or random reads.
+func WithAdaptiveReadahead(max int64) Option {
+	return func(s *S3FS) {
+		s.readaheadMax = max
+	}
+}
@@ -0,0 +1,244 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Overlay serves an ordered list of filesystems as one, e.g. a bucket of
+// site specific overrides on top of a bucket of shared base assets, each
+// possibly mounted at a prefix with WithPrefix, or a local directory of a
+// few patched files from os.DirFS on top of a bucket. Files are served from
+// the first layer they exist in, shadowing the same names in later layers.
+// Directories existing in several layers list the entries of all of them,
+// entries of earlier layers taking precedence, in name order. Unlike
+// WithPrefixLayers the layers keep their own caches and options.
+type Overlay struct {
+	layers []fs.FS
+}
+
+var (
+	_ fs.StatFS    = (*Overlay)(nil)
+	_ fs.ReadDirFS = (*Overlay)(nil)
+	_ DirPager     = (*Overlay)(nil)
+	_ DirStreamer  = (*Overlay)(nil)
+)
+
+// NewOverlay layers the filesystems, the first one on top.
+func NewOverlay(layers ...fs.FS) *Overlay {
+	return &Overlay{layers: layers}
+}
+
+// Layers returns the filesystems of o, the first one on top.
+func (o *Overlay) Layers() []fs.FS {
+	return o.layers
+}
+
+// WithContext returns a view of o issuing the S3 calls of all S3FS layers
+// with ctx, like S3FS.WithContext.
+func (o *Overlay) WithContext(ctx context.Context) *Overlay {
+	layers := make([]fs.FS, len(o.layers))
+	for i, l := range o.layers {
+		if s, ok := l.(*S3FS); ok {
+			l = s.WithContext(ctx)
+		}
+		layers[i] = l
+	}
+	return &Overlay{layers: layers}
+}
+
+// bound returns o issuing the S3 calls of its S3FS layers with the context
+// of the request bound to name, see BindRequest, and the name below the
+// root of the request, so layers other than S3FS are not handed the root.
+func (o *Overlay) bound(op, name string) (*Overlay, string, error) {
+	ctx, rest, err := BoundRequest(name)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if ctx != nil {
+		o = o.WithContext(ctx)
+	}
+	return o, rest, nil
+}
+
+// Open opens name in the first layer it exists in. A directory is opened in
+// all layers it exists in down to the first layer holding a file of the
+// name, its entries are merged on ReadDir.
+func (o *Overlay) Open(name string) (fs.File, error) {
+	o, name, err := o.bound("open", name)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []fs.File
+	for _, l := range o.layers {
+		f, err := l.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			var info fs.FileInfo
+			if info, err = f.Stat(); err != nil {
+				f.Close()
+			} else if !info.IsDir() {
+				if len(dirs) == 0 {
+					return f, nil
+				}
+				// shadowed by the directories of the layers above
+				f.Close()
+				break
+			}
+		}
+		if err != nil {
+			closeAll(dirs)
+			return nil, err
+		}
+		dirs = append(dirs, f)
+	}
+	switch len(dirs) {
+	case 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case 1:
+		return dirs[0], nil
+	}
+	return &overlayDir{name: name, dirs: dirs}, nil
+}
+
+// Stat returns the FileInfo of name in the first layer it exists in.
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	o, name, err := o.bound("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range o.layers {
+		info, err := fs.Stat(l, name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return info, err
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns the merged entries of the directory name.
+func (o *Overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return dir.ReadDir(-1)
+}
+
+// ReadDirPage pages through the merged entries of the directory name. The
+// token is the name of the last entry returned, merged listings are read in
+// full for every page.
+func (o *Overlay) ReadDirPage(name, token string, n int) ([]fs.DirEntry, string, error) {
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		return nil, "", err
+	}
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Name() > token })
+	entries = entries[i:]
+	if n <= 0 || n >= len(entries) {
+		return entries, "", nil
+	}
+	return entries[:n], entries[n-1].Name(), nil
+}
+
+// StreamDir calls fn once with the merged entries of the directory name.
+func (o *Overlay) StreamDir(ctx context.Context, name string, fn func(entries []fs.DirEntry) error) error {
+	entries, err := o.WithContext(ctx).ReadDir(name)
+	if err != nil {
+		return err
+	}
+	return fn(entries)
+}
+
+// closeAll closes files.
+func closeAll(files []fs.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// overlayDir is a directory existing in several layers of an Overlay.
+type overlayDir struct {
+	name    string
+	dirs    []fs.File     // dirs holds the directory in each layer, top first
+	entries []fs.DirEntry // entries holds the merged entries not yet returned, once listed
+	listed  bool
+}
+
+var _ fs.ReadDirFile = (*overlayDir)(nil)
+
+func (d *overlayDir) Stat() (fs.FileInfo, error) {
+	return d.dirs[0].Stat()
+}
+
+func (d *overlayDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *overlayDir) Close() error {
+	var err error
+	for _, f := range d.dirs {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		if err := d.list(); err != nil {
+			return nil, err
+		}
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// list merges the entries of the directory in all layers.
+func (d *overlayDir) list() error {
+	seen := make(map[string]bool)
+	var merged []fs.DirEntry
+	for _, f := range d.dirs {
+		dir, ok := f.(fs.ReadDirFile)
+		if !ok {
+			continue
+		}
+		entries, err := dir.ReadDir(-1)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				merged = append(merged, e)
+			}
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	d.entries = merged
+	d.listed = true
+	return nil
+}
@@ -0,0 +1,103 @@
+package s3fs
+
+import (
+	"context"
+	"time"
+)
+
+// Override changes parameters of the filesystem for keys matching any of its
+// patterns, e.g. a larger readahead for `downloads/**` than for the assets
+// of a site. Zero values keep the respective default.
+type Override struct {
+	// Patterns select the keys, following the rules of WithImmutablePrefixes.
+	Patterns []string
+	// Readahead is the number of bytes requested beyond each read, a
+	// negative value disables readahead.
+	Readahead int64
+	// CacheTTL is the lifetime of cached metadata of the keys.
+	CacheTTL time.Duration
+}
+
+// WithOverrides applies the first of the overrides matching a key.
+// Overrides of the route of a request, see WithRouteOverride, take
+// precedence.
+func WithOverrides(overrides ...Override) Option {
+	return func(s *S3FS) {
+		s.overrides = overrides
+	}
+}
+
+// routeOverrideKey is the context key of the override of a route.
+type routeOverrideKey struct{}
+
+// WithRouteOverride returns ctx changing the parameters of the calls issued
+// with it, see WithContext, by o, e.g. for the requests matched by an HTTP
+// route. The patterns of o are ignored, its parameters apply to all keys and
+// take precedence over WithOverrides.
+func WithRouteOverride(ctx context.Context, o Override) context.Context {
+	return context.WithValue(ctx, routeOverrideKey{}, &o)
+}
+
+// overridesFor returns the override of the route of the calls and the first
+// override matching key, each nil if unset.
+func (s3fs *S3FS) overridesFor(key string) [2]*Override {
+	route, _ := s3fs.callContext().Value(routeOverrideKey{}).(*Override)
+	return [2]*Override{route, s3fs.overrideFor(key)}
+}
+
+// overrideFor returns the first override matching key, or nil.
+func (s3fs *S3FS) overrideFor(key string) *Override {
+	for i := range s3fs.overrides {
+		if keyPatterns(s3fs.overrides[i].Patterns).match(key) {
+			return &s3fs.overrides[i]
+		}
+	}
+	return nil
+}
+
+// readahead returns the readahead for key.
+func (s3fs *S3FS) readahead(key string) int64 {
+	if s3fs.exactRanges.match(key) {
+		return 0
+	}
+	for _, o := range s3fs.overridesFor(key) {
+		if o != nil && o.Readahead != 0 {
+			if o.Readahead < 0 {
+				return 0
+			}
+			return o.Readahead
+		}
+	}
+	return s3fs.tunables.readahead.Load()
+}
+
+// nextReadahead returns the readahead of the range of f starting at from,
+// doubling the previous one if the range continues where the previous one
+// ended and adaptive readahead is enabled.
+func (f *s3File) nextReadahead(from int64) int64 {
+	base := f.fs.readahead(f.key)
+	max := f.fs.readaheadMax
+	if base == 0 || max <= base {
+		return base
+	}
+	switch {
+	case f.window == 0 || from != f.rangeEnd:
+		f.window = base
+	case f.window < max/2:
+		f.window *= 2
+	default:
+		f.window = max
+	}
+	return f.window
+}
+
+// cacheTTL returns the lifetime of cached metadata of key, or 0 for the
+// default of the cache.
+func (s3fs *S3FS) cacheTTL(key string) time.Duration {
+	for _, o := range s3fs.overridesFor(key) {
+		if o != nil && o.CacheTTL != 0 {
+			return o.CacheTTL
+		}
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// WithParallelDownload fetches the remainder of objects larger than partSize
+// bytes with up to concurrency ranged GETs of partSize bytes running at the
+// same time, handing out the parts in order. This raises the throughput of
+// large downloads beyond the one of a single stream, at the cost of
+// buffering up to concurrency parts in memory per download, and of fetching
+// up to concurrency parts of which only a few bytes may be read, e.g. for
+// short range requests. Keys read with exact ranges are not downloaded in
+// parallel.
+func WithParallelDownload(partSize int64, concurrency int) Option {
+	return func(s *S3FS) {
+		if partSize <= 0 || concurrency < 2 {
+			return
+		}
+		s.parallel = &parallelDownload{partSize: partSize, concurrency: concurrency}
+	}
+}
+
+// parallelDownload configures parallel downloads.
+type parallelDownload struct {
+	partSize    int64
+	concurrency int
+}
+
+// parallelStream returns a reader fetching f from offset from in parallel
+// parts if enabled and worthwhile.
+func (f *s3File) parallelStream(from int64) (io.ReadCloser, bool) {
+	p := f.fs.parallel
+	if p == nil || f.info.Size()-from <= p.partSize || f.fs.exactRanges.match(f.key) {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(f.fs.callContext())
+	r := &partReader{
+		cancel:  cancel,
+		pending: make(chan chan partResult, p.concurrency-1),
+	}
+	go r.fetch(ctx, f, from, p.partSize)
+	return r, true
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// partReader reads parts fetched in the background in order.
+type partReader struct {
+	cancel  context.CancelFunc
+	pending chan chan partResult // pending holds the results of requested parts in order
+	cur     *bytes.Reader        // cur is the part being read
+	err     error
+}
+
+// fetch requests the parts of f starting at from until the object is
+// complete or ctx is done. The capacity of pending, plus the part blocking
+// on it, bounds the number of parts requested ahead of the reader.
+func (r *partReader) fetch(ctx context.Context, f *s3File, from, partSize int64) {
+	defer close(r.pending)
+	size := f.info.Size()
+	for off := from; off < size; off += partSize {
+		target := off + partSize - 1
+		if target >= size {
+			target = size - 1
+		}
+		result := make(chan partResult, 1)
+		select {
+		case r.pending <- result:
+		case <-ctx.Done():
+			return
+		}
+		go func(off, target int64) {
+			body, err := f.getRange(ctx, off, target)
+			if err != nil {
+				result <- partResult{err: err}
+				return
+			}
+			data := make([]byte, target-off+1)
+			_, err = io.ReadFull(body, data)
+			body.Close()
+			result <- partResult{data: data, err: err}
+		}(off, target)
+	}
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	for r.cur == nil || r.cur.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		result, ok := <-r.pending
+		if !ok {
+			r.err = io.EOF
+			continue
+		}
+		res := <-result
+		if res.err != nil {
+			r.err = res.err
+			continue
+		}
+		r.cur = bytes.NewReader(res.data)
+	}
+	return r.cur.Read(p)
+}
+
+// Close stops fetching further parts and aborts the ones in flight.
+func (r *partReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,144 @@
+package s3fs
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hivePartitionKeys are the keys of date-partitioned layouts, from the
+// outermost to the innermost level.
+var hivePartitionKeys = []string{"year", "month", "day"}
+
+// WithPartitionWindow limits listings of date-partitioned prefixes, either
+// Hive style `year=YYYY/month=MM/day=DD/` or `<dateKey>=YYYY-MM-DD/`, to the
+// partitions of the last days days. Older partitions are omitted from
+// listings, and listings skip directly to the first partition within the
+// window instead of scanning past years of data. Files sorting before the
+// skipped partitions are retained only if they are part of the first page.
+func WithPartitionWindow(days int, dateKey string) Option {
+	return func(s *S3FS) {
+		if days <= 0 {
+			return
+		}
+		if dateKey == "" {
+			dateKey = "dt"
+		}
+		s.partitions = &partitionWindow{days: days, dateKey: dateKey}
+	}
+}
+
+// partitionWindow filters date partitions older than a number of days.
+type partitionWindow struct {
+	days    int
+	dateKey string
+}
+
+// partitionState describes how the window restricts the children of a directory.
+type partitionState struct {
+	outside    bool      // outside is set if the directory lies before the window
+	restricted bool      // restricted is set if child partitions must be filtered
+	next       string    // next is the Hive partition key expected at the child level
+	dateKey    string    // dateKey is the key of single-level date partitions
+	cutoff     time.Time // cutoff is the oldest day within the window
+}
+
+// state classifies the directory prefix against the window.
+func (w *partitionWindow) state(prefix string, now time.Time) partitionState {
+	cutoff := now.UTC().AddDate(0, 0, -(w.days - 1))
+	st := partitionState{restricted: true, dateKey: w.dateKey, cutoff: cutoff}
+	values := make(map[string]string)
+	for _, seg := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if k, v, ok := strings.Cut(seg, "="); ok {
+			values[k] = v
+		}
+	}
+	if v, ok := values[w.dateKey]; ok {
+		st.outside = v < cutoff.Format("2006-01-02")
+		st.restricted = false
+		return st
+	}
+	cutoffValues := []int{cutoff.Year(), int(cutoff.Month()), cutoff.Day()}
+	for i, k := range hivePartitionKeys {
+		v, ok := values[k]
+		if !ok {
+			st.next = k
+			return st
+		}
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil || n > cutoffValues[i]:
+			st.restricted = false
+			return st
+		case n < cutoffValues[i]:
+			st.outside = true
+			return st
+		}
+	}
+	// a day within the window, its children are not partitioned by date
+	st.restricted = false
+	return st
+}
+
+// keep reports whether the child directory name lies within the window.
+func (st partitionState) keep(name string) bool {
+	if !st.restricted {
+		return true
+	}
+	k, v, ok := strings.Cut(name, "=")
+	if !ok {
+		return true
+	}
+	if k == st.dateKey {
+		return v >= st.cutoff.Format("2006-01-02")
+	}
+	if k != st.next {
+		return true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return true
+	}
+	switch k {
+	case "year":
+		return n >= st.cutoff.Year()
+	case "month":
+		return n >= int(st.cutoff.Month())
+	default:
+		return n >= st.cutoff.Day()
+	}
+}
+
+// skipTo returns the key a listing of prefix may start after to skip the
+// partitions before the window, given the first child directory of the
+// prefix, or "" if nothing can be skipped. Only fixed-width partition values
+// sort lexicographically and can be skipped.
+func (st partitionState) skipTo(prefix, first string) string {
+	if st.keep(first) {
+		return ""
+	}
+	switch k, _, _ := strings.Cut(first, "="); k {
+	case st.dateKey:
+		return prefix + st.dateKey + "=" + st.cutoff.Format("2006-01-02")
+	case "year":
+		return prefix + fmt.Sprintf("year=%04d", st.cutoff.Year())
+	}
+	return ""
+}
+
+// filter removes child partitions outside the window from entries.
+func (st partitionState) filter(entries []fs.DirEntry) []fs.DirEntry {
+	if !st.restricted {
+		return entries
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.IsDir() && !st.keep(e.Name()) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
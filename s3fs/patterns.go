@@ -0,0 +1,29 @@
+package s3fs
+
+import (
+	"path"
+	"strings"
+)
+
+// keyPatterns matches keys against a list of patterns. Patterns ending in
+// `**` match every key starting with the preceding prefix, others are
+// matched using path.Match. Leading slashes are ignored.
+type keyPatterns []string
+
+// match reports whether name matches any of the patterns.
+func (p keyPatterns) match(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	for _, pattern := range p {
+		pattern = strings.TrimPrefix(pattern, "/")
+		if strings.HasSuffix(pattern, "**") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "**")) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
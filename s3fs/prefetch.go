@@ -0,0 +1,69 @@
+package s3fs
+
+import (
+	"io/fs"
+	"path"
+	"time"
+)
+
+// prefetcher issues HEAD requests for entries of listed directories in the
+// background, so opening them afterwards is served from the stat cache.
+type prefetcher struct {
+	count int           // count is the number of files prefetched per listing
+	sem   chan struct{} // sem bounds the number of concurrent HEAD requests
+	ttl   time.Duration // ttl is the lifetime of the stat cache if WithStatCache is not used
+}
+
+// WithPrefetch prefetches the metadata of the first count files of every
+// directory listing, i.e. the ones likely to be clicked next on a browse
+// page, using at most concurrency parallel HEAD requests. Results are kept
+// in the stat cache, which is set up with a lifetime of ttl if WithStatCache
+// is not used.
+func WithPrefetch(count, concurrency int, ttl time.Duration) Option {
+	return func(s *S3FS) {
+		if count <= 0 || concurrency <= 0 || ttl <= 0 {
+			return
+		}
+		s.prefetch = &prefetcher{
+			count: count,
+			sem:   make(chan struct{}, concurrency),
+			ttl:   ttl,
+		}
+	}
+}
+
+// prefetchEntries starts prefetching the files among entries of dir.
+func (s3fs *S3FS) prefetchEntries(dir string, entries []fs.DirEntry) {
+	p := s3fs.prefetch
+	if p == nil || s3fs.closer.closed() {
+		return
+	}
+	// prefetches outlive the listing requesting them, not the filesystem
+	s3fs = s3fs.WithContext(s3fs.closer.ctx)
+	n := 0
+	for _, e := range entries {
+		if n >= p.count {
+			break
+		}
+		if e.IsDir() {
+			continue
+		}
+		n++
+		name := path.Join(dir, e.Name())
+		if _, ok := s3fs.statCache.get(name); ok {
+			continue
+		}
+		select {
+		case p.sem <- struct{}{}:
+		default:
+			// all slots busy, skip instead of queueing up stale work
+			continue
+		}
+		s3fs.closer.run(func() {
+			defer func() { <-p.sem }()
+			if info, err := s3fs.stat(name); err == nil {
+				s3fs.statCache.putTTL(name, info, s3fs.cacheTTL(name))
+			}
+		})
+	}
+}
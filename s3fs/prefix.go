@@ -0,0 +1,70 @@
+package s3fs
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// WithPrefix mounts the filesystem at a key prefix of the bucket, like
+// fs.Sub: every name is looked up below prefix, e.g. "sites/example.com/"
+// for one of several sites hosted in a bucket. Names are cleaned before,
+// so ".." can't reach keys outside of prefix. Prefix layers are below
+// prefix, patterns of other options and purges match the keys including
+// it.
+func WithPrefix(prefix string) Option {
+	return func(s *S3FS) {
+		s.prefix = normalizePrefix(prefix)
+	}
+}
+
+// WithPrefixResolver mounts the filesystem at a key prefix resolved per
+// call by resolve from the context of the request, see WithContext and
+// BindRequest, e.g. "tenants/example.com/public/" for the host of the request,
+// taking precedence over WithPrefix. resolve is called with the background
+// context for calls not made on behalf of a request; calls fail with the
+// error it returns. The caches are shared, entries are keyed by the keys
+// including the prefix. It can't be combined with WithPrefixLayers.
+func WithPrefixResolver(resolve func(ctx context.Context) (string, error)) Option {
+	return func(s *S3FS) {
+		s.resolvePrefix = resolve
+	}
+}
+
+// normalizePrefix returns prefix without leading slash, ending in a slash
+// unless empty.
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// prefixed returns the key name stands for below the prefix of WithPrefix or
+// WithPrefixResolver, failing op if the prefix can't be resolved.
+func (s3fs *S3FS) prefixed(op, name string) (string, error) {
+	prefix := s3fs.prefix
+	if s3fs.resolvePrefix != nil {
+		resolved, err := s3fs.resolvePrefix(s3fs.callContext())
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		prefix = normalizePrefix(path.Clean("/" + resolved))
+	}
+	if prefix == "" {
+		return name, nil
+	}
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if s3fs.layers != nil {
+		// the layer prefixes include it
+		prefix = ""
+	}
+	key := layerName(prefix, clean)
+	if clean != "" && strings.HasSuffix(name, "/") {
+		// keep addressing directory markers
+		key += "/"
+	}
+	return key, nil
+}
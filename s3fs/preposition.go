@@ -0,0 +1,288 @@
+package s3fs
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CacheHead names the cache of WithHeadCache in Metrics.
+const CacheHead = "head"
+
+const defaultPrepositionConcurrency = 4
+
+// WithHeadCache keeps the first headSize bytes of objects prepositioned by
+// Preposition in memory, using at most maxBytes in total, so reads of them
+// start without waiting for S3 while the rest is requested. Like the content
+// cache, entries are keyed by ETag and size and the least recently used ones
+// are evicted first.
+func WithHeadCache(headSize, maxBytes int64) Option {
+	return func(s *S3FS) {
+		if headSize <= 0 || maxBytes <= 0 {
+			return
+		}
+		if headSize > maxBytes {
+			headSize = maxBytes
+		}
+		s.heads = &contentCache{
+			maxObjectSize: headSize,
+			maxBytes:      maxBytes,
+			lru:           list.New(),
+			entries:       make(map[string]*list.Element),
+		}
+	}
+}
+
+// Preposition selects the objects warmed by S3FS.Preposition.
+type Preposition struct {
+	// Extensions of the keys to preposition, e.g. ".html", ".css" and
+	// ".js". All keys if empty.
+	Extensions []string
+	// MaxObjects bounds the number of objects prepositioned, 0 meaning
+	// unbounded.
+	MaxObjects int
+	// Concurrency is the number of objects prepositioned in parallel.
+	// Defaults to 4.
+	Concurrency int
+}
+
+// PrepositionResult summarizes a run of S3FS.Preposition.
+type PrepositionResult struct {
+	// Objects is the number of objects whose metadata was cached.
+	Objects int `json:"objects"`
+	// Bytes is the number of content bytes cached.
+	Bytes int64 `json:"bytes"`
+	// Errors is the number of objects failing to be prepositioned.
+	Errors int `json:"errors"`
+	// Truncated is set if MaxObjects stopped the run early.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Preposition warms the caches with the objects below the key prefix,
+// e.g. of a newly deployed release before traffic is switched to it: their
+// metadata is put into the stat cache, objects fitting it into the content
+// cache and the heads of larger ones into the head cache of WithHeadCache.
+// Directory markers are skipped, contents are not cached with
+// WithMetadataOnly. It stops listing once ctx is done.
+func (s3fs *S3FS) Preposition(ctx context.Context, prefix string, p Preposition) (PrepositionResult, error) {
+	s3fs = s3fs.WithContext(ctx)
+	if p.Concurrency <= 0 {
+		p.Concurrency = defaultPrepositionConcurrency
+	}
+	var (
+		result      PrepositionResult
+		objects     atomic.Int64
+		cachedBytes atomic.Int64
+		errs        atomic.Int64
+		wg          sync.WaitGroup
+		sem         = make(chan struct{}, p.Concurrency)
+	)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3fs.backendFor(prefix).bucket),
+		Prefix: aws.String(prefix),
+	}
+	n := 0
+	var err error
+list:
+	for {
+		var output *s3.ListObjectsV2Output
+		output, err = s3fs.listObjects(prefix, input)
+		if err != nil {
+			break
+		}
+		for _, obj := range output.Contents {
+			key := aws.ToString(obj.Key)
+			if strings.HasSuffix(key, "/") || !prepositioned(key, p.Extensions) {
+				continue
+			}
+			if p.MaxObjects > 0 && n >= p.MaxObjects {
+				result.Truncated = true
+				break list
+			}
+			n++
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				err = ctx.Err()
+				break list
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				n, err := s3fs.preposition(key)
+				if err != nil {
+					errs.Add(1)
+					return
+				}
+				objects.Add(1)
+				cachedBytes.Add(n)
+			}()
+		}
+		if !output.IsTruncated {
+			break
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+	wg.Wait()
+	result.Objects = int(objects.Load())
+	result.Bytes = cachedBytes.Load()
+	result.Errors = int(errs.Load())
+	return result, err
+}
+
+// prepositioned reports whether key has one of extensions, or extensions is
+// empty.
+func prepositioned(key string, extensions []string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	ext := path.Ext(key)
+	for _, e := range extensions {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// preposition caches the metadata and content of key, returning the number
+// of content bytes cached.
+func (s3fs *S3FS) preposition(key string) (int64, error) {
+	info, err := s3fs.stat(key)
+	if err != nil {
+		return 0, err
+	}
+	s3fs.statCache.putTTL(key, info, s3fs.cacheTTL(key))
+	if info.IsDir() || info.Size() == 0 || s3fs.metadataOnly {
+		return 0, nil
+	}
+	file := newFile(s3fs, key)
+	file.info = info
+	if data, err := file.cachedContent(); data != nil || err != nil {
+		return int64(len(data)), err
+	}
+	h := s3fs.heads
+	oi, ok := info.Sys().(*ObjectInfo)
+	if h == nil || !ok || oi.ETag == "" {
+		return 0, nil
+	}
+	id := contentCacheID(oi.ETag, info.Size())
+	if _, ok := h.get(id, key); ok {
+		return 0, nil
+	}
+	n := info.Size()
+	if n > h.maxObjectSize {
+		n = h.maxObjectSize
+	}
+	r, err := file.getRange(s3fs.callContext(), 0, n-1)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	head := make([]byte, n)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+	h.put(id, key, head)
+	return n, nil
+}
+
+// cachedHead returns the head of f cached by Preposition, nil if none.
+func (f *s3File) cachedHead() []byte {
+	h := f.fs.heads
+	if h == nil {
+		return nil
+	}
+	oi, ok := f.info.Sys().(*ObjectInfo)
+	if !ok || oi.ETag == "" {
+		return nil
+	}
+	head, ok := h.get(contentCacheID(oi.ETag, f.info.Size()), f.key)
+	f.fs.observeCache(CacheHead, ok)
+	return head
+}
+
+// headStream returns a reader of f starting at from within the cached head
+// of f, requesting the rest from S3 while the head is read. It reports false
+// if from is not within a cached head.
+func (f *s3File) headStream(from, amt int64) (io.ReadCloser, bool) {
+	if f.fs.heads == nil || from >= f.fs.heads.maxObjectSize {
+		return nil, false
+	}
+	head := f.cachedHead()
+	if from >= int64(len(head)) {
+		return nil, false
+	}
+	s := &headReader{head: bytes.NewReader(head[from:])}
+	rest := int64(len(head))
+	if rest >= f.info.Size() {
+		return s, true
+	}
+	amt -= rest - from
+	if amt < 1 {
+		amt = 1
+	}
+	target := f.rangeTarget(rest, amt)
+	ctx := f.fs.callContext()
+	s.rest = make(chan headRest, 1)
+	go func() {
+		body, err := f.getRange(ctx, rest, target)
+		s.rest <- headRest{body: body, err: err}
+	}()
+	return s, true
+}
+
+// headReader reads a cached head followed by the rest of the range.
+type headReader struct {
+	head *bytes.Reader
+	rest chan headRest // rest delivers the response for the rest, nil if the head is the whole object
+	body io.ReadCloser // body is the rest once received
+}
+
+// headRest is the response for the rest of a headReader.
+type headRest struct {
+	body io.ReadCloser
+	err  error
+}
+
+func (r *headReader) Read(p []byte) (int, error) {
+	switch {
+	case r.head.Len() > 0:
+		return r.head.Read(p)
+	case r.body != nil:
+		return r.body.Read(p)
+	case r.rest == nil:
+		return 0, io.EOF
+	}
+	rest := <-r.rest
+	r.rest = nil
+	if rest.err != nil {
+		return 0, rest.err
+	}
+	r.body = rest.body
+	return r.body.Read(p)
+}
+
+func (r *headReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	if r.rest != nil {
+		// the rest was not read, release it once it arrives
+		go func(rest chan headRest) {
+			if res := <-rest; res.body != nil {
+				res.body.Close()
+			}
+		}(r.rest)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package s3fs
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// PresignGet stats the file name like Stat and returns a GetObject URL of
+// the object holding it, presigned with the credentials of the filesystem
+// for expires, e.g. to redirect downloads of large files to S3 instead of
+// streaming them through the server. Presigning needs an *s3.Client and
+// fails with ErrNotSupported for other clients and with WithCustomerKey,
+// whose key would have to be sent by the client. Files Open would refuse
+// or meter are not presigned either, see servableByURL.
+func (s3fs *S3FS) PresignGet(name string, expires time.Duration) (string, fs.FileInfo, error) {
+	s3fs, name, err := s3fs.bound("presign", name)
+	if err != nil {
+		return "", nil, err
+	}
+	name, err = s3fs.prefixed("presign", name)
+	if err != nil {
+		return "", nil, err
+	}
+	var key string
+	info, err := withPartitionPath(s3fs, name, func(name string) (fs.FileInfo, error) {
+		return withLayers(s3fs, name, func(name string) (fs.FileInfo, error) {
+			info, err := s3fs.cachedStat(name)
+			if err == nil {
+				key = name
+			}
+			return info, err
+		})
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return "", nil, &fs.PathError{Op: "presign", Path: name, Err: fmt.Errorf("%w: is a directory", fs.ErrInvalid)}
+	}
+	input := &s3.GetObjectInput{
+		Key:          aws.String(key),
+		RequestPayer: s3fs.requestPayer,
+	}
+	if k, versionID, ok := s3fs.versionPath(key); ok {
+		input.Key, input.VersionId = aws.String(k), aws.String(versionID)
+	}
+	if err := s3fs.servableByURL(aws.ToString(input.Key)); err != nil {
+		return "", nil, &fs.PathError{Op: "presign", Path: name, Err: err}
+	}
+	b := s3fs.backendFor(aws.ToString(input.Key))
+	client, ok := b.client().(*s3.Client)
+	if !ok {
+		return "", nil, &fs.PathError{Op: "presign", Path: name, Err: notSupported("PresignGetObject")}
+	}
+	input.Bucket = aws.String(b.bucket)
+	req, err := s3.NewPresignClient(client, s3.WithPresignExpires(expires)).PresignGetObject(s3fs.callContext(), input)
+	if err != nil {
+		return "", nil, &fs.PathError{Op: "presign", Path: name, Err: err}
+	}
+	s3fs.hotKeys.record(key, 1, 0)
+	s3fs.access.record(aws.ToString(input.Key))
+	requestStats(s3fs.ctx).opened(aws.ToString(input.Key), objectETag(info))
+	return req.URL, info, nil
+}
+
+// servableByURL returns nil if the contents of key may be handed out as a
+// presigned URL. Metadata-only filesystems serve no contents, the bytes
+// fetched by URL can't be counted against a quota, and the object could be
+// replaced after its signature was verified, so such keys are refused.
+func (s3fs *S3FS) servableByURL(key string) error {
+	switch {
+	case s3fs.metadataOnly:
+		return ErrMetadataOnly
+	case s3fs.customerKey != nil:
+		return fmt.Errorf("%w: presigned URLs of objects encrypted with a customer-provided key", ErrNotSupported)
+	case s3fs.tenantFor(key) != nil:
+		return fmt.Errorf("%w: presigned URLs of keys with a quota", ErrNotSupported)
+	case s3fs.signatures != nil && s3fs.signatures.patterns.match(key):
+		return fmt.Errorf("%w: presigned URLs of keys requiring a signature", ErrNotSupported)
+	}
+	return nil
+}
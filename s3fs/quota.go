@@ -0,0 +1,178 @@
+package s3fs
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrQuotaExceeded is returned when opening a file of a tenant that used up
+// its quota of bytes served.
+var ErrQuotaExceeded = errors.New("quota of bytes served exceeded")
+
+// Quota limits the bytes served of the keys below Prefix, a tenant of a
+// shared bucket. An empty prefix covers the whole filesystem, e.g. if every
+// host serves its own filesystem.
+type Quota struct {
+	Prefix string
+	// Daily is the number of bytes that may be served per UTC day, 0 is
+	// unlimited.
+	Daily int64
+	// Monthly is the number of bytes that may be served per UTC month, 0 is
+	// unlimited.
+	Monthly int64
+}
+
+// QuotaState describes the bytes served by a tenant in the current periods.
+type QuotaState struct {
+	Prefix     string `json:"prefix"`
+	Daily      int64  `json:"daily,omitempty"`
+	Monthly    int64  `json:"monthly,omitempty"`
+	DayBytes   int64  `json:"day_bytes"`
+	MonthBytes int64  `json:"month_bytes"`
+	Exceeded   bool   `json:"exceeded"`
+}
+
+// WithQuotas counts the bytes served per tenant and rejects opening files of
+// tenants which exceeded their daily or monthly quota with ErrQuotaExceeded
+// until the period is over. Keys belong to the quota with the longest
+// matching prefix. Downloads in progress are not interrupted, so a quota
+// may be overrun by the files open when it is reached. Counters are kept in
+// memory and start over on restarts.
+func WithQuotas(quotas ...Quota) Option {
+	return func(s *S3FS) {
+		if len(quotas) == 0 {
+			return
+		}
+		tenants := make([]*tenant, 0, len(quotas))
+		for _, q := range quotas {
+			tenants = append(tenants, &tenant{Quota: q, clock: systemClock{}})
+		}
+		sort.SliceStable(tenants, func(i, j int) bool {
+			return len(tenants[i].Prefix) > len(tenants[j].Prefix)
+		})
+		s.quotas = tenants
+	}
+}
+
+// tenant counts the bytes served below a prefix.
+type tenant struct {
+	Quota
+	clock Clock
+
+	mu         sync.Mutex
+	day        string // day is the UTC day dayBytes are counted for
+	month      string // month is the UTC month monthBytes are counted for
+	dayBytes   int64
+	monthBytes int64
+	notified   bool // notified is set once the exceeded quota was reported for the current period
+}
+
+// tenantFor returns the tenant of key, or nil if no quota applies.
+func (s3fs *S3FS) tenantFor(key string) *tenant {
+	for _, t := range s3fs.quotas {
+		if strings.HasPrefix(key, t.Prefix) {
+			return t
+		}
+	}
+	return nil
+}
+
+// roll starts new counters once a period is over, the lock must be held.
+func (t *tenant) roll(now time.Time) {
+	now = now.UTC()
+	if day := now.Format("2006-01-02"); day != t.day {
+		t.day, t.dayBytes, t.notified = day, 0, false
+	}
+	if month := now.Format("2006-01"); month != t.month {
+		t.month, t.monthBytes, t.notified = month, 0, false
+	}
+}
+
+// exceeded reports whether the tenant used up one of its quotas, the lock
+// must be held.
+func (t *tenant) exceeded() bool {
+	return (t.Daily > 0 && t.dayBytes >= t.Daily) || (t.Monthly > 0 && t.monthBytes >= t.Monthly)
+}
+
+// admit reports whether a file of the tenant may be opened, emitting
+// EventQuotaExceeded the first time it may not in a period.
+func (s3fs *S3FS) admit(t *tenant) bool {
+	t.mu.Lock()
+	t.roll(t.clock.Now())
+	ok := !t.exceeded()
+	notify := !ok && !t.notified
+	if notify {
+		t.notified = true
+	}
+	state := t.state()
+	t.mu.Unlock()
+	if notify {
+		s3fs.log.Warn("quota of bytes served exceeded", zap.String("prefix", state.Prefix))
+		s3fs.events.emit(EventQuotaExceeded, map[string]interface{}{
+			"bucket":      s3fs.primary.bucket,
+			"prefix":      state.Prefix,
+			"day_bytes":   state.DayBytes,
+			"month_bytes": state.MonthBytes,
+		})
+	}
+	return ok
+}
+
+// served counts n bytes served.
+func (t *tenant) served(n int64) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.mu.Lock()
+	t.roll(t.clock.Now())
+	t.dayBytes += n
+	t.monthBytes += n
+	t.mu.Unlock()
+}
+
+// state returns the counters, the lock must be held.
+func (t *tenant) state() QuotaState {
+	return QuotaState{
+		Prefix:     t.Prefix,
+		Daily:      t.Daily,
+		Monthly:    t.Monthly,
+		DayBytes:   t.dayBytes,
+		MonthBytes: t.monthBytes,
+		Exceeded:   t.exceeded(),
+	}
+}
+
+// QuotaStats returns the bytes served per tenant in the current periods.
+func (s3fs *S3FS) QuotaStats() []QuotaState {
+	states := make([]QuotaState, 0, len(s3fs.quotas))
+	for _, t := range s3fs.quotas {
+		t.mu.Lock()
+		t.roll(t.clock.Now())
+		states = append(states, t.state())
+		t.mu.Unlock()
+	}
+	return states
+}
+
+// adoptQuotas takes over the counters of the tenants of prev with the same
+// prefix.
+func (s3fs *S3FS) adoptQuotas(prev *S3FS) {
+	for _, t := range s3fs.quotas {
+		for _, p := range prev.quotas {
+			if p.Prefix != t.Prefix {
+				continue
+			}
+			p.mu.Lock()
+			t.mu.Lock()
+			t.day, t.month = p.day, p.month
+			t.dayBytes, t.monthBytes = p.dayBytes, p.monthBytes
+			t.mu.Unlock()
+			p.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package s3fs
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// WithRateLimit bounds the rate of S3 calls to rate per second with bursts
+// of up to burst calls, so traffic spikes or crawlers walking deep directory
+// trees can't cause unbounded request costs or account-level throttling.
+// Calls beyond the rate wait for their turn until their context is done. A
+// burst below 1 defaults to the rate, rounded up.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(s *S3FS) {
+		if rate <= 0 {
+			return
+		}
+		s.tunables.rate.Store(newRateLimiter(systemClock{}, rate, burst))
+	}
+}
+
+// rateLimiter is a token bucket handing out tokens in the order they are
+// requested.
+type rateLimiter struct {
+	rate  float64 // rate is the number of tokens added per second
+	burst float64 // burst is the capacity of the bucket
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64   // tokens is the number of tokens available, negative if reserved ahead
+	last   time.Time // last is the time tokens were last updated
+}
+
+// wait blocks until a token is available or ctx is done.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	now := r.clock.Now()
+	r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	r.tokens--
+	delay := time.Duration(-r.tokens / r.rate * float64(time.Second))
+	r.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := r.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		// hand the reserved token to the next caller
+		r.mu.Lock()
+		r.tokens++
+		r.mu.Unlock()
+		return ctx.Err()
+	}
+}
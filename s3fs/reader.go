@@ -22,38 +22,151 @@ package s3fs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ErrObjectChanged is returned when reading an object that has been modified
+// since it was opened and strict consistency is enabled.
+var ErrObjectChanged = errors.New("object changed since stat")
+
 // rangeReader produces an io.ReadCloser that reads
 // bytes in the range from [off, off+width)
 //
 // It is the caller's responsibility to call Close()
 // on the returned io.ReadCloser.
 func (f *s3File) rangeReader(from, amt int64) (io.ReadCloser, error) {
-	amt = amt + READAHEAD
-	target := from + amt - 1
-	if target >= f.info.Size() {
-		target = f.info.Size() - 1
-	}
 	if from >= f.info.Size() {
 		return nil, io.EOF
 	}
+	return f.getRange(f.fs.callContext(), from, f.rangeTarget(from, amt))
+}
+
+// rangeTarget returns the last byte of the range read at from for a read of
+// amt bytes, including the readahead.
+func (f *s3File) rangeTarget(from, amt int64) int64 {
+	size := f.info.Size()
+	readahead := f.nextReadahead(from)
+	// compute the last byte without overflowing int64 for huge objects or reads
+	target := size - 1
+	if remaining := size - from; amt < remaining-readahead && f.fs.ranges() {
+		target = from + amt + readahead - 1
+	}
+	f.rangeEnd = target + 1
+	return target
+}
+
+// getRange requests the bytes [from, target] of f. It does not modify f, so
+// it may be called concurrently.
+func (f *s3File) getRange(ctx context.Context, from, target int64) (io.ReadCloser, error) {
+	size := f.info.Size()
 	rq := &s3.GetObjectInput{
-		Bucket: aws.String(f.fs.bucket),
-		Key:    aws.String(f.name),
-		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", from, target)),
+		Key:          aws.String(f.key),
+		Range:        aws.String(fmt.Sprintf("bytes=%d-%d", from, target)),
+		RequestPayer: f.fs.requestPayer,
+	}
+	rq.SSECustomerAlgorithm, rq.SSECustomerKey, rq.SSECustomerKeyMD5 = f.fs.customerKey.headers()
+	if f.versionID != "" {
+		rq.VersionId = aws.String(f.versionID)
+	}
+	if ((f.fs.strictConsistency && !f.fs.isImmutable(f.key)) || f.pinned) && f.fs.conditional() {
+		if etag := objectETag(f.info); etag != "" {
+			rq.IfMatch = aws.String(etag)
+		} else if f.fs.modTimeMetadata == "" && f.fs.modTimePrecision <= 0 {
+			// the reported modification time only pins the version if it
+			// is the LastModified of S3
+			rq.IfUnmodifiedSince = aws.Time(f.info.ModTime())
+		}
+	}
+	prio := priorityLow
+	if size <= smallObjectSize {
+		prio = priorityHigh
 	}
-	res, err := f.fs.s3.GetObjectWithContext(context.TODO(), rq)
+	res, err := f.fs.sendGetObject(ctx, f.key, rq, prio,
+		attribute.String("aws.s3.range", aws.ToString(rq.Range)))
 	if err != nil {
-		if res.Body != nil {
-			res.Body.Close()
+		if httpStatus(err) == http.StatusPreconditionFailed {
+			return nil, fmt.Errorf("%w: %s", ErrObjectChanged, f.name)
 		}
 		return nil, err
 	}
-	return res.Body, nil
+	want := target - from + 1
+	// a zero length is not announced, the body is still checked while reading
+	if res.ContentLength != 0 && res.ContentLength != want {
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s: requested %d bytes at offset %d, response announced %d",
+			ErrTruncatedResponse, f.name, want, from, res.ContentLength)
+	}
+	return &lengthCheckingReader{
+		ReadCloser: res.Body,
+		name:       f.name,
+		offset:     from,
+		remaining:  want,
+	}, nil
+}
+
+// sendGetObject issues rq for key with priority prio on the backend of key,
+// retrying it on the replica while failed over. Like every read, it is
+// bounded by the first-byte timeout and takes access denied responses for
+// missing keys if configured. The body of the response is metered and
+// releases the call once closed.
+func (s3fs *S3FS) sendGetObject(ctx context.Context, key string, rq *s3.GetObjectInput, prio priority, attrs ...attribute.KeyValue) (*s3.GetObjectOutput, error) {
+	return withFailover(s3fs, s3fs.backendFor(key), func(b *backend) (*s3.GetObjectOutput, error) {
+		if err := s3fs.acquire(ctx, prio); err != nil {
+			return nil, err
+		}
+		rq.Bucket = aws.String(b.bucket)
+		start := time.Now()
+		ctx, traced := s3fs.traceCall(ctx, "GetObject", b.bucket, key, attrs...)
+		ctx, call := bound(ctx, "GetObject", s3fs.timeouts.FirstByte)
+		res, err := b.client().GetObject(ctx, rq)
+		err = call.responded(err)
+		traced.end(resultMetadata(res), err)
+		s3fs.limiter.release()
+		s3fs.observeCall(ctx, "GetObject", start, err)
+		err = s3fs.forbiddenAsNotExist(b, key, err)
+		b.observe(err)
+		err = s3fs.endpointPolicyError("s3:GetObject", key, err)
+		if err != nil {
+			call.done()
+			return nil, err
+		}
+		res.Body = call.body(s3fs.metered("GetObject", res.Body))
+		return res, nil
+	})
+}
+
+// ErrTruncatedResponse is returned if the body of a ranged GET does not
+// contain exactly the requested number of bytes, e.g. because a proxy
+// silently truncated it.
+var ErrTruncatedResponse = errors.New("truncated response body")
+
+// lengthCheckingReader verifies that the wrapped body delivers exactly
+// the expected number of bytes.
+type lengthCheckingReader struct {
+	io.ReadCloser
+	name      string
+	offset    int64 // offset of the range within the object
+	remaining int64 // remaining is the number of bytes still expected
+}
+
+func (r *lengthCheckingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	switch {
+	case r.remaining < 0:
+		return n, fmt.Errorf("%w: %s: %d bytes beyond the range at offset %d",
+			ErrTruncatedResponse, r.name, -r.remaining, r.offset)
+	case err == io.EOF && r.remaining > 0:
+		return n, fmt.Errorf("%w: %s: missing %d bytes of the range at offset %d",
+			ErrTruncatedResponse, r.name, r.remaining, r.offset)
+	}
+	return n, err
 }
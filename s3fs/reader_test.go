@@ -0,0 +1,149 @@
+package s3fs
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hugeSize is the size of the objects beyond 32 bit offsets.
+const hugeSize = 5<<30 + 123
+
+func TestRangeTarget(t *testing.T) {
+	fsys := newTestFS(newFakeClient(nil))
+	tests := []struct {
+		name      string
+		size      int64
+		from, amt int64
+		want      int64
+	}{
+		{"start", hugeSize, 0, 4096, 4096 + READAHEAD - 1},
+		{"beyond 4GiB", hugeSize, 1<<32 + 10, 4096, 1<<32 + 10 + 4096 + READAHEAD - 1},
+		{"readahead capped at end", hugeSize, hugeSize - 100, 10, hugeSize - 1},
+		{"read beyond end", hugeSize, 1 << 32, 1 << 33, hugeSize - 1},
+		{"huge read", hugeSize, 1<<32 + 1, math.MaxInt64, hugeSize - 1},
+		{"last byte", hugeSize, hugeSize - 1, 1, hugeSize - 1},
+		{"max size", math.MaxInt64, math.MaxInt64 - 10, math.MaxInt64, math.MaxInt64 - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFile(fsys, "huge")
+			f.info = newFileInfo("huge", tt.size, time.Time{}, `"etag"`)
+			if got := f.rangeTarget(tt.from, tt.amt); got != tt.want {
+				t.Errorf("rangeTarget(%d, %d) = %d, want %d", tt.from, tt.amt, got, tt.want)
+			}
+			if f.rangeEnd != tt.want+1 {
+				t.Errorf("rangeEnd = %d, want %d", f.rangeEnd, tt.want+1)
+			}
+		})
+	}
+}
+
+func TestSeekHugeObject(t *testing.T) {
+	client := newFakeClient(map[string]fakeObject{"huge": {size: hugeSize, etag: `"etag"`}})
+	fsys := newTestFS(client)
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		want   int64
+	}{
+		{"end", 0, io.SeekEnd, hugeSize},
+		{"before end", -10, io.SeekEnd, hugeSize - 10},
+		{"4GiB before end", -(1 << 32), io.SeekEnd, hugeSize - 1<<32},
+		{"beyond 4GiB", 1<<32 + 7, io.SeekStart, 1<<32 + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := fsys.Open("huge")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rs := f.(io.ReadSeeker)
+			got, err := rs.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Seek(%d, %d) = %d, want %d", tt.offset, tt.whence, got, tt.want)
+			}
+			buf := make([]byte, 16)
+			n, err := io.ReadFull(rs, buf)
+			if remaining := hugeSize - tt.want; remaining < int64(len(buf)) {
+				if int64(n) != remaining || (err != io.EOF && err != io.ErrUnexpectedEOF) {
+					t.Fatalf("read %d bytes at %d, %v, want %d bytes and EOF", n, tt.want, err, remaining)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			for i, b := range buf[:n] {
+				if want := fakeByte(tt.want + int64(i)); b != want {
+					t.Fatalf("byte at %d is %d, want %d", tt.want+int64(i), b, want)
+				}
+			}
+		})
+	}
+	f, err := fsys.Open("huge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Seeker).Seek(-hugeSize-1, io.SeekEnd); err == nil {
+		t.Error("seeking before the start succeeded")
+	}
+}
+
+func TestContentRangeHugeObject(t *testing.T) {
+	client := newFakeClient(map[string]fakeObject{"huge": {size: hugeSize, etag: `"etag"`}})
+	fsys := newTestFS(client)
+	tests := []struct {
+		rng         string
+		first, last int64
+	}{
+		{"bytes=4294967296-4294967305", 1 << 32, 1<<32 + 9},
+		{"bytes=4294967290-4294967305", 1<<32 - 6, 1<<32 + 9},
+		{"bytes=-10", hugeSize - 10, hugeSize - 1},
+		{fmt.Sprintf("bytes=%d-", hugeSize-5), hugeSize - 5, hugeSize - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rng, func(t *testing.T) {
+			f, err := fsys.Open("huge")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r := httptest.NewRequest(http.MethodGet, "/huge", nil)
+			r.Header.Set("Range", tt.rng)
+			w := httptest.NewRecorder()
+			http.ServeContent(w, r, "huge", time.Time{}, f.(io.ReadSeeker))
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("status %d, want %d", w.Code, http.StatusPartialContent)
+			}
+			client.mu.Lock()
+			requested := client.ranges[len(client.ranges)-1]
+			client.mu.Unlock()
+			if prefix := fmt.Sprintf("bytes=%d-", tt.first); !strings.HasPrefix(requested, prefix) {
+				t.Errorf("requested %s from S3, want %s...", requested, prefix)
+			}
+			want := fmt.Sprintf("bytes %d-%d/%d", tt.first, tt.last, int64(hugeSize))
+			if got := w.Header().Get("Content-Range"); got != want {
+				t.Errorf("Content-Range %q, want %q", got, want)
+			}
+			body := w.Body.Bytes()
+			if int64(len(body)) != tt.last-tt.first+1 {
+				t.Fatalf("body of %d bytes, want %d", len(body), tt.last-tt.first+1)
+			}
+			for i, b := range body {
+				if want := fakeByte(tt.first + int64(i)); b != want {
+					t.Fatalf("byte at %d is %d, want %d", tt.first+int64(i), b, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxDeleteKeys is the most keys DeleteObjects deletes at once.
+const maxDeleteKeys = 1000
+
+// ErrRemoveAllDisabled is returned by RemoveAll without WithRemoveAll.
+var ErrRemoveAllDisabled = errors.New("recursive removal is disabled")
+
+// WithRemoveAll enables RemoveAll on filesystems with WithWrites. As a
+// single call deletes every object below a prefix, it is disabled by
+// default.
+func WithRemoveAll() Option {
+	return func(s *S3FS) {
+		s.removeAll = true
+	}
+}
+
+// deleteObjectsClient is implemented by clients supporting batched
+// deletes.
+type deleteObjectsClient interface {
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// RemoveAll removes the file name, or the directory name and all objects
+// below it, like os.RemoveAll. Keys are listed page by page and deleted in
+// batches of up to 1000 with DeleteObjects. It returns nil if name does not
+// exist, on failure some of the objects may have been deleted already. The
+// root can't be removed.
+func (s3fs *S3FS) RemoveAll(name string) error {
+	key, err := s3fs.writeKey("removeall", name)
+	if err != nil {
+		return err
+	}
+	if !s3fs.removeAll {
+		return &fs.PathError{Op: "removeall", Path: name, Err: ErrRemoveAllDisabled}
+	}
+	batch := []types.ObjectIdentifier{{Key: aws.String(key)}}
+	input := &s3.ListObjectsV2Input{
+		Prefix:  aws.String(key + "/"),
+		MaxKeys: maxDeleteKeys,
+	}
+	for {
+		out, err := s3fs.listObjects(key+"/", input)
+		if err != nil {
+			return &fs.PathError{Op: "removeall", Path: name, Err: err}
+		}
+		for _, obj := range out.Contents {
+			batch = append(batch, types.ObjectIdentifier{Key: obj.Key})
+			if len(batch) == maxDeleteKeys {
+				if err := s3fs.deleteObjects(key, batch); err != nil {
+					return &fs.PathError{Op: "removeall", Path: name, Err: err}
+				}
+				batch = batch[:0]
+			}
+		}
+		if !out.IsTruncated {
+			break
+		}
+		input.ContinuationToken = out.NextContinuationToken
+	}
+	if len(batch) > 0 {
+		if err := s3fs.deleteObjects(key, batch); err != nil {
+			return &fs.PathError{Op: "removeall", Path: name, Err: err}
+		}
+	}
+	return nil
+}
+
+// deleteObjects deletes the objects of batch below prefix.
+func (s3fs *S3FS) deleteObjects(prefix string, batch []types.ObjectIdentifier) error {
+	out, err := writeCall(s3fs, "DeleteObjects", prefix, 0, func(ctx context.Context, client deleteObjectsClient, bucket string) (*s3.DeleteObjectsOutput, error) {
+		return client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{
+				Objects: batch,
+				Quiet:   true,
+			},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if len(out.Errors) > 0 {
+		e := out.Errors[0]
+		return fmt.Errorf("deleting %d of %d objects failed, first %q: %s: %s",
+			len(out.Errors), len(batch), aws.ToString(e.Key), aws.ToString(e.Code), aws.ToString(e.Message))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package s3fs
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// routingPartitions is the number of key-hash partitions distributed between
+// the primary and the replica bucket by latency routing.
+const routingPartitions = 64
+
+// probeTimeout bounds a single latency probe; a failed probe counts as this latency.
+const probeTimeout = 5 * time.Second
+
+// WithReplica configures a replica of the bucket, e.g. in another region,
+// which can serve all reads.
+func WithReplica(bucket string, s3 Client) Option {
+	return func(s *S3FS) {
+		s.replica = &backend{s3: s3, bucket: bucket}
+	}
+}
+
+// WithLatencyRouting continuously probes the primary and the replica bucket
+// and routes each key-hash partition to one of them, so that the faster
+// bucket receives a proportionally larger share of the keys. Probing stops
+// once ctx is done. It has no effect without WithReplica.
+func WithLatencyRouting(ctx context.Context, interval time.Duration) Option {
+	return func(s *S3FS) {
+		s.routing = &latencyRouter{ctx: ctx, interval: interval}
+	}
+}
+
+// backendFor returns the backend serving key.
+func (s3fs *S3FS) backendFor(key string) *backend {
+	if s3fs.replica != nil && s3fs.failover.primaryDown() {
+		return s3fs.replica
+	}
+	if s3fs.replica != nil && s3fs.routing != nil && s3fs.routing.useReplica(key) {
+		return s3fs.replica
+	}
+	return &s3fs.primary
+}
+
+// latencyRouter assigns key-hash partitions to the primary or replica
+// bucket based on the smoothed latency of periodic probes.
+type latencyRouter struct {
+	ctx      context.Context
+	interval time.Duration
+
+	mu            sync.RWMutex
+	primary       time.Duration // smoothed probe latency of the primary
+	replica       time.Duration // smoothed probe latency of the replica
+	replicaShares int           // number of partitions served by the replica
+}
+
+// start begins probing both buckets in the background.
+func (r *latencyRouter) start(s3fs *S3FS) {
+	r.ctx = s3fs.closer.bind(r.ctx)
+	s3fs.closer.run(func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.observe(probe(r.ctx, &s3fs.primary), probe(r.ctx, s3fs.replica), s3fs.log)
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// observe folds new probe results into the smoothed latencies and
+// recomputes the partition split.
+func (r *latencyRouter) observe(primary, replica time.Duration, log *zap.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.primary = smooth(r.primary, primary)
+	r.replica = smooth(r.replica, replica)
+	// the replica receives the share of partitions the primary is slower by
+	r.replicaShares = int(int64(routingPartitions) * int64(r.primary) / int64(r.primary+r.replica))
+	log.Debug("latency routing updated",
+		zap.Duration("primary", r.primary),
+		zap.Duration("replica", r.replica),
+		zap.Int("replica_partitions", r.replicaShares))
+}
+
+// useReplica reports whether key falls into a partition served by the replica.
+func (r *latencyRouter) useReplica(key string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return int(h.Sum32()%routingPartitions) < r.replicaShares
+}
+
+// smooth computes an exponentially weighted moving average.
+func smooth(avg, sample time.Duration) time.Duration {
+	if avg == 0 {
+		return sample
+	}
+	return (avg*7 + sample*3) / 10
+}
+
+// probe measures the latency of a HeadBucket call against b, or of listing
+// a single key if the client does not support HeadBucket.
+func probe(ctx context.Context, b *backend) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	start := time.Now()
+	var err error
+	if client, ok := b.client().(headBucketClient); ok {
+		_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{
+			Bucket: aws.String(b.bucket),
+		})
+	} else {
+		_, err = b.client().ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(b.bucket),
+			MaxKeys: 1,
+		})
+	}
+	b.observe(err)
+	if err != nil {
+		return probeTimeout
+	}
+	return time.Since(start)
+}
@@ -0,0 +1,189 @@
+package s3fs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestStats collects the S3 activity on behalf of a single request, e.g.
+// to enrich access logs. It is safe for concurrent use.
+type RequestStats struct {
+	mu     sync.Mutex
+	key    string
+	etag   string
+	calls  int
+	origin time.Duration
+	stale  bool
+}
+
+// RequestSummary is a snapshot of RequestStats.
+type RequestSummary struct {
+	// Key is the key of the last object opened, empty if none.
+	Key string
+	// ETag is the ETag of the last object opened, empty if unknown.
+	ETag string
+	// Calls is the number of S3 calls issued.
+	Calls int
+	// Origin is the total time S3 took to respond to the calls.
+	Origin time.Duration
+	// Stale is set if expired metadata was served, see WithStaleFallback.
+	Stale bool
+}
+
+// Cache returns STALE if expired metadata was served, HIT if the request
+// was served without calling S3, MISS otherwise.
+func (s RequestSummary) Cache() string {
+	if s.Stale {
+		return "STALE"
+	}
+	if s.Calls == 0 {
+		return "HIT"
+	}
+	return "MISS"
+}
+
+// Summary returns a snapshot of the collected activity.
+func (r *RequestStats) Summary() RequestSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RequestSummary{Key: r.key, ETag: r.etag, Calls: r.calls, Origin: r.origin, Stale: r.stale}
+}
+
+type requestStatsKey struct{}
+
+// NewRequestContext returns a context collecting the S3 activity of calls
+// issued with it, see WithContext, into the returned stats.
+func NewRequestContext(ctx context.Context) (context.Context, *RequestStats) {
+	stats := &RequestStats{}
+	return context.WithValue(ctx, requestStatsKey{}, stats), stats
+}
+
+// requestStats returns the stats collected for ctx, nil if none.
+func requestStats(ctx context.Context) *RequestStats {
+	if ctx == nil {
+		return nil
+	}
+	stats, _ := ctx.Value(requestStatsKey{}).(*RequestStats)
+	return stats
+}
+
+// call records an S3 call which took latency to respond.
+func (r *RequestStats) call(latency time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.calls++
+	r.origin += latency
+	r.mu.Unlock()
+}
+
+// opened records the object being served.
+func (r *RequestStats) opened(key, etag string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.key, r.etag = key, etag
+	r.mu.Unlock()
+}
+
+// servedStale records expired metadata being served.
+func (r *RequestStats) servedStale() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.stale = true
+	r.mu.Unlock()
+}
+
+// observeCall records the outcome of an S3 call of operation with ctx,
+// started at start, for the adaptive concurrency limit, the circuit breaker,
+// the metrics and the stats of the request issuing it.
+func (s3fs *S3FS) observeCall(ctx context.Context, operation string, start time.Time, err error) {
+	latency := time.Since(start)
+	s3fs.limiter.observe(latency, err)
+	s3fs.observeBreaker(err)
+	if s3fs.metrics != nil {
+		s3fs.metrics.Call(operation, latency, err)
+	}
+	requestStats(ctx).call(latency)
+}
+
+// boundRootPrefix starts the root of a request bound by BindRequest.
+const boundRootPrefix = ".s3fs-request-"
+
+// bindings holds the contexts of the requests bound by BindRequest by the
+// token of their root.
+var bindings = struct {
+	sync.Mutex
+	requests map[string]context.Context
+}{requests: make(map[string]context.Context)}
+
+// BindRequest binds the names below the returned root to the request of
+// ctx until unbind is called, e.g. to hand the request to the calls of
+// Caddy's file_server, which does not pass it on, by serving from below the
+// root. Calls of S3FS and Overlay for names below the root are issued with
+// ctx like with WithContext, so they are cancelled once the client
+// disconnects, attributing their activity to the stats of NewRequestContext
+// and resolving the prefix of WithPrefixResolver for that very request. The root is a random token, so
+// requests can't name the root of another request; names below roots that
+// are not bound fail with fs.ErrNotExist.
+func BindRequest(ctx context.Context) (root string, unbind func()) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	name := boundRootPrefix + hex.EncodeToString(token)
+	bindings.Lock()
+	bindings.requests[name] = ctx
+	bindings.Unlock()
+	return "/" + name, func() {
+		bindings.Lock()
+		delete(bindings.requests, name)
+		bindings.Unlock()
+	}
+}
+
+// BoundRequest returns the context of the request bound to the root name
+// starts with and the name below the root, nil and name if name is not
+// below the root of a request. Names below roots not bound fail with
+// fs.ErrNotExist.
+func BoundRequest(name string) (context.Context, string, error) {
+	clean := strings.TrimPrefix(name, "/")
+	if !strings.HasPrefix(clean, boundRootPrefix) {
+		return nil, name, nil
+	}
+	root, rest, _ := strings.Cut(clean, "/")
+	if rest == "" {
+		rest = "."
+	}
+	bindings.Lock()
+	ctx, ok := bindings.requests[root]
+	bindings.Unlock()
+	if !ok {
+		return nil, "", fs.ErrNotExist
+	}
+	return ctx, rest, nil
+}
+
+// bound returns s3fs issuing its calls with the context of the request bound
+// to name, see BindRequest, unless a context was set by WithContext, and the
+// name below the root of the request. Like with WithContext, the calls are
+// cancelled with the request, but for those shared with other requests.
+func (s3fs *S3FS) bound(op, name string) (*S3FS, string, error) {
+	ctx, rest, err := BoundRequest(name)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if ctx != nil && s3fs.ctx == nil {
+		s3fs = s3fs.WithContext(ctx)
+	}
+	return s3fs, rest, nil
+}
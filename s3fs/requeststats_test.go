@@ -0,0 +1,70 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRequestSummaryCache(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := newFakeClient(map[string]fakeObject{"a.txt": {size: 10, etag: `"a"`}})
+	fsys := newTestFS(client,
+		WithStatCache(time.Minute, 100),
+		WithStaleFallback(time.Second, 10*time.Minute),
+		WithClock(clock),
+	)
+	stat := func() string {
+		t.Helper()
+		ctx, stats := NewRequestContext(context.Background())
+		if _, err := fsys.WithContext(ctx).Stat("a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		return stats.Summary().Cache()
+	}
+	if got := stat(); got != "MISS" {
+		t.Errorf("first stat %s, want MISS", got)
+	}
+	if got := stat(); got != "HIT" {
+		t.Errorf("cached stat %s, want HIT", got)
+	}
+	client.failWith(errors.New("connection reset"))
+	clock.advance(2 * time.Minute)
+	if got := stat(); got != "STALE" {
+		t.Errorf("stale stat %s, want STALE", got)
+	}
+}
+
+func TestBoundRequestCancelled(t *testing.T) {
+	client := newFakeClient(map[string]fakeObject{"big.bin": {size: 1 << 20, etag: `"etag"`}})
+	fsys := newTestFS(client, WithReadahead(-1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root, unbind := BindRequest(ctx)
+	defer unbind()
+
+	f, err := fsys.Open(path.Join(root, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.count("GetObject")
+
+	cancel()
+	if _, err := f.(io.Seeker).Seek(1<<19, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(buf); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read of a cancelled request = %v, want context.Canceled", err)
+	}
+	if n := client.count("GetObject") - calls; n > 1 {
+		t.Errorf("%d GetObject calls after the cancellation, want at most 1", n)
+	}
+}
@@ -0,0 +1,122 @@
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrCacheDisabled is returned by SetSettings for a TTL of a cache the
+// filesystem was created without.
+var ErrCacheDisabled = errors.New("cache is disabled")
+
+// Settings are the settings of an S3FS that can be changed while it serves
+// with SetSettings, e.g. to react to an incident without recreating it.
+type Settings struct {
+	// Readahead is the number of bytes requested beyond each read, see
+	// WithReadahead, 0 if disabled. Overrides keep their own readahead.
+	Readahead int64 `json:"readahead"`
+	// StatCacheTTL is the lifetime of cached stat results, 0 if the stat
+	// cache is disabled. The cache can't be enabled at runtime.
+	StatCacheTTL time.Duration `json:"stat_cache_ttl"`
+	// NotFoundCacheTTL is the lifetime of names cached as missing, 0 if the
+	// negative cache is disabled. The cache can't be enabled at runtime.
+	NotFoundCacheTTL time.Duration `json:"not_found_cache_ttl"`
+	// RateLimit is the number of S3 calls per second of WithRateLimit, 0 if
+	// unlimited.
+	RateLimit float64 `json:"rate_limit"`
+	// RateBurst is the burst of RateLimit.
+	RateBurst int `json:"rate_burst"`
+	// Verbose logs every S3 call, see WithVerboseLogging.
+	Verbose bool `json:"verbose"`
+}
+
+// tunables holds the settings changed at runtime, shared by all views of an
+// S3FS.
+type tunables struct {
+	readahead atomic.Int64
+	rate      atomic.Pointer[rateLimiter] // rate bounds the rate of S3 calls, nil if unlimited
+	verbose   atomic.Bool
+}
+
+// Settings returns the current runtime settings.
+func (s3fs *S3FS) Settings() Settings {
+	s := Settings{
+		Readahead:        s3fs.tunables.readahead.Load(),
+		StatCacheTTL:     s3fs.statCache.lifetime(),
+		NotFoundCacheTTL: s3fs.notFound.lifetime(),
+		Verbose:          s3fs.tunables.verbose.Load(),
+	}
+	if r := s3fs.tunables.rate.Load(); r != nil {
+		s.RateLimit, s.RateBurst = r.rate, int(r.burst)
+	}
+	return s
+}
+
+// ValidateSettings returns the error SetSettings would fail with for s,
+// without applying it, e.g. to change several filesystems all or nothing.
+func (s3fs *S3FS) ValidateSettings(s Settings) error {
+	switch {
+	case s.Readahead < 0:
+		return fmt.Errorf("invalid readahead %d", s.Readahead)
+	case s.StatCacheTTL < 0 || s.NotFoundCacheTTL < 0:
+		return errors.New("cache TTLs can't be negative")
+	case s.RateLimit < 0 || s.RateBurst < 0:
+		return errors.New("rate limit and burst can't be negative")
+	case s3fs.statCache == nil && s.StatCacheTTL != 0:
+		return fmt.Errorf("%w: stat cache", ErrCacheDisabled)
+	case s3fs.notFound == nil && s.NotFoundCacheTTL != 0:
+		return fmt.Errorf("%w: not found cache", ErrCacheDisabled)
+	case s3fs.statCache != nil && s.StatCacheTTL == 0 || s3fs.notFound != nil && s.NotFoundCacheTTL == 0:
+		return errors.New("cache TTLs of enabled caches must be positive")
+	}
+	return nil
+}
+
+// SetSettings applies s, taking effect for calls issued from then on.
+// Shortened TTLs also apply to the entries already cached.
+func (s3fs *S3FS) SetSettings(s Settings) error {
+	if err := s3fs.ValidateSettings(s); err != nil {
+		return err
+	}
+	prev := s3fs.Settings()
+	s3fs.tunables.readahead.Store(s.Readahead)
+	s3fs.statCache.setTTL(s.StatCacheTTL)
+	s3fs.notFound.setTTL(s.NotFoundCacheTTL)
+	if s.RateLimit != prev.RateLimit || s.RateBurst != prev.RateBurst {
+		var r *rateLimiter
+		if s.RateLimit > 0 {
+			r = newRateLimiter(s3fs.clock, s.RateLimit, s.RateBurst)
+		}
+		s3fs.tunables.rate.Store(r)
+	}
+	s3fs.tunables.verbose.Store(s.Verbose)
+	s = s3fs.Settings()
+	if s != prev {
+		s3fs.log.Info("runtime settings changed",
+			zap.Int64("readahead", s.Readahead),
+			zap.Duration("stat_cache_ttl", s.StatCacheTTL),
+			zap.Duration("not_found_cache_ttl", s.NotFoundCacheTTL),
+			zap.Float64("rate_limit", s.RateLimit),
+			zap.Int("rate_burst", s.RateBurst),
+			zap.Bool("verbose", s.Verbose))
+		s3fs.events.emit(EventSettingsChanged, map[string]interface{}{
+			"bucket":   s3fs.primary.bucket,
+			"settings": s,
+		})
+	}
+	return nil
+}
+
+// newRateLimiter returns a limiter of rate calls per second on clock with
+// bursts of burst calls, the rate rounded up if burst is below 1.
+func newRateLimiter(clock Clock, rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), clock: clock, last: clock.Now()}
+}
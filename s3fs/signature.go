@@ -0,0 +1,301 @@
+package s3fs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrSignatureInvalid is logged for objects not served because their
+// signature sidecar is missing or does not verify.
+var ErrSignatureInvalid = errors.New("signature verification failed")
+
+// verifiedTTL is how long a verified object version is remembered, so it is
+// not downloaded and verified again on every open.
+const verifiedTTL = time.Hour
+
+// verifiedSize bounds the number of remembered object versions.
+const verifiedSize = 100000
+
+// maxSignatureSize bounds the size of signature sidecars.
+const maxSignatureSize = 64 << 10
+
+// maxBufferedSize bounds the size of objects verified with signature schemes
+// that need the whole content in memory, i.e. Ed25519 without prehashing.
+const maxBufferedSize = 64 << 20
+
+// Verifier checks the signature of an object stored next to it in a sidecar.
+type Verifier interface {
+	// Suffix returns the suffix of the key of the sidecar, e.g. `.sig`.
+	Suffix() string
+	// Verify checks the signature sig, the content of the sidecar, of the
+	// object read from content.
+	Verify(content io.Reader, sig []byte) error
+}
+
+// WithSignatureVerification only serves objects matching any of the patterns
+// if their signature sidecar verifies with v, e.g. for mirrors of software
+// downloads. Objects failing verification, including ones without sidecar,
+// cannot be opened. The whole object is downloaded and verified on the
+// first open of each version, and reads of verified objects are made
+// conditional on its ETag.
+func WithSignatureVerification(v Verifier, patterns ...string) Option {
+	return func(s *S3FS) {
+		if v == nil {
+			return
+		}
+		s.signatures = &signatures{
+			verifier: v,
+			patterns: keyPatterns(patterns),
+			verified: newTTLCache[struct{}](verifiedTTL, verifiedSize),
+		}
+	}
+}
+
+// signatures verifies objects before they are served.
+type signatures struct {
+	verifier Verifier
+	patterns keyPatterns
+	verified *ttlCache[struct{}] // verified holds recently verified versions
+}
+
+// verifySignature checks the signature of f if required, returning
+// fs.ErrPermission if it does not verify.
+func (s3fs *S3FS) verifySignature(f *s3File) error {
+	sv := s3fs.signatures
+	if sv == nil || !sv.patterns.match(f.key) {
+		return nil
+	}
+	etag := objectETag(f.info)
+	id := contentID(f.key, f.versionID, etag)
+	if _, ok := sv.verified.get(id); ok && etag != "" {
+		f.pinned = true
+		return nil
+	}
+	sig, err := s3fs.readSignature(f.key + sv.verifier.Suffix())
+	if err == nil {
+		err = s3fs.verifyContent(f, etag, sig)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrSignatureInvalid) {
+			return err
+		}
+		s3fs.log.Warn("refusing to serve object", zap.String("key", f.key), zap.Error(err))
+		return fs.ErrPermission
+	}
+	if etag != "" {
+		sv.verified.put(id, struct{}{})
+		f.pinned = true
+	}
+	return nil
+}
+
+// readSignature reads the signature sidecar key.
+func (s3fs *S3FS) readSignature(key string) ([]byte, error) {
+	body, err := s3fs.getObject(key, "", "")
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%w: missing signature %s", ErrSignatureInvalid, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	sig, err := io.ReadAll(io.LimitReader(body, maxSignatureSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) > maxSignatureSize {
+		return nil, fmt.Errorf("%w: signature %s exceeds %d bytes", ErrSignatureInvalid, key, maxSignatureSize)
+	}
+	return sig, nil
+}
+
+// verifyContent downloads the version of f with the given ETag and verifies
+// it against sig.
+func (s3fs *S3FS) verifyContent(f *s3File, etag string, sig []byte) error {
+	body, err := s3fs.getObject(f.key, f.versionID, etag)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := s3fs.signatures.verifier.Verify(body, sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrSignatureInvalid, err)
+	}
+	return nil
+}
+
+// getObject returns the body of the whole object key, restricted to the
+// given version and ETag if set.
+func (s3fs *S3FS) getObject(key, versionID, etag string) (io.ReadCloser, error) {
+	rq := &s3.GetObjectInput{
+		Key:          aws.String(key),
+		RequestPayer: s3fs.requestPayer,
+	}
+	rq.SSECustomerAlgorithm, rq.SSECustomerKey, rq.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+	if versionID != "" {
+		rq.VersionId = aws.String(versionID)
+	}
+	if etag != "" && s3fs.conditional() {
+		rq.IfMatch = aws.String(etag)
+	}
+	res, err := s3fs.sendGetObject(s3fs.callContext(), key, rq, priorityLow)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// NewCosignVerifier returns a Verifier for signatures created by
+// `cosign sign-blob`, stored base64 encoded in a `.sig` sidecar, using the
+// PEM encoded ECDSA or Ed25519 public key pemKey.
+func NewCosignVerifier(pemKey []byte) (Verifier, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("cosign public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cosign public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return cosignVerifier{key: key}, nil
+	}
+	return nil, fmt.Errorf("unsupported cosign public key type %T", key)
+}
+
+type cosignVerifier struct {
+	key interface{}
+}
+
+func (cosignVerifier) Suffix() string { return ".sig" }
+
+func (v cosignVerifier) Verify(content io.Reader, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	switch key := v.key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			return err
+		}
+		if !ecdsa.VerifyASN1(key, h.Sum(nil), raw) {
+			return errors.New("invalid signature")
+		}
+	case ed25519.PublicKey:
+		msg, err := readBuffered(content)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, msg, raw) {
+			return errors.New("invalid signature")
+		}
+	}
+	return nil
+}
+
+// NewMinisignVerifier returns a Verifier for signatures created by minisign,
+// stored in a `.minisig` sidecar, using the public key pubKey, given either
+// as the base64 encoded key or the content of a minisign public key file.
+func NewMinisignVerifier(pubKey string) (Verifier, error) {
+	raw, err := base64.StdEncoding.DecodeString(minisignPayload(pubKey))
+	if err != nil {
+		return nil, fmt.Errorf("decoding minisign public key: %w", err)
+	}
+	if len(raw) != 42 || string(raw[:2]) != "Ed" {
+		return nil, errors.New("invalid minisign public key")
+	}
+	v := minisignVerifier{key: ed25519.PublicKey(raw[10:])}
+	copy(v.keyID[:], raw[2:10])
+	return v, nil
+}
+
+type minisignVerifier struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+// minisignPayload returns the first line of s not being a comment.
+func minisignPayload(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			return line
+		}
+	}
+	return ""
+}
+
+func (minisignVerifier) Suffix() string { return ".minisig" }
+
+func (v minisignVerifier) Verify(content io.Reader, sig []byte) error {
+	// untrusted comment, signature, trusted comment, global signature
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("malformed minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 74 {
+		return errors.New("malformed minisign signature")
+	}
+	if !bytes.Equal(raw[2:10], v.keyID[:]) {
+		return errors.New("signed with another key")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("malformed minisign global signature")
+	}
+	trusted := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+	if !ed25519.Verify(v.key, append(raw[10:74:74], trusted...), global) {
+		return errors.New("invalid trusted comment signature")
+	}
+	var msg []byte
+	switch string(raw[:2]) {
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, content); err != nil {
+			return err
+		}
+		msg = h.Sum(nil)
+	case "Ed":
+		if msg, err = readBuffered(content); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported minisign signature algorithm")
+	}
+	if !ed25519.Verify(v.key, msg, raw[10:74]) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// readBuffered reads content for schemes signing the message itself,
+// refusing content larger than maxBufferedSize.
+func readBuffered(content io.Reader) ([]byte, error) {
+	msg, err := io.ReadAll(io.LimitReader(content, maxBufferedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > maxBufferedSize {
+		return nil, fmt.Errorf("content exceeds %d bytes, too large for signatures without prehashing", maxBufferedSize)
+	}
+	return msg, nil
+}
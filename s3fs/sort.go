@@ -0,0 +1,129 @@
+package s3fs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// ListOrder specifies the order of entries returned by a full directory listing.
+type ListOrder string
+
+const (
+	// OrderNone keeps the order in which S3 returned the entries, i.e.
+	// directories before files, each in lexicographical key order.
+	OrderNone ListOrder = ""
+	// OrderName sorts entries by name.
+	OrderName ListOrder = "name"
+	// OrderModTime sorts entries by modification time.
+	OrderModTime ListOrder = "modtime"
+	// OrderSize sorts entries by size.
+	OrderSize ListOrder = "size"
+)
+
+// ParseListOrder validates the textual representation of a ListOrder.
+func ParseListOrder(s string) (ListOrder, error) {
+	switch o := ListOrder(s); o {
+	case OrderNone, OrderName, OrderModTime, OrderSize:
+		return o, nil
+	}
+	return OrderNone, fmt.Errorf("unknown listing order %q", s)
+}
+
+// WithListOrder sorts the entries of full directory listings (ReadDir(n <= 0))
+// once all pages have been merged. Ties are broken by name, so repeated
+// listings of an unchanged directory always produce identical output. The
+// order of each directory is memoized until its entries change.
+func WithListOrder(order ListOrder, descending bool) Option {
+	return func(s *S3FS) {
+		s.listOrder = order
+		s.listDescending = descending
+		s.sortedListings = nil
+		if order != OrderNone {
+			s.sortedListings = newTTLCache[sortedListing](sortedListingsTTL, sortedListingsSize)
+		}
+	}
+}
+
+const (
+	// sortedListingsSize is the number of directories whose order is memoized.
+	sortedListingsSize = 1000
+	// sortedListingsTTL bounds how long the order of a directory not listed
+	// again is kept.
+	sortedListingsTTL = time.Hour
+)
+
+// sortedListing is the memoized order of a listing.
+type sortedListing struct {
+	fingerprint [sha256.Size]byte // fingerprint identifies the entries in listed order
+	order       []int             // order holds the listed index of each sorted entry
+}
+
+// sortEntries sorts the entries of dir in place according to the configured
+// order, reusing the order of the previous listing of dir if its entries are
+// unchanged.
+func (s3fs *S3FS) sortEntries(dir string, entries []fs.DirEntry) {
+	if s3fs.listOrder == OrderNone {
+		return
+	}
+	// resolve the sort keys once instead of on every comparison
+	infos := make([]fs.FileInfo, len(entries))
+	for i, e := range entries {
+		infos[i], _ = e.Info()
+	}
+	fingerprint := listingFingerprint(infos)
+	listing, ok := s3fs.sortedListings.get(dir)
+	if !ok || listing.fingerprint != fingerprint {
+		listing = sortedListing{fingerprint: fingerprint, order: s3fs.sortOrder(infos)}
+		s3fs.sortedListings.put(dir, listing)
+	}
+	sorted := make([]fs.DirEntry, len(entries))
+	for i, j := range listing.order {
+		sorted[i] = entries[j]
+	}
+	copy(entries, sorted)
+}
+
+// sortOrder returns the indexes of infos in the configured order.
+func (s3fs *S3FS) sortOrder(infos []fs.FileInfo) []int {
+	less := func(a, b fs.FileInfo) bool {
+		switch s3fs.listOrder {
+		case OrderModTime:
+			if !a.ModTime().Equal(b.ModTime()) {
+				return a.ModTime().Before(b.ModTime())
+			}
+		case OrderSize:
+			if a.Size() != b.Size() {
+				return a.Size() < b.Size()
+			}
+		}
+		return a.Name() < b.Name()
+	}
+	order := make([]int, len(infos))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := infos[order[i]], infos[order[j]]
+		if s3fs.listDescending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return order
+}
+
+// listingFingerprint hashes the name, ETag, size, modification time and
+// type of each entry of a listing, in listed order.
+func listingFingerprint(infos []fs.FileInfo) [sha256.Size]byte {
+	h := sha256.New()
+	for _, info := range infos {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%t\n",
+			info.Name(), objectETag(info), info.Size(), info.ModTime().UnixNano(), info.IsDir())
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
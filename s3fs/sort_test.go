@@ -0,0 +1,55 @@
+package s3fs
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestSortedListingMemoized(t *testing.T) {
+	client := newFakeClient(map[string]fakeObject{
+		"dir/a.txt": {size: 30, etag: `"a"`},
+		"dir/b.txt": {size: 10, etag: `"b"`},
+		"dir/c.txt": {size: 20, etag: `"c"`},
+	})
+	fsys := newTestFS(client, WithListOrder(OrderSize, false))
+	names := func() []string {
+		t.Helper()
+		f, err := fsys.Open("dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		entries, err := f.(fs.ReadDirFile).ReadDir(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = e.Name()
+		}
+		return names
+	}
+	check := func(got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("listed %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("listed %v, want %v", got, want)
+			}
+		}
+	}
+
+	check(names(), "b.txt", "c.txt", "a.txt")
+	if n := fsys.sortedListings.len(); n != 1 {
+		t.Fatalf("%d memoized listings, want 1", n)
+	}
+	check(names(), "b.txt", "c.txt", "a.txt")
+
+	// a changed object invalidates the memoized order
+	client.mu.Lock()
+	client.objects["dir/a.txt"] = fakeObject{size: 5, etag: `"a2"`}
+	client.mu.Unlock()
+	check(names(), "a.txt", "b.txt", "c.txt")
+}
@@ -0,0 +1,47 @@
+package s3fs
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Encryption is the server-side encryption of objects written with
+// WithWrites.
+type Encryption struct {
+	// Algorithm is types.ServerSideEncryptionAes256 for SSE-S3 or
+	// types.ServerSideEncryptionAwsKms for SSE-KMS.
+	Algorithm types.ServerSideEncryption
+	// KMSKeyID is the ID or ARN of the KMS key of SSE-KMS, the AWS managed
+	// key of S3 if empty.
+	KMSKeyID string
+	// BucketKey makes SSE-KMS use an S3 Bucket Key, reducing the calls to
+	// KMS.
+	BucketKey bool
+}
+
+// WithServerSideEncryption encrypts every object written, by PutObject,
+// multipart uploads as well as copies, with e instead of the default
+// encryption of the bucket, so uploads comply with encryption policies
+// denying requests without the expected encryption headers. An empty
+// Algorithm keeps the default of the bucket.
+func WithServerSideEncryption(e Encryption) Option {
+	return func(s *S3FS) {
+		if e.Algorithm == "" {
+			s.encryption = nil
+			return
+		}
+		s.encryption = &e
+	}
+}
+
+// headers returns the encryption headers of the requests writing objects,
+// all empty if e is nil.
+func (e *Encryption) headers() (algorithm types.ServerSideEncryption, keyID *string, bucketKey bool) {
+	if e == nil {
+		return "", nil, false
+	}
+	if e.KMSKeyID != "" {
+		keyID = aws.String(e.KMSKeyID)
+	}
+	return e.Algorithm, keyID, e.BucketKey
+}
@@ -0,0 +1,57 @@
+package s3fs
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ErrCustomerKey is returned by NewCustomerKey for invalid keys.
+var ErrCustomerKey = errors.New("invalid SSE-C key")
+
+// CustomerKey is the key of objects encrypted with a customer-provided key
+// (SSE-C), which S3 needs on every read.
+type CustomerKey struct {
+	key    string // key is the base64 encoded key
+	keyMD5 string // keyMD5 is the base64 encoded MD5 digest of the key
+}
+
+// NewCustomerKey returns the 32 byte AES-256 key of SSE-C. The base64
+// encoded MD5 digest keyMD5 is computed if empty, otherwise it has to match
+// the key, catching keys mangled in transit.
+func NewCustomerKey(key []byte, keyMD5 string) (*CustomerKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: key has %d bytes instead of 32", ErrCustomerKey, len(key))
+	}
+	sum := md5.Sum(key)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	if keyMD5 != "" && keyMD5 != digest {
+		return nil, fmt.Errorf("%w: MD5 digest %s does not match the key", ErrCustomerKey, keyMD5)
+	}
+	return &CustomerKey{key: base64.StdEncoding.EncodeToString(key), keyMD5: digest}, nil
+}
+
+// WithCustomerKey attaches k to every GetObject and HeadObject, serving
+// buckets whose objects are encrypted with a customer-provided key. S3
+// rejects reads of objects encrypted with another key as well as of objects
+// not encrypted with SSE-C at all with 400 Bad Request. Objects written with
+// WithWrites, uploaded in parts or copied are encrypted with k as well, so
+// k can't be combined with WithServerSideEncryption. A nil k reads objects
+// without SSE-C.
+func WithCustomerKey(k *CustomerKey) Option {
+	return func(s *S3FS) {
+		s.customerKey = k
+	}
+}
+
+// headers returns the SSE-C headers of the requests reading or writing
+// objects, and of the sources of copies, all nil if k is nil.
+func (k *CustomerKey) headers() (algorithm, key, keyMD5 *string) {
+	if k == nil {
+		return nil, nil, nil
+	}
+	return aws.String("AES256"), aws.String(k.key), aws.String(k.keyMD5)
+}
@@ -0,0 +1,71 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheStale names stale metadata served by WithStaleFallback in Metrics.
+const CacheStale = "stale"
+
+// WithStaleFallback bounds the time a request waits for S3 to refresh
+// expired metadata of the stat cache: if HeadObject did not respond within
+// budget, or failed with a server error, throttling or a network error, the
+// metadata which expired less than maxStale ago is served instead together
+// with the contents cached for it, and the refresh completes in the
+// background. Missing objects are never served stale. This bounds the
+// latency seen by visitors during a brownout of S3 to budget for objects
+// served from the content or disk cache. It requires WithStatCache.
+func WithStaleFallback(budget, maxStale time.Duration) Option {
+	return func(s *S3FS) {
+		if budget <= 0 || maxStale <= 0 {
+			return
+		}
+		s.stale = &staleFallback{budget: budget, maxStale: maxStale}
+	}
+}
+
+// staleFallback holds the settings of WithStaleFallback.
+type staleFallback struct {
+	budget   time.Duration
+	maxStale time.Duration
+	refresh  singleflight.Group // refresh deduplicates the refreshes of a name
+}
+
+// staleLookup refreshes the expired metadata of name, returning stale if the
+// refresh does not succeed within the budget.
+func (s3fs *S3FS) staleLookup(name string, stale fs.FileInfo) (fs.FileInfo, error) {
+	refreshing := s3fs.stale.refresh.DoChan(name, func() (interface{}, error) {
+		// outlives the request if it exceeds the budget
+		info, err := s3fs.detached().lookup(name)
+		if err == nil {
+			s3fs.statCache.putTTL(name, info, s3fs.cacheTTL(name))
+		}
+		return info, err
+	})
+	timer := s3fs.clock.NewTimer(s3fs.stale.budget)
+	defer timer.Stop()
+	select {
+	case res := <-refreshing:
+		if res.Err == nil {
+			return res.Val.(fs.FileInfo), nil
+		}
+		if errors.Is(res.Err, fs.ErrNotExist) || !isBreakerFailure(res.Err) {
+			return nil, res.Err
+		}
+		s3fs.log.Debug("refreshing metadata failed, serving stale metadata",
+			zap.String("name", name),
+			zap.Error(res.Err))
+	case <-timer.C():
+		s3fs.log.Debug("refreshing metadata exceeded the budget, serving stale metadata",
+			zap.String("name", name),
+			zap.Duration("budget", s3fs.stale.budget))
+	}
+	s3fs.observeCache(CacheStale, true)
+	requestStats(s3fs.ctx).servedStale()
+	return stale, nil
+}
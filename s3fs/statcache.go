@@ -0,0 +1,18 @@
+package s3fs
+
+import (
+	"io/fs"
+	"time"
+)
+
+// WithStatCache caches the results of Stat, of files and directories alike,
+// for ttl, so repeated requests for the same key do not each issue a
+// HeadObject. At most maxEntries results are kept.
+func WithStatCache(ttl time.Duration, maxEntries int) Option {
+	return func(s *S3FS) {
+		if ttl <= 0 {
+			return
+		}
+		s.statCache = newTTLCache[fs.FileInfo](ttl, maxEntries)
+	}
+}
@@ -0,0 +1,110 @@
+package s3fs
+
+import (
+	"context"
+	"time"
+)
+
+// State is a snapshot of the internal state of an S3FS, for debugging.
+type State struct {
+	Bucket         string               `json:"bucket"`
+	Streams        *StreamReport        `json:"streams,omitempty"`
+	CacheEntries   map[string]int       `json:"cache_entries"`
+	Limiter        *LimiterState        `json:"limiter,omitempty"`
+	LatencyRouting *LatencyRoutingState `json:"latency_routing,omitempty"`
+	Credentials    CredentialsState     `json:"credentials"`
+	Quotas         []QuotaState         `json:"quotas,omitempty"`
+	Canary         *CanaryState         `json:"canary,omitempty"`
+	CircuitBreaker *BreakerState        `json:"circuit_breaker,omitempty"`
+	Failover       *FailoverState       `json:"failover,omitempty"`
+}
+
+// LimiterState describes the concurrency limiter.
+type LimiterState struct {
+	Max    int `json:"max"`
+	InUse  int `json:"in_use"`
+	Queued int `json:"queued"`
+	// Ceiling is the configured limit Max adapts to, if adaptive.
+	Ceiling int `json:"ceiling,omitempty"`
+}
+
+// LatencyRoutingState describes the split between primary and replica bucket.
+type LatencyRoutingState struct {
+	PrimaryLatency    time.Duration `json:"primary_latency"`
+	ReplicaLatency    time.Duration `json:"replica_latency"`
+	ReplicaPartitions int           `json:"replica_partitions"`
+}
+
+// CredentialsState describes the credentials in use for the primary bucket.
+type CredentialsState struct {
+	// ActiveSource is the index of the credential source in use, 0 being the
+	// client passed to NewFS and subsequent ones the fallbacks.
+	ActiveSource int `json:"active_source"`
+	// Expires is the expiry time of the credentials of the client passed to
+	// NewFS, if known and they expire.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// State returns a snapshot of the internal state.
+func (s3fs *S3FS) State() State {
+	state := State{
+		Bucket:         s3fs.primary.bucket,
+		Streams:        s3fs.StreamStats(),
+		CacheEntries:   make(map[string]int),
+		Quotas:         s3fs.QuotaStats(),
+		Canary:         s3fs.canary.stateOf(),
+		CircuitBreaker: s3fs.breaker.stateOf(),
+	}
+	if s3fs.replica != nil {
+		state.Failover = s3fs.failover.stateOf()
+	}
+	if s3fs.statCache != nil {
+		state.CacheEntries["stat"] = s3fs.statCache.len()
+	}
+	if s3fs.notFound != nil {
+		state.CacheEntries["not_found"] = s3fs.notFound.len()
+	}
+	if s3fs.immutable != nil {
+		state.CacheEntries["immutable"] = s3fs.immutable.cache.len()
+	}
+	if s3fs.content != nil {
+		state.CacheEntries["content"] = s3fs.content.len()
+	}
+	if s3fs.disk != nil {
+		state.CacheEntries["disk"] = s3fs.disk.len()
+	}
+	if s3fs.tagCache != nil {
+		state.CacheEntries["tags"] = s3fs.tagCache.len()
+	}
+	if l := s3fs.limiter; l != nil {
+		l.mu.Lock()
+		state.Limiter = &LimiterState{Max: l.max, InUse: l.inUse}
+		if l.adaptive != nil {
+			state.Limiter.Ceiling = l.adaptive.ceiling
+		}
+		for _, w := range l.waiting {
+			state.Limiter.Queued += len(w)
+		}
+		l.mu.Unlock()
+	}
+	if r := s3fs.routing; r != nil && s3fs.replica != nil {
+		r.mu.RLock()
+		state.LatencyRouting = &LatencyRoutingState{
+			PrimaryLatency:    r.primary,
+			ReplicaLatency:    r.replica,
+			ReplicaPartitions: r.replicaShares,
+		}
+		r.mu.RUnlock()
+	}
+	if c := s3fs.primary.creds; c != nil {
+		c.mu.Lock()
+		state.Credentials.ActiveSource = c.active
+		c.mu.Unlock()
+	}
+	if p := s3fs.credentials; p != nil {
+		if creds, err := p.Retrieve(context.TODO()); err == nil && creds.CanExpire {
+			state.Credentials.Expires = &creds.Expires
+		}
+	}
+	return state
+}
@@ -0,0 +1,133 @@
+package s3fs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StreamReport aggregates the read statistics of all open files.
+type StreamReport struct {
+	// OpenStreams is the number of files currently open for reading.
+	OpenStreams int `json:"open_streams"`
+	// SlowStreams is the number of open files delivering less than the
+	// configured throughput.
+	SlowStreams int `json:"slow_streams"`
+	// SlowClientStreams is the share of SlowStreams spending most of their
+	// time waiting for the client to consume data.
+	SlowClientStreams int `json:"slow_client_streams"`
+	// SlowOriginStreams is the share of SlowStreams spending most of their
+	// time waiting for S3.
+	SlowOriginStreams int `json:"slow_origin_streams"`
+	// BufferedBytes is the number of requested bytes not yet consumed by
+	// the readers.
+	BufferedBytes int64 `json:"buffered_bytes"`
+}
+
+// WithStreamStats tracks the throughput of every open file and reports files
+// delivering less than slowBytesPerSec as slow, see StreamStats.
+func WithStreamStats(slowBytesPerSec int64) Option {
+	return func(s *S3FS) {
+		if slowBytesPerSec <= 0 {
+			return
+		}
+		s.streams = &streamRegistry{
+			slow:  slowBytesPerSec,
+			files: make(map[*readStats]struct{}),
+		}
+	}
+}
+
+// StreamStats returns the aggregated statistics of all open files.
+// It returns nil if stream statistics are disabled.
+func (s3fs *S3FS) StreamStats() *StreamReport {
+	if s3fs.streams == nil {
+		return nil
+	}
+	return s3fs.streams.report(time.Now())
+}
+
+// readStats tracks the reads of a single open file. The counters are
+// updated by the reading goroutine and read concurrently by reports.
+type readStats struct {
+	name        string
+	opened      time.Time
+	bytes       atomic.Int64 // bytes delivered to the reader
+	originNanos atomic.Int64 // time spent waiting for S3
+	buffered    atomic.Int64 // bytes requested from S3 but not yet read
+}
+
+// throughput returns the bytes per second delivered since the file was opened.
+func (r *readStats) throughput(now time.Time) float64 {
+	elapsed := now.Sub(r.opened).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(r.bytes.Load()) / elapsed
+}
+
+// originBound reports whether the file spent most of its lifetime waiting for S3.
+func (r *readStats) originBound(now time.Time) bool {
+	return time.Duration(r.originNanos.Load()) > now.Sub(r.opened)/2
+}
+
+// streamRegistry holds the statistics of all open files.
+type streamRegistry struct {
+	slow int64 // slow is the throughput in bytes/s below which a stream is slow
+
+	mu    sync.Mutex
+	files map[*readStats]struct{}
+}
+
+func (r *streamRegistry) open(name string) *readStats {
+	if r == nil {
+		return nil
+	}
+	stats := &readStats{name: name, opened: time.Now()}
+	r.mu.Lock()
+	r.files[stats] = struct{}{}
+	r.mu.Unlock()
+	return stats
+}
+
+// close unregisters stats and logs it if the stream was slow.
+func (r *streamRegistry) close(stats *readStats, log *zap.Logger) {
+	if r == nil || stats == nil {
+		return
+	}
+	r.mu.Lock()
+	_, ok := r.files[stats]
+	delete(r.files, stats)
+	r.mu.Unlock()
+	now := time.Now()
+	if !ok || stats.bytes.Load() == 0 || stats.throughput(now) >= float64(r.slow) {
+		return
+	}
+	log.Info("slow stream",
+		zap.String("name", stats.name),
+		zap.Int64("bytes", stats.bytes.Load()),
+		zap.Duration("duration", now.Sub(stats.opened)),
+		zap.Duration("origin_wait", time.Duration(stats.originNanos.Load())),
+		zap.Bool("origin_bound", stats.originBound(now)))
+}
+
+func (r *streamRegistry) report(now time.Time) *StreamReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &StreamReport{OpenStreams: len(r.files)}
+	for stats := range r.files {
+		report.BufferedBytes += stats.buffered.Load()
+		if stats.throughput(now) >= float64(r.slow) {
+			continue
+		}
+		report.SlowStreams++
+		if stats.originBound(now) {
+			report.SlowOriginStreams++
+		} else {
+			report.SlowClientStreams++
+		}
+	}
+	return report
+}
@@ -0,0 +1,162 @@
+package s3fs
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// tagCacheSize bounds the number of objects whose tags are cached.
+const tagCacheSize = 100000
+
+// tagLookupConcurrency bounds the GetObjectTagging calls of a listing made
+// at once.
+const tagLookupConcurrency = 16
+
+// WithRequiredTag only serves objects carrying the tag key with the given
+// value, e.g. `public=true`, so publication state can be managed by tagging.
+// Other objects are reported as nonexistent and omitted from listings. Tags
+// are fetched with GetObjectTagging and cached for ttl; S3 Inventory reports
+// don't carry tags, so they can't be used instead.
+func WithRequiredTag(key, value string, ttl time.Duration) Option {
+	return func(s *S3FS) {
+		if key == "" {
+			return
+		}
+		s.requiredTag = &tagFilter{key: key, value: value}
+		s.enableTagCache(ttl)
+	}
+}
+
+// tagFilter requires objects to carry a tag.
+type tagFilter struct {
+	key, value string
+}
+
+// enableTagCache sets up the cache used by all features relying on object tags.
+func (s3fs *S3FS) enableTagCache(ttl time.Duration) {
+	if s3fs.tagCache == nil || ttl < s3fs.tagCache.ttl {
+		s3fs.tagCache = newTTLCache[map[string]string](ttl, tagCacheSize)
+	}
+}
+
+// objectTags returns the tags of the object stored at key.
+func (s3fs *S3FS) objectTags(key string) (map[string]string, error) {
+	if tags, ok := s3fs.tagCache.get(key); ok {
+		return tags, nil
+	}
+	b := s3fs.backendFor(key)
+	client, ok := b.client().(taggingClient)
+	if !ok {
+		return nil, notSupported("GetObjectTagging")
+	}
+	ctx := s3fs.callContext()
+	if err := s3fs.acquire(ctx, priorityHigh); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	ctx, traced := s3fs.traceCall(ctx, "GetObjectTagging", b.bucket, key)
+	ctx, call := bound(ctx, "GetObjectTagging", s3fs.timeouts.Head)
+	resp, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket:       aws.String(b.bucket),
+		Key:          aws.String(key),
+		RequestPayer: s3fs.requestPayer,
+	})
+	err = call.responded(err)
+	call.done()
+	traced.end(resultMetadata(resp), err)
+	s3fs.limiter.release()
+	s3fs.observeCall(ctx, "GetObjectTagging", start, err)
+	b.observe(err)
+	err = s3fs.endpointPolicyError("s3:GetObjectTagging", key, err)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(resp.TagSet))
+	for _, tag := range resp.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	s3fs.tagCache.put(key, tags)
+	return tags, nil
+}
+
+// visible reports whether the tags of the object stored at key allow serving it.
+func (s3fs *S3FS) visible(key string) (bool, error) {
+	if s3fs.requiredTag == nil && s3fs.expiry.tag == "" {
+		return true, nil
+	}
+	tags, err := s3fs.objectTags(key)
+	if err != nil {
+		return false, err
+	}
+	return s3fs.tagsAllow(tags), nil
+}
+
+// tagsAllow reports whether tags allow serving an object.
+func (s3fs *S3FS) tagsAllow(tags map[string]string) bool {
+	if s3fs.expiry.tag != "" && s3fs.expired(tags[s3fs.expiry.tag]) {
+		return false
+	}
+	if s3fs.requiredTag == nil {
+		return true
+	}
+	v, ok := tags[s3fs.requiredTag.key]
+	return ok && v == s3fs.requiredTag.value
+}
+
+// filterVisible removes the files of a listing of dir which may not be
+// served due to their tags. Tags are looked up concurrently, at most
+// tagLookupConcurrency at a time; files whose tags can't be looked up are
+// removed as well, failing closed, unless the call was canceled.
+func (s3fs *S3FS) filterVisible(dir string, entries []fs.DirEntry) ([]fs.DirEntry, error) {
+	if s3fs.requiredTag == nil && s3fs.expiry.tag == "" {
+		return entries, nil
+	}
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, tagLookupConcurrency)
+		keep = make([]bool, len(entries))
+	)
+	for i, e := range entries {
+		if e.IsDir() {
+			keep[i] = true
+			continue
+		}
+		key := path.Join(dirPrefix(dir), e.Name())
+		if tags, ok := s3fs.tagCache.get(key); ok {
+			keep[i] = s3fs.tagsAllow(tags)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ok, err := s3fs.visible(key)
+			if err != nil {
+				s3fs.log.Debug("omitting object whose tags could not be looked up from listing",
+					zap.String("key", key), zap.Error(err))
+				return
+			}
+			keep[i] = ok
+		}(i, key)
+	}
+	wg.Wait()
+	if err := s3fs.callContext().Err(); err != nil {
+		return nil, err
+	}
+	visible := entries[:0]
+	for i, e := range entries {
+		if keep[i] {
+			visible = append(visible, e)
+		}
+	}
+	return visible, nil
+}
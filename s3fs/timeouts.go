@@ -0,0 +1,103 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTimeout is returned if S3 did not respond within a timeout of
+// WithTimeouts.
+var ErrTimeout = errors.New("s3 request timed out")
+
+// Timeouts bound S3 calls so a slow or hung endpoint can't stall requests
+// indefinitely. Zero values leave calls unbounded.
+type Timeouts struct {
+	// Head bounds HeadObject and other metadata calls, e.g.
+	// GetObjectTagging.
+	Head time.Duration
+	// FirstByte bounds the time until GetObject responds, reading the body
+	// is not bounded.
+	FirstByte time.Duration
+	// List bounds single ListObjectsV2 and ListObjectVersions calls.
+	List time.Duration
+}
+
+// WithTimeouts bounds S3 calls by t. A call exceeding its timeout fails with
+// ErrTimeout.
+func WithTimeouts(t Timeouts) Option {
+	return func(s *S3FS) {
+		s.timeouts = t
+	}
+}
+
+// boundedCall is an S3 call cancelled unless it responds within a timeout.
+type boundedCall struct {
+	op      string
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	fired   atomic.Bool
+}
+
+// bound returns a context for the call op cancelled after timeout unless
+// it responded by then. It returns ctx and a nil call if timeout is not set;
+// the methods of a nil call are no-ops.
+func bound(ctx context.Context, op string, timeout time.Duration) (context.Context, *boundedCall) {
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c := &boundedCall{op: op, timeout: timeout, cancel: cancel}
+	c.timer = time.AfterFunc(timeout, func() {
+		c.fired.Store(true)
+		cancel()
+	})
+	return ctx, c
+}
+
+// responded stops the timer once the call returned err, reporting a failure
+// caused by the timeout as ErrTimeout.
+func (c *boundedCall) responded(err error) error {
+	if c == nil {
+		return err
+	}
+	c.timer.Stop()
+	if err != nil && c.fired.Load() {
+		return fmt.Errorf("%w: %s did not respond within %s", ErrTimeout, c.op, c.timeout)
+	}
+	return err
+}
+
+// done releases the context of the call, aborting a response still being
+// read.
+func (c *boundedCall) done() {
+	if c != nil {
+		c.timer.Stop()
+		c.cancel()
+	}
+}
+
+// boundedBody is the body of a bounded call, releasing its context on Close.
+type boundedBody struct {
+	io.ReadCloser
+	call *boundedCall
+}
+
+func (b *boundedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.call.done()
+	return err
+}
+
+// body wraps the response body of the call, releasing the context of the
+// call once it is closed.
+func (c *boundedCall) body(body io.ReadCloser) io.ReadCloser {
+	if c == nil {
+		return body
+	}
+	return &boundedBody{ReadCloser: body, call: c}
+}
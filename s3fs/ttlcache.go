@@ -0,0 +1,207 @@
+package s3fs
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ttlCache holds values for a limited time.
+type ttlCache[V any] struct {
+	ttl        time.Duration
+	maxEntries int
+	keepStale  time.Duration // keepStale retains expired entries for getStale
+	clock      Clock
+
+	mu      sync.Mutex
+	entries map[string]ttlCacheEntry[V]
+}
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration, maxEntries int) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		clock:      systemClock{},
+		entries:    make(map[string]ttlCacheEntry[V]),
+	}
+}
+
+// get returns the cached value for key if present and not expired.
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	if now := c.clock.Now(); now.After(e.expires) {
+		if now.After(e.expires.Add(c.keepStale)) {
+			delete(c.entries, key)
+		}
+		return zero, false
+	}
+	return e.value, true
+}
+
+// getStale returns the value for key if it expired less than keepStale ago.
+func (c *ttlCache[V]) getStale(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || c.clock.Now().After(e.expires.Add(c.keepStale)) {
+		return zero, false
+	}
+	return e.value, true
+}
+
+// put stores value for key, evicting expired entries, or an arbitrary
+// one if none expired, once the cache is full.
+func (c *ttlCache[V]) put(key string, value V) {
+	c.putTTL(key, value, 0)
+}
+
+// putTTL stores value for key like put, expiring it after ttl instead of the
+// default lifetime of the cache unless ttl is 0.
+func (c *ttlCache[V]) putTTL(key string, value V, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if ttl == 0 {
+		ttl = c.ttl
+	}
+	if _, ok := c.entries[key]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = ttlCacheEntry[V]{value: value, expires: now.Add(ttl)}
+}
+
+// evict removes expired entries no longer kept as stale, or a single
+// arbitrary entry, preferring expired ones, if none was removed.
+func (c *ttlCache[V]) evict(now time.Time) {
+	var victim string
+	for key, e := range c.entries {
+		if now.After(e.expires.Add(c.keepStale)) {
+			delete(c.entries, key)
+			continue
+		}
+		if victim == "" || now.After(e.expires) {
+			victim = key
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, victim)
+	}
+}
+
+// len returns the number of cached entries, including expired ones not yet evicted.
+func (c *ttlCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// copyFrom adds the unexpired entries of src, expiring them no later than
+// entries put into c now, until c is full.
+func (c *ttlCache[V]) copyFrom(src *ttlCache[V]) {
+	if c == nil || src == nil || c == src {
+		return
+	}
+	now := c.clock.Now()
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range src.entries {
+		if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+			return
+		}
+		if now.After(e.expires) {
+			continue
+		}
+		if limit := now.Add(c.ttl); e.expires.After(limit) {
+			e.expires = limit
+		}
+		c.entries[key] = e
+	}
+}
+
+// lifetime returns the default lifetime of entries, 0 if c is nil.
+func (c *ttlCache[V]) lifetime() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ttl
+}
+
+// setTTL changes the default lifetime of entries, shortening the remaining
+// lifetime of the entries cached to it.
+func (c *ttlCache[V]) setTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	limit := c.clock.Now().Add(ttl)
+	for key, e := range c.entries {
+		if e.expires.After(limit) {
+			e.expires = limit
+			c.entries[key] = e
+		}
+	}
+}
+
+// setClock makes c take the time from clock.
+func (c *ttlCache[V]) setClock(clock Clock) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clock
+}
+
+// delete removes the entry for key.
+func (c *ttlCache[V]) delete(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// purge removes all entries whose key starts with prefix and returns their number.
+func (c *ttlCache[V]) purge(prefix string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,163 @@
+package s3fs
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// versionsDir is the name of the virtual directory exposing object versions.
+const versionsDir = ".versions"
+
+// WithVersionsDirectory exposes prior versions of every object through a
+// virtual `.versions` directory next to it: `dir/.versions/file` lists the
+// versions of `dir/file` by version ID, and `dir/.versions/file/<version>`
+// opens a single version read-only. Requires a versioned bucket and the
+// s3:ListBucketVersions and s3:GetObjectVersion permissions.
+func WithVersionsDirectory() Option {
+	return func(s *S3FS) {
+		s.versions = true
+	}
+}
+
+// versionPath splits a path within a virtual versions directory into the
+// key of the object and the version ID, which is empty for the directory
+// listing the versions of the object.
+func (s3fs *S3FS) versionPath(name string) (key, versionID string, ok bool) {
+	if !s3fs.versions {
+		return "", "", false
+	}
+	parts := strings.Split(path.Clean(strings.TrimPrefix(name, "/")), "/")
+	for i, part := range parts {
+		if part != versionsDir {
+			continue
+		}
+		rest := parts[i+1:]
+		if len(rest) < 1 || len(rest) > 2 {
+			return "", "", false
+		}
+		key = path.Join(append(parts[:i:i], rest[0])...)
+		if len(rest) == 2 {
+			versionID = rest[1]
+		}
+		return key, versionID, true
+	}
+	return "", "", false
+}
+
+// statVersion describes the versions directory of key, or a single version of it.
+func (s3fs *S3FS) statVersion(name, key, versionID string) (fs.FileInfo, error) {
+	if versionID == "" {
+		return newDirEntry(path.Base(name)), nil
+	}
+	b := s3fs.backendFor(key)
+	ctx := s3fs.callContext()
+	if err := s3fs.acquire(ctx, priorityHigh); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	start := time.Now()
+	ctx, traced := s3fs.traceCall(ctx, "HeadObject", b.bucket, key,
+		attribute.String("aws.s3.version_id", versionID))
+	ctx, call := bound(ctx, "HeadObject", s3fs.timeouts.Head)
+	in := &s3.HeadObjectInput{
+		Bucket:       aws.String(b.bucket),
+		Key:          aws.String(key),
+		VersionId:    aws.String(versionID),
+		RequestPayer: s3fs.requestPayer,
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+	resp, err := b.client().HeadObject(ctx, in)
+	err = call.responded(err)
+	call.done()
+	traced.end(resultMetadata(resp), err)
+	s3fs.limiter.release()
+	s3fs.observeCall(ctx, "HeadObject", start, err)
+	b.observe(err)
+	err = s3fs.endpointPolicyError("s3:GetObjectVersion", key, err)
+	if err != nil {
+		if isNotFound(err) {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  err,
+		}
+	}
+	info := newFileInfo(versionID, resp.ContentLength, aws.ToTime(resp.LastModified), aws.ToString(resp.ETag))
+	info.contentEncoding = aws.ToString(resp.ContentEncoding)
+	info.objectLock = newObjectLock(resp.ObjectLockMode, resp.ObjectLockRetainUntilDate, resp.ObjectLockLegalHoldStatus)
+	return info, nil
+}
+
+// readVersions lists the versions of the object the file is the versions
+// directory of, newest first.
+func (f *s3File) readVersions(n int) ([]fs.DirEntry, error) {
+	if f.versionEntries == nil {
+		entries, err := f.fs.listVersions(f.versionsOf)
+		if err != nil {
+			return nil, &fs.PathError{
+				Op:   "readdir",
+				Path: f.Name(),
+				Err:  err,
+			}
+		}
+		f.versionEntries = entries
+	}
+	if len(f.versionEntries) == 0 && n > 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(f.versionEntries) {
+		n = len(f.versionEntries)
+	}
+	entries := f.versionEntries[:n]
+	f.versionEntries = f.versionEntries[n:]
+	return entries, nil
+}
+
+// listVersions lists all versions of key, skipping delete markers.
+func (s3fs *S3FS) listVersions(key string) ([]fs.DirEntry, error) {
+	entries := []fs.DirEntry{}
+	b := s3fs.backendFor(key)
+	client, ok := b.client().(s3.ListObjectVersionsAPIClient)
+	if !ok {
+		return nil, notSupported("ListObjectVersions")
+	}
+	pages := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(b.bucket),
+		Prefix:       aws.String(key),
+		RequestPayer: s3fs.requestPayer,
+	})
+	for pages.HasMorePages() && (s3fs.maxListEntries <= 0 || len(entries) <= s3fs.maxListEntries) {
+		ctx, traced := s3fs.traceCall(s3fs.callContext(), "ListObjectVersions", b.bucket, "",
+			attribute.String("aws.s3.prefix", key))
+		ctx, call := bound(ctx, "ListObjectVersions", s3fs.timeouts.List)
+		page, err := pages.NextPage(ctx)
+		err = call.responded(err)
+		call.done()
+		traced.end(resultMetadata(page), err)
+		b.observe(err)
+		err = s3fs.endpointPolicyError("s3:ListBucketVersions", key, err)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			// the prefix also matches keys merely starting with key
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+			id := aws.ToString(v.VersionId)
+			entries = append(entries, newFileInfo(id, v.Size, aws.ToTime(v.LastModified), aws.ToString(v.ETag)))
+		}
+	}
+	if s3fs.maxListEntries > 0 && len(entries) > s3fs.maxListEntries {
+		return nil, ErrListingTooLarge
+	}
+	return entries, nil
+}
@@ -0,0 +1,33 @@
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrEndpointPolicy is returned for requests denied while WithVPCEndpoint is
+// in effect, as the policy of the VPC endpoint is a common cause besides the
+// bucket policy and IAM permissions.
+var ErrEndpointPolicy = errors.New("access denied, check that the VPC endpoint policy allows the action")
+
+// WithVPCEndpoint marks the S3 client as talking to an S3 interface endpoint
+// (PrivateLink), so denied requests report the action the endpoint policy
+// needs to allow.
+func WithVPCEndpoint() Option {
+	return func(s *S3FS) {
+		s.vpcEndpoint = true
+	}
+}
+
+// endpointPolicyError annotates err with the denied action if it is an access
+// denied error received through a VPC endpoint. Other errors are returned as is.
+func (s3fs *S3FS) endpointPolicyError(action, key string, err error) error {
+	if !s3fs.vpcEndpoint || err == nil {
+		return err
+	}
+	if httpStatus(err) != http.StatusForbidden || isForbiddenNotExist(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %s on %q: %v", ErrEndpointPolicy, action, key, err)
+}
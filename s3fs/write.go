@@ -0,0 +1,330 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// ErrReadOnly is returned by calls modifying a filesystem without
+// WithWrites.
+var ErrReadOnly = errors.New("filesystem is read-only")
+
+// ErrDirNotEmpty is returned by Remove for directories holding objects.
+var ErrDirNotEmpty = errors.New("directory not empty")
+
+// WithWrites enables Create, OpenFile for writing, Mkdir, Remove and Rename,
+// e.g. for WebDAV or uploads. The client passed to NewFS has to implement
+// PutObject, DeleteObject and CopyObject like *s3.Client. Writes always go
+// to the bucket passed to NewFS, below the prefix and the first layer of
+// WithPrefixLayers, and drop the cached metadata and contents of the keys
+// written.
+func WithWrites() Option {
+	return func(s *S3FS) {
+		s.writable = true
+	}
+}
+
+// writeClient is implemented by clients supporting writes.
+type writeClient interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+}
+
+// WritableFile is a file opened for writing by Create or OpenFile. The
+// object is written on Close, which reports whether it succeeded.
+type WritableFile interface {
+	fs.File
+	io.Writer
+}
+
+// writeKey returns the key name is written to.
+func (s3fs *S3FS) writeKey(op, name string) (string, error) {
+	if !s3fs.writable {
+		return "", &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+	}
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if clean == "" {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	key, err := s3fs.prefixed(op, clean)
+	if err != nil {
+		return "", err
+	}
+	if s3fs.layers != nil {
+		key = layerName(s3fs.layers.prefixes[0], key)
+	}
+	return key, nil
+}
+
+// Create creates or truncates the file name, like OpenFile with
+// os.O_WRONLY|os.O_CREATE|os.O_TRUNC.
+func (s3fs *S3FS) Create(name string) (WritableFile, error) {
+	key, err := s3fs.writeKey("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return &writeFile{fs: s3fs, name: name, key: key}, nil
+}
+
+// OpenFile opens name with flag like os.OpenFile, for reading if neither
+// os.O_WRONLY nor os.O_RDWR is set. Files opened for writing replace the
+// object on Close whether os.O_TRUNC is set or not, they can't be read back
+// or appended to, so os.O_RDWR and os.O_APPEND fail with fs.ErrInvalid. The
+// permissions are ignored.
+func (s3fs *S3FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return s3fs.Open(name)
+	}
+	if flag&(os.O_RDWR|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	key, err := s3fs.writeKey("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE == 0 || flag&os.O_EXCL != 0 {
+		info, err := s3fs.Stat(name)
+		switch {
+		case err != nil && !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		case err == nil && flag&os.O_EXCL != 0:
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		case err != nil && flag&os.O_CREATE == 0:
+			return nil, err
+		case err == nil && info.IsDir():
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+	}
+	return &writeFile{fs: s3fs, name: name, key: key}, nil
+}
+
+// Mkdir creates the directory name as a zero-byte marker object. The
+// permissions are ignored.
+func (s3fs *S3FS) Mkdir(name string, perm fs.FileMode) error {
+	key, err := s3fs.writeKey("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err := s3fs.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := s3fs.putObject(key+"/", bytes.NewReader(nil), 0); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Remove removes the file or empty directory name.
+func (s3fs *S3FS) Remove(name string) error {
+	key, err := s3fs.writeKey("remove", name)
+	if err != nil {
+		return err
+	}
+	info, err := s3fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		key += "/"
+		out, err := s3fs.listObjects(key, &s3.ListObjectsV2Input{
+			Prefix:  aws.String(key),
+			MaxKeys: 2,
+		})
+		if err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		for _, obj := range out.Contents {
+			if aws.ToString(obj.Key) != key {
+				return &fs.PathError{Op: "remove", Path: name, Err: ErrDirNotEmpty}
+			}
+		}
+	}
+	if err := s3fs.deleteObject(key); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Rename moves the file oldname to newname by copying it within S3 and
+// deleting the original, replacing newname if it exists, so the content is
+// never transferred through the process. Objects over 5GiB are copied in
+// parts with UploadPartCopy, which needs the multipart operations and keeps
+// only the content type of the object. Directories can't be renamed.
+func (s3fs *S3FS) Rename(oldname, newname string) error {
+	oldKey, err := s3fs.writeKey("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newKey, err := s3fs.writeKey("rename", newname)
+	if err != nil {
+		return err
+	}
+	info, err := s3fs.Stat(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if info.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	var etag string
+	if obj, ok := info.Sys().(*ObjectInfo); ok {
+		etag = obj.ETag
+	}
+	if err := s3fs.copyObject(oldKey, newKey, info.Size(), etag); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if err := s3fs.deleteObject(oldKey); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// writeCall issues the modifying call operation for key to the primary
+// bucket with a client implementing C.
+func writeCall[C, T any](s3fs *S3FS, operation, key string, timeout time.Duration, fn func(ctx context.Context, client C, bucket string) (T, error)) (T, error) {
+	var zero T
+	b := &s3fs.primary
+	client, ok := b.client().(C)
+	if !ok {
+		return zero, notSupported(operation)
+	}
+	ctx := s3fs.callContext()
+	if err := s3fs.acquire(ctx, priorityHigh); err != nil {
+		return zero, err
+	}
+	start := time.Now()
+	ctx, traced := s3fs.traceCall(ctx, operation, b.bucket, key)
+	ctx, call := bound(ctx, operation, timeout)
+	v, err := fn(ctx, client, b.bucket)
+	err = call.responded(err)
+	call.done()
+	traced.end(resultMetadata(v), err)
+	s3fs.limiter.release()
+	s3fs.observeCall(ctx, operation, start, err)
+	b.observe(err)
+	s3fs.forget(key)
+	return v, s3fs.endpointPolicyError("s3:"+operation, key, err)
+}
+
+// forget drops the cached entries of key, including the marker of a
+// directory, and the directories above it.
+func (s3fs *S3FS) forget(key string) {
+	key = strings.TrimSuffix(key, "/")
+	s3fs.purge(key)
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		s3fs.statCache.delete(dir)
+		s3fs.notFound.delete(dir)
+		if s3fs.layers != nil {
+			s3fs.layers.misses.delete(dir)
+		}
+	}
+	s3fs.statCache.delete(".")
+}
+
+// putObject writes size bytes of body to key.
+func (s3fs *S3FS) putObject(key string, body io.Reader, size int64) error {
+	_, err := writeCall(s3fs, "PutObject", key, 0, func(ctx context.Context, client writeClient, bucket string) (*s3.PutObjectOutput, error) {
+		in := &s3.PutObjectInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			Body:          body,
+			ContentLength: size,
+		}
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.BucketKeyEnabled = s3fs.encryption.headers()
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = s3fs.customerKey.headers()
+		if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+			in.ContentType = aws.String(ct)
+		}
+		return client.PutObject(ctx, in)
+	})
+	return err
+}
+
+// deleteObject deletes key.
+func (s3fs *S3FS) deleteObject(key string) error {
+	_, err := writeCall(s3fs, "DeleteObject", key, s3fs.timeouts.Head, func(ctx context.Context, client writeClient, bucket string) (*s3.DeleteObjectOutput, error) {
+		return client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+	})
+	return err
+}
+
+// writeFile is a file opened for writing, buffering its content until it
+// is closed or, with WithMultipartUpload, until a part is complete.
+type writeFile struct {
+	fs      *S3FS
+	name    string
+	key     string
+	buf     bytes.Buffer
+	written int64            // written is the number of bytes written
+	upload  *multipartUpload // upload is the multipart upload in progress, nil if none
+	parts   int32            // parts is the number of parts uploaded
+	closed  bool
+}
+
+var _ WritableFile = (*writeFile)(nil)
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	n, _ := f.buf.Write(p)
+	f.written += int64(n)
+	if f.fs.multipart == nil {
+		return n, nil
+	}
+	if err := f.flushParts(false); err != nil {
+		return n, &fs.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	return n, nil
+}
+
+func (f *writeFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+}
+
+// Stat describes the content written so far.
+func (f *writeFile) Stat() (fs.FileInfo, error) {
+	return newFileInfo(path.Base(f.name), f.written, time.Now(), ""), nil
+}
+
+// Close writes the object, or completes its multipart upload.
+func (f *writeFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	if f.upload != nil {
+		err := f.flushParts(true)
+		if completeErr := f.upload.complete(); err == nil {
+			err = completeErr
+		}
+		if err != nil {
+			f.fs.log.Debug("multipart upload failed", zap.String("key", f.key), zap.Error(err))
+			return &fs.PathError{Op: "close", Path: f.name, Err: err}
+		}
+		return nil
+	}
+	if err := f.fs.putObject(f.key, bytes.NewReader(f.buf.Bytes()), int64(f.buf.Len())); err != nil {
+		f.fs.log.Debug("writing object failed", zap.String("key", f.key), zap.Error(err))
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+// SignatureVerification configures the verification of signature sidecars
+// before serving objects, failing closed.
+type SignatureVerification struct {
+	// Format of the signatures, `cosign` for base64 encoded signatures of
+	// `cosign sign-blob` in `.sig` sidecars or `minisign` for `.minisig`
+	// sidecars.
+	Format string `json:"format,omitempty"`
+
+	// Path of the public key file, PEM encoded for cosign.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Patterns of keys to verify, all if empty.
+	Match []string `json:"match,omitempty"`
+}
+
+// option returns the s3fs option verifying signatures.
+func (v *SignatureVerification) option() (s3fs.Option, error) {
+	key, err := os.ReadFile(v.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	var verifier s3fs.Verifier
+	switch v.Format {
+	case "cosign":
+		verifier, err = s3fs.NewCosignVerifier(key)
+	case "minisign":
+		verifier, err = s3fs.NewMinisignVerifier(string(key))
+	default:
+		err = fmt.Errorf("unsupported signature format %q", v.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s3fs.WithSignatureVerification(verifier, v.Match...), nil
+}
+
+// unmarshalSignatureVerification parses the verify_signatures block.
+func unmarshalSignatureVerification(d *caddyfile.Dispenser) (*SignatureVerification, error) {
+	v := new(SignatureVerification)
+	if !d.AllArgs(&v.Format, &v.PublicKey) {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "match":
+			v.Match = append(v.Match, d.RemainingArgs()...)
+		default:
+			return nil, d.Errf("%s not a valid verify_signatures option", d.Val())
+		}
+	}
+	return v, nil
+}
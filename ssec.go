@@ -0,0 +1,25 @@
+package caddys3fs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+// option returns the s3fs option reading objects with the key.
+func (k *CustomerKey) option() (s3fs.Option, error) {
+	data, err := os.ReadFile(k.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("customer key: %w", err)
+	}
+	key, err := decodeKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("customer key: %w", err)
+	}
+	customerKey, err := s3fs.NewCustomerKey(key, k.KeyMD5)
+	if err != nil {
+		return nil, err
+	}
+	return s3fs.WithCustomerKey(customerKey), nil
+}
@@ -0,0 +1,76 @@
+package caddys3fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// endpointResolver returns a resolver directing S3 requests to endpoint and
+// STS requests to the configured STS endpoint or region, falling back to the
+// default endpoints otherwise. A custom S3 endpoint thereby does not leak
+// into the credential providers.
+func (fs *FS) endpointResolver(endpoint string) aws.EndpointResolverWithOptions {
+	return aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+		switch service {
+		case s3.ServiceID:
+			if endpoint != "" {
+				return aws.Endpoint{URL: endpoint, SigningRegion: region, Source: aws.EndpointSourceCustom}, nil
+			}
+		case sts.ServiceID:
+			if fs.STSRegion != "" {
+				region = fs.STSRegion
+			}
+			switch {
+			case fs.STSEndpoint != "":
+				return aws.Endpoint{URL: fs.STSEndpoint, SigningRegion: region, Source: aws.EndpointSourceCustom}, nil
+			case fs.STSRegion != "":
+				return aws.Endpoint{URL: regionalSTSEndpoint(region), SigningRegion: region}, nil
+			}
+		}
+		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+	})
+}
+
+// regionalSTSEndpoint returns the URL of the STS endpoint of region.
+func regionalSTSEndpoint(region string) string {
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://sts.%s.%s", region, domain)
+}
+
+// timeoutCredentials bounds every retrieval of the credentials of provider,
+// including the STS calls and their retries, by timeout, so an unreachable
+// STS endpoint fails requests instead of hanging them.
+type timeoutCredentials struct {
+	provider aws.CredentialsProvider
+	timeout  time.Duration
+}
+
+func (c timeoutCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.provider.Retrieve(ctx)
+}
+
+// resolveCredentials retrieves the credentials of cfg once, failing if
+// they can't be resolved within the credential timeout, e.g. since the STS
+// endpoint of a role is unreachable. The credentials stay cached for the
+// first requests.
+func (fs *FS) resolveCredentials(cfg aws.Config) error {
+	if cfg.Credentials == nil {
+		return nil
+	}
+	if _, err := cfg.Credentials.Retrieve(context.Background()); err != nil {
+		return fmt.Errorf("resolving credentials within %s (sts_region %q, sts_endpoint %q): %w",
+			time.Duration(fs.CredentialTimeout), fs.STSRegion, fs.STSEndpoint, err)
+	}
+	return nil
+}
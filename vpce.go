@@ -0,0 +1,67 @@
+package caddys3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// vpcEndpointLookupTimeout bounds the DNS lookup of the VPC endpoint on provision.
+const vpcEndpointLookupTimeout = 5 * time.Second
+
+// validateVPCEndpoint checks the configuration is usable through S3 interface
+// endpoints and that their DNS names resolve, failing provision early instead
+// of letting requests hang.
+func (fs *FS) validateVPCEndpoint(log *zap.Logger) error {
+	if err := lookupEndpoint(fs.Endpoint, log); err != nil {
+		return err
+	}
+	if fs.Fallback != nil {
+		// interface endpoints only serve the region they are created in
+		if fs.Fallback.Endpoint == "" {
+			return errors.New("vpc_endpoint requires the endpoint of the fallback bucket to be set")
+		}
+		if err := lookupEndpoint(fs.Fallback.Endpoint, log); err != nil {
+			return err
+		}
+	}
+	if fs.STSEndpoint != "" {
+		if err := lookupEndpoint(fs.STSEndpoint, log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupEndpoint resolves the host of endpoint, warning if it does not
+// resolve to private addresses only, i.e. traffic would leave the VPC.
+func lookupEndpoint(endpoint string, log *zap.Logger) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("invalid endpoint %q: missing host, use a URL like https://bucket.vpce-xxx.s3.region.vpce.amazonaws.com", endpoint)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vpcEndpointLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("vpc endpoint %q does not resolve, check the endpoint exists and private DNS is enabled: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if !addr.IP.IsPrivate() && !addr.IP.IsLoopback() {
+			log.Warn("vpc endpoint resolves to a public address, requests may not use PrivateLink",
+				zap.String("host", host),
+				zap.String("address", addr.IP.String()))
+			break
+		}
+	}
+	return nil
+}
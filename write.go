@@ -0,0 +1,86 @@
+package caddys3fs
+
+import (
+	iofs "io/fs"
+	"os"
+
+	"github.com/floj/caddy-s3fs/s3fs"
+)
+
+// writableFS is implemented by filesystems supporting writes.
+type writableFS interface {
+	Create(name string) (s3fs.WritableFile, error)
+	OpenFile(name string, flag int, perm iofs.FileMode) (iofs.File, error)
+	Mkdir(name string, perm iofs.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	RemoveAll(name string) error
+}
+
+var _ writableFS = (*s3fs.S3FS)(nil)
+
+// writable returns the filesystem to write name to, or the error of op if
+// fs is read-only.
+func (fs *FS) writable(op, name string) (writableFS, error) {
+	if w, ok := fs.StatFS.(writableFS); ok {
+		return w, nil
+	}
+	return nil, &iofs.PathError{Op: op, Path: name, Err: s3fs.ErrReadOnly}
+}
+
+// Create creates or replaces the file name, written when it is closed.
+func (fs *FS) Create(name string) (s3fs.WritableFile, error) {
+	w, err := fs.writable("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return w.Create(name)
+}
+
+// OpenFile opens name for reading or, with os.O_WRONLY, for writing.
+func (fs *FS) OpenFile(name string, flag int, perm iofs.FileMode) (iofs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return fs.StatFS.Open(name)
+	}
+	w, err := fs.writable("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return w.OpenFile(name, flag, perm)
+}
+
+// Mkdir creates the directory name.
+func (fs *FS) Mkdir(name string, perm iofs.FileMode) error {
+	w, err := fs.writable("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return w.Mkdir(name, perm)
+}
+
+// Remove removes the file or empty directory name.
+func (fs *FS) Remove(name string) error {
+	w, err := fs.writable("remove", name)
+	if err != nil {
+		return err
+	}
+	return w.Remove(name)
+}
+
+// RemoveAll removes the file or directory name with all objects below it.
+func (fs *FS) RemoveAll(name string) error {
+	w, err := fs.writable("removeall", name)
+	if err != nil {
+		return err
+	}
+	return w.RemoveAll(name)
+}
+
+// Rename moves the file oldname to newname.
+func (fs *FS) Rename(oldname, newname string) error {
+	w, ok := fs.StatFS.(writableFS)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: s3fs.ErrReadOnly}
+	}
+	return w.Rename(oldname, newname)
+}